@@ -0,0 +1,97 @@
+package torrent
+
+import (
+	"github.com/anacrolix/missinggo/v2/bitmap"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// How the pieces we have are disclosed to peers after the handshake. A torrent with millions of
+// pieces has a bitfield of hundreds of kilobytes, sent on every connection, even to peers that
+// leave before using it. See ClientConfig.BitfieldPolicy. Have-all and have-none are still sent
+// where the fast extension allows, whatever the policy. The "bitfield bytes avoided" and
+// "incremental have bytes" counters measure the savings.
+type BitfieldPolicy int
+
+const (
+	// Always send the bitfield.
+	BitfieldPolicyFull BitfieldPolicy = iota
+	// Send a have message per piece instead of the bitfield when that's smaller, such as when few of
+	// a torrent's pieces are complete.
+	BitfieldPolicyCompact
+	// Don't send the bitfield. Pieces are disclosed with have messages a few at a time, when the
+	// connection has nothing else to write, skipping pieces the peer already has.
+	BitfieldPolicyIncremental
+)
+
+// The most have messages written for undisclosed pieces each time the write buffer is filled.
+const incrementalHavesPerFill = 64
+
+const (
+	haveMessageLen     = 4 + 1 + 4
+	haveNoneMessageLen = 4 + 1
+	haveAllMessageLen  = 4 + 1
+)
+
+func bitfieldMessageLen(numPieces int) int {
+	return 4 + 1 + (numPieces+7)/8
+}
+
+// Tells the peer which pieces we have, per ClientConfig.BitfieldPolicy. Have-all and have-none
+// have already been considered.
+func (cn *PeerConn) discloseInitialPieces() {
+	policy := cn.t.cl.config.BitfieldPolicy
+	if policy == BitfieldPolicyFull || !cn.t.advertiseAnyPieces() || cn.t.advertiseAllPieces() {
+		cn.postBitfield()
+		return
+	}
+	have := cn.t.advertisedPieces()
+	full := bitfieldMessageLen(cn.t.numPieces())
+	prefix := 0
+	if cn.fastEnabled() {
+		// The fast extension requires a bitfield, have-all or have-none first.
+		prefix = haveNoneMessageLen
+	}
+	if policy == BitfieldPolicyCompact && prefix+int(have.Len())*haveMessageLen >= full {
+		cn.postBitfield()
+		return
+	}
+	if cn.fastEnabled() {
+		cn.post(pp.Message{Type: pp.HaveNone})
+	}
+	if policy == BitfieldPolicyCompact {
+		have.IterTyped(func(piece int) bool {
+			cn.have(piece)
+			return true
+		})
+		torrent.Add("bitfield bytes avoided", int64(full-prefix-int(have.Len())*haveMessageLen))
+		return
+	}
+	cn.undisclosedPieces = have
+	torrent.Add("bitfield bytes avoided", int64(full-prefix))
+	cn.tickleWriter()
+}
+
+// Writes have messages for some of the pieces not yet disclosed. Pieces the peer has, or that
+// are no longer complete, are dropped without one.
+func (cn *PeerConn) discloseHaves(msg func(pp.Message) bool) {
+	if cn.undisclosedPieces.IsEmpty() {
+		return
+	}
+	var next []pieceIndex
+	cn.undisclosedPieces.IterTyped(func(piece int) bool {
+		next = append(next, piece)
+		return len(next) < incrementalHavesPerFill
+	})
+	for _, piece := range next {
+		cn.undisclosedPieces.Remove(piece)
+		if cn.sentHaves.Get(bitmap.BitIndex(piece)) || cn.peerHasPiece(piece) || !cn.t.pieceComplete(piece) {
+			continue
+		}
+		cn.sentHaves.Add(bitmap.BitIndex(piece))
+		torrent.Add("incremental have bytes", haveMessageLen)
+		if !msg(pp.Message{Type: pp.Have, Index: pp.Integer(piece)}) {
+			return
+		}
+	}
+}
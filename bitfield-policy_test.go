@@ -0,0 +1,54 @@
+package torrent
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func testBitfieldPolicy(t *testing.T, policy BitfieldPolicy, numPieces int, completed []int, expected string) {
+	cfg := TestingConfig()
+	cfg.BitfieldPolicy = policy
+	cl := Client{
+		config: cfg,
+	}
+	cl.initLogger()
+	c := cl.newConnection(nil, false, nil, "", "")
+	c.setTorrent(cl.newTorrent(metainfo.Hash{}, nil))
+	c.t.setInfo(&metainfo.Info{
+		Pieces: make([]byte, metainfo.HashSize*numPieces),
+	})
+	r, w := io.Pipe()
+	c.r = r
+	c.w = w
+	go c.writer(time.Minute)
+	c.locker().Lock()
+	for _, i := range completed {
+		c.t._completedPieces.Add(i)
+	}
+	c.discloseInitialPieces()
+	c.locker().Unlock()
+	b := make([]byte, len(expected))
+	_, err := io.ReadFull(r, b)
+	c.locker().Lock()
+	c.closed.Set()
+	c.locker().Unlock()
+	require.NoError(t, err)
+	require.EqualValues(t, expected, string(b))
+}
+
+func TestBitfieldPolicyCompact(t *testing.T) {
+	// A have is smaller than the bitfield for 100 pieces.
+	testBitfieldPolicy(t, BitfieldPolicyCompact, 100, []int{9}, "\x00\x00\x00\x05\x04\x00\x00\x00\x09")
+	// But not for 3.
+	testBitfieldPolicy(t, BitfieldPolicyCompact, 3, []int{1}, "\x00\x00\x00\x02\x05@")
+}
+
+func TestBitfieldPolicyIncremental(t *testing.T) {
+	testBitfieldPolicy(t, BitfieldPolicyIncremental, 3, []int{0, 2},
+		"\x00\x00\x00\x05\x04\x00\x00\x00\x00\x00\x00\x00\x05\x04\x00\x00\x00\x02")
+}
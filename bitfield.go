@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+)
+
+// The version byte leading serialized bitfields. Increment it if the layout changes.
+const bitfieldVersion = 1
+
+// Serializes the set of pieces of a torrent with numPieces pieces. The layout is the version byte,
+// the number of pieces as a uvarint, and then a bit per piece with the high bit of the first byte
+// corresponding to piece 0, as in the BitTorrent bitfield message.
+func marshalBitfield(numPieces int, have bitmap.Bitmap) []byte {
+	b := make([]byte, 1+binary.MaxVarintLen64+(numPieces+7)/8)
+	b[0] = bitfieldVersion
+	n := 1 + binary.PutUvarint(b[1:], uint64(numPieces))
+	b = b[:n+(numPieces+7)/8]
+	have.IterTyped(func(piece int) bool {
+		if piece >= numPieces {
+			return false
+		}
+		b[n+piece/8] |= 0x80 >> uint(piece%8)
+		return true
+	})
+	return b
+}
+
+func unmarshalBitfield(b []byte, numPieces int) (ret bitmap.Bitmap, err error) {
+	if len(b) == 0 {
+		err = errors.New("empty bitfield")
+		return
+	}
+	if b[0] != bitfieldVersion {
+		err = fmt.Errorf("unsupported bitfield version %d", b[0])
+		return
+	}
+	encodedNumPieces, n := binary.Uvarint(b[1:])
+	if n <= 0 {
+		err = errors.New("bad piece count")
+		return
+	}
+	if encodedNumPieces != uint64(numPieces) {
+		err = fmt.Errorf("bitfield has %d pieces, torrent has %d", encodedNumPieces, numPieces)
+		return
+	}
+	b = b[1+n:]
+	if len(b) != (numPieces+7)/8 {
+		err = fmt.Errorf("bitfield has %d bytes, expected %d", len(b), (numPieces+7)/8)
+		return
+	}
+	for piece := 0; piece < numPieces; piece++ {
+		if b[piece/8]&(0x80>>uint(piece%8)) != 0 {
+			ret.Add(piece)
+		}
+	}
+	return
+}
+
+// Returns a compact serialization of the verified pieces, suitable for storing with resume data or
+// replicating to other clients. It's nil if the torrent info isn't available yet.
+func (t *Torrent) Bitfield() []byte {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	if !t.haveInfo() {
+		return nil
+	}
+	return marshalBitfield(t.numPieces(), t._completedPieces)
+}
+
+// Sets piece completion from a serialization returned by Bitfield, without verifying piece data.
+// Pieces not in the bitfield are marked incomplete. This is only for restoring state from a trusted
+// source: pieces marked complete that have incorrect data will be served to peers.
+func (t *Torrent) SetBitfieldUnchecked(b []byte) error {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if !t.haveInfo() {
+		return errors.New("torrent info not available")
+	}
+	have, err := unmarshalBitfield(b, t.numPieces())
+	if err != nil {
+		return err
+	}
+	for i := 0; i < t.numPieces(); i++ {
+		complete := have.Get(bitmap.BitIndex(i))
+		if complete == t.pieceComplete(i) {
+			continue
+		}
+		p := t.piece(i)
+		t.cl.unlock()
+		if complete {
+			err = p.Storage().MarkComplete()
+		} else {
+			err = p.Storage().MarkNotComplete()
+		}
+		t.cl.lock()
+		if err != nil {
+			return fmt.Errorf("setting piece %d completion: %w", i, err)
+		}
+		if t.closed.IsSet() {
+			return errors.New("torrent closed")
+		}
+		t.updatePieceCompletion(i)
+		t.publishPieceChange(i)
+	}
+	return nil
+}
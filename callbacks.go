@@ -15,6 +15,19 @@ type Callbacks struct {
 	ReadMessage           func(*PeerConn, *pp.Message)
 	ReadExtendedHandshake func(*PeerConn, *pp.ExtendedHandshakeMessage)
 	PeerConnClosed        func(*PeerConn)
+	// Called when a torrent is quarantined for repeated hash failures. See
+	// HashFailureQuarantine. It's called in its own goroutine.
+	TorrentQuarantined func(*Torrent, TorrentQuarantine)
+	// Called when downloading is paused or resumed due to ClientConfig.MinFreeSpace, with the
+	// available space. The Client lock is not held.
+	FreeSpaceLow func(low bool, available int64)
+	// Called for each action refused for an infohash blocked by Client.BlockInfoHashes, such as
+	// for an audit log. It's called in its own goroutine, without the Client lock.
+	InfoHashRefused func(InfoHashRefusal)
+	// Called when an error category's rate goes over its threshold in
+	// ClientConfig.ErrorAlarmThresholds. It's called again only after the rate has dropped back
+	// under it. It's called in its own goroutine.
+	ErrorAlarm func(ErrorAlarm)
 
 	// Provides secret keys to be tried against incoming encrypted connections.
 	ReceiveEncryptedHandshakeSkeys mse.SecretKeyIter
@@ -18,7 +18,6 @@ import (
 	"github.com/anacrolix/dht/v2"
 	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/log"
-	"github.com/anacrolix/missinggo/bitmap"
 	"github.com/anacrolix/missinggo/perf"
 	"github.com/anacrolix/missinggo/pubsub"
 	"github.com/anacrolix/missinggo/slices"
@@ -37,6 +36,7 @@ import (
 	"github.com/anacrolix/missinggo/v2/conntrack"
 
 	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/geoip"
 	"github.com/anacrolix/torrent/iplist"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/mse"
@@ -50,6 +50,8 @@ type Client struct {
 	// An aggregate of stats over all connections. First in struct to ensure 64-bit alignment of
 	// fields. See #262.
 	stats ConnStats
+	// Aggregates by the class of network the peer is on. Sizes of ConnStats maintain alignment.
+	networkClassStats [numPeerNetworkClasses]ConnStats
 
 	_mu    lockWithDeferreds
 	event  sync.Cond
@@ -66,19 +68,57 @@ type Client struct {
 	dhtServers     []DhtServer
 	ipBlockList    iplist.Ranger
 
+	// Indexed by DHT family. See Client.DhtFamilyStats.
+	dhtFamilyCounts [numDhtFamilies]dhtFamilyCounts
+	// Peers announced over the DHT for torrents that aren't added.
+	dhtStoredPeers map[metainfo.Hash][]PeerInfo
+	// By DHT server address. See Client.Rebootstrap.
+	dhtBootstrapMu sync.Mutex
+	dhtBootstraps  map[string]*dhtBootstrapState
+
 	// Set of addresses that have our client ID. This intentionally will
 	// include ourselves if we end up trying to connect to our own address
 	// through legitimate channels.
 	dopplegangerAddrs map[string]struct{}
 	badPeerIPs        map[string]struct{}
-	torrents          map[InfoHash]*Torrent
+	// Established connections by remote peer ID, across torrents. See peer-fairness.go.
+	connsByPeerID map[PeerID]map[*PeerConn]struct{}
+	torrents      map[InfoHash]*Torrent
+	// Torrents added with AddDormantTorrentSpec that haven't been activated.
+	dormantTorrents map[InfoHash]*dormantTorrent
+	// Torrents activated from dormancy, that may be returned to it.
+	lazyActive map[InfoHash]*lazyTorrent
+	// See BlockInfoHashes.
+	blockedInfoHashes map[InfoHash]struct{}
 
 	acceptLimiter   map[ipStr]int
 	dialRateLimiter *rate.Limiter
-	numHalfOpen     int
+	// See ClientConfig.NewPeerUploadReservation.
+	establishedUploadLimiter *rate.Limiter
+	numHalfOpen              int
+	// Pieces being hashed across all torrents. See ClientConfig.PieceHashers.
+	activePieceHashes int
+	// Nil unless ClientConfig.UploadCacheSize is set.
+	uploadCache *uploadCache
 
 	websocketTrackers websocketTrackers
-	activeAnnounces   map[string]struct{}
+	// Shares connections between announces to the same trackers.
+	trackerPool *tracker.Pool
+	// Count of announces in progress by tracker host.
+	activeAnnounces map[string]int
+	// Limits the event commands running at once.
+	eventCommandSem chan struct{}
+	// Downloading is paused for ClientConfig.MinFreeSpace.
+	lowFreeSpace bool
+	// See ClientConfig.PreHandshakeAuthKey.
+	preHandshakeAuthNonces preHandshakeAuthNonces
+	// See Client.ErrorStats.
+	errorCounters errorCounters
+	// The highest TorrentPriority of the torrents downloading, accessed atomically. See
+	// Client.updateTopPriority.
+	topPriority int32
+	// Recomputes topPriority after the torrents at it stop receiving data.
+	topPriorityTimer *time.Timer
 }
 
 type ipStr string
@@ -131,6 +171,13 @@ func (cl *Client) WriteStatus(_w io.Writer) {
 		writeDhtServerStatus(w, s)
 	})
 	spew.Fdump(w, &cl.stats)
+	if rs, ok := cl.defaultStorage.ReadStats(); ok {
+		fmt.Fprintf(w, "Storage reads: %d (mean %v), cache hits: %d (%.1f%%), bytes from cache: %d, from backing: %d\n",
+			rs.Reads, rs.MeanReadDuration(), rs.CacheHits, 100*rs.CacheHitRatio(), rs.CacheBytes, rs.BackingBytes)
+	}
+	if es := cl.ErrorStats(); len(es) != 0 {
+		fmt.Fprintf(w, "Errors: %s\n", formatErrorStats(es))
+	}
 	fmt.Fprintf(w, "# Torrents: %d\n", len(cl.torrentsAsSlice()))
 	fmt.Fprintln(w)
 	for _, t := range slices.Sort(cl.torrentsAsSlice(), func(l, r *Torrent) bool {
@@ -194,6 +241,13 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		cl.Close()
 	}()
 	cl.event.L = cl.locker()
+	cl.initUploadReservation()
+	cl.eventCommandSem = make(chan struct{}, cl.eventCommandConcurrency())
+	cl.trackerPool = &tracker.Pool{HTTPProxy: cfg.HTTPProxy}
+	if cfg.UploadCacheSize > 0 {
+		cl.uploadCache = newUploadCache(cfg.UploadCacheSize)
+	}
+	cl.onClose = append(cl.onClose, func() { cl.trackerPool.Close() })
 	storageImpl := cfg.DefaultStorage
 	if storageImpl == nil {
 		// We'd use mmap by default but HFS+ doesn't support sparse files.
@@ -206,6 +260,15 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		storageImpl = storageImplCloser
 	}
 	cl.defaultStorage = storage.NewClient(storageImpl)
+	if cfg.MinFreeSpace > 0 {
+		go cl.monitorFreeSpace()
+	}
+	if len(cfg.LifecycleRules) != 0 {
+		go cl.runLifecycleRules()
+	}
+	if cl.dormantAnnounceInterval() > 0 {
+		go cl.announceDormantTorrents()
+	}
 	if cfg.IPBlocklist != nil {
 		cl.ipBlockList = cfg.IPBlocklist
 	}
@@ -238,7 +301,14 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		}
 	}
 
-	go cl.forwardPort()
+	if cfg.OutgoingOnly {
+		err = cl.initOutgoingOnly()
+		if err != nil {
+			return
+		}
+	} else {
+		go cl.forwardPort()
+	}
 	if !cfg.NoDHT {
 		for _, s := range sockets {
 			if pc, ok := s.(net.PacketConn); ok {
@@ -246,8 +316,12 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 				if err != nil {
 					panic(err)
 				}
-				cl.dhtServers = append(cl.dhtServers, anacrolixDhtServerWrapper{ds})
+				s := anacrolixDhtServerWrapper{ds}
+				cl.dhtServers = append(cl.dhtServers, s)
 				cl.onClose = append(cl.onClose, func() { ds.Close() })
+				if cfg.DhtStartingNodes != nil {
+					cl.initialDhtBootstrap(s, cfg.DhtStartingNodes(pc.LocalAddr().Network()))
+				}
 			}
 		}
 	}
@@ -293,7 +367,7 @@ func (cl *Client) AddDialer(d Dialer) {
 	cl.lock()
 	defer cl.unlock()
 	cl.dialers = append(cl.dialers, d)
-	for _, t := range cl.torrents {
+	for _, t := range cl.torrentsByPriority() {
 		t.openNewConns()
 	}
 }
@@ -318,6 +392,9 @@ func (cl *Client) firewallCallback(net.Addr) bool {
 }
 
 func (cl *Client) listenOnNetwork(n network) bool {
+	if cl.config.OutgoingOnly {
+		return false
+	}
 	if n.Ipv4 && cl.config.DisableIPv4 {
 		return false
 	}
@@ -343,6 +420,11 @@ func (cl *Client) listenNetworks() (ns []network) {
 }
 
 func (cl *Client) newAnacrolixDhtServer(conn net.PacketConn) (s *dht.Server, err error) {
+	// Bootstraps from the routing table only.
+	startingNodes := func() ([]dht.Addr, error) { return nil, nil }
+	if f := cl.config.DhtStartingNodes; f != nil {
+		startingNodes = f(conn.LocalAddr().Network())
+	}
 	cfg := dht.ServerConfig{
 		IPBlocklist:    cl.ipBlockList,
 		Conn:           conn,
@@ -353,21 +435,13 @@ func (cl *Client) newAnacrolixDhtServer(conn net.PacketConn) (s *dht.Server, err
 			}
 			return cl.config.PublicIp4
 		}(),
-		StartingNodes:      cl.config.DhtStartingNodes(conn.LocalAddr().Network()),
+		StartingNodes:      startingNodes,
 		ConnectionTracking: cl.config.ConnTracker,
-		OnQuery:            cl.config.DHTOnQuery,
+		OnQuery:            cl.onDhtQuery,
+		Passive:            cl.config.OutgoingOnly,
 		Logger:             cl.logger.WithContextText(fmt.Sprintf("dht server on %v", conn.LocalAddr().String())),
 	}
 	s, err = dht.NewServer(&cfg)
-	if err == nil {
-		go func() {
-			ts, err := s.Bootstrap()
-			if err != nil {
-				cl.logger.Printf("error bootstrapping dht: %s", err)
-			}
-			log.Fstr("%v completed bootstrap (%v)", s, ts).AddValues(s, ts).Log(cl.logger)
-		}()
-	}
 	return
 }
 
@@ -389,6 +463,9 @@ func (cl *Client) Close() {
 	cl.lock()
 	defer cl.unlock()
 	cl.closed.Set()
+	if cl.topPriorityTimer != nil {
+		cl.topPriorityTimer.Stop()
+	}
 	for _, t := range cl.torrents {
 		t.close()
 	}
@@ -410,7 +487,71 @@ func (cl *Client) ipIsBlocked(ip net.IP) bool {
 	return blocked
 }
 
+func (cl *Client) requestStrategyMaker() requestStrategyMaker {
+	if cl.config.DefaultRequestStrategy != nil {
+		return cl.config.DefaultRequestStrategy
+	}
+	return RequestStrategyDuplicateRequestTimeout(cl.peerRequestTimeout())
+}
+
+func (cl *Client) trackerHostAnnounceConcurrency() int {
+	if n := cl.config.TrackerHostAnnounceConcurrency; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func (cl *Client) lookupGeoIP(ip net.IP) geoip.Info {
+	if cl.config.GeoIP == nil || ip == nil {
+		return geoip.Info{}
+	}
+	info, err := cl.config.GeoIP.Lookup(ip)
+	if err != nil {
+		cl.logger.WithDefaultLevel(log.Debug).Printf("looking up geoip for %v: %v", ip, err)
+	}
+	return info
+}
+
+func (cl *Client) eventCommandConcurrency() int {
+	if n := cl.config.EventCommandConcurrency; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func (cl *Client) peerKeepAliveInterval() time.Duration {
+	if d := cl.config.PeerKeepAliveInterval; d > 0 {
+		return d
+	}
+	return defaultPeerKeepAliveInterval
+}
+
+func (cl *Client) peerIdleTimeout() time.Duration {
+	if d := cl.config.PeerIdleTimeout; d > 0 {
+		return d
+	}
+	return defaultPeerIdleTimeout
+}
+
+func (cl *Client) peerProbationPeriod() time.Duration {
+	if d := cl.config.PeerProbationPeriod; d > 0 {
+		return d
+	}
+	return defaultPeerProbationPeriod
+}
+
+func (cl *Client) peerRequestTimeout() time.Duration {
+	if d := cl.config.PeerRequestTimeout; d > 0 {
+		return d
+	}
+	return defaultPeerRequestTimeout
+}
+
 func (cl *Client) wantConns() bool {
+	// Peers may connect to activate dormant torrents.
+	if len(cl.dormantTorrents) != 0 {
+		return true
+	}
 	for _, t := range cl.torrents {
 		if t.wantConns() {
 			return true
@@ -507,6 +648,12 @@ func (cl *Client) incomingConnection(nc net.Conn) {
 	if tc, ok := nc.(*net.TCPConn); ok {
 		tc.SetLinger(0)
 	}
+	if err := cl.receivePreHandshakeAuth(nc); err != nil {
+		// Close without responding, so as not to reveal what's listening.
+		torrent.Add("pre-handshake auth failures", 1)
+		log.Fmsg("pre-handshake auth from %v: %v", nc.RemoteAddr(), err).SetLevel(log.Debug).Log(cl.logger)
+		return
+	}
 	c := cl.newConnection(nc, false, nc.RemoteAddr(), nc.RemoteAddr().Network(),
 		regularNetConnPeerConnConnString(nc))
 	defer c.close()
@@ -662,7 +809,7 @@ func (cl *Client) noLongerHalfOpen(t *Torrent, addr string) {
 	}
 	delete(t.halfOpen, addr)
 	cl.numHalfOpen--
-	for _, t := range cl.torrents {
+	for _, t := range cl.torrentsByPriority() {
 		t.openNewConns()
 	}
 }
@@ -703,7 +850,16 @@ func (cl *Client) establishOutgoingConnEx(t *Torrent, addr net.Addr, obfuscatedH
 		return t.dialTimeout()
 	}())
 	defer cancel()
-	dr := cl.dialFirst(dialCtx, addr.String())
+	var dr dialResult
+	if oa, ok := addr.(OverlayAddr); ok {
+		var err error
+		dr, err = cl.dialOverlay(dialCtx, oa)
+		if err != nil {
+			return nil, xerrors.Errorf("dialing overlay: %w", err)
+		}
+	} else {
+		dr = cl.dialFirst(dialCtx, addr.String())
+	}
 	nc := dr.Conn
 	if nc == nil {
 		if dialCtx.Err() != nil {
@@ -711,6 +867,10 @@ func (cl *Client) establishOutgoingConnEx(t *Torrent, addr net.Addr, obfuscatedH
 		}
 		return nil, errors.New("dial failed")
 	}
+	if err := cl.sendPreHandshakeAuth(nc); err != nil {
+		nc.Close()
+		return nil, xerrors.Errorf("sending pre-handshake auth: %w", err)
+	}
 	c, err := cl.initiateProtocolHandshakes(context.Background(), nc, t, true, obfuscatedHeader, addr, dr.Network, regularNetConnPeerConnConnString(nc))
 	if err != nil {
 		nc.Close()
@@ -765,6 +925,25 @@ func (cl *Client) outgoingConnection(t *Torrent, addr net.Addr, ps PeerSource, t
 	t.runHandshookConnLoggingErr(c)
 }
 
+// The port to announce to the DHT, which may differ from the listen port where there's port
+// forwarding.
+func (cl *Client) dhtAnnouncePort() int {
+	if cl.config.OutgoingOnly {
+		return 0
+	}
+	if cl.config.DhtAnnouncePort != 0 {
+		return cl.config.DhtAnnouncePort
+	}
+	return cl.incomingPeerPort()
+}
+
+func (cl *Client) dhtAnnounceInterval() time.Duration {
+	if d := cl.config.DhtAnnounceInterval; d > 0 {
+		return d
+	}
+	return defaultDhtAnnounceInterval
+}
+
 // The port number for incoming peer connections. 0 if the client isn't listening.
 func (cl *Client) incomingPeerPort() int {
 	return cl.LocalPort()
@@ -818,7 +997,12 @@ func (cl *Client) forSkeys(f func([]byte) bool) {
 	}
 	for ih := range cl.torrents {
 		if !f(ih[:]) {
-			break
+			return
+		}
+	}
+	for ih := range cl.dormantTorrents {
+		if !f(ih[:]) {
+			return
 		}
 	}
 }
@@ -861,8 +1045,16 @@ func (cl *Client) receiveHandshakes(c *PeerConn) (t *Torrent, err error) {
 		return
 	}
 	cl.lock()
+	if cl.refuseBlockedInfoHash(ih, "handshake", c.RemoteAddr) {
+		cl.unlock()
+		err = ErrInfoHashBlocked
+		return
+	}
 	t = cl.torrents[ih]
 	cl.unlock()
+	if t == nil {
+		t = cl.activateForPeer(ih)
+	}
 	return
 }
 
@@ -930,7 +1122,7 @@ func (cl *Client) runHandshookConn(c *PeerConn, t *Torrent) error {
 		return errors.New("local and remote peer ids are the same")
 	}
 	c.conn.SetWriteDeadline(time.Time{})
-	c.r = deadlineReader{c.conn, c.r}
+	c.r = deadlineReader{c.conn, c.r, cl.peerIdleTimeout()}
 	completedHandshakeConnectionFlags.Add(c.connectionFlags(), 1)
 	if connIsIpv6(c.conn) {
 		torrent.Add("completed handshake over ipv6", 1)
@@ -939,10 +1131,13 @@ func (cl *Client) runHandshookConn(c *PeerConn, t *Torrent) error {
 		return fmt.Errorf("adding connection: %w", err)
 	}
 	defer t.dropConnection(c)
-	go c.writer(time.Minute)
+	go c.writer(cl.peerKeepAliveInterval())
 	cl.sendInitialMessages(c, t)
 	err := c.mainReadLoop()
 	if err != nil {
+		if !isPeerDisconnect(err) {
+			cl.countError(ErrorCategoryPeerProtocol, err)
+		}
 		return fmt.Errorf("main read loop: %w", err)
 	}
 	return nil
@@ -963,7 +1158,7 @@ func (cl *Client) sendInitialMessages(conn *PeerConn, torrent *Torrent) {
 					// If peer requests are buffered on read, this instructs the amount of memory
 					// that might be used to cache pending writes. Assuming 512KiB cached for
 					// sending, for 16KiB chunks.
-					Reqq:         1 << 5,
+					Reqq:         cl.advertisedPeerRequests(),
 					YourIp:       pp.CompactIp(addrIpOrNil(conn.RemoteAddr)),
 					Encryption:   cl.config.HeaderObfuscationPolicy.Preferred || !cl.config.HeaderObfuscationPolicy.RequirePreferred,
 					Port:         cl.incomingPeerPort(),
@@ -976,23 +1171,25 @@ func (cl *Client) sendInitialMessages(conn *PeerConn, torrent *Torrent) {
 				if !cl.config.DisablePEX {
 					msg.M[pp.ExtensionNamePex] = pexExtendedId
 				}
+				if cl.config.EnableMerkleTorrents {
+					msg.M[pp.ExtensionNameHashPiece] = hashPieceExtendedId
+				}
 				return bencode.MustMarshal(msg)
 			}(),
 		})
 	}
 	func() {
 		if conn.fastEnabled() {
-			if torrent.haveAllPieces() {
-				conn.post(pp.Message{Type: pp.HaveAll})
-				conn.sentHaves.AddRange(0, bitmap.BitIndex(conn.t.NumPieces()))
+			if torrent.advertiseAllPieces() {
+				conn.postHaveAll()
 				return
-			} else if !torrent.haveAnyPieces() {
+			} else if !torrent.advertiseAnyPieces() {
 				conn.post(pp.Message{Type: pp.HaveNone})
 				conn.sentHaves.Clear()
 				return
 			}
 		}
-		conn.postBitfield()
+		conn.discloseInitialPieces()
 	}()
 	if conn.PeerExtensionBytes.SupportsDHT() && cl.config.Extensions.SupportsDHT() && cl.haveDhtServer() {
 		conn.post(pp.Message{
@@ -1002,6 +1199,22 @@ func (cl *Client) sendInitialMessages(conn *PeerConn, torrent *Torrent) {
 	}
 }
 
+// The maximum number of outstanding requests we accept from a peer.
+func (cl *Client) maxPeerRequests() int {
+	if cl.config.SeedOnly {
+		return 4 * maxRequests
+	}
+	return maxRequests
+}
+
+// The request queue length we tell peers about in the extended handshake.
+func (cl *Client) advertisedPeerRequests() int {
+	if cl.config.SeedOnly {
+		return cl.maxPeerRequests()
+	}
+	return 1 << 5
+}
+
 func (cl *Client) dhtPort() (ret uint16) {
 	cl.eachDhtServer(func(s DhtServer) {
 		ret = uint16(missinggo.AddrPort(s.Addr()))
@@ -1098,6 +1311,9 @@ func (cl *Client) newTorrent(ih metainfo.Hash, specStorage storage.ClientImpl) (
 			getPrio: func(p PeerInfo) peerPriority {
 				return bep40PriorityIgnoreError(cl.publicAddr(addrIpOrNil(p.Addr)), p.addr())
 			},
+			isAffine: func(p PeerInfo) bool {
+				return cl.peerAffine(addrIpOrNil(p.Addr))
+			},
 		},
 		conns: make(map[*PeerConn]struct{}, 2*cl.config.EstablishedConnsPerTorrent),
 
@@ -1107,14 +1323,18 @@ func (cl *Client) newTorrent(ih metainfo.Hash, specStorage storage.ClientImpl) (
 		storageOpener:       storageClient,
 		maxEstablishedConns: cl.config.EstablishedConnsPerTorrent,
 
-		networkingEnabled: true,
+		networkingEnabled:      true,
+		dataDownloadDisallowed: cl.config.SeedOnly,
+		hashFailureQuarantine:  cl.config.HashFailureQuarantine,
 		metadataChanged: sync.Cond{
 			L: cl.locker(),
 		},
 		webSeeds: make(map[string]*peer),
+		addedAt:  time.Now(),
 	}
+	t.lastActive = t.addedAt
 	t._pendingPieces.NewSet = priorityBitmapStableNewSet
-	t.requestStrategy = cl.config.DefaultRequestStrategy(t.requestStrategyCallbacks(), &cl._mu)
+	t.requestStrategy = cl.requestStrategyMaker()(t.requestStrategyCallbacks(), &cl._mu)
 	t.logger = cl.logger.WithContextValue(t)
 	t.setChunkSize(defaultChunkSize)
 	return
@@ -1134,21 +1354,52 @@ func (cl *Client) AddTorrentInfoHash(infoHash metainfo.Hash) (t *Torrent, new bo
 
 // Adds a torrent by InfoHash with a custom Storage implementation.
 // If the torrent already exists then this Storage is ignored and the
-// existing torrent returned with `new` set to `false`
+// existing torrent returned with `new` set to `false`. If the infohash is blocked (see
+// BlockInfoHashes), the Torrent returned isn't added, and is already closed, like one dropped when
+// it was blocked.
 func (cl *Client) AddTorrentInfoHashWithStorage(infoHash metainfo.Hash, specStorage storage.ClientImpl) (t *Torrent, new bool) {
 	cl.lock()
 	defer cl.unlock()
+	if cl.refuseBlockedInfoHash(infoHash, "add", nil) {
+		t = cl.newTorrent(infoHash, nil)
+		t.close()
+		return
+	}
+	t, new, dormant := cl.addTorrentInfoHashWithStorage(infoHash, specStorage)
+	if new {
+		t.runEventCommands(TorrentEventAdded, nil)
+	}
+	if dormant != nil {
+		cl.unlock()
+		err := t.MergeSpec(dormant)
+		cl.lock()
+		if err != nil {
+			t.logger.Printf("merging dormant torrent spec: %v", err)
+		}
+	}
+	return
+}
+
+// Adds the Torrent if it isn't already. If it was dormant, it's no longer, and the spec it was added
+// with is returned, to be merged into the Torrent after the lock is released.
+func (cl *Client) addTorrentInfoHashWithStorage(infoHash metainfo.Hash, specStorage storage.ClientImpl) (t *Torrent, new bool, dormant *TorrentSpec) {
 	t, ok := cl.torrents[infoHash]
 	if ok {
 		return
 	}
 	new = true
+	dormant = cl.takeDormantSpec(infoHash)
+	if specStorage == nil && dormant != nil {
+		specStorage = dormant.Storage
+	}
 
 	t = cl.newTorrent(infoHash, specStorage)
 	cl.eachDhtServer(func(s DhtServer) {
 		go t.dhtAnnouncer(s)
 	})
 	cl.torrents[infoHash] = t
+	cl.takeDhtStoredPeers(t)
+	cl.updateTopPriority()
 	cl.clearAcceptLimits()
 	t.updateWantPeersEvent()
 	// Tickle Client.waitAccept, new torrent may want conns.
@@ -1159,10 +1410,39 @@ func (cl *Client) AddTorrentInfoHashWithStorage(infoHash metainfo.Hash, specStor
 // Add or merge a torrent spec. Returns new if the torrent wasn't already in the client. See also
 // Torrent.MergeSpec.
 func (cl *Client) AddTorrentSpec(spec *TorrentSpec) (t *Torrent, new bool, err error) {
-	t, new = cl.AddTorrentInfoHashWithStorage(spec.InfoHash, spec.Storage)
-	err = t.MergeSpec(spec)
+	t, new, err = cl.addTorrentSpec(spec)
+	if err == nil && new {
+		cl.lock()
+		t.runEventCommands(TorrentEventAdded, nil)
+		cl.unlock()
+	}
+	return
+}
+
+// Adds a torrent spec without running event commands, as when activating dormant torrents.
+func (cl *Client) addTorrentSpec(spec *TorrentSpec) (t *Torrent, new bool, err error) {
+	cl.lock()
+	if cl.refuseBlockedInfoHash(spec.InfoHash, "add", nil) {
+		cl.unlock()
+		err = ErrInfoHashBlocked
+		return
+	}
+	t, new, dormant := cl.addTorrentInfoHashWithStorage(spec.InfoHash, spec.Storage)
+	if new && (spec.DisallowDhtAnnounce || dormant != nil && dormant.DisallowDhtAnnounce) {
+		// Before the DHT announcers the torrent was added with get the lock. MergeSpec is too late.
+		t.dhtAnnouncesDisallowed.Set()
+	}
+	cl.unlock()
+	if dormant != nil && dormant != spec {
+		err = t.MergeSpec(dormant)
+	}
+	if err == nil {
+		err = t.MergeSpec(spec)
+	}
 	if err != nil && new {
-		t.Drop()
+		cl.lock()
+		cl.dropTorrent(spec.InfoHash)
+		cl.unlock()
 	}
 	return
 }
@@ -1175,17 +1455,32 @@ func (stringAddr) Network() string   { return "" }
 func (me stringAddr) String() string { return string(me) }
 
 // The trackers will be merged with the existing ones. If the Info isn't yet known, it will be set.
-// spec.DisallowDataDownload/Upload will be read and applied
+// spec.DisallowDataDownload/Upload and spec.SeedMode will be read and applied
 // The display name is replaced if the new spec provides one. Note that any `Storage` is ignored.
 func (t *Torrent) MergeSpec(spec *TorrentSpec) error {
 	if spec.DisplayName != "" {
 		t.SetDisplayName(spec.DisplayName)
 	}
+	for i, path := range spec.FilePaths {
+		// This must happen before the info is set, so that no data is written to the default
+		// paths.
+		err := t.setFilePath(i, path)
+		if err != nil {
+			return err
+		}
+	}
+	if spec.SeedMode {
+		t.cl.lock()
+		t.enterSeedMode()
+		t.cl.unlock()
+	}
 	if spec.InfoBytes != nil {
 		err := t.SetInfoBytes(spec.InfoBytes)
 		if err != nil {
 			return err
 		}
+	} else {
+		t.useCachedMetadata()
 	}
 	cl := t.cl
 	cl.AddDhtNodes(spec.DhtNodes)
@@ -1193,7 +1488,7 @@ func (t *Torrent) MergeSpec(spec *TorrentSpec) error {
 	defer cl.unlock()
 	useTorrentSources(spec.Sources, t)
 	for _, url := range spec.Webseeds {
-		t.addWebSeed(url)
+		t.addWebSeed(url, WebSeedOpts{})
 	}
 	for _, peerAddr := range spec.PeerAddrs {
 		t.addPeer(PeerInfo{
@@ -1209,6 +1504,13 @@ func (t *Torrent) MergeSpec(spec *TorrentSpec) error {
 	t.maybeNewConns()
 	t.dataDownloadDisallowed = spec.DisallowDataDownload
 	t.dataUploadDisallowed = spec.DisallowDataUpload
+	if spec.DisallowDhtAnnounce {
+		t.dhtAnnouncesDisallowed.Set()
+	}
+	t.addLabels(spec.Labels)
+	if spec.Priority != TorrentPriorityNormal {
+		t.setPriority(spec.Priority)
+	}
 	return nil
 }
 
@@ -1266,6 +1568,8 @@ func (cl *Client) dropTorrent(infoHash metainfo.Hash) (err error) {
 		panic(err)
 	}
 	delete(cl.torrents, infoHash)
+	delete(cl.lazyActive, infoHash)
+	cl.updateTopPriority()
 	return
 }
 
@@ -1382,10 +1686,18 @@ func (cl *Client) newConnection(nc net.Conn, outgoing bool, remoteAddr net.Addr,
 	c.peerImpl = c
 	c.logger = cl.logger.WithDefaultLevel(log.Warning).WithContextValue(c)
 	c.writerCond.L = cl.locker()
+	c.networkClass = peerNetworkClass(addrIpOrNil(remoteAddr))
+	c.geoIP = cl.lookupGeoIP(addrIpOrNil(remoteAddr))
+	c.affine = cl.peerAffine(addrIpOrNil(remoteAddr))
 	c.setRW(connStatsReadWriter{nc, c})
+	downloadLimiter := cl.config.DownloadRateLimiter
+	if cl.rateLimitExempt(c.networkClass) {
+		downloadLimiter = unlimited
+	}
 	c.r = &rateLimitedReader{
-		l: cl.config.DownloadRateLimiter,
-		r: c.r,
+		l:    downloadLimiter,
+		r:    c.r,
+		cost: c.rateLimitCost,
 	}
 	c.logger.WithDefaultLevel(log.Debug).Printf("initialized with remote %v over network %v (outgoing=%t)", remoteAddr, network, outgoing)
 	return
@@ -1394,14 +1706,17 @@ func (cl *Client) newConnection(nc net.Conn, outgoing bool, remoteAddr net.Addr,
 func (cl *Client) onDHTAnnouncePeer(ih metainfo.Hash, ip net.IP, port int, portOk bool) {
 	cl.lock()
 	defer cl.unlock()
+	cl.dhtFamilyCounts[dhtIpFamily(ip)].AnnouncePeersReceived.Add(1)
+	pi := PeerInfo{
+		Addr:   ipPortAddr{ip, port},
+		Source: PeerSourceDhtAnnouncePeer,
+	}
 	t := cl.torrent(ih)
 	if t == nil {
+		cl.storeDhtAnnouncedPeer(ih, pi)
 		return
 	}
-	t.addPeers([]PeerInfo{{
-		Addr:   ipPortAddr{ip, port},
-		Source: PeerSourceDhtAnnouncePeer,
-	}})
+	t.addPeers([]PeerInfo{pi})
 }
 
 func firstNotNil(ips ...net.IP) net.IP {
@@ -1552,3 +1867,9 @@ func (cl *Client) String() string {
 func (cl *Client) ConnStats() ConnStats {
 	return cl.stats.Copy()
 }
+
+// Returns how the default storage served reads, with ok false if it doesn't report them. See
+// storage.ReadStatsReporter.
+func (cl *Client) StorageReadStats() (storage.ReadStats, bool) {
+	return cl.defaultStorage.ReadStats()
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -219,6 +220,39 @@ func BenchmarkAddLargeTorrent(b *testing.B) {
 	}
 }
 
+// Run with -benchtime=10000x to compare modes at 10k seeded torrents.
+func benchmarkAddSeededTorrents(b *testing.B, seedOnly bool) {
+	cfg := TestingConfig()
+	cfg.DisableTCP = true
+	cfg.DisableUTP = true
+	cfg.SeedOnly = seedOnly
+	cfg.DefaultStorage = badStorage{}
+	cl, err := NewClient(cfg)
+	require.NoError(b, err)
+	defer cl.Close()
+	b.ReportAllocs()
+	for i := range iter.N(b.N) {
+		infoBytes, err := bencode.Marshal(metainfo.Info{
+			Name:        fmt.Sprintf("seeded-%d", i),
+			PieceLength: 5,
+			Length:      13,
+			Pieces:      make([]byte, 3*metainfo.HashSize),
+		})
+		require.NoError(b, err)
+		if _, err := cl.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAddSeededTorrents(b *testing.B) {
+	benchmarkAddSeededTorrents(b, false)
+}
+
+func BenchmarkAddSeededTorrentsSeedOnly(b *testing.B) {
+	benchmarkAddSeededTorrents(b, true)
+}
+
 func TestResponsive(t *testing.T) {
 	seederDataDir, mi := testutil.GreetingTestTorrent()
 	defer os.RemoveAll(seederDataDir)
@@ -810,3 +844,49 @@ func TestClientDisabledImplicitNetworksButDhtEnabled(t *testing.T) {
 	assert.Empty(t, cl.listeners)
 	assert.NotEmpty(t, cl.DhtServers())
 }
+
+func TestEventCommands(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	cfg := TestingConfig()
+	out := filepath.Join(cfg.DataDir, "events")
+	cfg.EventCommands = []EventCommand{
+		{Event: TorrentEventAdded, Path: sh, Args: []string{"-c", `echo "$TORRENT_EVENT ${TORRENT_INFOHASH}" >> ` + out}},
+		{Event: TorrentEventRemoved, Path: sh, Args: []string{"-c", `echo "$TORRENT_EVENT $TORRENT_INFOHASH" >> ` + out}},
+	}
+	cfg.EventCommandConcurrency = 1
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ih := metainfo.Hash{1}
+	tor, new := cl.AddTorrentInfoHash(ih)
+	require.True(t, new)
+	waitEvents := func(want string) {
+		for i := 0; ; i++ {
+			b, _ := ioutil.ReadFile(out)
+			if string(b) == want {
+				return
+			}
+			require.Less(t, i, 100, "got events %q", b)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	waitEvents("added " + ih.HexString() + "\n")
+	tor.Drop()
+	waitEvents("added " + ih.HexString() + "\nremoved " + ih.HexString() + "\n")
+}
+
+func TestPeerRequestTimeout(t *testing.T) {
+	for _, c := range []struct {
+		config, want time.Duration
+	}{
+		{0, defaultPeerRequestTimeout},
+		{time.Second, time.Second},
+	} {
+		cl := &Client{config: &ClientConfig{PeerRequestTimeout: c.config}}
+		rs := cl.requestStrategyMaker()(nil, cl.locker())
+		assert.Equal(t, c.want, rs.(requestStrategyDuplicateRequestTimeout).duplicateRequestTimeout)
+	}
+}
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestSync(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := torrent.TestingConfig()
+	cfg.DataDir = greetingTempDir
+	seeder, err := torrent.NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	s := httptest.NewServer(&Server{Client: seeder, Secret: "hunter2"})
+	defer s.Close()
+
+	leecher, err := torrent.NewClient(torrent.TestingConfig())
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+
+	imported, err := Sync(context.Background(), leecherTorrent, []Peer{{URL: s.URL, Secret: "wrong"}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, imported)
+
+	imported, err = Sync(context.Background(), leecherTorrent, []Peer{{URL: s.URL, Secret: "hunter2"}})
+	require.NoError(t, err)
+	assert.EqualValues(t, leecherTorrent.NumPieces(), imported)
+	assert.EqualValues(t, 0, leecherTorrent.BytesMissing())
+}
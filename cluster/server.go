@@ -0,0 +1,96 @@
+// Package cluster lets Clients run by the same operator exchange pieces over HTTP before going to
+// the public swarm. Each member runs a Server, and fetches pieces from the others with Sync.
+// Members authenticate with a shared secret, so Servers should be exposed over TLS or on a trusted
+// network.
+package cluster
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const secretHeader = "X-Cluster-Secret"
+
+// Serves the completed pieces of a Client's torrents to other members of the cluster.
+//
+// GET /<infohash>/pieces returns a bitfield of completed pieces, in the same layout as the peer
+// protocol. GET /<infohash>/pieces/<index> returns the data for a completed piece.
+type Server struct {
+	Client *torrent.Client
+	Secret string
+}
+
+var _ http.Handler = (*Server)(nil)
+
+func (me *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(me.Secret)) != 1 {
+		http.Error(w, "bad secret", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[1] != "pieces" {
+		http.NotFound(w, r)
+		return
+	}
+	var ih metainfo.Hash
+	if err := ih.FromHexString(parts[0]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, ok := me.Client.Torrent(ih)
+	if !ok || t.Info() == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch len(parts) {
+	case 2:
+		w.Write(completedBitfield(t))
+	case 3:
+		index, err := strconv.ParseInt(parts[2], 10, 0)
+		if err != nil || index < 0 || int(index) >= t.NumPieces() {
+			http.Error(w, "bad piece index", http.StatusBadRequest)
+			return
+		}
+		me.servePiece(w, t.Piece(int(index)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (me *Server) servePiece(w http.ResponseWriter, p *torrent.Piece) {
+	if !p.State().Complete {
+		http.Error(w, "piece not complete", http.StatusNotFound)
+		return
+	}
+	b := make([]byte, p.Info().Length())
+	n, err := p.ReadUnverified(b, 0)
+	if n != len(b) {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		http.Error(w, "error reading piece: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+func completedBitfield(t *torrent.Torrent) []byte {
+	ret := make([]byte, (t.NumPieces()+7)/8)
+	for i := 0; i < t.NumPieces(); i++ {
+		if t.PieceState(i).Complete {
+			ret[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Another member of the cluster.
+type Peer struct {
+	// The base URL of the member's Server.
+	URL    string
+	Secret string
+	// Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (me Peer) httpClient() *http.Client {
+	if me.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return me.HTTPClient
+}
+
+func (me Peer) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(me.URL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(secretHeader, me.Secret)
+	resp, err := me.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Returns the pieces the member has completed for the torrent.
+func (me Peer) Pieces(ctx context.Context, ih metainfo.Hash) (bitfield []byte, err error) {
+	resp, err := me.get(ctx, "/"+ih.HexString()+"/pieces")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Returns the data for a piece the member has completed.
+func (me Peer) Piece(ctx context.Context, ih metainfo.Hash, index int) (io.ReadCloser, error) {
+	resp, err := me.get(ctx, fmt.Sprintf("/%s/pieces/%d", ih.HexString(), index))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Fetches every incomplete piece of the torrent that any of the peers has, and imports it with
+// Torrent.ImportPiece. Pieces that fail to transfer or verify from one peer are tried from the
+// next. To avoid fetching from the public swarm in the meantime, call Torrent.DisallowDataDownload
+// beforehand.
+func Sync(ctx context.Context, t *torrent.Torrent, peers []Peer) (imported int, err error) {
+	if t.Info() == nil {
+		return 0, errors.New("torrent info not available")
+	}
+	ih := t.InfoHash()
+	bitfields := make([][]byte, len(peers))
+	for i, p := range peers {
+		// A member that can't be reached just doesn't contribute.
+		bitfields[i], _ = p.Pieces(ctx, ih)
+	}
+	for index := 0; index < t.NumPieces(); index++ {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+		if t.PieceState(index).Complete {
+			continue
+		}
+		for i, p := range peers {
+			if !bitfieldHas(bitfields[i], index) {
+				continue
+			}
+			if importPiece(ctx, t, p, index) == nil {
+				imported++
+				break
+			}
+		}
+	}
+	return imported, ctx.Err()
+}
+
+func importPiece(ctx context.Context, t *torrent.Torrent, p Peer, index int) error {
+	r, err := p.Piece(ctx, t.InfoHash(), index)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return t.ImportPiece(index, r)
+}
+
+func bitfieldHas(bf []byte, index int) bool {
+	if index/8 >= len(bf) {
+		return false
+	}
+	return bf[index/8]&(0x80>>uint(index%8)) != 0
+}
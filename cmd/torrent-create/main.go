@@ -25,6 +25,14 @@ func main() {
 		EmptyAnnounceList bool     `name:"n" help:"exclude default announce-list entries"`
 		Comment           string   `name:"t" help:"comment"`
 		CreatedBy         string   `name:"c" help:"created by"`
+		CreationDate      int64    `name:"d" help:"creation date in seconds since the epoch, instead of SOURCE_DATE_EPOCH or the current time"`
+		NoCreationDate    bool     `help:"omit the creation date"`
+		Exclude           []string `name:"x" help:"pattern of files to leave out, matched against base names, or relative paths if it contains a slash"`
+		Include           []string `name:"i" help:"pattern of files to include, if any are given, matched as for -x"`
+		ExcludeHidden     bool     `help:"leave out files and directories with names starting with a dot"`
+		ExcludeJunk       bool     `help:"leave out files left by operating systems, such as .DS_Store and Thumbs.db"`
+		Symlinks          string   `help:"how symlinks are handled: follow, skip or reject"`
+		GroupDirs         bool     `help:"order files by path components, keeping each directory's files together"`
 		tagflag.StartPos
 		Root string
 	}
@@ -45,10 +53,36 @@ func main() {
 	if len(args.CreatedBy) > 0 {
 		mi.CreatedBy = args.CreatedBy
 	}
+	if args.CreationDate != 0 {
+		mi.CreationDate = args.CreationDate
+	}
+	if args.NoCreationDate {
+		mi.CreationDate = 0
+	}
 	info := metainfo.Info{
 		PieceLength: 256 * 1024,
 	}
-	err := info.BuildFromFilePath(args.Root)
+	opts := metainfo.BuildOpts{
+		Exclude:       args.Exclude,
+		Include:       args.Include,
+		ExcludeHidden: args.ExcludeHidden,
+	}
+	if args.ExcludeJunk {
+		opts.Exclude = append(opts.Exclude, metainfo.JunkFiles...)
+	}
+	switch args.Symlinks {
+	case "", "follow":
+	case "skip":
+		opts.Symlinks = metainfo.SkipSymlinks
+	case "reject":
+		opts.Symlinks = metainfo.RejectSymlinks
+	default:
+		log.Fatalf("unknown symlink policy %q", args.Symlinks)
+	}
+	if args.GroupDirs {
+		opts.Less = metainfo.FilesByPathComponents
+	}
+	err := info.BuildFromFilePathOpts(args.Root, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"fmt"
 	"io"
 	"log"
@@ -49,7 +48,7 @@ func verifyTorrent(info *metainfo.Info, root string) error {
 	span.InitIndex()
 	for i := range iter.N(info.NumPieces()) {
 		p := info.Piece(i)
-		hash := sha1.New()
+		hash := metainfo.NewPieceHash()
 		_, err := io.Copy(hash, io.NewSectionReader(span, p.Offset(), p.Length()))
 		if err != nil {
 			return err
@@ -290,6 +290,8 @@ func downloadErr(args []string, parent *tagflag.Parser) error {
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		client.WriteStatus(w)
 	})
+	http.Handle("/healthz", client.HealthHandler())
+	http.Handle("/readyz", client.HealthHandler())
 	err = addTorrents(client)
 	if err != nil {
 		return fmt.Errorf("adding torrents: %w", err)
@@ -0,0 +1,46 @@
+package torrent
+
+// Handles a torrent that has finished downloading. files are the torrent's files, in info order.
+// Returning an error stops the remaining hooks in the pipeline.
+type CompletionHook func(t *Torrent, files []*File) error
+
+// Starts the completion hooks if the torrent has just become complete. Hooks run once per
+// completion: they're not run for data that's already complete when the torrent is loaded, nor when
+// a recheck confirms complete data, but are run again if a recheck finds a bad piece that is then
+// redownloaded.
+func (t *Torrent) maybeRunCompletionHooks() {
+	if !t.gotMetainfo.IsSet() || t.completionHooksRan || !t.haveAllPieces() || !t.allFilesScanned() {
+		return
+	}
+	t.completionHooksRan = true
+	t.runEventCommands(TorrentEventCompleted, nil)
+	hooks := append([]CompletionHook(nil), t.cl.config.CompletionHooks...)
+	if len(hooks) == 0 {
+		return
+	}
+	files := append([]*File(nil), *t.files...)
+	go t.runCompletionHooks(hooks, files)
+}
+
+func (t *Torrent) runCompletionHooks(hooks []CompletionHook, files []*File) {
+	// Don't overlap with a run for an earlier completion.
+	t.completionHooksMu.Lock()
+	defer t.completionHooksMu.Unlock()
+	for i, h := range hooks {
+		if !t.completeForHooks() {
+			// The pipeline restarts when the torrent completes again.
+			t.logger.Printf("torrent no longer complete, stopping completion hooks")
+			return
+		}
+		if err := h(t, files); err != nil {
+			t.logger.Printf("completion hook %d: %v", i, err)
+			return
+		}
+	}
+}
+
+func (t *Torrent) completeForHooks() bool {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return !t.closed.IsSet() && t.haveAllPieces()
+}
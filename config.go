@@ -14,7 +14,9 @@ import (
 	"github.com/anacrolix/missinggo/v2/conntrack"
 	"golang.org/x/time/rate"
 
+	"github.com/anacrolix/torrent/geoip"
 	"github.com/anacrolix/torrent/iplist"
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/mse"
 	"github.com/anacrolix/torrent/storage"
 )
@@ -30,25 +32,54 @@ type ClientConfig struct {
 	ListenPort              int
 	NoDefaultPortForwarding bool
 	UpnpID                  string
+	// Don't listen for incoming connections or forward ports, for environments where binding ports
+	// isn't possible. Peers are only dialed over TCP, trackers are announced port 0, and the DHT,
+	// unless disabled, is used only to find peers, from ephemeral UDP ports. See
+	// Client.initOutgoingOnly.
+	OutgoingOnly bool `long:"outgoing-only"`
 	// Don't announce to trackers. This only leaves DHT to discover peers.
 	DisableTrackers bool `long:"disable-trackers"`
 	DisablePEX      bool `long:"disable-pex"`
 
 	// Don't create a DHT.
-	NoDHT            bool `long:"disable-dht"`
+	NoDHT bool `long:"disable-dht"`
+	// Returns the nodes each DHT server bootstraps from, by the network of its socket. The default
+	// is the global bootstrap nodes. If nil, or the getter returns no nodes, servers don't bootstrap
+	// until Client.Rebootstrap. See DhtBootstrapNodes.
 	DhtStartingNodes func(network string) dht.StartingNodesGetter
+	// How long each DHT announce for a torrent runs before another is started. Zero means 5
+	// minutes.
+	DhtAnnounceInterval time.Duration
+	// The port announced to the DHT, if different from the listen port, such as when an external
+	// port is forwarded to it. Zero means the listen port.
+	DhtAnnouncePort int
+	// Don't ask DHT nodes to use the source port of our announces instead of the announced port
+	// (implied_port in BEP 5). The DHT shares the uTP socket by default, so the source port is
+	// usually the right one, but it isn't with DhtAnnouncePort, or behind some NATs.
+	DisableDhtImpliedPort bool
 	// Never send chunks to peers.
 	NoUpload bool `long:"no-upload"`
+	// How the pieces we have are disclosed to peers. The default sends the bitfield. See
+	// BitfieldPolicy.
+	BitfieldPolicy BitfieldPolicy
 	// Disable uploading even when it isn't fair.
 	DisableAggressiveUpload bool `long:"disable-aggressive-upload"`
 	// Upload even after there's nothing in it for us. By default uploading is
 	// not altruistic, we'll only upload to encourage the peer to reciprocate.
 	Seed bool `long:"seed"`
+	// Torrents are added with data download disallowed (see Torrent.AllowDataDownload), and peers
+	// may have more outstanding requests. Implies Seed.
+	SeedOnly bool `long:"seed-only"`
 	// Only applies to chunks uploaded to peers, to maintain responsiveness
 	// communicating local Client state to peers. Each limiter token
 	// represents one byte. The Limiter's burst must be large enough to fit a
 	// whole chunk, which is usually 16 KiB (see TorrentSpec.ChunkSize).
 	UploadRateLimiter *rate.Limiter
+	// The fraction of UploadRateLimiter's rate, between 0 and 1, reserved for peers that have no
+	// pieces yet. Other peers are held to the rest, so that new peers can still join the swarm
+	// when established peers would use all of it. Such peers are also uploaded to when they have
+	// nothing we want, within the usual allowance. Applied when the Client is created.
+	NewPeerUploadReservation float64
 	// Rate limits all reads from connections to peers. Each limiter token
 	// represents one byte. The Limiter's burst must be bigger than the
 	// largest Read performed on a the underlying rate-limiting io.Reader
@@ -63,10 +94,21 @@ type ClientConfig struct {
 	DisableUTP bool
 	// For the bittorrent protocol.
 	DisableTCP bool `long:"disable-tcp"`
+	// Peers with addresses on these networks are dialed through them. Addresses are claimed by the
+	// first network to parse them. HTTP trackers with hosts on them are announced to through them
+	// too, and told the network's Listener address if it has one, rather than the client's IPs. See
+	// OverlayNetwork, and the i2p package.
+	OverlayNetworks []OverlayNetwork
 	// Called to instantiate storage for each added torrent. Builtin backends
 	// are in the storage package. If not set, the "file" implementation is
 	// used (and Closed when the Client is Closed).
 	DefaultStorage storage.ClientImpl
+	// If set, info bytes obtained from peers are saved to it, and it's checked for the info of
+	// torrents added without it.
+	MetadataCache MetadataCache
+	// If positive, up to this many bytes of piece data read for peers' requests are kept in memory,
+	// shared by all torrents, so pieces many peers request at once are read from storage once.
+	UploadCacheSize int64
 
 	HeaderObfuscationPolicy HeaderObfuscationPolicy
 	// The crypto methods to offer when initiating connections with header obfuscation.
@@ -112,6 +154,17 @@ type ClientConfig struct {
 	// impact of a few bad apples. 4s loses 1% of successful handshakes that
 	// are obtained with 60s timeout, and 5% of unsuccessful handshakes.
 	HandshakesTimeout time.Duration
+	// How long to go without writing to a peer before sending a keep-alive. Zero means a minute.
+	PeerKeepAliveInterval time.Duration
+	// Connections that receive nothing, not even a keep-alive, for this long are closed. Peers
+	// usually send keep-alives every two minutes. Zero means 150 seconds.
+	PeerIdleTimeout time.Duration
+	// How long a connection gets to prove itself useful before it can be dropped to make room for
+	// others. Zero means a minute.
+	PeerProbationPeriod time.Duration
+	// How long to wait for a requested chunk before it may be requested from another peer. Only
+	// applies when DefaultRequestStrategy is nil. Zero means 5 seconds.
+	PeerRequestTimeout time.Duration
 
 	// The IP addresses as our peers should see them. May differ from the
 	// local interfaces due to NAT or other network configurations.
@@ -119,23 +172,118 @@ type ClientConfig struct {
 	PublicIp6 net.IP
 
 	DisableAcceptRateLimiting bool
+	// If set, peer connections over TCP and uTP must start with a token authenticated with this
+	// key, ahead of the BitTorrent handshake, and outgoing connections send one. Connections
+	// without a valid token are closed without a response, so the listener doesn't reveal itself
+	// to scanners. Only peers configured with the same key can connect, in either direction.
+	PreHandshakeAuthKey []byte
 	// Don't add connections that have the same peer ID as an existing
 	// connection for a given Torrent.
 	DropDuplicatePeerIds bool
+	// Divides the requests made to a peer that serves several of our torrents between them,
+	// weighted by their priorities, instead of each torrent requesting as much as it would alone.
+	// See Torrent.SetPriority.
+	PeerRequestFairness bool
 
 	ConnTracker *conntrack.Instance
 
 	// OnQuery hook func
 	DHTOnQuery func(query *krpc.Msg, source net.Addr) (propagate bool)
 
+	// If nil, RequestStrategyDuplicateRequestTimeout(PeerRequestTimeout) is used.
 	DefaultRequestStrategy requestStrategyMaker
 
+	// The maximum number of torrents activated from dormancy (see Client.AddDormantTorrentSpec)
+	// to keep active. The least recently used are returned to dormancy. Zero is unlimited.
+	MaxLazyActiveTorrents int
+	// How often each dormant torrent is activated to announce to its trackers and the DHT, so that
+	// peers can find it. It returns to dormancy as other torrents are activated. Zero defaults to 30
+	// minutes, and negative values disable it.
+	DormantAnnounceInterval time.Duration
+
+	// The maximum number of concurrent announces to any one tracker host, across all torrents.
+	// Values less than 1 are treated as 1.
+	TrackerHostAnnounceConcurrency int
+
 	Extensions PeerExtensionBits
 
+	// Annotates peers with their country and autonomous system. See PeerConn.GeoIP.
+	GeoIP geoip.Provider
+
+	// ISP-friendly peer selection. Peers in these networks are connected to before others, and
+	// their connections are kept over others that are as useful.
+	PreferredPeerNetworks []*net.IPNet
+	// Also prefer peers in the same autonomous system as our public IP, using GeoIP. This requires
+	// PublicIp4 or PublicIp6, or listening on a specific address.
+	PreferSameASNPeers bool
+
+	// The default for Torrent.SetHashFailureQuarantine. The zero value disables quarantine.
+	HashFailureQuarantine HashFailureQuarantine
+
+	// Don't apply UploadRateLimiter and DownloadRateLimiter to peers on the local network, so
+	// transfers over a LAN aren't held to an internet connection's limits. See PeerNetworkClass.
+	ExemptLocalPeersFromRateLimits bool
+
+	// Readers that haven't been read from or moved for this long stop raising piece priorities
+	// until they're used again, so that abandoned readers don't hold on to them. Zero, the default,
+	// disables this.
+	ReaderIdleTimeout time.Duration
+	// After a reader moves away from pieces, they keep Normal priority for this long, so that
+	// seeking away and back again doesn't abandon them. Zero, the default, disables this.
+	ReaderPriorityLinger time.Duration
+
+	// Support BEP 30 merkle torrents, which have a root hash in place of piece hashes. Torrents
+	// with such infos fail to add otherwise.
+	EnableMerkleTorrents bool
+
 	DisableWebtorrent bool
 	DisableWebseeds   bool
+	// Decides how much downloading webseeds take on when peers can also supply pieces. The zero
+	// value leaves webseeds unrestricted.
+	WebSeedArbitration WebSeedArbitration
 
 	Callbacks Callbacks
+
+	// Run in order, on a separate goroutine and without the Client lock, each time a torrent
+	// finishes downloading. See the unpack package for a hook that extracts archives.
+	CompletionHooks []CompletionHook
+
+	// Run in order on each file when it completes. Its pieces aren't advertised or uploaded, and
+	// its OnHashed handlers, completion hooks and the completed event wait, until every scanner
+	// passes it. A rejection quarantines the torrent.
+	ContentScanners []ContentScanner
+
+	// External programs run on torrent events. See EventCommand.
+	EventCommands []EventCommand
+	// Commands still running after this long are killed. Defaults to a minute if zero.
+	EventCommandTimeout time.Duration
+	// The maximum number of event commands run at once. Values less than 1 are treated as 1.
+	EventCommandConcurrency int
+
+	// Downloading is paused while the default storage reports less than this many bytes available
+	// (see storage.SpaceReporter), and resumes when there's at least this much again. Reads waiting
+	// for data fail meanwhile, with storage.ErrDiskFull. See also TorrentEventPaused. Zero disables.
+	MinFreeSpace int64
+	// How often available space is checked for MinFreeSpace.
+	FreeSpaceCheckInterval time.Duration
+
+	// Automatic actions on torrents, such as removing them once seeded. See LifecycleRule.
+	LifecycleRules []LifecycleRule
+	// How often LifecycleRules are evaluated.
+	LifecycleRuleInterval time.Duration
+
+	// The period error rates are measured over, for Client.ErrorStats and ErrorAlarmThresholds.
+	// Defaults to a minute if zero.
+	ErrorRateWindow time.Duration
+	// Errors per second, by category, over which Callbacks.ErrorAlarm is called.
+	ErrorAlarmThresholds map[ErrorCategory]float64
+
+	// If positive, the most pieces hashed at once across all torrents, given out to the torrents
+	// being read first, then by TorrentPriority. Each torrent still hashes at most two pieces at a
+	// time. See Client.QueueDataVerification.
+	PieceHashers int
+	// Hashes pieces to verify them. Defaults to crypto/sha1. See metainfo.PieceHasher.
+	PieceHasher metainfo.PieceHasher
 }
 
 func (cfg *ClientConfig) SetListenAddr(addr string) *ClientConfig {
@@ -146,6 +294,15 @@ func (cfg *ClientConfig) SetListenAddr(addr string) *ClientConfig {
 	return cfg
 }
 
+// Used where the ClientConfig fields are zero.
+const (
+	defaultPeerKeepAliveInterval = time.Minute
+	defaultPeerIdleTimeout       = 150 * time.Second
+	defaultPeerProbationPeriod   = time.Minute
+	defaultPeerRequestTimeout    = 5 * time.Second
+	defaultDhtAnnounceInterval   = 5 * time.Minute
+)
+
 func NewDefaultClientConfig() *ClientConfig {
 	cc := &ClientConfig{
 		HTTPUserAgent:                  "Go-Torrent/1.0",
@@ -160,6 +317,11 @@ func NewDefaultClientConfig() *ClientConfig {
 		TorrentPeersHighWater:          500,
 		TorrentPeersLowWater:           50,
 		HandshakesTimeout:              4 * time.Second,
+		DhtAnnounceInterval:            defaultDhtAnnounceInterval,
+		PeerKeepAliveInterval:          defaultPeerKeepAliveInterval,
+		PeerIdleTimeout:                defaultPeerIdleTimeout,
+		PeerProbationPeriod:            defaultPeerProbationPeriod,
+		PeerRequestTimeout:             defaultPeerRequestTimeout,
 		DhtStartingNodes: func(network string) dht.StartingNodesGetter {
 			return func() ([]dht.Addr, error) { return dht.GlobalBootstrapAddrs(network) }
 		},
@@ -177,7 +339,11 @@ func NewDefaultClientConfig() *ClientConfig {
 		ListenPort:     42069,
 		Logger:         log.Default,
 
-		DefaultRequestStrategy: RequestStrategyDuplicateRequestTimeout(5 * time.Second),
+		TrackerHostAnnounceConcurrency: 2,
+		EventCommandTimeout:            time.Minute,
+		EventCommandConcurrency:        4,
+		FreeSpaceCheckInterval:         time.Minute,
+		LifecycleRuleInterval:          time.Minute,
 
 		Extensions: defaultPeerExtensionBytes(),
 	}
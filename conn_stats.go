@@ -47,6 +47,14 @@ func (me *ConnStats) Copy() (ret ConnStats) {
 	return
 }
 
+// Adds each of other's counts to ours.
+func (me *ConnStats) add(other *ConnStats) {
+	for i := 0; i < reflect.TypeOf(ConnStats{}).NumField(); i++ {
+		n := reflect.ValueOf(other).Elem().Field(i).Addr().Interface().(*Count).Int64()
+		reflect.ValueOf(me).Elem().Field(i).Addr().Interface().(*Count).Add(n)
+	}
+}
+
 type Count struct {
 	n int64
 }
@@ -69,6 +77,10 @@ func (me *Count) MarshalJSON() ([]byte, error) {
 	return json.Marshal(me.n)
 }
 
+func (me *Count) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &me.n)
+}
+
 func (cs *ConnStats) wroteMsg(msg *pp.Message) {
 	// TODO: Track messages and not just chunks.
 	switch msg.Type {
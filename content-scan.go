@@ -0,0 +1,220 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+)
+
+// Checks the data of a completed file, such as with a virus scanner or a format validator. It's
+// called without the Client lock, and may read the file with File.NewReader. Returning an error
+// rejects the file, which quarantines the torrent. ctx is done if the file becomes incomplete or
+// the torrent is closed. See ClientConfig.ContentScanners.
+type ContentScanner func(ctx context.Context, f *File) error
+
+// A file rejected by a ContentScanner.
+type ContentScanRejection struct {
+	// The file's DisplayPath.
+	File string
+	Err  error
+}
+
+func (me ContentScanRejection) String() string {
+	return fmt.Sprintf("content scan rejected %q: %v", me.File, me.Err)
+}
+
+type fileScanState int
+
+const (
+	fileScanNone fileScanState = iota
+	fileScanPending
+	fileScanPassed
+	fileScanRejected
+)
+
+func (t *Torrent) scanningContent() bool {
+	return len(t.cl.config.ContentScanners) != 0
+}
+
+// Whether the piece may be advertised and uploaded. When scanning, that's once every file it's in
+// has passed. Scan results aren't stored, so data complete when the torrent is loaded is scanned
+// again before it's seeded.
+func (t *Torrent) pieceScanned(piece pieceIndex) bool {
+	if !t.scanningContent() {
+		return true
+	}
+	for _, f := range t.piece(piece).files {
+		if f.scan != fileScanPassed {
+			return false
+		}
+	}
+	return true
+}
+
+// The completed pieces that peers may be told about.
+func (t *Torrent) advertisedPieces() (ret bitmap.Bitmap) {
+	ret = t._completedPieces.Copy()
+	if !t.scanningContent() {
+		return
+	}
+	t._completedPieces.IterTyped(func(piece int) bool {
+		if !t.pieceScanned(piece) {
+			ret.Remove(piece)
+		}
+		return true
+	})
+	return
+}
+
+func (t *Torrent) advertiseAllPieces() bool {
+	return t.haveAllPieces() && t.allFilesScanned()
+}
+
+func (t *Torrent) advertiseAnyPieces() bool {
+	if !t.scanningContent() {
+		return t.haveAnyPieces()
+	}
+	return !t.advertisedPieces().IsEmpty()
+}
+
+func (t *Torrent) allFilesScanned() bool {
+	if !t.scanningContent() {
+		return true
+	}
+	for _, f := range *t.files {
+		if f.scan != fileScanPassed {
+			return false
+		}
+	}
+	return true
+}
+
+// Starts scans of files the hashed piece completed, and abandons those of files it failed.
+func (t *Torrent) updateFileScans(res PieceHashResult) {
+	if !t.scanningContent() {
+		return
+	}
+	for _, f := range t.piece(res.Index).files {
+		if res.Passed {
+			t.maybeStartFileScan(f, res)
+		} else {
+			f.resetScan()
+		}
+	}
+}
+
+// Scans the files that were already complete when the info was obtained.
+func (t *Torrent) startLoadedFileScans() {
+	if !t.scanningContent() {
+		return
+	}
+	for _, f := range *t.files {
+		t.maybeStartFileScan(f, PieceHashResult{Index: f.endPieceIndex() - 1, Passed: true})
+	}
+}
+
+// res is the hash result passed to the file's hashed handlers if the scan passes.
+func (t *Torrent) maybeStartFileScan(f *File, res PieceHashResult) {
+	if f.scan != fileScanNone || !f.piecesComplete() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.scan = fileScanPending
+	f.cancelScan = cancel
+	scanners := append([]ContentScanner(nil), t.cl.config.ContentScanners...)
+	torrent.Add("content scans started", 1)
+	go t.runFileScan(ctx, f, scanners, res)
+}
+
+func (f *File) resetScan() {
+	if f.cancelScan != nil {
+		f.cancelScan()
+		f.cancelScan = nil
+	}
+	f.scan = fileScanNone
+}
+
+func (t *Torrent) cancelFileScans() {
+	if t.files == nil {
+		return
+	}
+	for _, f := range *t.files {
+		f.resetScan()
+	}
+}
+
+func (t *Torrent) runFileScan(ctx context.Context, f *File, scanners []ContentScanner, res PieceHashResult) {
+	var err error
+	for _, s := range scanners {
+		if err = s(ctx, f); err != nil {
+			break
+		}
+	}
+	t.cl.lock()
+	if ctx.Err() != nil || t.closed.IsSet() {
+		// The scan was abandoned.
+		t.cl.unlock()
+		return
+	}
+	f.cancelScan()
+	f.cancelScan = nil
+	if err != nil {
+		t.onFileScanRejected(f, err)
+		t.cl.unlock()
+		return
+	}
+	runHandlers := t.onFileScanPassed(f, res)
+	t.cl.unlock()
+	runHandlers()
+}
+
+// Advertises the file's pieces and completes the torrent if this was the last scan. Returns a func
+// that runs the file's hashed handlers, which were withheld until now. It should be called without
+// the Client lock.
+func (t *Torrent) onFileScanPassed(f *File, res PieceHashResult) func() {
+	f.scan = fileScanPassed
+	torrent.Add("content scans passed", 1)
+	for i := f.firstPieceIndex(); i < f.endPieceIndex(); i++ {
+		if !t.pieceComplete(i) || !t.pieceScanned(i) {
+			continue
+		}
+		for conn := range t.conns {
+			conn.have(i)
+		}
+	}
+	t.maybeRunCompletionHooks()
+	var handlers []*func(FileHashResult)
+	handlers = append(handlers, f.hashedHandlers...)
+	fileRes := FileHashResult{File: f, Complete: true, Piece: res}
+	return func() {
+		for _, h := range handlers {
+			(*h)(fileRes)
+		}
+	}
+}
+
+func (t *Torrent) onFileScanRejected(f *File, err error) {
+	f.scan = fileScanRejected
+	torrent.Add("content scans rejected", 1)
+	if t.quarantined() {
+		return
+	}
+	t.setQuarantine(TorrentQuarantine{ScanRejection: &ContentScanRejection{
+		File: f.DisplayPath(),
+		Err:  err,
+	}})
+}
+
+// Scans rejected files again, such as after the scanners are updated.
+func (t *Torrent) rescanRejectedFiles() {
+	if t.files == nil {
+		return
+	}
+	for _, f := range *t.files {
+		if f.scan == fileScanRejected {
+			f.scan = fileScanNone
+			t.maybeStartFileScan(f, PieceHashResult{Index: f.endPieceIndex() - 1, Passed: true})
+		}
+	}
+}
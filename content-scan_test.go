@@ -0,0 +1,83 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func testContentScanTorrent(t *testing.T, scanner ContentScanner) (*Torrent, func()) {
+	dir, mi := testutil.GreetingTestTorrent()
+	cfg := TestingConfig()
+	cfg.DataDir = dir
+	cfg.ContentScanners = []ContentScanner{scanner}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	return tt, func() {
+		cl.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func (t *Torrent) advertisedPieceCount() int {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.advertisedPieces().Len()
+}
+
+func TestContentScanWithholdsFile(t *testing.T) {
+	release := make(chan struct{})
+	tt, cleanup := testContentScanTorrent(t, func(ctx context.Context, f *File) error {
+		<-release
+		return nil
+	})
+	defer cleanup()
+	completed := make(chan FileHashResult, 1)
+	tt.Files()[0].OnHashed(func(res FileHashResult) {
+		completed <- res
+	})
+	tt.VerifyData()
+	assert.EqualValues(t, tt.Length(), tt.BytesCompleted())
+	assert.Zero(t, tt.advertisedPieceCount())
+	select {
+	case <-completed:
+		t.Fatal("file completed before its scan")
+	default:
+	}
+	close(release)
+	select {
+	case res := <-completed:
+		assert.True(t, res.Complete)
+	case <-time.After(10 * time.Second):
+		t.Fatal("file not completed after its scan")
+	}
+	assert.EqualValues(t, tt.NumPieces(), tt.advertisedPieceCount())
+	_, quarantined := tt.Quarantine()
+	assert.False(t, quarantined)
+}
+
+func TestContentScanRejectionQuarantines(t *testing.T) {
+	tt, cleanup := testContentScanTorrent(t, func(ctx context.Context, f *File) error {
+		return errors.New("infected")
+	})
+	defer cleanup()
+	tt.VerifyData()
+	var q TorrentQuarantine
+	assert.Eventually(t, func() (quarantined bool) {
+		q, quarantined = tt.Quarantine()
+		return
+	}, 10*time.Second, time.Millisecond)
+	require.NotNil(t, q.ScanRejection)
+	assert.Equal(t, testutil.GreetingFileName, q.ScanRejection.File)
+	assert.Contains(t, q.String(), "infected")
+	assert.Zero(t, tt.advertisedPieceCount())
+}
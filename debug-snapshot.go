@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+
+	"github.com/anacrolix/torrent/geoip"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// A point-in-time copy of a Torrent's internal state, for tools and debugging. It holds only plain
+// data, and doesn't change after it's returned.
+type TorrentDebugSnapshot struct {
+	InfoHash metainfo.Hash
+	// Pieces is empty until the info is available.
+	HaveInfo bool
+	Pieces   []PieceDebugState
+	// Connections and webseeds, in order from worst to best, as in the status output.
+	Peers []PeerDebugState
+	Stats TorrentStats
+}
+
+type PieceDebugState struct {
+	Index int
+	PieceState
+	// Chunks that have been written but not yet verified.
+	DirtyChunks int
+	NumChunks   int
+	// The number of connected peers that have the piece.
+	Availability int
+}
+
+// A chunk request, either by us or by a peer.
+type RequestDebugState struct {
+	Index  int
+	Begin  int
+	Length int
+}
+
+type PeerDebugState struct {
+	// The remote address, or the URL for webseeds.
+	Addr string
+	// Set for webseeds, which have no peer protocol connection.
+	WebSeed bool
+	Flags   string
+
+	Interested     bool
+	Choking        bool
+	PeerInterested bool
+	PeerChoking    bool
+
+	// Requests we've sent to the peer that haven't been fulfilled.
+	Requests []RequestDebugState
+	// Requests from the peer we haven't fulfilled.
+	PeerRequests    []RequestDebugState
+	PeerMaxRequests int
+	// The number of pieces the peer has.
+	PeerPieces int
+	// From ClientConfig.GeoIP.
+	GeoIP geoip.Info
+
+	CompletedHandshake  time.Time
+	LastMessageReceived time.Time
+	LastHelpful         time.Time
+	Stats               ConnStats
+}
+
+// Returns a structured dump of the Torrent's piece, connection and request state.
+func (t *Torrent) DebugSnapshot() (ret TorrentDebugSnapshot) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	ret.InfoHash = t.infoHash
+	ret.HaveInfo = t.haveInfo()
+	ret.Stats = t.statsLocked()
+	if ret.HaveInfo {
+		ret.Pieces = make([]PieceDebugState, 0, t.numPieces())
+		for i := 0; i < t.numPieces(); i++ {
+			p := t.piece(i)
+			ret.Pieces = append(ret.Pieces, PieceDebugState{
+				Index:        i,
+				PieceState:   t.pieceState(i),
+				DirtyChunks:  int(p.numDirtyChunks()),
+				NumChunks:    int(p.numChunks()),
+				Availability: t.pieceAvailability(i),
+			})
+		}
+	}
+	peers := t.peersAsSlice()
+	sort.Slice(peers, func(i, j int) bool {
+		return worseConn(peers[i], peers[j])
+	})
+	for _, p := range peers {
+		ret.Peers = append(ret.Peers, p.debugState())
+	}
+	return
+}
+
+func (cn *peer) debugState() (ret PeerDebugState) {
+	if ws, ok := cn.peerImpl.(*webseedPeer); ok {
+		ret.Addr = ws.client.Url
+		ret.WebSeed = true
+	} else if cn.RemoteAddr != nil {
+		ret.Addr = cn.RemoteAddr.String()
+	}
+	ret.Flags = cn.statusFlags()
+	ret.Interested = cn.interested
+	ret.Choking = cn.choking
+	ret.PeerInterested = cn.peerInterested
+	ret.PeerChoking = cn.peerChoking
+	for r := range cn.requests {
+		ret.Requests = append(ret.Requests, requestDebugState(r))
+	}
+	sortRequestDebugStates(ret.Requests)
+	for r := range cn.peerRequests {
+		ret.PeerRequests = append(ret.PeerRequests, requestDebugState(r))
+	}
+	sortRequestDebugStates(ret.PeerRequests)
+	ret.PeerMaxRequests = cn.PeerMaxRequests
+	ret.PeerPieces = cn.numPeerPieces()
+	ret.GeoIP = cn.geoIP
+	ret.CompletedHandshake = cn.completedHandshake
+	ret.LastMessageReceived = cn.lastMessageReceived
+	ret.LastHelpful = cn.lastHelpful()
+	ret.Stats = cn._stats.Copy()
+	return
+}
+
+func (cn *peer) numPeerPieces() int {
+	if cn.peerSentHaveAll {
+		return cn.bestPeerNumPieces()
+	}
+	return cn._peerPieces.Len()
+}
+
+func requestDebugState(r request) RequestDebugState {
+	return RequestDebugState{
+		Index:  int(r.Index),
+		Begin:  int(r.Begin),
+		Length: int(r.Length),
+	}
+}
+
+func sortRequestDebugStates(rs []RequestDebugState) {
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Index != rs[j].Index {
+			return rs[i].Index < rs[j].Index
+		}
+		return rs[i].Begin < rs[j].Begin
+	})
+}
@@ -0,0 +1,225 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/log"
+)
+
+// Each DHT server the Client creates bootstraps from ClientConfig.DhtStartingNodes, filling its
+// routing table by looking up its own ID. After a long time offline the nodes in the table stop
+// responding, and Client.Rebootstrap starts over, from the table if anything is left in it, or from
+// the starting nodes.
+
+// Optionally implemented by a DhtServer, for Client.Rebootstrap and Client.DhtBootstrapStatus. The
+// servers the Client creates implement it.
+type DhtBootstrapper interface {
+	Bootstrap() (dht.TraversalStats, error)
+	// The nodes in the routing table.
+	Nodes() []krpc.NodeInfo
+}
+
+// Returns a ClientConfig.DhtStartingNodes of the addresses, as host:port, resolved for each
+// network. Addresses that don't resolve for a network, such as IPv4 hosts for udp6, are skipped.
+// With no addresses, servers don't bootstrap until Client.Rebootstrap, such as in a closed network
+// where nodes are added with Client.AddDhtNodes.
+func DhtBootstrapNodes(addrs ...string) func(network string) dht.StartingNodesGetter {
+	return func(network string) dht.StartingNodesGetter {
+		return func() (ret []dht.Addr, err error) {
+			if len(addrs) == 0 {
+				return
+			}
+			for _, s := range addrs {
+				ua, err := net.ResolveUDPAddr(network, s)
+				if err != nil {
+					continue
+				}
+				ret = append(ret, dht.NewAddr(ua))
+			}
+			if len(ret) == 0 {
+				err = errors.New("no bootstrap nodes resolved")
+			}
+			return
+		}
+	}
+}
+
+// The bootstraps of a DHT server, by its address.
+type dhtBootstrapState struct {
+	// Closed when the bootstrap in progress is done. Nil if there isn't one.
+	running  chan struct{}
+	count    int
+	last     time.Time
+	lastErr  error
+	lastStat dht.TraversalStats
+}
+
+// Starts bootstrapping the server unless it's already bootstrapping, and returns a channel closed
+// when that's done. Returns nil if the server can't bootstrap.
+func (cl *Client) startDhtBootstrap(s DhtServer) <-chan struct{} {
+	b, ok := s.(DhtBootstrapper)
+	if !ok {
+		return nil
+	}
+	key := s.Addr().String()
+	cl.dhtBootstrapMu.Lock()
+	defer cl.dhtBootstrapMu.Unlock()
+	if cl.dhtBootstraps == nil {
+		cl.dhtBootstraps = make(map[string]*dhtBootstrapState)
+	}
+	st := cl.dhtBootstraps[key]
+	if st == nil {
+		st = &dhtBootstrapState{}
+		cl.dhtBootstraps[key] = st
+	}
+	if st.running != nil {
+		return st.running
+	}
+	done := make(chan struct{})
+	st.running = done
+	go func() {
+		defer close(done)
+		ts, err := b.Bootstrap()
+		if err != nil {
+			cl.logger.Printf("error bootstrapping dht: %s", err)
+		}
+		log.Fstr("%v completed bootstrap (%v)", s, ts).AddValues(s, ts).Log(cl.logger)
+		cl.dhtBootstrapMu.Lock()
+		defer cl.dhtBootstrapMu.Unlock()
+		st.running = nil
+		st.count++
+		st.last = time.Now()
+		st.lastErr = err
+		st.lastStat = ts
+	}()
+	return done
+}
+
+// Bootstraps a new DHT server, unless its starting nodes getter returns no nodes. The getter may
+// resolve hostnames, so it's called in its own goroutine.
+func (cl *Client) initialDhtBootstrap(s DhtServer, startingNodes dht.StartingNodesGetter) {
+	go func() {
+		addrs, err := startingNodes()
+		if err == nil && len(addrs) == 0 {
+			return
+		}
+		cl.rLock()
+		closed := cl.closed.IsSet()
+		cl.rUnlock()
+		if closed {
+			return
+		}
+		// An error is left for the bootstrap to report, and to record for DhtBootstrapStatus.
+		cl.startDhtBootstrap(s)
+	}()
+}
+
+// Bootstraps each DHT server again, such as after a long time offline, and waits for them.
+// Bootstraps already in progress are waited for rather than started again. Returns the first
+// error, or ctx.Err() if ctx is done first, in which case the bootstraps carry on in the
+// background.
+func (cl *Client) Rebootstrap(ctx context.Context) error {
+	cl.rLock()
+	servers := append([]DhtServer(nil), cl.dhtServers...)
+	cl.rUnlock()
+	var dones []<-chan struct{}
+	for _, s := range servers {
+		if done := cl.startDhtBootstrap(s); done != nil {
+			dones = append(dones, done)
+		}
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, s := range servers {
+		st := cl.dhtBootstrapStatus(s)
+		if st.LastErr != nil {
+			return fmt.Errorf("bootstrapping dht server on %v: %w", st.Addr, st.LastErr)
+		}
+	}
+	return nil
+}
+
+// The bootstrap progress and routing table of a DHT server. See Client.DhtBootstrapStatus.
+type DhtBootstrapStatus struct {
+	Addr   net.Addr
+	NodeId [20]byte
+	// Whether the server implements DhtBootstrapper. The fields below are zero if it doesn't.
+	CanBootstrap  bool
+	Bootstrapping bool
+	// Completed bootstraps, and the result of the last one.
+	Bootstraps    int
+	LastBootstrap time.Time
+	LastTraversal dht.TraversalStats
+	LastErr       error
+	// Nodes in the routing table, and those of them known to be responding.
+	Nodes     int
+	GoodNodes int
+	// Buckets with at least one node. Bucket i holds the nodes whose IDs first differ from NodeId
+	// at bit i, so a well-bootstrapped table fills the first few dozen.
+	BucketsFilled int
+}
+
+// Returns the bootstrap status of each DHT server.
+func (cl *Client) DhtBootstrapStatus() (ret []DhtBootstrapStatus) {
+	cl.rLock()
+	servers := append([]DhtServer(nil), cl.dhtServers...)
+	cl.rUnlock()
+	for _, s := range servers {
+		ret = append(ret, cl.dhtBootstrapStatus(s))
+	}
+	return
+}
+
+func (cl *Client) dhtBootstrapStatus(s DhtServer) (ret DhtBootstrapStatus) {
+	ret.Addr = s.Addr()
+	ret.NodeId = s.ID()
+	b, ok := s.(DhtBootstrapper)
+	if !ok {
+		return
+	}
+	ret.CanBootstrap = true
+	cl.dhtBootstrapMu.Lock()
+	if st := cl.dhtBootstraps[ret.Addr.String()]; st != nil {
+		ret.Bootstrapping = st.running != nil
+		ret.Bootstraps = st.count
+		ret.LastBootstrap = st.last
+		ret.LastTraversal = st.lastStat
+		ret.LastErr = st.lastErr
+	}
+	cl.dhtBootstrapMu.Unlock()
+	nodes := b.Nodes()
+	ret.Nodes = len(nodes)
+	if ss, ok := s.Stats().(dht.ServerStats); ok {
+		ret.GoodNodes = ss.GoodNodes
+	}
+	var buckets [160]bool
+	for _, ni := range nodes {
+		if i := dhtBucketIndex(ret.NodeId, ni.ID); i < len(buckets) && !buckets[i] {
+			buckets[i] = true
+			ret.BucketsFilled++
+		}
+	}
+	return
+}
+
+// Returns the index of the first bit that differs between the IDs, or 160 if they're the same.
+func dhtBucketIndex(self, other [20]byte) int {
+	for i := range self {
+		if x := self[i] ^ other[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return 160
+}
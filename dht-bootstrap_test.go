@@ -0,0 +1,49 @@
+package torrent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDhtBootstrapNodes(t *testing.T) {
+	addrs, err := DhtBootstrapNodes("127.0.0.1:6881", "[::1]:6881")("udp4")()
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	assert.Equal(t, "127.0.0.1:6881", addrs[0].String())
+	addrs, err = DhtBootstrapNodes()("udp")()
+	assert.NoError(t, err)
+	assert.Empty(t, addrs)
+}
+
+func TestDhtBucketIndex(t *testing.T) {
+	self := [20]byte{0x80}
+	assert.Equal(t, 0, dhtBucketIndex(self, [20]byte{}))
+	assert.Equal(t, 7, dhtBucketIndex(self, [20]byte{0x81}))
+	assert.Equal(t, 159, dhtBucketIndex(self, [20]byte{0x80, 19: 1}))
+	assert.Equal(t, 160, dhtBucketIndex(self, self))
+}
+
+func TestRebootstrapWithoutStartingNodes(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.NoDHT = false
+	cfg.DhtStartingNodes = nil
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	statuses := cl.DhtBootstrapStatus()
+	require.NotEmpty(t, statuses)
+	for _, st := range statuses {
+		assert.True(t, st.CanBootstrap)
+		assert.Zero(t, st.Bootstraps)
+		assert.Zero(t, st.Nodes)
+	}
+	// There's nothing to bootstrap from, but it's still attempted.
+	cl.Rebootstrap(context.Background())
+	for _, st := range cl.DhtBootstrapStatus() {
+		assert.False(t, st.Bootstrapping)
+		assert.Equal(t, 1, st.Bootstraps)
+	}
+}
@@ -0,0 +1,118 @@
+package torrent
+
+import (
+	"net"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// The client runs a DHT server on each UDP socket, so with IPv4 and IPv6 enabled there's a node for
+// each family (BEP 32), with its own node ID and routing table. Torrents announce to every server,
+// and the peers found are combined in the torrent.
+//
+// The servers don't share a peer store: the dht package doesn't let one be supplied, so get_peers
+// queries from other nodes are answered by each server alone. The Client only keeps a bounded
+// table of peers announced to either server for torrents it hasn't added, which is handed to the
+// torrent if it's added later.
+
+const (
+	dhtFamilyIpv4 = iota
+	dhtFamilyIpv6
+	numDhtFamilies
+)
+
+var dhtFamilyNames = [numDhtFamilies]string{"ipv4", "ipv6"}
+
+func dhtIpFamily(ip net.IP) int {
+	if ip.To4() == nil && ip.To16() != nil {
+		return dhtFamilyIpv6
+	}
+	return dhtFamilyIpv4
+}
+
+func dhtServerFamily(s DhtServer) int {
+	return dhtIpFamily(addrIpOrNil(s.Addr()))
+}
+
+type dhtFamilyCounts struct {
+	Announces             Count
+	PeersFound            Count
+	AnnouncePeersReceived Count
+}
+
+// DHT activity for an IP family. See Client.DhtFamilyStats.
+type DhtFamilyStats struct {
+	// The IDs of the family's DHT servers.
+	NodeIds [][20]byte
+	// Announces started by torrents, which also look up peers.
+	Announces int64
+	// Peers returned by lookups.
+	PeersFound int64
+	// announce_peer queries received from nodes of the family.
+	AnnouncePeersReceived int64
+	// The Stats of each of the family's DHT servers, in the order of NodeIds.
+	ServerStats []interface{}
+}
+
+// Returns DHT activity by IP family, keyed by "ipv4" and "ipv6". Families without servers are only
+// included if they have activity.
+func (cl *Client) DhtFamilyStats() map[string]DhtFamilyStats {
+	cl.rLock()
+	defer cl.rUnlock()
+	var stats [numDhtFamilies]DhtFamilyStats
+	for i := range stats {
+		c := &cl.dhtFamilyCounts[i]
+		stats[i].Announces = c.Announces.Int64()
+		stats[i].PeersFound = c.PeersFound.Int64()
+		stats[i].AnnouncePeersReceived = c.AnnouncePeersReceived.Int64()
+	}
+	cl.eachDhtServer(func(s DhtServer) {
+		fs := &stats[dhtServerFamily(s)]
+		fs.NodeIds = append(fs.NodeIds, s.ID())
+		fs.ServerStats = append(fs.ServerStats, s.Stats())
+	})
+	ret := make(map[string]DhtFamilyStats, numDhtFamilies)
+	for i, fs := range stats {
+		if len(fs.NodeIds) == 0 && fs.Announces == 0 && fs.AnnouncePeersReceived == 0 {
+			continue
+		}
+		ret[dhtFamilyNames[i]] = fs
+	}
+	return ret
+}
+
+const (
+	// The most infohashes peers are kept for when they're announced for torrents that haven't been
+	// added.
+	maxDhtStoredInfoHashes = 1000
+	// The most peers kept for each of them.
+	maxDhtStoredPeers = 50
+)
+
+// Keeps a peer announced over the DHT for a torrent that isn't added, for the torrent if it's
+// added later. The Client lock must be held.
+func (cl *Client) storeDhtAnnouncedPeer(ih metainfo.Hash, pi PeerInfo) {
+	if cl.dhtStoredPeers == nil {
+		cl.dhtStoredPeers = make(map[metainfo.Hash][]PeerInfo)
+	}
+	ps, ok := cl.dhtStoredPeers[ih]
+	if !ok && len(cl.dhtStoredPeers) >= maxDhtStoredInfoHashes {
+		torrent.Add("dht announced peers dropped", 1)
+		return
+	}
+	if len(ps) >= maxDhtStoredPeers {
+		// Prefer the most recent announces.
+		ps = ps[1:]
+	}
+	cl.dhtStoredPeers[ih] = append(ps, pi)
+}
+
+// Gives a newly added torrent the peers announced for it before it was added.
+func (cl *Client) takeDhtStoredPeers(t *Torrent) {
+	ps, ok := cl.dhtStoredPeers[t.infoHash]
+	if !ok {
+		return
+	}
+	delete(cl.dhtStoredPeers, t.infoHash)
+	t.addPeers(ps)
+}
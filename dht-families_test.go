@@ -0,0 +1,48 @@
+package torrent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestDhtStoredPeers(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	ih := metainfo.Hash{1}
+	cl.onDHTAnnouncePeer(ih, net.ParseIP("2001:db8::1"), 1234, true)
+	cl.onDHTAnnouncePeer(ih, net.ParseIP("192.0.2.1"), 1234, true)
+	stats := cl.DhtFamilyStats()
+	assert.EqualValues(t, 1, stats["ipv4"].AnnouncePeersReceived)
+	assert.EqualValues(t, 1, stats["ipv6"].AnnouncePeersReceived)
+	tt, _ := cl.AddTorrentInfoHash(ih)
+	var addrs []string
+	for _, pi := range tt.KnownSwarm() {
+		addrs = append(addrs, pi.Addr.String())
+	}
+	assert.ElementsMatch(t, []string{"[2001:db8::1]:1234", "192.0.2.1:1234"}, addrs)
+	cl.lock()
+	assert.Empty(t, cl.dhtStoredPeers)
+	cl.unlock()
+}
+
+func TestDhtStoredPeersBounded(t *testing.T) {
+	var cl Client
+	ih := metainfo.Hash{1}
+	for i := 0; i < maxDhtStoredPeers+1; i++ {
+		cl.storeDhtAnnouncedPeer(ih, PeerInfo{Addr: ipPortAddr{net.IPv4(192, 0, 2, byte(i)), 1}})
+	}
+	ps := cl.dhtStoredPeers[ih]
+	require.Len(t, ps, maxDhtStoredPeers)
+	// The oldest is dropped.
+	assert.Equal(t, "192.0.2.1:1", ps[0].Addr.String())
+	for i := 1; i < maxDhtStoredInfoHashes+1; i++ {
+		cl.storeDhtAnnouncedPeer(metainfo.Hash{byte(i), byte(i >> 8), 1}, PeerInfo{})
+	}
+	assert.Len(t, cl.dhtStoredPeers, maxDhtStoredInfoHashes)
+}
@@ -0,0 +1,77 @@
+package torrent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Records the ports of announces. Other DhtServer methods aren't implemented.
+type testDhtServer struct {
+	DhtServer
+	announces chan int
+}
+
+func (me *testDhtServer) Addr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+func (me *testDhtServer) Announce(hash [20]byte, port int, impliedPort bool) (DhtAnnounce, error) {
+	me.announces <- port
+	return testDhtAnnounce{}, nil
+}
+
+type testDhtAnnounce struct{}
+
+func (testDhtAnnounce) Close() {}
+
+// No peers are found.
+func (testDhtAnnounce) Peers() <-chan dht.PeersValues {
+	c := make(chan dht.PeersValues)
+	close(c)
+	return c
+}
+
+func TestDhtAnnounceInterval(t *testing.T) {
+	cl := &Client{config: &ClientConfig{}}
+	assert.Equal(t, defaultDhtAnnounceInterval, cl.dhtAnnounceInterval())
+	cl.config.DhtAnnounceInterval = time.Second
+	assert.Equal(t, time.Second, cl.dhtAnnounceInterval())
+}
+
+func TestDhtAnnounces(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.DhtAnnouncePort = 1234
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	require.NotEqual(t, 1234, cl.LocalPort())
+	s := &testDhtServer{announces: make(chan int, 10)}
+	cl.lock()
+	cl.dhtServers = append(cl.dhtServers, s)
+	cl.unlock()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash:            metainfo.HashBytes([]byte("dht announces")),
+		DisallowDhtAnnounce: true,
+	})
+	require.NoError(t, err)
+	select {
+	case <-s.announces:
+		t.Fatal("announced while disallowed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	tt.AllowDhtAnnounces()
+	select {
+	case port := <-s.announces:
+		// The configured port replaces the listen port.
+		assert.Equal(t, 1234, port)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no announce after allowing them")
+	}
+}
@@ -0,0 +1,233 @@
+package torrent
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// A torrent added with AddDormantTorrentSpec that hasn't been activated.
+type dormantTorrent struct {
+	spec *TorrentSpec
+	// When it's next activated to announce. See ClientConfig.DormantAnnounceInterval.
+	announceAfter time.Time
+}
+
+// A torrent that was activated from dormancy, and may be returned to it.
+type lazyTorrent struct {
+	spec     *TorrentSpec
+	lastUsed time.Time
+}
+
+func (cl *Client) dormantAnnounceInterval() time.Duration {
+	if d := cl.config.DormantAnnounceInterval; d != 0 {
+		return d
+	}
+	return 30 * time.Minute
+}
+
+// Adds a torrent without creating a Torrent for it: no goroutines, connections or storage handles
+// exist until ActivateTorrent is called, a peer connects wanting it, or it's due to announce (see
+// ClientConfig.DormantAnnounceInterval). If the torrent is already active, the spec is merged into
+// it.
+func (cl *Client) AddDormantTorrentSpec(spec *TorrentSpec) error {
+	cl.lock()
+	if cl.refuseBlockedInfoHash(spec.InfoHash, "add", nil) {
+		cl.unlock()
+		return ErrInfoHashBlocked
+	}
+	t, active := cl.torrents[spec.InfoHash]
+	if !active {
+		if cl.dormantTorrents == nil {
+			cl.dormantTorrents = make(map[metainfo.Hash]*dormantTorrent)
+		}
+		// Spread the first announces over the interval, so a session added at once doesn't
+		// announce at once.
+		var jitter time.Duration
+		if d := cl.dormantAnnounceInterval(); d > 0 {
+			jitter = time.Duration(rand.Int63n(int64(d)))
+		}
+		cl.dormantTorrents[spec.InfoHash] = &dormantTorrent{
+			spec:          spec,
+			announceAfter: time.Now().Add(jitter),
+		}
+	}
+	cl.unlock()
+	if active {
+		return t.MergeSpec(spec)
+	}
+	return nil
+}
+
+// Returns the infohashes of torrents that are currently dormant.
+func (cl *Client) DormantTorrents() (ret []metainfo.Hash) {
+	cl.rLock()
+	defer cl.rUnlock()
+	for ih := range cl.dormantTorrents {
+		ret = append(ret, ih)
+	}
+	return
+}
+
+// Removes the dormant entry for a torrent being added, returning its spec for merging into the
+// Torrent, or nil.
+func (cl *Client) takeDormantSpec(ih metainfo.Hash) *TorrentSpec {
+	dt, ok := cl.dormantTorrents[ih]
+	if !ok {
+		return nil
+	}
+	delete(cl.dormantTorrents, ih)
+	return dt.spec
+}
+
+// Returns the Torrent for the infohash, activating it if it's dormant. If activating exceeds
+// ClientConfig.MaxLazyActiveTorrents, the least recently used torrents that were activated from
+// dormancy are returned to it.
+func (cl *Client) ActivateTorrent(ih metainfo.Hash) (*Torrent, error) {
+	return cl.activateTorrent(ih, time.Now())
+}
+
+// Activates the torrent as used at lastUsed. The torrent replaces the dormant entry under the
+// lock, so concurrent activations and adds find it, and the spec is merged into it after, as with
+// AddTorrentSpec.
+func (cl *Client) activateTorrent(ih metainfo.Hash, lastUsed time.Time) (*Torrent, error) {
+	cl.lock()
+	if t, ok := cl.torrents[ih]; ok {
+		if lt, ok := cl.lazyActive[ih]; ok && lastUsed.After(lt.lastUsed) {
+			lt.lastUsed = lastUsed
+		}
+		cl.unlock()
+		return t, nil
+	}
+	dt, ok := cl.dormantTorrents[ih]
+	if !ok {
+		cl.unlock()
+		return nil, errors.New("no such torrent")
+	}
+	spec := dt.spec
+	t, _, _ := cl.addTorrentInfoHashWithStorage(ih, spec.Storage)
+	if cl.lazyActive == nil {
+		cl.lazyActive = make(map[metainfo.Hash]*lazyTorrent)
+	}
+	cl.lazyActive[ih] = &lazyTorrent{spec: spec, lastUsed: lastUsed}
+	torrent.Add("dormant torrents activated", 1)
+	cl.evictLazyActive(ih)
+	cl.unlock()
+	err := t.MergeSpec(spec)
+	if err != nil {
+		cl.lock()
+		defer cl.unlock()
+		if cl.torrents[ih] == t {
+			cl.dropTorrent(ih)
+			delete(cl.lazyActive, ih)
+			if !cl.infoHashBlocked(ih) {
+				cl.dormantTorrents[ih] = dt
+			}
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// Returns lazily activated torrents to dormancy until the limit is met, lowest priority first, and
+// then least recently used. keep is never evicted.
+func (cl *Client) evictLazyActive(keep metainfo.Hash) {
+	limit := cl.config.MaxLazyActiveTorrents
+	for limit > 0 && len(cl.lazyActive) > limit {
+		var (
+			oldest         metainfo.Hash
+			oldestLt       *lazyTorrent
+			oldestPriority TorrentPriority
+		)
+		for ih, lt := range cl.lazyActive {
+			if ih == keep {
+				continue
+			}
+			priority := cl.torrentPriority(ih)
+			if oldestLt == nil || priority < oldestPriority ||
+				priority == oldestPriority && lt.lastUsed.Before(oldestLt.lastUsed) {
+				oldest, oldestLt, oldestPriority = ih, lt, priority
+			}
+		}
+		if oldestLt == nil {
+			return
+		}
+		cl.deactivateTorrent(oldest, oldestLt)
+	}
+}
+
+func (cl *Client) deactivateTorrent(ih metainfo.Hash, lt *lazyTorrent) {
+	delete(cl.lazyActive, ih)
+	if t, ok := cl.torrents[ih]; ok {
+		// Keep the info if it was obtained while active.
+		if lt.spec.InfoBytes == nil && t.haveInfo() {
+			lt.spec.InfoBytes = t.metadataBytes
+		}
+		lt.spec.Priority = t.Priority()
+		cl.dropTorrent(ih)
+	}
+	cl.dormantTorrents[ih] = &dormantTorrent{
+		spec:          lt.spec,
+		announceAfter: time.Now().Add(cl.dormantAnnounceInterval()),
+	}
+	torrent.Add("lazy torrents returned to dormancy", 1)
+}
+
+// Activates a dormant torrent a peer has connected for. Returns nil if there's no such torrent.
+func (cl *Client) activateForPeer(ih metainfo.Hash) *Torrent {
+	cl.rLock()
+	_, ok := cl.dormantTorrents[ih]
+	cl.rUnlock()
+	if !ok {
+		return nil
+	}
+	t, err := cl.ActivateTorrent(ih)
+	if err != nil {
+		cl.logger.Printf("activating dormant torrent %v for peer: %v", ih, err)
+		return nil
+	}
+	return t
+}
+
+// Activates dormant torrents as they become due to announce, so peers can find them. They're
+// activated as last used an interval ago, so they return to dormancy before torrents activated by
+// use, and no more than ClientConfig.MaxLazyActiveTorrents are activated each tick.
+func (cl *Client) announceDormantTorrents() {
+	interval := cl.dormantAnnounceInterval()
+	tick := interval
+	if tick > time.Minute {
+		tick = time.Minute
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	closed := cl.Closed()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var due []metainfo.Hash
+		cl.rLock()
+		for ih, dt := range cl.dormantTorrents {
+			if !now.Before(dt.announceAfter) {
+				due = append(due, ih)
+			}
+		}
+		cl.rUnlock()
+		if limit := cl.config.MaxLazyActiveTorrents; limit > 0 && len(due) > limit {
+			due = due[:limit]
+		}
+		for _, ih := range due {
+			_, err := cl.activateTorrent(ih, now.Add(-interval))
+			if err != nil {
+				cl.logger.Printf("activating dormant torrent %v to announce: %v", ih, err)
+				continue
+			}
+			torrent.Add("dormant torrents activated to announce", 1)
+		}
+	}
+}
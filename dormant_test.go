@@ -0,0 +1,70 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestDormantTorrentActivation(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.MaxLazyActiveTorrents = 1
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	greeting := TorrentSpecFromMetaInfo(testutil.GreetingMetaInfo())
+	other := &TorrentSpec{InfoHash: metainfo.HashBytes([]byte("other"))}
+	require.NoError(t, cl.AddDormantTorrentSpec(greeting))
+	require.NoError(t, cl.AddDormantTorrentSpec(other))
+	assert.Empty(t, cl.Torrents())
+	assert.Len(t, cl.DormantTorrents(), 2)
+
+	tt, err := cl.ActivateTorrent(greeting.InfoHash)
+	require.NoError(t, err)
+	assert.EqualValues(t, greeting.InfoHash, tt.InfoHash())
+	assert.Len(t, cl.Torrents(), 1)
+	assert.EqualValues(t, []metainfo.Hash{other.InfoHash}, cl.DormantTorrents())
+
+	// Only one lazily activated torrent is allowed, so the greeting returns to dormancy.
+	_, err = cl.ActivateTorrent(other.InfoHash)
+	require.NoError(t, err)
+	assert.Len(t, cl.Torrents(), 1)
+	assert.EqualValues(t, []metainfo.Hash{greeting.InfoHash}, cl.DormantTorrents())
+
+	_, err = cl.ActivateTorrent(metainfo.Hash{})
+	assert.Error(t, err)
+}
+
+func TestDormantTorrentAdded(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	greeting := TorrentSpecFromMetaInfo(testutil.GreetingMetaInfo())
+	require.NoError(t, cl.AddDormantTorrentSpec(greeting))
+	tt, new, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: greeting.InfoHash})
+	require.NoError(t, err)
+	assert.True(t, new)
+	assert.Empty(t, cl.DormantTorrents())
+	// The dormant spec was merged.
+	assert.NotNil(t, tt.Info())
+}
+
+func TestDormantTorrentAnnounce(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.DormantAnnounceInterval = time.Millisecond
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ih := metainfo.HashBytes([]byte("dormant"))
+	require.NoError(t, cl.AddDormantTorrentSpec(&TorrentSpec{InfoHash: ih}))
+	assert.Eventually(t, func() bool {
+		_, ok := cl.Torrent(ih)
+		return ok
+	}, 10*time.Second, time.Millisecond)
+	assert.Empty(t, cl.DormantTorrents())
+}
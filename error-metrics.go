@@ -0,0 +1,207 @@
+package torrent
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A subsystem errors are counted by. See Client.ErrorStats.
+type ErrorCategory string
+
+const (
+	// Failed tracker announces.
+	ErrorCategoryTracker ErrorCategory = "tracker"
+	// Failed DHT announces.
+	ErrorCategoryDht ErrorCategory = "dht"
+	// Failed reads and writes of torrent data, and of piece completion.
+	ErrorCategoryStorage ErrorCategory = "storage"
+	// Peers that broke the protocol, such as by sending malformed or unexpected messages. Peers
+	// disconnecting aren't counted.
+	ErrorCategoryPeerProtocol ErrorCategory = "peer protocol"
+	// Pieces that couldn't be read to be hashed. Pieces that fail their hash check aren't counted.
+	ErrorCategoryHashing ErrorCategory = "hashing"
+)
+
+var ErrorCategories = []ErrorCategory{
+	ErrorCategoryTracker,
+	ErrorCategoryDht,
+	ErrorCategoryStorage,
+	ErrorCategoryPeerProtocol,
+	ErrorCategoryHashing,
+}
+
+// Rates are counted in this many slots across ClientConfig.ErrorRateWindow.
+const errorRateSlots = 12
+
+const defaultErrorRateWindow = time.Minute
+
+// Also published as expvars, by category.
+var errorsByCategory = expvar.NewMap("errorsByCategory")
+
+// Errors in a category, for Client.ErrorStats.
+type ErrorCategoryStats struct {
+	// Errors since the Client was created.
+	Total int64
+	// Errors per second over the last ClientConfig.ErrorRateWindow.
+	Rate float64
+	// The most recent error, and when it happened.
+	LastErr  error
+	LastTime time.Time
+	// Whether the rate is over the category's alarm threshold.
+	Alarmed bool
+}
+
+// Passed to Callbacks.ErrorAlarm when a category's error rate goes over its threshold in
+// ClientConfig.ErrorAlarmThresholds.
+type ErrorAlarm struct {
+	Category ErrorCategory
+	// Errors per second, over ClientConfig.ErrorRateWindow.
+	Rate      float64
+	Threshold float64
+	// The error that took the rate over the threshold.
+	Err error
+}
+
+type errorCounter struct {
+	total    int64
+	lastErr  error
+	lastTime time.Time
+	// Counts for consecutive periods of the rate window, ending with the one starting at
+	// slotStart.
+	slots     [errorRateSlots]int64
+	slotStart time.Time
+	alarmed   bool
+}
+
+// Shifts the slots so the last one contains now.
+func (me *errorCounter) advance(now time.Time, slot time.Duration) {
+	current := now.Truncate(slot)
+	shift := int(current.Sub(me.slotStart) / slot)
+	if shift <= 0 {
+		return
+	}
+	if shift > errorRateSlots {
+		shift = errorRateSlots
+	}
+	copy(me.slots[:], me.slots[shift:])
+	for i := errorRateSlots - shift; i < errorRateSlots; i++ {
+		me.slots[i] = 0
+	}
+	me.slotStart = current
+}
+
+func (me *errorCounter) rate(window time.Duration) float64 {
+	var sum int64
+	for _, n := range me.slots {
+		sum += n
+	}
+	return float64(sum) / window.Seconds()
+}
+
+type errorCounters struct {
+	mu         sync.Mutex
+	categories map[ErrorCategory]*errorCounter
+}
+
+func (cl *Client) errorRateWindow() time.Duration {
+	if cl.config.ErrorRateWindow > 0 {
+		return cl.config.ErrorRateWindow
+	}
+	return defaultErrorRateWindow
+}
+
+// Counts an error in the category, and calls Callbacks.ErrorAlarm if that takes the category over
+// its threshold. It doesn't need the Client lock.
+func (cl *Client) countError(category ErrorCategory, err error) {
+	errorsByCategory.Add(string(category), 1)
+	window := cl.errorRateWindow()
+	now := time.Now()
+	threshold, hasThreshold := cl.config.ErrorAlarmThresholds[category]
+	var alarm *ErrorAlarm
+	func() {
+		me := &cl.errorCounters
+		me.mu.Lock()
+		defer me.mu.Unlock()
+		if me.categories == nil {
+			me.categories = make(map[ErrorCategory]*errorCounter)
+		}
+		c := me.categories[category]
+		if c == nil {
+			c = new(errorCounter)
+			me.categories[category] = c
+		}
+		c.advance(now, window/errorRateSlots)
+		c.slots[errorRateSlots-1]++
+		c.total++
+		c.lastErr = err
+		c.lastTime = now
+		if !hasThreshold {
+			return
+		}
+		rate := c.rate(window)
+		over := rate > threshold
+		if over && !c.alarmed {
+			alarm = &ErrorAlarm{
+				Category:  category,
+				Rate:      rate,
+				Threshold: threshold,
+				Err:       err,
+			}
+		}
+		c.alarmed = over
+	}()
+	if alarm != nil {
+		if f := cl.config.Callbacks.ErrorAlarm; f != nil {
+			// Errors are counted with and without the Client lock.
+			go f(*alarm)
+		}
+	}
+}
+
+// Returns counts and rates for the categories that have had errors. A category stops being alarmed
+// once its rate is back under the threshold, and can then alarm again.
+func (cl *Client) ErrorStats() map[ErrorCategory]ErrorCategoryStats {
+	window := cl.errorRateWindow()
+	now := time.Now()
+	me := &cl.errorCounters
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ret := make(map[ErrorCategory]ErrorCategoryStats, len(me.categories))
+	for category, c := range me.categories {
+		c.advance(now, window/errorRateSlots)
+		rate := c.rate(window)
+		if threshold, ok := cl.config.ErrorAlarmThresholds[category]; ok && rate <= threshold {
+			c.alarmed = false
+		}
+		ret[category] = ErrorCategoryStats{
+			Total:    c.total,
+			Rate:     rate,
+			LastErr:  c.lastErr,
+			LastTime: c.lastTime,
+			Alarmed:  c.alarmed,
+		}
+	}
+	return ret
+}
+
+func formatErrorStats(stats map[ErrorCategory]ErrorCategoryStats) string {
+	var parts []string
+	for category, s := range stats {
+		parts = append(parts, fmt.Sprintf("%s %d (%.2f/s)", category, s.Total, s.Rate))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// Errors reading from a peer are usually it going away, rather than breaking the protocol.
+func isPeerDisconnect(err error) bool {
+	var ne net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &ne)
+}
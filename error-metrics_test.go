@@ -0,0 +1,70 @@
+package torrent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorAlarm(t *testing.T) {
+	// Alarms are passed on in their own goroutines.
+	alarms := make(chan ErrorAlarm, 2)
+	cfg := TestingConfig()
+	cfg.ErrorRateWindow = time.Hour
+	// More than one error in the window.
+	cfg.ErrorAlarmThresholds = map[ErrorCategory]float64{ErrorCategoryStorage: 1 / time.Hour.Seconds()}
+	cfg.Callbacks.ErrorAlarm = func(a ErrorAlarm) { alarms <- a }
+	cl := &Client{config: cfg}
+	errDisk := errors.New("disk")
+	cl.countError(ErrorCategoryStorage, errDisk)
+	cl.countError(ErrorCategoryTracker, errors.New("tracker"))
+	cl.countError(ErrorCategoryStorage, errDisk)
+	var alarm ErrorAlarm
+	select {
+	case alarm = <-alarms:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no alarm")
+	}
+	assert.Equal(t, ErrorCategoryStorage, alarm.Category)
+	assert.Equal(t, errDisk, alarm.Err)
+	// Still over the threshold, so there's no new alarm.
+	cl.countError(ErrorCategoryStorage, errDisk)
+	select {
+	case alarm = <-alarms:
+		t.Fatalf("unexpected alarm: %v", alarm)
+	case <-time.After(10 * time.Millisecond):
+	}
+	stats := cl.ErrorStats()
+	assert.EqualValues(t, 3, stats[ErrorCategoryStorage].Total)
+	assert.InDelta(t, 3/time.Hour.Seconds(), stats[ErrorCategoryStorage].Rate, 1e-9)
+	assert.True(t, stats[ErrorCategoryStorage].Alarmed)
+	assert.EqualValues(t, 1, stats[ErrorCategoryTracker].Total)
+	assert.False(t, stats[ErrorCategoryTracker].Alarmed)
+	assert.NotContains(t, stats, ErrorCategoryDht)
+}
+
+func TestErrorCounterAdvance(t *testing.T) {
+	var c errorCounter
+	start := time.Unix(1000, 0)
+	c.advance(start, time.Second)
+	c.slots[errorRateSlots-1] = 2
+	c.advance(start.Add(time.Second), time.Second)
+	c.slots[errorRateSlots-1]++
+	assert.EqualValues(t, 2, c.slots[errorRateSlots-2])
+	assert.InDelta(t, 3/float64(errorRateSlots), c.rate(errorRateSlots*time.Second), 1e-9)
+	// The first errors have left the window.
+	c.advance(start.Add(errorRateSlots*time.Second), time.Second)
+	assert.InDelta(t, 1/float64(errorRateSlots), c.rate(errorRateSlots*time.Second), 1e-9)
+	c.advance(start.Add(time.Hour), time.Second)
+	assert.Zero(t, c.rate(errorRateSlots*time.Second))
+}
+
+func TestIsPeerDisconnect(t *testing.T) {
+	assert.True(t, isPeerDisconnect(io.EOF))
+	assert.True(t, isPeerDisconnect(&net.OpError{Op: "read", Err: errors.New("reset")}))
+	assert.False(t, isPeerDisconnect(errors.New("received fast extension message")))
+}
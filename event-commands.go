@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+// A Torrent lifecycle event that can run EventCommands.
+type TorrentEvent string
+
+const (
+	TorrentEventAdded     TorrentEvent = "added"
+	TorrentEventCompleted TorrentEvent = "completed"
+	// A chunk couldn't be written to storage.
+	TorrentEventError   TorrentEvent = "error"
+	TorrentEventRemoved TorrentEvent = "removed"
+	// Too many pieces failed their hash check. See HashFailureQuarantine.
+	TorrentEventQuarantined TorrentEvent = "quarantined"
+	// Downloading was paused, or resumed, for ClientConfig.MinFreeSpace. Only torrents still
+	// downloading have these.
+	TorrentEventPaused  TorrentEvent = "paused"
+	TorrentEventResumed TorrentEvent = "resumed"
+)
+
+// An external program run when a torrent event occurs. It's run with the Client's environment plus
+// the following variables. Args are passed as given: the variables hold values from peers and
+// metainfo, such as the torrent name, so they're never expanded into Args. A shell script should
+// refer to them as quoted variables, such as "$TORRENT_NAME".
+//
+//	TORRENT_EVENT       the event name
+//	TORRENT_INFOHASH    the infohash in hex
+//	TORRENT_NAME        the torrent name, if known
+//	TORRENT_PATH        where the default storage keeps the torrent's data
+//	TORRENT_TRACKER     the first tracker URL, if any
+//	TORRENT_ERROR       the error, for error, quarantined and paused events
+//	TORRENT_ERROR_KIND  the kind of storage error, such as "storage full" (see storage.Error)
+type EventCommand struct {
+	Event TorrentEvent
+	Path  string
+	Args  []string
+}
+
+func (cl *Client) eventCommandTimeout() time.Duration {
+	if d := cl.config.EventCommandTimeout; d > 0 {
+		return d
+	}
+	return time.Minute
+}
+
+// Starts the commands for an event. Must be called with the Client lock held, so the variables
+// reflect the state when the event occurred.
+func (t *Torrent) runEventCommands(event TorrentEvent, err error) {
+	var cmds []EventCommand
+	for _, c := range t.cl.config.EventCommands {
+		if c.Event == event {
+			cmds = append(cmds, c)
+		}
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	vars := map[string]string{
+		"TORRENT_EVENT":    string(event),
+		"TORRENT_INFOHASH": t.infoHash.HexString(),
+		"TORRENT_NAME":     t.name(),
+		"TORRENT_PATH":     filepath.Join(t.cl.config.DataDir, t.name()),
+	}
+	for _, tier := range t.metainfo.UpvertedAnnounceList() {
+		if len(tier) != 0 {
+			vars["TORRENT_TRACKER"] = tier[0]
+			break
+		}
+	}
+	if err != nil {
+		vars["TORRENT_ERROR"] = err.Error()
+		var se storage.Error
+		if errors.As(err, &se) {
+			vars["TORRENT_ERROR_KIND"] = se.Kind.Error()
+		}
+	}
+	for _, c := range cmds {
+		go t.cl.runEventCommand(c, vars)
+	}
+}
+
+func (cl *Client) runEventCommand(c EventCommand, vars map[string]string) {
+	cl.eventCommandSem <- struct{}{}
+	defer func() { <-cl.eventCommandSem }()
+	ctx, cancel := context.WithTimeout(context.Background(), cl.eventCommandTimeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	out, err := cmd.CombinedOutput()
+	torrent.Add("event commands run", 1)
+	if err != nil {
+		torrent.Add("event commands failed", 1)
+		cl.logger.WithDefaultLevel(log.Warning).Printf("%s command %q: %v: %s", vars["TORRENT_EVENT"], c.Path, err, out)
+	}
+}
@@ -0,0 +1,60 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Returns the digest of the file's data with a hash from newHash, such as sha256.New or md5.New.
+// The data is read from storage, and every piece of the file must be complete, so the digest is of
+// verified data. Use File.OnHashed to learn when that is.
+func (f *File) Checksum(newHash func() hash.Hash) ([]byte, error) {
+	t := f.t
+	t.cl.rLock()
+	if !t.haveInfo() {
+		t.cl.rUnlock()
+		return nil, errors.New("torrent info not available")
+	}
+	if !f.piecesComplete() {
+		t.cl.rUnlock()
+		return nil, fmt.Errorf("file %q is incomplete", f.Path())
+	}
+	begin, end := f.firstPieceIndex(), f.endPieceIndex()
+	pieceSize := int64(t.usualPieceSize())
+	t.cl.rUnlock()
+	h := newHash()
+	for i := begin; i < end; i++ {
+		data, err := t.readVerifiedPiece(i)
+		if err != nil {
+			return nil, fmt.Errorf("reading piece %d: %w", i, err)
+		}
+		pieceOff := int64(i) * pieceSize
+		lo := max(f.offset-pieceOff, 0)
+		hi := f.offset + f.length - pieceOff
+		if hi > int64(len(data)) {
+			hi = int64(len(data))
+		}
+		h.Write(data[lo:hi])
+	}
+	return h.Sum(nil), nil
+}
+
+// Writes a manifest of the checksums of the torrent's files to w, in the format read by tools like
+// sha256sum and md5sum: each line has the hex digest, two spaces, and the File.Path. See
+// File.Checksum.
+func (t *Torrent) WriteChecksums(w io.Writer, newHash func() hash.Hash) error {
+	for _, f := range t.Files() {
+		sum, err := f.Checksum(newHash)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(sum), f.Path())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestFileChecksums(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cfg := TestingConfig()
+	cfg.DataDir = dir
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	f := tt.Files()[0]
+	_, err = f.Checksum(sha256.New)
+	assert.Error(t, err)
+	tt.VerifyData()
+	sum, err := f.Checksum(sha256.New)
+	require.NoError(t, err)
+	want := sha256.Sum256([]byte(testutil.GreetingFileContents))
+	assert.Equal(t, want[:], sum)
+	var buf bytes.Buffer
+	require.NoError(t, tt.WriteChecksums(&buf, sha256.New))
+	assert.Equal(t, fmt.Sprintf("%x  %s\n", want, testutil.GreetingFileName), buf.String())
+}
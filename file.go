@@ -10,12 +10,65 @@ import (
 
 // Provides access to regions of torrent data that correspond to its files.
 type File struct {
-	t      *Torrent
+	t *Torrent
+	// The index of the file in the info.
+	index  int
 	path   string
 	offset int64
 	length int64
 	fi     metainfo.FileInfo
 	prio   piecePriority
+
+	// Registered with OnHashed.
+	hashedHandlers []*func(FileHashResult)
+
+	// See ClientConfig.ContentScanners.
+	scan       fileScanState
+	cancelScan func()
+}
+
+// Moves the file's data to the given OS path, where it's stored from then on. This requires
+// storage that supports it, such as the default file storage (see storage.FileRelocator).
+func (f *File) SetStoragePath(path string) error {
+	return f.t.relocateFile(f.index, path, true)
+}
+
+// Reports a change in a file's verification state.
+type FileHashResult struct {
+	File *File
+	// Every piece of the file has passed its hash check.
+	Complete bool
+	// The hash result for the piece of the file that caused this.
+	Piece PieceHashResult
+}
+
+// Registers f to be called when a piece of the file fails its hash check, or when the last of the
+// file's pieces passes. Handlers are run like those for Piece.OnHashed. The returned func
+// unregisters f.
+func (f *File) OnHashed(handler func(FileHashResult)) (unregister func()) {
+	f.t.cl.lock()
+	defer f.t.cl.unlock()
+	h := &handler
+	f.hashedHandlers = append(f.hashedHandlers, h)
+	return func() {
+		f.t.cl.lock()
+		defer f.t.cl.unlock()
+		for i, e := range f.hashedHandlers {
+			if e == h {
+				f.hashedHandlers = append(f.hashedHandlers[:i:i], f.hashedHandlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (f *File) piecesComplete() bool {
+	for i := f.firstPieceIndex(); i < f.endPieceIndex(); i++ {
+		if !f.t.pieceComplete(i) {
+			return false
+		}
+	}
+	return true
 }
 
 func (f *File) Torrent() *Torrent {
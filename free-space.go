@@ -0,0 +1,81 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Returned by reads waiting for data while downloading is paused for ClientConfig.MinFreeSpace.
+var errLowFreeSpace = storage.Error{
+	Kind: storage.ErrDiskFull,
+	Err:  errors.New("downloading paused for low free space"),
+}
+
+func (cl *Client) monitorFreeSpace() {
+	interval := cl.config.FreeSpaceCheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	closed := cl.Closed()
+	for {
+		cl.checkFreeSpace()
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pauses or resumes downloading for the default storage's available space. Torrents still
+// downloading run their paused or resumed EventCommands, and readers waiting for data are woken to
+// fail.
+func (cl *Client) checkFreeSpace() {
+	avail, ok, err := cl.defaultStorage.AvailableSpace()
+	if !ok {
+		return
+	}
+	if err != nil {
+		cl.logger.Printf("error getting available storage space: %v", err)
+		return
+	}
+	low := avail < cl.config.MinFreeSpace
+	cl.lock()
+	changed := low != cl.lowFreeSpace
+	if changed {
+		cl.lowFreeSpace = low
+		event, err := TorrentEventResumed, error(nil)
+		if low {
+			event = TorrentEventPaused
+			err = fmt.Errorf("%d bytes available, below minimum of %d", avail, cl.config.MinFreeSpace)
+		}
+		// Requests are cancelled or resumed.
+		for _, t := range cl.torrents {
+			t.iterPeers(func(p *peer) {
+				p.updateRequests()
+			})
+			if !t.haveInfo() || !t.haveAllPieces() {
+				t.runEventCommands(event, err)
+			}
+		}
+		cl.event.Broadcast()
+	}
+	cl.unlock()
+	if !changed {
+		return
+	}
+	if low {
+		torrent.Add("downloading paused for low free space", 1)
+		cl.logger.Printf("pausing downloading: %d bytes available, below minimum of %d", avail, cl.config.MinFreeSpace)
+	} else {
+		cl.logger.Printf("resuming downloading: %d bytes available", avail)
+	}
+	if f := cl.config.Callbacks.FreeSpaceLow; f != nil {
+		f(low, avail)
+	}
+}
@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/storage"
+)
+
+type fakeSpaceStorage struct {
+	storage.ClientImpl
+	avail int64
+}
+
+func (me *fakeSpaceStorage) AvailableSpace() (int64, error) {
+	return atomic.LoadInt64(&me.avail), nil
+}
+
+func TestFreeSpacePausesDownloading(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	cfg := TestingConfig()
+	fs := &fakeSpaceStorage{ClientImpl: storage.NewFile(cfg.DataDir), avail: 100}
+	cfg.DefaultStorage = fs
+	cfg.MinFreeSpace = 50
+	// The monitor only checks when it starts, and the test checks after that.
+	cfg.FreeSpaceCheckInterval = time.Hour
+	out := filepath.Join(cfg.DataDir, "events")
+	cfg.EventCommands = []EventCommand{
+		{Event: TorrentEventPaused, Path: sh, Args: []string{"-c", `echo "$TORRENT_EVENT" >> ` + out}},
+		{Event: TorrentEventResumed, Path: sh, Args: []string{"-c", `echo "$TORRENT_EVENT" >> ` + out}},
+	}
+	var mu sync.Mutex
+	var events []bool
+	cfg.Callbacks.FreeSpaceLow = func(low bool, _ int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, low)
+	}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	<-tt.GotInfo()
+	// There's no data or peers, so the read waits.
+	r := tt.NewReader()
+	defer r.Close()
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		readErr <- err
+	}()
+
+	cl.checkFreeSpace()
+	cl.lock()
+	assert.False(t, cl.lowFreeSpace)
+	cl.unlock()
+	atomic.StoreInt64(&fs.avail, 10)
+	cl.checkFreeSpace()
+	cl.lock()
+	assert.True(t, cl.lowFreeSpace)
+	cl.unlock()
+	select {
+	case err := <-readErr:
+		assert.True(t, errors.Is(err, storage.ErrDiskFull), err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("read didn't fail")
+	}
+	atomic.StoreInt64(&fs.avail, 60)
+	cl.checkFreeSpace()
+	cl.lock()
+	assert.False(t, cl.lowFreeSpace)
+	cl.unlock()
+	mu.Lock()
+	assert.Equal(t, []bool{true, false}, events)
+	mu.Unlock()
+	for i := 0; ; i++ {
+		b, _ := ioutil.ReadFile(out)
+		if string(b) == "paused\nresumed\n" {
+			break
+		}
+		require.Less(t, i, 100, "got events %q", b)
+		time.Sleep(50 * time.Millisecond)
+	}
+}
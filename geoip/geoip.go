@@ -0,0 +1,47 @@
+// Package geoip looks up the country and network operator of IP addresses, for annotating peers.
+// It has no dependencies beyond the standard library.
+package geoip
+
+import (
+	"net"
+)
+
+// What's known about an IP. Fields are zero if unknown.
+type Info struct {
+	// ISO 3166-1 alpha-2 code, such as "NZ".
+	Country string
+	// Autonomous system number.
+	ASN uint32
+	// The organization operating the autonomous system.
+	ASOrg string
+}
+
+// Provides Info for IPs. Implementations must be safe for concurrent use.
+type Provider interface {
+	Lookup(net.IP) (Info, error)
+}
+
+// Combines Providers, such as separate country and ASN databases. For each field the first
+// Provider to give a non-zero value is used.
+func Merge(ps ...Provider) Provider {
+	return merged(ps)
+}
+
+type merged []Provider
+
+func (me merged) Lookup(ip net.IP) (ret Info, err error) {
+	for _, p := range me {
+		i, err := p.Lookup(ip)
+		if err != nil {
+			return ret, err
+		}
+		if ret.Country == "" {
+			ret.Country = i.Country
+		}
+		if ret.ASN == 0 {
+			ret.ASN = i.ASN
+			ret.ASOrg = i.ASOrg
+		}
+	}
+	return
+}
@@ -0,0 +1,313 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+var metadataStartMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// A MaxMind DB (.mmdb) file, such as the GeoLite2 Country, City or ASN databases. The whole file is
+// held in memory.
+type MMDB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// The node IPv4 addresses are looked up from in IPv6 trees.
+	ipv4Start uint
+}
+
+// Opens a MaxMind DB file.
+func OpenMMDB(path string) (*MMDB, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDB(b)
+}
+
+// Reads a MaxMind DB from its contents, which must not be modified afterwards.
+func NewMMDB(b []byte) (*MMDB, error) {
+	i := bytes.LastIndex(b, metadataStartMarker)
+	if i == -1 {
+		return nil, errors.New("metadata not found")
+	}
+	metaBytes := b[i+len(metadataStartMarker):]
+	v, _, err := decoder{metaBytes}.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata isn't a map")
+	}
+	db := &MMDB{buf: b}
+	db.nodeCount, _ = asUint(meta["node_count"])
+	db.recordSize, _ = asUint(meta["record_size"])
+	db.ipVersion, _ = asUint(meta["ip_version"])
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %v", db.recordSize)
+	}
+	treeSize := db.recordSize * 2 / 8 * db.nodeCount
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("search tree exceeds file")
+	}
+	db.data = b[treeSize+16 : i]
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readNode(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func (db *MMDB) readNode(node, bit uint) uint {
+	off := node * db.recordSize / 4
+	b := db.buf[off:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Returns the record for ip, or nil if there isn't one.
+func (db *MMDB) LookupRecord(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		node = db.readNode(node, uint(ip[i/8]>>(7-uint(i%8))&1))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("search tree too deep")
+	}
+	v, _, err := decoder{db.data}.decode(node - db.nodeCount - 16)
+	return v, err
+}
+
+// Extracts Info from the records of the GeoLite2 and GeoIP2 Country, City and ASN databases.
+func (db *MMDB) Lookup(ip net.IP) (ret Info, err error) {
+	v, err := db.LookupRecord(ip)
+	if err != nil {
+		return
+	}
+	m, _ := v.(map[string]interface{})
+	if country, ok := m["country"].(map[string]interface{}); ok {
+		ret.Country, _ = country["iso_code"].(string)
+	}
+	asn, _ := asUint(m["autonomous_system_number"])
+	ret.ASN = uint32(asn)
+	ret.ASOrg, _ = m["autonomous_system_organization"].(string)
+	return
+}
+
+func asUint(v interface{}) (uint, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return uint(v), true
+	case int32:
+		return uint(v), v >= 0
+	default:
+		return 0, false
+	}
+}
+
+// Decodes values from the data section, per the MaxMind DB format specification.
+type decoder struct {
+	b []byte
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errTruncated = errors.New("truncated data")
+
+func (d decoder) bytes(off, n uint) ([]byte, error) {
+	if off+n > uint(len(d.b)) || off+n < off {
+		return nil, errTruncated
+	}
+	return d.b[off : off+n], nil
+}
+
+// Returns the value at off, and the offset following it.
+func (d decoder) decode(off uint) (v interface{}, next uint, err error) {
+	return d.decodeDepth(off, 0)
+}
+
+func (d decoder) decodeDepth(off uint, depth int) (v interface{}, next uint, err error) {
+	if depth > 64 {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	b, err := d.bytes(off, 1)
+	if err != nil {
+		return
+	}
+	ctrl := b[0]
+	off++
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		var ptr uint
+		ptr, off, err = d.pointer(ctrl, off)
+		if err != nil {
+			return
+		}
+		v, _, err = d.decodeDepth(ptr, depth+1)
+		return v, off, err
+	}
+	if typ == typeExtended {
+		if b, err = d.bytes(off, 1); err != nil {
+			return
+		}
+		typ = 7 + uint(b[0])
+		off++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, err = d.bytes(off, n); err != nil {
+			return
+		}
+		off += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		case 3:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var k, e interface{}
+			k, off, err = d.decodeDepth(off, depth+1)
+			if err != nil {
+				return
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key isn't a string")
+			}
+			e, off, err = d.decodeDepth(off, depth+1)
+			if err != nil {
+				return
+			}
+			m[ks] = e
+		}
+		return m, off, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var e interface{}
+			e, off, err = d.decodeDepth(off, depth+1)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		return a, off, nil
+	case typeBool:
+		return size != 0, off, nil
+	}
+	if b, err = d.bytes(off, size); err != nil {
+		return
+	}
+	off += size
+	switch typ {
+	case typeString:
+		v = string(b)
+	case typeBytes, typeUint128:
+		v = append([]byte(nil), b...)
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("bad double size")
+		}
+		v = math.Float64frombits(binary.BigEndian.Uint64(b))
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("bad float size")
+		}
+		v = math.Float32frombits(binary.BigEndian.Uint32(b))
+	case typeUint16, typeUint32, typeUint64:
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		v = u
+	case typeInt32:
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		v = int32(u)
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %v", typ)
+	}
+	return v, off, nil
+}
+
+func (d decoder) pointer(ctrl byte, off uint) (ptr, next uint, err error) {
+	n := uint(ctrl>>3&0x3) + 1
+	b, err := d.bytes(off, n)
+	if err != nil {
+		return
+	}
+	vvv := uint(ctrl & 0x7)
+	switch n {
+	case 1:
+		ptr = vvv<<8 | uint(b[0])
+	case 2:
+		ptr = 2048 + (vvv<<16 | uint(b[0])<<8 | uint(b[1]))
+	case 3:
+		ptr = 526336 + (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+	case 4:
+		ptr = uint(binary.BigEndian.Uint32(b))
+	}
+	return ptr, off + n, nil
+}
@@ -0,0 +1,100 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mmdbEncoder struct {
+	bytes.Buffer
+}
+
+// Handles sizes up to 284.
+func (e *mmdbEncoder) ctrl(typ, size int) {
+	sizeBits := size
+	if size >= 29 {
+		sizeBits = 29
+	}
+	if typ > 7 {
+		e.WriteByte(byte(sizeBits))
+		e.WriteByte(byte(typ - 7))
+	} else {
+		e.WriteByte(byte(typ<<5 | sizeBits))
+	}
+	if size >= 29 {
+		e.WriteByte(byte(size - 29))
+	}
+}
+
+func (e *mmdbEncoder) string(s string) {
+	e.ctrl(typeString, len(s))
+	e.WriteString(s)
+}
+
+func (e *mmdbEncoder) uint32(u uint32) {
+	e.ctrl(typeUint32, 4)
+	binary.Write(e, binary.BigEndian, u)
+}
+
+func (e *mmdbEncoder) uint16(u uint16) {
+	e.ctrl(typeUint16, 2)
+	binary.Write(e, binary.BigEndian, u)
+}
+
+// Builds an IPv4 database with a single node: 0.0.0.0/1 has a record, and 128.0.0.0/1 doesn't.
+func testMMDB() []byte {
+	var data mmdbEncoder
+	data.ctrl(typeMap, 3)
+	data.string("country")
+	data.ctrl(typeMap, 1)
+	data.string("iso_code")
+	data.string("NZ")
+	data.string("autonomous_system_number")
+	data.uint32(64512)
+	data.string("autonomous_system_organization")
+	// A pointer back to "NZ", at offset 19 of the data section.
+	data.WriteByte(typePointer << 5)
+	data.WriteByte(19)
+	const nodeCount = 1
+	var b mmdbEncoder
+	// Left record points to the start of the data section, right is "not found".
+	b.Write([]byte{0, 0, nodeCount + 16, 0, 0, nodeCount})
+	b.Write(make([]byte, 16))
+	b.Write(data.Bytes())
+	b.Write(metadataStartMarker)
+	b.ctrl(typeMap, 3)
+	b.string("node_count")
+	b.uint32(nodeCount)
+	b.string("record_size")
+	b.uint16(24)
+	b.string("ip_version")
+	b.uint16(4)
+	return b.Bytes()
+}
+
+func TestMMDBLookup(t *testing.T) {
+	db, err := NewMMDB(testMMDB())
+	require.NoError(t, err)
+	info, err := db.Lookup(net.ParseIP("10.1.2.3"))
+	require.NoError(t, err)
+	assert.EqualValues(t, Info{Country: "NZ", ASN: 64512, ASOrg: "NZ"}, info)
+	info, err = db.Lookup(net.ParseIP("200.1.2.3"))
+	require.NoError(t, err)
+	assert.EqualValues(t, Info{}, info)
+	info, err = db.Lookup(net.ParseIP("::1"))
+	require.NoError(t, err)
+	assert.EqualValues(t, Info{}, info)
+}
+
+func TestMerge(t *testing.T) {
+	db, err := NewMMDB(testMMDB())
+	require.NoError(t, err)
+	info, err := Merge(db, db).Lookup(net.ParseIP("1.1.1.1"))
+	require.NoError(t, err)
+	assert.EqualValues(t, "NZ", info.Country)
+}
@@ -14,13 +14,13 @@ import (
 // Wraps a raw connection and provides the interface we want for using the
 // connection in the message loop.
 type deadlineReader struct {
-	nc net.Conn
-	r  io.Reader
+	nc      net.Conn
+	r       io.Reader
+	timeout time.Duration
 }
 
 func (r deadlineReader) Read(b []byte) (int, error) {
-	// Keep-alives should be received every 2 mins. Give a bit of gracetime.
-	err := r.nc.SetReadDeadline(time.Now().Add(150 * time.Second))
+	err := r.nc.SetReadDeadline(time.Now().Add(r.timeout))
 	if err != nil {
 		return 0, fmt.Errorf("error setting read deadline: %s", err)
 	}
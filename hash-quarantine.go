@@ -0,0 +1,115 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pauses a torrent after repeated piece hash failures, to protect against swarms that are being
+// poisoned. See ClientConfig.HashFailureQuarantine and Torrent.SetHashFailureQuarantine.
+type HashFailureQuarantine struct {
+	// The number of hash failures within Window that quarantines the torrent. Zero disables
+	// quarantine.
+	Failures int
+	// Failures older than this are forgotten. Zero keeps them until the torrent is resumed from
+	// quarantine.
+	Window time.Duration
+}
+
+// A piece hash failure for which peers supplied the data.
+type HashFailure struct {
+	Time  time.Time
+	Piece pieceIndex
+	// The remote addresses of the peers that contributed data to the piece.
+	Peers []string
+}
+
+// Describes why a torrent was quarantined.
+type TorrentQuarantine struct {
+	// The failures within the window, oldest first.
+	Failures []HashFailure
+	// Set if a ContentScanner rejected a file instead.
+	ScanRejection *ContentScanRejection
+}
+
+func (me TorrentQuarantine) String() string {
+	if me.ScanRejection != nil {
+		return me.ScanRejection.String()
+	}
+	return fmt.Sprintf("%d piece hash failures since %v", len(me.Failures), me.Failures[0].Time)
+}
+
+// Replaces the quarantine settings inherited from ClientConfig.HashFailureQuarantine.
+func (t *Torrent) SetHashFailureQuarantine(q HashFailureQuarantine) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.hashFailureQuarantine = q
+}
+
+// Returns the reason the torrent is quarantined, if it is. Data isn't downloaded or uploaded for
+// quarantined torrents until ResumeFromQuarantine is called.
+func (t *Torrent) Quarantine() (_ TorrentQuarantine, quarantined bool) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	if t.quarantine == nil {
+		return
+	}
+	return *t.quarantine, true
+}
+
+// Resumes data transfer for a quarantined torrent, and forgets past hash failures.
+func (t *Torrent) ResumeFromQuarantine() {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.quarantine = nil
+	t.recentHashFailures = nil
+	t.rescanRejectedFiles()
+	t.iterPeers(func(c *peer) {
+		c.updateRequests()
+	})
+	t.tickleReaders()
+}
+
+func (t *Torrent) quarantined() bool {
+	return t.quarantine != nil
+}
+
+// Records a hash failure for data from peers, quarantining the torrent if there have been too many
+// recently.
+func (t *Torrent) onPeerHashFailure(piece pieceIndex, peers []*peer) {
+	q := t.hashFailureQuarantine
+	if q.Failures <= 0 || t.quarantined() {
+		return
+	}
+	now := time.Now()
+	f := HashFailure{Time: now, Piece: piece}
+	for _, c := range peers {
+		if c.RemoteAddr != nil {
+			f.Peers = append(f.Peers, c.RemoteAddr.String())
+		}
+	}
+	fs := append(t.recentHashFailures, f)
+	for q.Window > 0 && len(fs) != 0 && now.Sub(fs[0].Time) > q.Window {
+		fs = fs[1:]
+	}
+	t.recentHashFailures = fs
+	if len(fs) < q.Failures {
+		return
+	}
+	t.setQuarantine(TorrentQuarantine{Failures: append([]HashFailure(nil), fs...)})
+}
+
+func (t *Torrent) setQuarantine(q TorrentQuarantine) {
+	t.quarantine = &q
+	torrent.Add("torrents quarantined", 1)
+	t.logger.Printf("quarantined: %v", t.quarantine)
+	t.iterPeers(func(c *peer) {
+		c.updateRequests()
+	})
+	t.tickleReaders()
+	if cb := t.cl.config.Callbacks.TorrentQuarantined; cb != nil {
+		// The Client lock is held.
+		go cb(t, *t.quarantine)
+	}
+	t.runEventCommands(TorrentEventQuarantined, fmt.Errorf("%v", t.quarantine))
+}
@@ -0,0 +1,130 @@
+package torrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/anacrolix/dht/v2"
+)
+
+// The result of one check in a HealthReport.
+type HealthCheck struct {
+	Name   string
+	OK     bool
+	Detail string `json:",omitempty"`
+	// Whether the check must pass for the Client to be ready.
+	Required bool
+}
+
+// Describes whether a Client is working, for monitoring and orchestration.
+type HealthReport struct {
+	// False once the Client is closed.
+	Live bool
+	// Live, and all the required checks pass.
+	Ready  bool
+	Checks []HealthCheck
+}
+
+// Checks the listeners, DHT servers, default storage and tracker announces.
+func (cl *Client) Health() (ret HealthReport) {
+	select {
+	case <-cl.Closed():
+	default:
+		ret.Live = true
+	}
+	ret.Checks = append(ret.Checks, cl.listenersHealth())
+	for _, ds := range cl.DhtServers() {
+		ret.Checks = append(ret.Checks, dhtServerHealth(ds))
+	}
+	ret.Checks = append(ret.Checks, cl.storageHealth(), cl.announceHealth())
+	ret.Ready = ret.Live
+	for _, c := range ret.Checks {
+		if c.Required && !c.OK {
+			ret.Ready = false
+		}
+	}
+	return
+}
+
+func (cl *Client) listenersHealth() HealthCheck {
+	addrs := cl.ListenAddrs()
+	return HealthCheck{
+		Name:     "listeners",
+		OK:       len(addrs) != 0,
+		Detail:   fmt.Sprint(addrs),
+		Required: true,
+	}
+}
+
+// A DHT server is bootstrapped once it knows of good nodes.
+func dhtServerHealth(ds DhtServer) HealthCheck {
+	ret := HealthCheck{
+		Name:     fmt.Sprintf("dht %v", ds.Addr()),
+		OK:       true,
+		Required: true,
+	}
+	if s, ok := ds.Stats().(dht.ServerStats); ok {
+		ret.OK = s.GoodNodes != 0
+		ret.Detail = fmt.Sprintf("%d good nodes of %d", s.GoodNodes, s.Nodes)
+	}
+	return ret
+}
+
+func (cl *Client) storageHealth() HealthCheck {
+	ret := HealthCheck{
+		Name:     "storage",
+		OK:       true,
+		Required: true,
+	}
+	if err := cl.defaultStorage.Ping(); err != nil {
+		ret.OK = false
+		ret.Detail = err.Error()
+	}
+	return ret
+}
+
+// Reports the proportion of trackers whose last announce succeeded. This isn't required, as
+// trackers come and go, and torrents may rely on other peer sources.
+func (cl *Client) announceHealth() HealthCheck {
+	cl.rLock()
+	defer cl.rUnlock()
+	var announced, succeeded int
+	for _, t := range cl.torrents {
+		for _, ta := range t.trackerAnnouncers {
+			ts, ok := ta.(*trackerScraper)
+			if !ok || ts.lastAnnounce.Completed.IsZero() {
+				continue
+			}
+			announced++
+			if ts.lastAnnounce.Err == nil {
+				succeeded++
+			}
+		}
+	}
+	return HealthCheck{
+		Name:   "announces",
+		OK:     announced == 0 || succeeded != 0,
+		Detail: fmt.Sprintf("%d of %d trackers announced successfully", succeeded, announced),
+	}
+}
+
+// Returns a handler serving liveness at /healthz and readiness at /readyz, in the style of
+// Kubernetes probes. Both respond with the HealthReport as JSON, and with status 503 if it fails.
+// It's intended to be mounted on a debug HTTP mux.
+func (cl *Client) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	serve := func(ok func(HealthReport) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			report := cl.Health()
+			w.Header().Set("Content-Type", "application/json")
+			if !ok(report) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(report)
+		}
+	}
+	mux.Handle("/healthz", serve(func(r HealthReport) bool { return r.Live }))
+	mux.Handle("/readyz", serve(func(r HealthReport) bool { return r.Ready }))
+	return mux
+}
@@ -0,0 +1,49 @@
+package torrent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+type failingPingStorage struct {
+	storage.ClientImpl
+}
+
+func (failingPingStorage) Ping() error {
+	return errors.New("unreachable")
+}
+
+func TestHealth(t *testing.T) {
+	cfg := TestingConfig()
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	report := cl.Health()
+	assert.True(t, report.Live)
+	assert.True(t, report.Ready, "%+v", report)
+	h := cl.HealthHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	cl.Close()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHealthStorageUnreachable(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.DefaultStorage = failingPingStorage{storage.NewFile(cfg.DataDir)}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	report := cl.Health()
+	assert.True(t, report.Live)
+	assert.False(t, report.Ready)
+}
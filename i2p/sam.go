@@ -0,0 +1,392 @@
+// Package i2p connects to peers and trackers on the I2P network through a router's SAMv3 bridge. See
+// https://geti2p.net/en/docs/api/samv3. A SAM is both a torrent.OverlayNetwork, for dialing peers
+// and trackers at .i2p addresses, and a torrent.Listener, for peers connecting to its destination:
+//
+//	sam, err := i2p.NewSAM(ctx, i2p.SAMOpts{})
+//	cfg.OverlayNetworks = []torrent.OverlayNetwork{sam}
+//	cl, err := torrent.NewClient(cfg)
+//	cl.AddListener(sam)
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The address of the SAM bridge of a router with the default configuration.
+const DefaultSAMAddr = "127.0.0.1:7656"
+
+// I2P uses base64 with "-" and "~" in place of "+" and "/".
+var Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+var b32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// The shortest destination: an ElGamal public key, a DSA signing key, and a null certificate.
+const minDestinationLen = 256 + 128 + 3
+
+// Returns the .b32.i2p address of the destination with the given SHA-256 hash, such as from the
+// compact peers of an I2P tracker.
+func HashAddr(hash [32]byte) string {
+	return strings.ToLower(b32Encoding.EncodeToString(hash[:])) + ".b32.i2p"
+}
+
+// Returns the .b32.i2p address of a base64 destination.
+func Base32Addr(dest string) (string, error) {
+	b, err := Encoding.DecodeString(dest)
+	if err != nil {
+		return "", err
+	}
+	return HashAddr(sha256.Sum256(b)), nil
+}
+
+func isDestination(s string) bool {
+	b, err := Encoding.DecodeString(s)
+	return err == nil && len(b) >= minDestinationLen
+}
+
+// An I2P address: a .b32.i2p or .i2p host name, or a base64 destination.
+type Addr string
+
+func (Addr) Network() string {
+	return "i2p"
+}
+
+func (me Addr) String() string {
+	return string(me)
+}
+
+type SAMOpts struct {
+	// The address of the router's SAM bridge. Defaults to DefaultSAMAddr.
+	Addr string
+	// Keys returned by SAM.PrivateKey, to keep the same destination across sessions. A new
+	// transient destination is created if empty.
+	PrivateKey string
+	// Additional SESSION CREATE options, such as "inbound.length=2".
+	Options []string
+}
+
+// A SAM STREAM session. Its destination is created with the session, and goes away when it's
+// closed.
+type SAM struct {
+	opts       SAMOpts
+	id         string
+	control    net.Conn
+	privateKey string
+	dest       string
+	addr       Addr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Creates a session on the router's SAM bridge. This can take a while, as the router builds
+// tunnels for the destination.
+func NewSAM(ctx context.Context, opts SAMOpts) (_ *SAM, err error) {
+	if opts.Addr == "" {
+		opts.Addr = DefaultSAMAddr
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	me := &SAM{
+		opts:   opts,
+		id:     hex.EncodeToString(id[:]),
+		closed: make(chan struct{}),
+	}
+	c, r, err := me.open(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+	stop := closeOnDone(ctx.Done(), c)
+	defer stop()
+	dest := opts.PrivateKey
+	if dest == "" {
+		dest = "TRANSIENT SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
+	}
+	reply, err := command(c, r, fmt.Sprintf(
+		"SESSION CREATE STYLE=STREAM ID=%s DESTINATION=%s %s",
+		me.id, dest, strings.Join(opts.Options, " ")))
+	if err != nil {
+		return nil, fmt.Errorf("creating session: %w", err)
+	}
+	me.privateKey = reply["DESTINATION"]
+	reply, err = command(c, r, "NAMING LOOKUP NAME=ME")
+	if err != nil {
+		return nil, fmt.Errorf("looking up session destination: %w", err)
+	}
+	me.dest = reply["VALUE"]
+	b32, err := Base32Addr(me.dest)
+	if err != nil {
+		return nil, fmt.Errorf("session destination: %w", err)
+	}
+	me.addr = Addr(b32)
+	c.SetDeadline(time.Time{})
+	me.control = c
+	go me.keepAlive(r)
+	return me, nil
+}
+
+// Answers pings on the control connection, which holds the session open, until it's closed.
+func (me *SAM) keepAlive(r *bufio.Reader) {
+	defer me.Close()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(line, "PING") {
+			_, err = io.WriteString(me.control, "PONG"+strings.TrimPrefix(line, "PING"))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Ends the session. Streams already established are closed by the router.
+func (me *SAM) Close() error {
+	me.closeOnce.Do(func() {
+		close(me.closed)
+		if me.control != nil {
+			me.control.Close()
+		}
+	})
+	return nil
+}
+
+// The session's private keys, for SAMOpts.PrivateKey.
+func (me *SAM) PrivateKey() string {
+	return me.privateKey
+}
+
+// The session's base64 destination.
+func (me *SAM) Destination() string {
+	return me.dest
+}
+
+// The session's .b32.i2p address.
+func (me *SAM) Addr() net.Addr {
+	return me.addr
+}
+
+func (me *SAM) Network() string {
+	return "i2p"
+}
+
+// Claims .i2p host names, and base64 destinations. Ports aren't used.
+func (me *SAM) ParseAddr(host string, port int) (string, bool) {
+	if strings.HasSuffix(host, ".i2p") || isDestination(host) {
+		return host, true
+	}
+	return "", false
+}
+
+// Opens a stream to an address accepted by ParseAddr.
+func (me *SAM) Dial(ctx context.Context, addr string) (_ net.Conn, err error) {
+	c, r, err := me.open(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+	stop := closeOnDone(ctx.Done(), c)
+	defer stop()
+	dest, err := lookup(c, r, addr)
+	if err != nil {
+		return
+	}
+	_, err = command(c, r, fmt.Sprintf("STREAM CONNECT ID=%s DESTINATION=%s SILENT=false", me.id, dest))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %v: %w", addr, err)
+	}
+	c.SetDeadline(time.Time{})
+	return streamConn{c, r, me.addr, Addr(addr)}, nil
+}
+
+// Dials host:port addresses of I2P hosts, for use with net/http. The port is ignored.
+func (me *SAM) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if _, ok := me.ParseAddr(host, 0); !ok {
+		return nil, fmt.Errorf("%q isn't an I2P address", host)
+	}
+	return me.Dial(ctx, host)
+}
+
+// Waits for a stream to the session's destination. The remote address is the peer's .b32.i2p
+// address.
+func (me *SAM) Accept() (_ net.Conn, err error) {
+	c, r, err := me.open(context.Background())
+	if err != nil {
+		// Don't spin if the bridge has gone away.
+		select {
+		case <-me.closed:
+		case <-time.After(time.Second):
+		}
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+	stop := closeOnDone(me.closed, c)
+	defer stop()
+	_, err = command(c, r, fmt.Sprintf("STREAM ACCEPT ID=%s SILENT=false", me.id))
+	if err != nil {
+		return nil, fmt.Errorf("accepting: %w", err)
+	}
+	// The peer's destination comes first, followed by any options, on a line of its own.
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, errors.New("no peer destination")
+	}
+	remote, err := Base32Addr(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("peer destination: %w", err)
+	}
+	return streamConn{c, r, me.addr, Addr(remote)}, nil
+}
+
+// Connects to the bridge and negotiates the protocol version.
+func (me *SAM) open(ctx context.Context) (c net.Conn, r *bufio.Reader, err error) {
+	select {
+	case <-me.closed:
+		return nil, nil, errors.New("sam closed")
+	default:
+	}
+	var d net.Dialer
+	c, err = d.DialContext(ctx, "tcp", me.opts.Addr)
+	if err != nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	r = bufio.NewReader(c)
+	_, err = command(c, r, "HELLO VERSION MIN=3.1 MAX=3.3")
+	if err != nil {
+		c.Close()
+		err = fmt.Errorf("hello: %w", err)
+	}
+	return
+}
+
+// Closes c if cancel is closed before stop is called.
+func closeOnDone(cancel <-chan struct{}, c net.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Returns the base64 destination for an address accepted by ParseAddr, looking up host names with
+// the router.
+func lookup(c net.Conn, r *bufio.Reader, addr string) (string, error) {
+	if isDestination(addr) {
+		return addr, nil
+	}
+	// Trackers give destinations in place of IPs with a .i2p suffix.
+	if dest := strings.TrimSuffix(addr, ".i2p"); isDestination(dest) {
+		return dest, nil
+	}
+	reply, err := command(c, r, "NAMING LOOKUP NAME="+addr)
+	if err != nil {
+		return "", fmt.Errorf("looking up %v: %w", addr, err)
+	}
+	return reply["VALUE"], nil
+}
+
+// Sends a command, and returns the key-value pairs of its reply. An error is returned if the reply
+// has a RESULT other than OK.
+func command(w io.Writer, r *bufio.Reader, cmd string) (map[string]string, error) {
+	_, err := io.WriteString(w, cmd+"\n")
+	if err != nil {
+		return nil, err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	reply := parseReply(line)
+	if result, ok := reply["RESULT"]; ok && result != "OK" {
+		if msg := reply["MESSAGE"]; msg != "" {
+			return reply, fmt.Errorf("%s: %s", result, msg)
+		}
+		return reply, errors.New(result)
+	}
+	return reply, nil
+}
+
+// Parses the KEY=VALUE pairs following the topic of a reply line. Values can be quoted.
+func parseReply(line string) map[string]string {
+	ret := make(map[string]string)
+	line = strings.TrimSpace(line)
+	for line != "" {
+		var field string
+		i := 0
+		inQuote := false
+		for ; i < len(line); i++ {
+			if line[i] == '"' {
+				inQuote = !inQuote
+			} else if line[i] == ' ' && !inQuote {
+				break
+			}
+		}
+		field, line = line[:i], strings.TrimLeft(line[i:], " ")
+		if eq := strings.IndexByte(field, '='); eq >= 0 {
+			ret[field[:eq]] = strings.Trim(field[eq+1:], `"`)
+		}
+	}
+	return ret
+}
+
+// A stream over a bridge connection. Data the peer sent before the handshake completed may be
+// buffered in the reader.
+type streamConn struct {
+	net.Conn
+	r             *bufio.Reader
+	local, remote Addr
+}
+
+func (me streamConn) Read(b []byte) (int, error) {
+	return me.r.Read(b)
+}
+
+func (me streamConn) LocalAddr() net.Addr {
+	return me.local
+}
+
+func (me streamConn) RemoteAddr() net.Addr {
+	return me.remote
+}
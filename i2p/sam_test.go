@@ -0,0 +1,135 @@
+package i2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDestination(b byte) string {
+	return Encoding.EncodeToString(bytes.Repeat([]byte{b}, minDestinationLen))
+}
+
+var (
+	testSessionDest = testDestination(1)
+	testPeerDest    = testDestination(2)
+)
+
+// Answers SAM commands on each connection like a router would. Streams echo what's written to them,
+// and accepted streams are from testPeerDest.
+func fakeBridge(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeBridge(c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveFakeBridge(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		reply := parseReply(line)
+		switch {
+		case strings.HasPrefix(line, "HELLO"):
+			io.WriteString(c, "HELLO REPLY RESULT=OK VERSION=3.3\n")
+		case strings.HasPrefix(line, "SESSION CREATE"):
+			io.WriteString(c, "SESSION STATUS RESULT=OK DESTINATION=privkey\n")
+		case strings.HasPrefix(line, "NAMING LOOKUP"):
+			switch reply["NAME"] {
+			case "ME":
+				io.WriteString(c, "NAMING REPLY RESULT=OK NAME=ME VALUE="+testSessionDest+"\n")
+			case "peer.i2p":
+				io.WriteString(c, "NAMING REPLY RESULT=OK NAME=peer.i2p VALUE="+testPeerDest+"\n")
+			default:
+				io.WriteString(c, `NAMING REPLY RESULT=KEY_NOT_FOUND MESSAGE="no such host"`+"\n")
+			}
+		case strings.HasPrefix(line, "STREAM CONNECT"):
+			if reply["DESTINATION"] != testPeerDest {
+				io.WriteString(c, "STREAM STATUS RESULT=CANT_REACH_PEER\n")
+				continue
+			}
+			io.WriteString(c, "STREAM STATUS RESULT=OK\n")
+			io.Copy(c, r)
+			return
+		case strings.HasPrefix(line, "STREAM ACCEPT"):
+			io.WriteString(c, "STREAM STATUS RESULT=OK\n"+testPeerDest+" FROM_PORT=0 TO_PORT=0\nhello")
+			io.Copy(ioutil.Discard, r)
+			return
+		}
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	assert.Equal(t,
+		map[string]string{"RESULT": "I2P_ERROR", "MESSAGE": "bad things"},
+		parseReply(`STREAM STATUS RESULT=I2P_ERROR MESSAGE="bad things"`+"\n"))
+}
+
+func TestBase32Addr(t *testing.T) {
+	addr, err := Base32Addr(testPeerDest)
+	require.NoError(t, err)
+	b, _ := Encoding.DecodeString(testPeerDest)
+	assert.Equal(t, HashAddr(sha256.Sum256(b)), addr)
+	assert.Len(t, addr, 52+len(".b32.i2p"))
+}
+
+func TestSAM(t *testing.T) {
+	sam, err := NewSAM(context.Background(), SAMOpts{Addr: fakeBridge(t)})
+	require.NoError(t, err)
+	defer sam.Close()
+	assert.Equal(t, testSessionDest, sam.Destination())
+	assert.Equal(t, "privkey", sam.PrivateKey())
+	addr, _ := Base32Addr(testSessionDest)
+	assert.Equal(t, addr, sam.Addr().String())
+
+	_, ok := sam.ParseAddr("peer.i2p", 6881)
+	assert.True(t, ok)
+	_, ok = sam.ParseAddr("example.com", 6881)
+	assert.False(t, ok)
+
+	for _, addr := range []string{"peer.i2p", testPeerDest, testPeerDest + ".i2p"} {
+		c, err := sam.Dial(context.Background(), addr)
+		require.NoError(t, err, addr)
+		io.WriteString(c, "ping")
+		b := make([]byte, 4)
+		_, err = io.ReadFull(c, b)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(b))
+		assert.Equal(t, addr, c.RemoteAddr().String())
+		c.Close()
+	}
+	_, err = sam.Dial(context.Background(), "unknown.i2p")
+	assert.Contains(t, err.Error(), "no such host")
+
+	c, err := sam.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	peerAddr, _ := Base32Addr(testPeerDest)
+	assert.Equal(t, peerAddr, c.RemoteAddr().String())
+	b := make([]byte, 5)
+	_, err = io.ReadFull(c, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
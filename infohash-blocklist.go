@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/anacrolix/dht/v2/krpc"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Returned when adding a torrent with an infohash blocked by Client.BlockInfoHashes.
+var ErrInfoHashBlocked = errors.New("infohash blocked")
+
+// Something refused for an infohash blocked by Client.BlockInfoHashes. See
+// Callbacks.InfoHashRefused.
+type InfoHashRefusal struct {
+	InfoHash metainfo.Hash
+	// One of "add", "drop" (for a torrent already added when it was blocked), "handshake" or "dht
+	// announce".
+	Action string
+	// The remote address, for handshakes and DHT announces.
+	Source net.Addr
+	Time   time.Time
+}
+
+// Refuses to add torrents with the infohashes, to complete incoming handshakes for them, and to
+// accept DHT announces of peers for them. Torrents with the infohashes that are already added are
+// dropped, and dormant ones are forgotten. Each refusal is logged and passed to
+// Callbacks.InfoHashRefused. The infohashes are blocked in addition to those already blocked.
+func (cl *Client) BlockInfoHashes(ihs []metainfo.Hash) {
+	cl.lock()
+	defer cl.unlock()
+	if cl.blockedInfoHashes == nil {
+		cl.blockedInfoHashes = make(map[metainfo.Hash]struct{}, len(ihs))
+	}
+	for _, ih := range ihs {
+		cl.blockedInfoHashes[ih] = struct{}{}
+		delete(cl.dormantTorrents, ih)
+		delete(cl.dhtStoredPeers, ih)
+		if _, ok := cl.torrents[ih]; ok {
+			cl.dropTorrent(ih)
+			cl.refuseInfoHash(ih, "drop", nil)
+		}
+	}
+}
+
+// Stops blocking the infohashes. Torrents dropped when they were blocked aren't re-added.
+func (cl *Client) UnblockInfoHashes(ihs []metainfo.Hash) {
+	cl.lock()
+	defer cl.unlock()
+	for _, ih := range ihs {
+		delete(cl.blockedInfoHashes, ih)
+	}
+}
+
+// Returns whether the infohash is blocked by BlockInfoHashes.
+func (cl *Client) InfoHashBlocked(ih metainfo.Hash) bool {
+	cl.rLock()
+	defer cl.rUnlock()
+	return cl.infoHashBlocked(ih)
+}
+
+func (cl *Client) infoHashBlocked(ih metainfo.Hash) bool {
+	_, ok := cl.blockedInfoHashes[ih]
+	return ok
+}
+
+// Records the refusal of an action for a blocked infohash. The Client lock must be held, for
+// reading at least, so Callbacks.InfoHashRefused is called in its own goroutine.
+func (cl *Client) refuseInfoHash(ih metainfo.Hash, action string, source net.Addr) {
+	torrent.Add("blocked infohash refusals: "+action, 1)
+	if source != nil {
+		cl.logger.Printf("refused %s for blocked infohash %v from %v", action, ih, source)
+	} else {
+		cl.logger.Printf("refused %s for blocked infohash %v", action, ih)
+	}
+	if cb := cl.config.Callbacks.InfoHashRefused; cb != nil {
+		go cb(InfoHashRefusal{
+			InfoHash: ih,
+			Action:   action,
+			Source:   source,
+			Time:     time.Now(),
+		})
+	}
+}
+
+// Refuses an action if the infohash is blocked. The Client lock must be held, for reading at least.
+func (cl *Client) refuseBlockedInfoHash(ih metainfo.Hash, action string, source net.Addr) bool {
+	if !cl.infoHashBlocked(ih) {
+		return false
+	}
+	cl.refuseInfoHash(ih, action, source)
+	return true
+}
+
+// Drops announce_peer queries for blocked infohashes, so the DHT servers don't store the peers or
+// respond, before any ClientConfig.DHTOnQuery.
+func (cl *Client) onDhtQuery(query *krpc.Msg, source net.Addr) (propagate bool) {
+	if query.Q == "announce_peer" && query.A != nil {
+		cl.rLock()
+		refused := cl.refuseBlockedInfoHash(metainfo.Hash(query.A.InfoHash), "dht announce", source)
+		cl.rUnlock()
+		if refused {
+			return false
+		}
+	}
+	if f := cl.config.DHTOnQuery; f != nil {
+		return f(query, source)
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package torrent
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+func TestBlockInfoHashes(t *testing.T) {
+	var mu sync.Mutex
+	var actions []string
+	cfg := TestingConfig()
+	cfg.Callbacks.InfoHashRefused = func(r InfoHashRefusal) {
+		mu.Lock()
+		defer mu.Unlock()
+		actions = append(actions, r.Action)
+	}
+	refused := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), actions...)
+	}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ih := metainfo.Hash{1}
+	cl.AddTorrentInfoHash(ih)
+	cl.BlockInfoHashes([]metainfo.Hash{ih})
+	assert.True(t, cl.InfoHashBlocked(ih))
+	assert.Empty(t, cl.Torrents())
+	tt, new := cl.AddTorrentInfoHash(ih)
+	require.NotNil(t, tt)
+	assert.False(t, new)
+	assert.True(t, tt.closed.IsSet())
+	assert.Empty(t, cl.Torrents())
+	_, _, err = cl.AddTorrentSpec(&TorrentSpec{InfoHash: ih})
+	assert.Equal(t, ErrInfoHashBlocked, err)
+	assert.Equal(t, ErrInfoHashBlocked, cl.AddDormantTorrentSpec(&TorrentSpec{InfoHash: ih}))
+
+	msg := krpc.Msg{Q: "announce_peer", A: &krpc.MsgArgs{}}
+	copy(msg.A.InfoHash[:], ih[:])
+	assert.False(t, cl.onDhtQuery(&msg, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}))
+	msg.Q = "get_peers"
+	assert.True(t, cl.onDhtQuery(&msg, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}))
+
+	nc, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cl.LocalPort()))
+	require.NoError(t, err)
+	defer nc.Close()
+	_, err = pp.Handshake(nc, &ih, [20]byte{2}, pp.PeerExtensionBits{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(refused()) == 6
+	}, 10*time.Second, time.Millisecond)
+	// The callback is called in its own goroutine, so the refusals can arrive in any order.
+	assert.ElementsMatch(t, []string{"drop", "add", "add", "add", "dht announce", "handshake"}, refused())
+
+	cl.UnblockInfoHashes([]metainfo.Hash{ih})
+	tt, new = cl.AddTorrentInfoHash(ih)
+	assert.NotNil(t, tt)
+	assert.True(t, new)
+}
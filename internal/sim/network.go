@@ -0,0 +1,357 @@
+// Package sim runs swarms of in-process Clients over a virtual network, so that piece selection and
+// request strategies can be measured under controlled latency, loss and bandwidth. All random
+// choices made by the network and swarm are drawn from a single seeded source, so runs with the same
+// seed draw the same sequence of delays and peer selections (goroutine scheduling still varies).
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Describes the behaviour of every link in a Network. Each direction of a connection is modelled
+// separately.
+type LinkModel struct {
+	// One-way delay applied to every write.
+	Latency time.Duration
+	// Uniformly distributed extra delay in [0, Jitter).
+	Jitter time.Duration
+	// Probability in [0, 1] that a write is lost and has to be retransmitted.
+	Loss float64
+	// Added to the delivery of lost writes. Defaults to 4 × Latency, with a minimum of 10ms.
+	RetransmitTimeout time.Duration
+	// Bytes per second for each direction of a connection. Zero is unlimited.
+	Bandwidth int64
+}
+
+func (me LinkModel) retransmitTimeout() time.Duration {
+	if me.RetransmitTimeout != 0 {
+		return me.RetransmitTimeout
+	}
+	ret := 4 * me.Latency
+	if ret < 10*time.Millisecond {
+		ret = 10 * time.Millisecond
+	}
+	return ret
+}
+
+// A virtual network connecting Nodes. Connections are reliable ordered streams like TCP; the
+// LinkModel only affects when data becomes readable.
+type Network struct {
+	link LinkModel
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	nodes    map[string]*Node
+	nextHost uint32
+}
+
+func NewNetwork(seed int64, link LinkModel) *Network {
+	return &Network{
+		link:  link,
+		rand:  rand.New(rand.NewSource(seed)),
+		nodes: make(map[string]*Node),
+	}
+}
+
+// Returns a pseudo-random int in [0, max) drawn from the Network's seeded source. Safe for
+// concurrent use.
+func (n *Network) Intn(max int) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.rand.Intn(max)
+}
+
+// Adds a Node with a unique address to the network.
+func (n *Network) NewNode() *Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nextHost++
+	h := n.nextHost
+	node := &Node{
+		net: n,
+		addr: &net.TCPAddr{
+			IP:   net.IPv4(10, byte(h>>16), byte(h>>8), byte(h)),
+			Port: 6881,
+		},
+		conns:  make(chan net.Conn, 16),
+		closed: make(chan struct{}),
+	}
+	n.nodes[node.addr.String()] = node
+	return node
+}
+
+// Returns the delay for a single write across a link.
+func (n *Network) delay() (ret time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ret = n.link.Latency
+	if n.link.Jitter > 0 {
+		ret += time.Duration(n.rand.Int63n(int64(n.link.Jitter)))
+	}
+	if n.link.Loss > 0 && n.rand.Float64() < n.link.Loss {
+		ret += n.link.retransmitTimeout()
+	}
+	return
+}
+
+func (n *Network) newConnPair(a, b net.Addr) (net.Conn, net.Conn) {
+	ab := newPipe()
+	ba := newPipe()
+	return &conn{net: n, in: ba, out: ab, local: a, remote: b},
+		&conn{net: n, in: ab, out: ba, local: b, remote: a}
+}
+
+var errClosed = errors.New("use of closed connection")
+
+// A host on a Network. It implements both torrent.Listener and torrent.Dialer.
+type Node struct {
+	net       *Network
+	addr      *net.TCPAddr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (me *Node) Addr() net.Addr {
+	return me.addr
+}
+
+func (me *Node) LocalAddr() net.Addr {
+	return me.addr
+}
+
+func (me *Node) Accept() (net.Conn, error) {
+	select {
+	case c := <-me.conns:
+		return c, nil
+	case <-me.closed:
+		return nil, errClosed
+	}
+}
+
+// Stops accepting connections. Established connections are unaffected.
+func (me *Node) Close() error {
+	me.closeOnce.Do(func() {
+		close(me.closed)
+		me.net.mu.Lock()
+		delete(me.net.nodes, me.addr.String())
+		me.net.mu.Unlock()
+	})
+	return nil
+}
+
+func (me *Node) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	me.net.mu.Lock()
+	remote, ok := me.net.nodes[addr]
+	me.net.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial %v: connection refused", addr)
+	}
+	// Establishing the connection costs a round trip.
+	t := time.NewTimer(me.net.delay() + me.net.delay())
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	local, accepted := me.net.newConnPair(me.addr, remote.addr)
+	select {
+	case remote.conns <- accepted:
+		return local, nil
+	case <-remote.closed:
+		return nil, fmt.Errorf("dial %v: connection refused", addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type segment struct {
+	b  []byte
+	at time.Time
+}
+
+// One direction of a connection. Writes never block, and become readable once their delivery time
+// has passed.
+type pipe struct {
+	mu        sync.Mutex
+	segs      []segment
+	lastAt    time.Time
+	busyUntil time.Time
+	deadline  time.Time
+	// The writer has closed: reads return io.EOF once drained.
+	writeClosed bool
+	// The reader has closed: writes fail.
+	readClosed bool
+	notify     chan struct{}
+}
+
+func newPipe() *pipe {
+	return &pipe{notify: make(chan struct{}, 1)}
+}
+
+func (p *pipe) signal() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pipe) write(b []byte, link LinkModel, delay time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeClosed {
+		return errClosed
+	}
+	if p.readClosed {
+		return errors.New("broken pipe")
+	}
+	now := time.Now()
+	sent := now
+	if link.Bandwidth > 0 {
+		if p.busyUntil.After(sent) {
+			sent = p.busyUntil
+		}
+		sent = sent.Add(time.Duration(int64(len(b)) * int64(time.Second) / link.Bandwidth))
+		p.busyUntil = sent
+	}
+	at := sent.Add(delay)
+	// Streams are ordered, so nothing can arrive before data written earlier.
+	if at.Before(p.lastAt) {
+		at = p.lastAt
+	}
+	p.lastAt = at
+	p.segs = append(p.segs, segment{append([]byte(nil), b...), at})
+	p.signal()
+	return nil
+}
+
+func (p *pipe) read(b []byte) (n int, err error) {
+	for {
+		p.mu.Lock()
+		if p.readClosed {
+			p.mu.Unlock()
+			return 0, errClosed
+		}
+		now := time.Now()
+		for len(p.segs) != 0 && n < len(b) && !now.Before(p.segs[0].at) {
+			head := &p.segs[0]
+			m := copy(b[n:], head.b)
+			n += m
+			head.b = head.b[m:]
+			if len(head.b) == 0 {
+				p.segs = p.segs[1:]
+			}
+		}
+		if n != 0 {
+			p.mu.Unlock()
+			return
+		}
+		if len(p.segs) == 0 && p.writeClosed {
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		if !p.deadline.IsZero() && !now.Before(p.deadline) {
+			p.mu.Unlock()
+			return 0, timeoutError{}
+		}
+		wake := p.deadline
+		if len(p.segs) != 0 && (wake.IsZero() || p.segs[0].at.Before(wake)) {
+			wake = p.segs[0].at
+		}
+		p.mu.Unlock()
+		if wake.IsZero() {
+			<-p.notify
+			continue
+		}
+		t := time.NewTimer(wake.Sub(now))
+		select {
+		case <-p.notify:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+func (p *pipe) setDeadline(t time.Time) {
+	p.mu.Lock()
+	p.deadline = t
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *pipe) closeWrite() {
+	p.mu.Lock()
+	p.writeClosed = true
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *pipe) closeRead() {
+	p.mu.Lock()
+	p.readClosed = true
+	p.segs = nil
+	p.mu.Unlock()
+	p.signal()
+}
+
+type conn struct {
+	net           *Network
+	in, out       *pipe
+	local, remote net.Addr
+	closeOnce     sync.Once
+}
+
+var _ net.Conn = (*conn)(nil)
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := c.out.write(b, c.net.link, c.net.delay()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.out.closeWrite()
+		c.in.closeRead()
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// Writes never block, so write deadlines have no effect.
+func (c *conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
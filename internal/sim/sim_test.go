@@ -0,0 +1,89 @@
+package sim
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelaysReproducible(t *testing.T) {
+	link := LinkModel{
+		Latency: time.Millisecond,
+		Jitter:  5 * time.Millisecond,
+		Loss:    0.2,
+	}
+	a := NewNetwork(42, link)
+	b := NewNetwork(42, link)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, a.delay(), b.delay())
+	}
+}
+
+func TestConnDeliversInOrder(t *testing.T) {
+	n := NewNetwork(1, LinkModel{
+		Latency: time.Millisecond,
+		Jitter:  3 * time.Millisecond,
+		Loss:    0.1,
+	})
+	server := n.NewNode()
+	client := n.NewNode()
+	go func() {
+		c, err := client.Dial(context.Background(), server.Addr().String())
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			c.Write([]byte{byte(i)})
+		}
+		c.Close()
+	}()
+	c, err := server.Accept()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(c)
+	require.NoError(t, err)
+	require.Len(t, b, 100)
+	for i := range b {
+		assert.EqualValues(t, i, b[i])
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	n := NewNetwork(1, LinkModel{})
+	a, _ := n.newConnPair(n.NewNode().Addr(), n.NewNode().Addr())
+	a.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := a.Read(make([]byte, 1))
+	require.Error(t, err)
+	assert.True(t, err.(interface{ Timeout() bool }).Timeout())
+}
+
+func TestSwarmCompletes(t *testing.T) {
+	s, err := NewSwarm(SwarmConfig{
+		Seed: 1,
+		Link: LinkModel{
+			Latency: time.Millisecond,
+			Jitter:  time.Millisecond,
+			Loss:    0.01,
+		},
+		Seeders:     1,
+		Leechers:    3,
+		Length:      1 << 18,
+		PieceLength: 1 << 14,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	res, err := s.Run(ctx)
+	require.NoError(t, err)
+	t.Logf("%+v", res)
+	assert.True(t, res.TotalUploadedData >= int64(len(s.Data))*int64(len(s.Leechers)))
+	for _, l := range s.Leechers {
+		r := l.NewReader()
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.True(t, string(b) == string(s.Data))
+	}
+}
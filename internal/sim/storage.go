@@ -0,0 +1,103 @@
+package sim
+
+import (
+	"io"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Storage that keeps all torrent data in memory. If data is given, every torrent opened is
+// prefilled with it and all pieces are reported complete.
+type memoryStorage struct {
+	data []byte
+}
+
+func newMemoryStorage(data []byte) storage.ClientImpl {
+	return memoryStorage{data}
+}
+
+func (me memoryStorage) OpenTorrent(info *metainfo.Info, _ metainfo.Hash) (storage.TorrentImpl, error) {
+	t := &memoryTorrent{
+		data:     make([]byte, info.TotalLength()),
+		complete: make([]bool, info.NumPieces()),
+	}
+	if me.data != nil {
+		copy(t.data, me.data)
+		for i := range t.complete {
+			t.complete[i] = true
+		}
+	}
+	return t, nil
+}
+
+type memoryTorrent struct {
+	mu       sync.RWMutex
+	data     []byte
+	complete []bool
+}
+
+func (me *memoryTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	return memoryPiece{me, p.Index(), p.Offset(), p.Length()}
+}
+
+func (me *memoryTorrent) Close() error {
+	return nil
+}
+
+type memoryPiece struct {
+	t      *memoryTorrent
+	index  int
+	offset int64
+	length int64
+}
+
+func (me memoryPiece) ReadAt(b []byte, off int64) (n int, err error) {
+	me.t.mu.RLock()
+	defer me.t.mu.RUnlock()
+	if off >= me.length {
+		return 0, io.EOF
+	}
+	n = copy(b, me.t.data[me.offset+off:me.offset+me.length])
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
+func (me memoryPiece) WriteAt(b []byte, off int64) (n int, err error) {
+	me.t.mu.Lock()
+	defer me.t.mu.Unlock()
+	if off >= me.length {
+		return 0, io.ErrShortWrite
+	}
+	n = copy(me.t.data[me.offset+off:me.offset+me.length], b)
+	if n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return
+}
+
+func (me memoryPiece) MarkComplete() error {
+	me.t.mu.Lock()
+	defer me.t.mu.Unlock()
+	me.t.complete[me.index] = true
+	return nil
+}
+
+func (me memoryPiece) MarkNotComplete() error {
+	me.t.mu.Lock()
+	defer me.t.mu.Unlock()
+	me.t.complete[me.index] = false
+	return nil
+}
+
+func (me memoryPiece) Completion() storage.Completion {
+	me.t.mu.RLock()
+	defer me.t.mu.RUnlock()
+	return storage.Completion{
+		Complete: me.t.complete[me.index],
+		Ok:       true,
+	}
+}
@@ -0,0 +1,206 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+type SwarmConfig struct {
+	// Seeds the Network and the generated torrent data.
+	Seed int64
+	Link LinkModel
+	// Clients that start with the complete torrent.
+	Seeders int
+	// Clients that start with nothing.
+	Leechers int
+	// Size of the generated torrent data.
+	Length      int64
+	PieceLength int64
+	// The number of random other clients each leecher is given as peers. Zero means all of them.
+	PeersPerLeecher int
+	// Applied to the configuration of every client after the simulation defaults.
+	ConfigureClient func(_ *torrent.ClientConfig, seeder bool)
+}
+
+// A set of Clients sharing a single torrent over a virtual Network.
+type Swarm struct {
+	Network  *Network
+	MetaInfo *metainfo.MetaInfo
+	Data     []byte
+	Seeders  []*torrent.Torrent
+	Leechers []*torrent.Torrent
+
+	cfg     SwarmConfig
+	clients []*torrent.Client
+	nodes   []*Node
+}
+
+// Per-run measurements.
+type Result struct {
+	// Time until every leecher completed.
+	Duration time.Duration
+	// Time until each leecher completed, in the order of Swarm.Leechers.
+	LeecherDurations []time.Duration
+	// Piece data uploaded by the initial seeders. Lower values mean leechers did more of the work.
+	SeederUploadedData int64
+	// Piece data uploaded by all clients.
+	TotalUploadedData int64
+}
+
+func NewSwarm(cfg SwarmConfig) (_ *Swarm, err error) {
+	s := &Swarm{
+		Network: NewNetwork(cfg.Seed, cfg.Link),
+		Data:    make([]byte, cfg.Length),
+		cfg:     cfg,
+	}
+	rand.New(rand.NewSource(cfg.Seed)).Read(s.Data)
+	info := metainfo.Info{
+		Name:        "sim",
+		Length:      cfg.Length,
+		PieceLength: cfg.PieceLength,
+	}
+	err = info.GeneratePieces(func(metainfo.FileInfo) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(s.Data)), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generating pieces: %w", err)
+	}
+	s.MetaInfo = &metainfo.MetaInfo{}
+	s.MetaInfo.InfoBytes, err = bencode.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+	for i := 0; i < cfg.Seeders; i++ {
+		t, err := s.addClient(true)
+		if err != nil {
+			return nil, err
+		}
+		s.Seeders = append(s.Seeders, t)
+	}
+	for i := 0; i < cfg.Leechers; i++ {
+		t, err := s.addClient(false)
+		if err != nil {
+			return nil, err
+		}
+		s.Leechers = append(s.Leechers, t)
+	}
+	return s, nil
+}
+
+func (s *Swarm) addClient(seeder bool) (*torrent.Torrent, error) {
+	cfg := torrent.TestingConfig()
+	cfg.DisableTCP = true
+	cfg.DisableUTP = true
+	cfg.Seed = true
+	if seeder {
+		cfg.DefaultStorage = newMemoryStorage(s.Data)
+	} else {
+		cfg.DefaultStorage = newMemoryStorage(nil)
+	}
+	if s.cfg.ConfigureClient != nil {
+		s.cfg.ConfigureClient(cfg, seeder)
+	}
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+	s.clients = append(s.clients, cl)
+	node := s.Network.NewNode()
+	s.nodes = append(s.nodes, node)
+	cl.AddListener(node)
+	cl.AddDialer(node)
+	t, err := cl.AddTorrent(s.MetaInfo)
+	if err != nil {
+		return nil, fmt.Errorf("adding torrent: %w", err)
+	}
+	return t, nil
+}
+
+// Introduces peers, starts all leechers downloading and waits until they complete or ctx is done.
+func (s *Swarm) Run(ctx context.Context) (ret Result, err error) {
+	for i, l := range s.Leechers {
+		l.AddPeers(s.peersFor(len(s.Seeders) + i))
+	}
+	started := time.Now()
+	for _, l := range s.Leechers {
+		l.DownloadAll()
+	}
+	ret.LeecherDurations = make([]time.Duration, len(s.Leechers))
+	remaining := len(s.Leechers)
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for remaining != 0 {
+		select {
+		case <-ctx.Done():
+			return ret, ctx.Err()
+		case <-tick.C:
+		}
+		for i, l := range s.Leechers {
+			if ret.LeecherDurations[i] == 0 && l.BytesMissing() == 0 {
+				ret.LeecherDurations[i] = time.Since(started)
+				remaining--
+			}
+		}
+	}
+	ret.Duration = time.Since(started)
+	for _, t := range s.Seeders {
+		ret.SeederUploadedData += uploadedData(t)
+	}
+	ret.TotalUploadedData = ret.SeederUploadedData
+	for _, t := range s.Leechers {
+		ret.TotalUploadedData += uploadedData(t)
+	}
+	return
+}
+
+func uploadedData(t *torrent.Torrent) int64 {
+	stats := t.Stats()
+	return stats.BytesWrittenData.Int64()
+}
+
+// Returns the peers for the client with the given index.
+func (s *Swarm) peersFor(self int) (ret []torrent.PeerInfo) {
+	others := make([]int, 0, len(s.nodes)-1)
+	for i := range s.nodes {
+		if i != self {
+			others = append(others, i)
+		}
+	}
+	if n := s.cfg.PeersPerLeecher; n != 0 && n < len(others) {
+		for i := 0; i < n; i++ {
+			j := i + s.Network.Intn(len(others)-i)
+			others[i], others[j] = others[j], others[i]
+		}
+		others = others[:n]
+	}
+	for _, i := range others {
+		ret = append(ret, torrent.PeerInfo{
+			Addr:    s.nodes[i].Addr(),
+			Trusted: true,
+		})
+	}
+	return
+}
+
+func (s *Swarm) Close() {
+	for _, cl := range s.clients {
+		cl.Close()
+	}
+	for _, n := range s.nodes {
+		n.Close()
+	}
+}
@@ -65,3 +65,18 @@ func tryIpPortFromNetAddr(na net.Addr) (ret ipPortAddr, ok bool) {
 	ok = true
 	return
 }
+
+// Parses an address as host:port, giving an ipPortAddr if the host is an IP. Other addresses, such
+// as hostnames and overlay addresses, are kept as they are.
+func parseHostPortAddr(s string) net.Addr {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return stringAddr(s)
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if ip == nil || err != nil {
+		return stringAddr(s)
+	}
+	return ipPortAddr{ip, int(port)}
+}
@@ -0,0 +1,199 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// An automatic action on torrents matching a LifecycleRule.
+type LifecycleAction string
+
+const (
+	// Disallows data download and upload.
+	LifecyclePause LifecycleAction = "pause"
+	// Drops the torrent.
+	LifecycleRemove LifecycleAction = "remove"
+	// Drops the torrent and deletes its data. See Torrent.DropAndDeleteData.
+	LifecycleRemoveAndDeleteData LifecycleAction = "remove-and-delete-data"
+	// Sets the maximum established connections to LifecycleRule.MaxEstablishedConns.
+	LifecycleSetLimits LifecycleAction = "set-limits"
+	// Moves the torrent's files under LifecycleRule.StorageDir. See File.SetStoragePath.
+	LifecycleMoveStorage LifecycleAction = "move-storage"
+)
+
+// Selects torrents for a LifecycleRule. Zero fields match all torrents, and all non-zero fields
+// must match.
+type LifecycleMatch struct {
+	// The host of one of the torrent's trackers.
+	TrackerHost string
+	// One of the torrent's labels. See TorrentSpec.Labels.
+	Label string
+	// The torrent was added to the Client at least this long ago.
+	MinAge time.Duration
+	// Piece data uploaded, as a multiple of the torrent length. This requires the info.
+	MinRatio float64
+	// No piece data was uploaded or downloaded for at least this long, as observed by the rule
+	// evaluations.
+	MinIdle time.Duration
+	// All the torrent's pieces are complete.
+	Complete bool
+}
+
+// Applies an action to torrents matching some criteria. Each rule is applied to a torrent at most
+// once. See ClientConfig.LifecycleRules.
+type LifecycleRule struct {
+	// Used in logging.
+	Name   string
+	Match  LifecycleMatch
+	Action LifecycleAction
+	// For LifecycleSetLimits.
+	MaxEstablishedConns int
+	// For LifecycleMoveStorage.
+	StorageDir string
+}
+
+func (cl *Client) runLifecycleRules() {
+	interval := cl.config.LifecycleRuleInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	closed := cl.Closed()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		cl.EvaluateLifecycleRules()
+	}
+}
+
+type lifecycleApplication struct {
+	t    *Torrent
+	rule *LifecycleRule
+}
+
+// Applies ClientConfig.LifecycleRules to the Client's torrents now. This also happens every
+// ClientConfig.LifecycleRuleInterval.
+func (cl *Client) EvaluateLifecycleRules() {
+	now := time.Now()
+	var apply []lifecycleApplication
+	cl.lock()
+	for _, t := range cl.torrents {
+		t.updateLifecycleActivity(now)
+		for i := range cl.config.LifecycleRules {
+			r := &cl.config.LifecycleRules[i]
+			if _, ok := t.lifecycleRulesApplied[i]; ok || !t.lifecycleMatch(r.Match, now) {
+				continue
+			}
+			if t.lifecycleRulesApplied == nil {
+				t.lifecycleRulesApplied = make(map[int]struct{})
+			}
+			t.lifecycleRulesApplied[i] = struct{}{}
+			apply = append(apply, lifecycleApplication{t, r})
+		}
+	}
+	cl.unlock()
+	for _, a := range apply {
+		if a.t.closed.IsSet() {
+			continue
+		}
+		torrent.Add(fmt.Sprintf("lifecycle actions %s", a.rule.Action), 1)
+		a.t.logger.Printf("applying lifecycle rule %q: %s", a.rule.Name, a.rule.Action)
+		if err := a.t.applyLifecycleAction(a.rule); err != nil {
+			a.t.logger.Printf("applying lifecycle rule %q: %v", a.rule.Name, err)
+		}
+	}
+}
+
+// Tracks when piece data was last transferred, for LifecycleMatch.MinIdle.
+func (t *Torrent) updateLifecycleActivity(now time.Time) {
+	transferred := t.stats.BytesReadData.Int64() + t.stats.BytesWrittenData.Int64()
+	if transferred != t.lifecycleBytesTransferred {
+		t.lifecycleBytesTransferred = transferred
+		t.lastActive = now
+	}
+}
+
+func (t *Torrent) lifecycleMatch(m LifecycleMatch, now time.Time) bool {
+	if m.TrackerHost != "" && !t.hasTrackerHost(m.TrackerHost) {
+		return false
+	}
+	if m.Label != "" && !t.hasLabel(m.Label) {
+		return false
+	}
+	if m.MinAge != 0 && now.Sub(t.addedAt) < m.MinAge {
+		return false
+	}
+	if m.MinIdle != 0 && now.Sub(t.lastActive) < m.MinIdle {
+		return false
+	}
+	if m.MinRatio != 0 {
+		if !t.haveInfo() || *t.length == 0 {
+			return false
+		}
+		if float64(t.stats.BytesWrittenData.Int64())/float64(*t.length) < m.MinRatio {
+			return false
+		}
+	}
+	if m.Complete && !t.haveAllPieces() {
+		return false
+	}
+	return true
+}
+
+func (t *Torrent) hasTrackerHost(host string) bool {
+	for _, tier := range t.metainfo.UpvertedAnnounceList() {
+		for _, tracker := range tier {
+			u, err := url.Parse(tracker)
+			if err == nil && u.Hostname() == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *Torrent) applyLifecycleAction(r *LifecycleRule) error {
+	switch r.Action {
+	case LifecyclePause:
+		t.DisallowDataDownload()
+		t.DisallowDataUpload()
+	case LifecycleRemove:
+		t.Drop()
+	case LifecycleRemoveAndDeleteData:
+		return t.DropAndDeleteData()
+	case LifecycleSetLimits:
+		t.SetMaxEstablishedConns(r.MaxEstablishedConns)
+	case LifecycleMoveStorage:
+		return t.moveStorage(r.StorageDir)
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+// Moves each file to where the default file storage would put it under dir.
+func (t *Torrent) moveStorage(dir string) error {
+	t.cl.rLock()
+	info := t.info
+	t.cl.rUnlock()
+	if info == nil {
+		return errors.New("torrent info not available")
+	}
+	for _, f := range t.Files() {
+		path := filepath.Join(dir, info.Name)
+		if info.IsDir() {
+			path = filepath.Join(path, filepath.FromSlash(f.DisplayPath()))
+		}
+		if err := f.SetStoragePath(path); err != nil {
+			return fmt.Errorf("moving %q: %w", f.DisplayPath(), err)
+		}
+	}
+	return nil
+}
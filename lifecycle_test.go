@@ -0,0 +1,48 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestLifecycleRules(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.LifecycleRules = []LifecycleRule{{
+		Name:   "remove done",
+		Match:  LifecycleMatch{Label: "done"},
+		Action: LifecycleRemove,
+	}, {
+		Name:                "limit tracker",
+		Match:               LifecycleMatch{TrackerHost: "tracker.example"},
+		Action:              LifecycleSetLimits,
+		MaxEstablishedConns: 3,
+	}}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	done, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{1},
+		Labels:   []string{"done"},
+	})
+	require.NoError(t, err)
+	tracked, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{2},
+		Trackers: [][]string{{"http://tracker.example:6969/announce"}},
+	})
+	require.NoError(t, err)
+	other, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{3}})
+	require.NoError(t, err)
+	cl.EvaluateLifecycleRules()
+	assert.True(t, done.closed.IsSet())
+	assert.False(t, tracked.closed.IsSet())
+	assert.EqualValues(t, 3, tracked.SetMaxEstablishedConns(10))
+	assert.EqualValues(t, cfg.EstablishedConnsPerTorrent, other.SetMaxEstablishedConns(10))
+	// Rules are applied at most once.
+	cl.EvaluateLifecycleRules()
+	assert.EqualValues(t, 10, tracked.SetMaxEstablishedConns(10))
+	assert.Len(t, cl.Torrents(), 2)
+}
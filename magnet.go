@@ -0,0 +1,149 @@
+package torrent
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Options for Torrent.MagnetLink. The zero value gives the infohash, the display name and all the
+// trackers.
+type MagnetOpts struct {
+	// Leaves out the display name ("dn").
+	NoDisplayName bool
+	// Leaves out the trackers ("tr").
+	NoTrackers bool
+	// If positive, at most this many trackers are included, taken from the first tiers first.
+	// Duplicates are always removed.
+	MaxTrackers int
+	// Includes the webseed URLs ("ws", BEP 19).
+	WebSeeds bool
+	// Includes the indexes of the files that aren't set to PiecePriorityNone ("so", BEP 53), if
+	// the info is available and some files are deselected. It's empty if every file is.
+	SelectedFiles bool
+	// Includes our address for peers ("x.pe", BEP 9), if a public IP is configured or we listen on
+	// a specific one.
+	Self bool
+}
+
+// Returns a magnet link for the torrent as it's currently known to the client.
+func (t *Torrent) MagnetLink(opts MagnetOpts) string {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.magnet(opts).String()
+}
+
+func (t *Torrent) magnet(opts MagnetOpts) (m metainfo.Magnet) {
+	m.InfoHash = t.infoHash
+	if !opts.NoDisplayName {
+		m.DisplayName = t.name()
+	}
+	if !opts.NoTrackers {
+		m.Trackers = t.magnetTrackers(opts.MaxTrackers)
+	}
+	params := make(url.Values)
+	if opts.WebSeeds {
+		for _, ws := range t.webSeedUrls() {
+			params.Add("ws", ws)
+		}
+	}
+	if opts.SelectedFiles {
+		if so, ok := t.selectOnlyParam(); ok {
+			params.Set("so", so)
+		}
+	}
+	if opts.Self {
+		if pe := t.cl.selfPeerParam(); pe != "" {
+			params.Set("x.pe", pe)
+		}
+	}
+	if len(params) != 0 {
+		m.Params = params
+	}
+	return
+}
+
+func (t *Torrent) magnetTrackers(limit int) (ret []string) {
+	seen := make(map[string]struct{})
+	for _, tier := range t.metainfo.UpvertedAnnounceList() {
+		for _, tr := range tier {
+			if limit > 0 && len(ret) >= limit {
+				return
+			}
+			if _, ok := seen[tr]; ok {
+				continue
+			}
+			seen[tr] = struct{}{}
+			ret = append(ret, tr)
+		}
+	}
+	return
+}
+
+// Includes webseeds that are disabled for now, as they might be usable by whoever gets the link.
+func (t *Torrent) webSeedUrls() (ret []string) {
+	for u := range t.webSeeds {
+		ret = append(ret, u)
+	}
+	for u := range t.disabledWebSeeds {
+		ret = append(ret, u)
+	}
+	sort.Strings(ret)
+	return
+}
+
+// Returns the BEP 53 file ranges of the files that will be downloaded. ok is false if they all
+// will be, or the files aren't known. If none will be, the ranges are empty, rather than left out,
+// which would select them all.
+func (t *Torrent) selectOnlyParam() (so string, ok bool) {
+	if !t.haveInfo() {
+		return
+	}
+	var selected []int
+	for i, f := range *t.files {
+		if f.prio != PiecePriorityNone {
+			selected = append(selected, i)
+		}
+	}
+	if len(selected) == len(*t.files) {
+		return
+	}
+	return selectOnlyRanges(selected), true
+}
+
+// Formats sorted file indexes with runs collapsed, such as "0,2,4-6".
+func selectOnlyRanges(indexes []int) string {
+	var parts []string
+	for i := 0; i < len(indexes); {
+		j := i
+		for j+1 < len(indexes) && indexes[j+1] == indexes[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(indexes[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", indexes[i], indexes[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Returns our address for peers, preferring IPv4, or "" if there isn't a specific one.
+func (cl *Client) selfPeerParam() string {
+	port := cl.incomingPeerPort()
+	if port == 0 {
+		return ""
+	}
+	for _, ip := range []net.IP{cl.publicIp(net.IPv4zero), cl.publicIp(net.IPv6zero)} {
+		if ip != nil && !ip.IsUnspecified() {
+			return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		}
+	}
+	return ""
+}
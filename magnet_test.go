@@ -0,0 +1,66 @@
+package torrent
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestMagnetLink(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash:    metainfo.Hash{1},
+		DisplayName: "name",
+		Trackers: [][]string{
+			{"http://a/announce", "http://b/announce"},
+			{"http://a/announce", "http://c/announce"},
+		},
+	})
+	require.NoError(t, err)
+	m, err := metainfo.ParseMagnetUri(tt.MagnetLink(MagnetOpts{}))
+	require.NoError(t, err)
+	assert.Equal(t, metainfo.Hash{1}, m.InfoHash)
+	assert.Equal(t, "name", m.DisplayName)
+	assert.Equal(t, []string{"http://a/announce", "http://b/announce", "http://c/announce"}, m.Trackers)
+	m, err = metainfo.ParseMagnetUri(tt.MagnetLink(MagnetOpts{MaxTrackers: 2, NoDisplayName: true}))
+	require.NoError(t, err)
+	assert.Equal(t, "", m.DisplayName)
+	assert.Equal(t, []string{"http://a/announce", "http://b/announce"}, m.Trackers)
+	m, err = metainfo.ParseMagnetUri(tt.MagnetLink(MagnetOpts{NoTrackers: true, SelectedFiles: true}))
+	require.NoError(t, err)
+	assert.Empty(t, m.Trackers)
+	// The files aren't known without the info.
+	assert.Empty(t, m.Params)
+}
+
+func TestMagnetLinkNoFilesSelected(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	tt, _, err := cl.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	require.NoError(t, err)
+	<-tt.GotInfo()
+	m, err := metainfo.ParseMagnetUri(tt.MagnetLink(MagnetOpts{SelectedFiles: true}))
+	require.NoError(t, err)
+	assert.NotContains(t, m.Params, "so")
+	tt.Files()[0].SetPriority(PiecePriorityNone)
+	m, err = metainfo.ParseMagnetUri(tt.MagnetLink(MagnetOpts{SelectedFiles: true}))
+	require.NoError(t, err)
+	// Leaving it out would select every file.
+	assert.Equal(t, []string{""}, m.Params["so"])
+}
+
+func TestSelectOnlyRanges(t *testing.T) {
+	assert.Equal(t, "", selectOnlyRanges(nil))
+	assert.Equal(t, "3", selectOnlyRanges([]int{3}))
+	assert.Equal(t, "0,2,4-6,9-10", selectOnlyRanges([]int{0, 2, 4, 5, 6, 9, 10}))
+}
@@ -0,0 +1,127 @@
+package torrent
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/anacrolix/missinggo"
+
+	"github.com/anacrolix/torrent/merkle"
+	"github.com/anacrolix/torrent/metainfo"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// More tree hashes than this can't be needed to verify a piece.
+const maxHashPieceNodes = 64
+
+func (t *Torrent) pieceHashCorrect(piece pieceIndex, sum metainfo.Hash) bool {
+	if t.merkleTree == nil {
+		return sum == *t.piece(piece).hash
+	}
+	proposed := t.merkleProposedHashes[piece]
+	delete(t.merkleProposedHashes, piece)
+	return t.merkleTree.Verify(piece, sum, proposed)
+}
+
+// Pieces whose completion came from storage haven't been verified against the tree, so their
+// hashes can't be sent to peers. If we have the whole torrent, hash it all and build the tree from
+// the data instead.
+func (t *Torrent) maybeBuildMerkleTree() {
+	if t.merkleTree == nil || !t.haveAllPieces() {
+		return
+	}
+	if _, ok := t.merkleTree.PieceHashes(0); ok {
+		return
+	}
+	var infoRoot metainfo.Hash
+	missinggo.CopyExact(&infoRoot, t.info.RootHash)
+	numPieces := t.numPieces()
+	go func() {
+		hashes := make([]metainfo.Hash, 0, numPieces)
+		for i := 0; i < numPieces; i++ {
+			t.storageLock.RLock()
+			sum, err := t.hashPiece(i)
+			t.storageLock.RUnlock()
+			if err != nil {
+				t.logger.Printf("hashing piece %d for merkle tree: %v", i, err)
+				return
+			}
+			hashes = append(hashes, sum)
+		}
+		tree := merkle.FromPieceHashes(hashes)
+		t.cl.lock()
+		defer t.cl.unlock()
+		if t.closed.IsSet() {
+			return
+		}
+		if tree.Root() != infoRoot {
+			t.logger.Printf("data doesn't match merkle root hash, rechecking")
+			for i := 0; i < numPieces; i++ {
+				t.queuePieceCheck(i)
+			}
+			return
+		}
+		t.merkleTree = tree
+	}()
+}
+
+func (c *PeerConn) onReadHashPiece(payload []byte) error {
+	t := c.t
+	if t.merkleTree == nil {
+		return errors.New("hash piece message for torrent without hash tree")
+	}
+	m, err := pp.LoadHashPieceMsg(payload)
+	if err != nil {
+		return err
+	}
+	if len(m.Hashes) > maxHashPieceNodes {
+		return errors.New("too many hashes")
+	}
+	if m.Begin == 0 && len(m.Hashes) != 0 {
+		hashes := make(map[int]metainfo.Hash, len(m.Hashes))
+		for _, h := range m.Hashes {
+			hashes[h.Index] = h.Hash
+		}
+		if t.merkleProposedHashes == nil {
+			t.merkleProposedHashes = make(map[pieceIndex]map[int]metainfo.Hash)
+		}
+		t.merkleProposedHashes[pieceIndex(m.Index)] = hashes
+	}
+	msg := pp.Message{
+		Type:  pp.Piece,
+		Index: m.Index,
+		Begin: m.Begin,
+		Piece: m.Piece,
+	}
+	c.readMsg(&msg)
+	return c.receiveChunk(&msg)
+}
+
+// Returns a hash piece message for the chunk if the peer should get one in place of a piece
+// message.
+func (c *PeerConn) hashPieceMessage(r request, data []byte) (pp.Message, bool) {
+	t := c.t
+	if t.merkleTree == nil || r.Begin != 0 {
+		return pp.Message{}, false
+	}
+	id, ok := c.PeerExtensionIDs[pp.ExtensionNameHashPiece]
+	if !ok {
+		return pp.Message{}, false
+	}
+	hashes, ok := t.merkleTree.PieceHashes(int(r.Index))
+	if !ok {
+		return pp.Message{}, false
+	}
+	m := pp.HashPieceMsg{
+		Index: r.Index,
+		Begin: r.Begin,
+		Piece: data,
+	}
+	for i, h := range hashes {
+		m.Hashes = append(m.Hashes, pp.HashPieceNode{Index: i, Hash: h})
+	}
+	sort.Slice(m.Hashes, func(i, j int) bool {
+		return m.Hashes[i].Index < m.Hashes[j].Index
+	})
+	return m.Message(id), true
+}
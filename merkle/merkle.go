@@ -0,0 +1,176 @@
+// Package merkle implements the hash trees of BEP 30 merkle torrents, where the info contains only
+// the root of a tree over the piece hashes, and peers send the hashes needed to verify each piece
+// along with its data.
+package merkle
+
+import (
+	"crypto/sha1"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// A tree of SHA-1 hashes over the pieces of a torrent. Nodes are numbered as in BEP 30: the root is
+// 0, and the children of node i are 2i+1 and 2i+2. The leaves are the piece hashes, padded to a
+// power of two with zero hashes. Only nodes that have been verified against the root are kept. It's
+// not safe for concurrent use.
+type Tree struct {
+	numPieces int
+	numLeaves int
+	nodes     []metainfo.Hash
+	verified  []bool
+}
+
+func NewTree(root metainfo.Hash, numPieces int) *Tree {
+	numLeaves := 1
+	for numLeaves < numPieces {
+		numLeaves *= 2
+	}
+	t := &Tree{
+		numPieces: numPieces,
+		numLeaves: numLeaves,
+		nodes:     make([]metainfo.Hash, 2*numLeaves-1),
+		verified:  make([]bool, 2*numLeaves-1),
+	}
+	t.nodes[0] = root
+	t.verified[0] = true
+	// Padding leaves, and subtrees made entirely of them, are known without any data.
+	for i := len(t.nodes) - 1; i > 0; i-- {
+		if t.isLeaf(i) {
+			if t.leafPiece(i) >= numPieces {
+				t.verified[i] = true
+			}
+			continue
+		}
+		l, r := 2*i+1, 2*i+2
+		if t.verified[l] && t.verified[r] {
+			t.nodes[i] = hashChildren(t.nodes[l], t.nodes[r])
+			t.verified[i] = true
+		}
+	}
+	return t
+}
+
+func hashChildren(l, r metainfo.Hash) (ret metainfo.Hash) {
+	h := sha1.New()
+	h.Write(l[:])
+	h.Write(r[:])
+	copy(ret[:], h.Sum(nil))
+	return
+}
+
+func (t *Tree) isLeaf(node int) bool {
+	return node >= t.numLeaves-1
+}
+
+func (t *Tree) leafPiece(node int) int {
+	return node - (t.numLeaves - 1)
+}
+
+func (t *Tree) pieceLeaf(piece int) int {
+	return t.numLeaves - 1 + piece
+}
+
+func sibling(node int) int {
+	if node%2 == 1 {
+		return node + 1
+	}
+	return node - 1
+}
+
+func parent(node int) int {
+	return (node - 1) / 2
+}
+
+// Returns whether pieceHash is the correct hash for the piece, using the verified nodes and the
+// proposed node hashes, such as those sent by a peer with the piece. On success the piece hash and
+// the proposed hashes that were used are verified.
+func (t *Tree) Verify(piece int, pieceHash metainfo.Hash, proposed map[int]metainfo.Hash) bool {
+	if piece < 0 || piece >= t.numPieces {
+		return false
+	}
+	type node struct {
+		index int
+		hash  metainfo.Hash
+	}
+	i := t.pieceLeaf(piece)
+	h := pieceHash
+	var path []node
+	for !t.verified[i] {
+		path = append(path, node{i, h})
+		sib := sibling(i)
+		var sibHash metainfo.Hash
+		if t.verified[sib] {
+			sibHash = t.nodes[sib]
+		} else if p, ok := proposed[sib]; ok {
+			sibHash = p
+			path = append(path, node{sib, p})
+		} else {
+			return false
+		}
+		if i%2 == 1 {
+			h = hashChildren(h, sibHash)
+		} else {
+			h = hashChildren(sibHash, h)
+		}
+		i = parent(i)
+	}
+	if t.nodes[i] != h {
+		return false
+	}
+	for _, n := range path {
+		t.nodes[n.index] = n.hash
+		t.verified[n.index] = true
+	}
+	return true
+}
+
+// Returns the verified hash of the piece.
+func (t *Tree) PieceHash(piece int) (metainfo.Hash, bool) {
+	if piece < 0 || piece >= t.numPieces {
+		return metainfo.Hash{}, false
+	}
+	i := t.pieceLeaf(piece)
+	return t.nodes[i], t.verified[i]
+}
+
+// Returns the hashes a peer needs to verify the piece: its own hash, and the siblings of it and of
+// each of its ancestors. ok is false if the piece hash isn't verified.
+func (t *Tree) PieceHashes(piece int) (ret map[int]metainfo.Hash, ok bool) {
+	if _, ok = t.PieceHash(piece); !ok {
+		return
+	}
+	ret = make(map[int]metainfo.Hash)
+	i := t.pieceLeaf(piece)
+	ret[i] = t.nodes[i]
+	for i != 0 {
+		sib := sibling(i)
+		ret[sib] = t.nodes[sib]
+		i = parent(i)
+	}
+	return
+}
+
+// Returns a tree with every node verified, as when creating or seeding a torrent from its data.
+func FromPieceHashes(pieceHashes []metainfo.Hash) *Tree {
+	t := NewTree(metainfo.Hash{}, len(pieceHashes))
+	for i, h := range pieceHashes {
+		leaf := t.pieceLeaf(i)
+		t.nodes[leaf] = h
+		t.verified[leaf] = true
+	}
+	for i := t.numLeaves - 2; i >= 0; i-- {
+		t.nodes[i] = hashChildren(t.nodes[2*i+1], t.nodes[2*i+2])
+		t.verified[i] = true
+	}
+	return t
+}
+
+// Returns the root of the tree over the piece hashes, which is the "root hash" of a merkle torrent
+// info.
+func Root(pieceHashes []metainfo.Hash) metainfo.Hash {
+	return FromPieceHashes(pieceHashes).Root()
+}
+
+func (t *Tree) Root() metainfo.Hash {
+	return t.nodes[0]
+}
@@ -0,0 +1,42 @@
+package merkle
+
+import (
+	"crypto/sha1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func pieceHashes(n int) (ret []metainfo.Hash) {
+	for i := 0; i < n; i++ {
+		ret = append(ret, sha1.Sum([]byte{byte(i)}))
+	}
+	return
+}
+
+func TestTreeVerify(t *testing.T) {
+	hashes := pieceHashes(5)
+	seeder := FromPieceHashes(hashes)
+	leecher := NewTree(Root(hashes), len(hashes))
+	for i := range hashes {
+		assert.False(t, leecher.Verify(i, hashes[i], nil))
+	}
+	for i := range hashes {
+		proposed, ok := seeder.PieceHashes(i)
+		assert.True(t, ok)
+		assert.False(t, leecher.Verify(i, metainfo.Hash{1}, proposed))
+		assert.True(t, leecher.Verify(i, hashes[i], proposed))
+		h, ok := leecher.PieceHash(i)
+		assert.True(t, ok)
+		assert.Equal(t, hashes[i], h)
+	}
+}
+
+func TestTreeSinglePiece(t *testing.T) {
+	hashes := pieceHashes(1)
+	tree := NewTree(Root(hashes), 1)
+	assert.Equal(t, hashes[0], Root(hashes))
+	assert.True(t, tree.Verify(0, hashes[0], nil))
+}
@@ -0,0 +1,50 @@
+package torrent
+
+import (
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Stores info bytes obtained from peers, so that torrents added again without the info, such as
+// from magnet links, don't need to fetch it. See storage.NewSqliteMetadataCache.
+type MetadataCache interface {
+	// Returns nil if the info isn't cached.
+	GetInfoBytes(metainfo.Hash) ([]byte, error)
+	SetInfoBytes(metainfo.Hash, []byte) error
+}
+
+// Sets the info from ClientConfig.MetadataCache, if the torrent doesn't have it.
+func (t *Torrent) useCachedMetadata() {
+	cache := t.cl.config.MetadataCache
+	if cache == nil || t.Info() != nil {
+		return
+	}
+	b, err := cache.GetInfoBytes(t.infoHash)
+	if err != nil {
+		t.logger.Printf("error getting cached metadata: %v", err)
+		return
+	}
+	if b == nil {
+		return
+	}
+	err = t.SetInfoBytes(b)
+	if err != nil {
+		t.logger.Printf("error setting cached metadata: %v", err)
+		return
+	}
+	torrent.Add("metadata cache hits", 1)
+}
+
+// Saves metadata obtained from peers. Must be called with the Client lock held.
+func (t *Torrent) cacheMetadata() {
+	cache := t.cl.config.MetadataCache
+	if cache == nil {
+		return
+	}
+	ih, b := t.infoHash, t.metadataBytes
+	go func() {
+		err := cache.SetInfoBytes(ih, b)
+		if err != nil {
+			t.logger.Printf("error caching metadata: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+package torrent
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+type mapMetadataCache struct {
+	mu sync.Mutex
+	m  map[metainfo.Hash][]byte
+	// Signalled on each set.
+	set chan struct{}
+}
+
+func (me *mapMetadataCache) GetInfoBytes(ih metainfo.Hash) ([]byte, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.m[ih], nil
+}
+
+func (me *mapMetadataCache) SetInfoBytes(ih metainfo.Hash, b []byte) error {
+	me.mu.Lock()
+	me.m[ih] = b
+	me.mu.Unlock()
+	me.set <- struct{}{}
+	return nil
+}
+
+func TestMetadataCache(t *testing.T) {
+	mi := testutil.GreetingMetaInfo()
+	ih := mi.HashInfoBytes()
+	cache := &mapMetadataCache{
+		m:   make(map[metainfo.Hash][]byte),
+		set: make(chan struct{}, 1),
+	}
+	cfg := TestingConfig()
+	cfg.MetadataCache = cache
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: ih})
+	require.NoError(t, err)
+	assert.Nil(t, tt.Info())
+	// Complete the metadata as if from peers.
+	cl.lock()
+	require.NoError(t, tt.setMetadataSize(len(mi.InfoBytes)))
+	tt.saveMetadataPiece(0, mi.InfoBytes)
+	require.NoError(t, tt.maybeCompleteMetadata())
+	cl.unlock()
+	<-cache.set
+	assert.EqualValues(t, mi.InfoBytes, cache.m[ih])
+	tt.Drop()
+	tt, _, err = cl.AddTorrentSpec(&TorrentSpec{InfoHash: ih})
+	require.NoError(t, err)
+	assert.NotNil(t, tt.Info())
+}
@@ -0,0 +1,179 @@
+package metainfo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options for Info.BuildFromFilePathOpts. The zero value builds as BuildFromFilePath does.
+type BuildOpts struct {
+	// Orders the files in the info. Defaults to FilesByPath.
+	Less func(l, r FileInfo) bool
+	// Patterns, as for path.Match, of files and directories to leave out. Patterns containing a
+	// slash are matched against the slash-separated path relative to the root, and others against
+	// the base name. Excluding a directory excludes everything under it. See JunkFiles.
+	Exclude []string
+	// If not empty, only files matching one of these patterns, as for Exclude, are included.
+	// Directories are searched regardless.
+	Include []string
+	// Leaves out files and directories with names starting with a dot.
+	ExcludeHidden bool
+	// How symlinks under the root are handled. The root itself is always followed.
+	Symlinks SymlinkPolicy
+	// Hashes the pieces. Defaults to NewPieceHash.
+	PieceHasher PieceHasher
+}
+
+type SymlinkPolicy int
+
+const (
+	// Symlinks are stored as the files or directories they point to. A link to a directory that
+	// contains it is an error.
+	FollowSymlinks SymlinkPolicy = iota
+	// Symlinks are left out.
+	SkipSymlinks
+	// Symlinks are an error.
+	RejectSymlinks
+)
+
+// Files left by operating systems and file managers, to use in BuildOpts.Exclude.
+var JunkFiles = []string{
+	".DS_Store",
+	"._*",
+	".Spotlight-V100",
+	".Trashes",
+	".fseventsd",
+	"Thumbs.db",
+	"ehthumbs.db",
+	"desktop.ini",
+	"$RECYCLE.BIN",
+}
+
+func matchesAny(patterns []string, relPath string) bool {
+	base := path.Base(relPath)
+	for _, pattern := range patterns {
+		name := base
+		if strings.Contains(pattern, "/") {
+			name = relPath
+		}
+		// Malformed patterns don't match anything.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (me BuildOpts) skipped(relPath string, isDir bool) bool {
+	if me.ExcludeHidden && strings.HasPrefix(path.Base(relPath), ".") {
+		return true
+	}
+	if matchesAny(me.Exclude, relPath) {
+		return true
+	}
+	return !isDir && len(me.Include) != 0 && !matchesAny(me.Include, relPath)
+}
+
+// Calls f with the slash-separated path relative to root and the length of each regular file
+// included by the options. Other kinds of files, such as devices and pipes, are left out.
+func (me BuildOpts) walk(root string, f func(relPath string, length int64)) error {
+	return me.walkDir(root, "", make(map[string]struct{}), f)
+}
+
+// ancestors holds the real paths of the directories being walked, to catch symlink cycles.
+func (me BuildOpts) walkDir(dir, relDir string, ancestors map[string]struct{}, f func(string, int64)) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if _, ok := ancestors[realDir]; ok {
+		return fmt.Errorf("symlink cycle at %q", dir)
+	}
+	ancestors[realDir] = struct{}{}
+	defer delete(ancestors, realDir)
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		osPath := filepath.Join(dir, name)
+		relPath := path.Join(relDir, name)
+		fi, err := os.Lstat(osPath)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			switch me.Symlinks {
+			case SkipSymlinks:
+				continue
+			case RejectSymlinks:
+				return fmt.Errorf("%q is a symlink", osPath)
+			}
+			fi, err = os.Stat(osPath)
+			if err != nil {
+				return err
+			}
+		}
+		if me.skipped(relPath, fi.IsDir()) {
+			continue
+		}
+		if fi.IsDir() {
+			// Directories are implicit in torrent files.
+			err = me.walkDir(osPath, relPath, ancestors, f)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if fi.Mode().IsRegular() {
+			f(relPath, fi.Size())
+		}
+	}
+	return nil
+}
+
+// Orders files by their slash-separated paths. This is the default.
+func FilesByPath(l, r FileInfo) bool {
+	return strings.Join(l.Path, "/") < strings.Join(r.Path, "/")
+}
+
+// Orders files by each of their path components in turn, so the files in a directory are
+// contiguous, even when a sibling's name sorts between the directory's name and a slash.
+func FilesByPathComponents(l, r FileInfo) bool {
+	for i := 0; i < len(l.Path) && i < len(r.Path); i++ {
+		if l.Path[i] != r.Path[i] {
+			return l.Path[i] < r.Path[i]
+		}
+	}
+	return len(l.Path) < len(r.Path)
+}
+
+// The environment variable used by reproducible builds to fix timestamps. See
+// https://reproducible-builds.org/specs/source-date-epoch/.
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// Returns the creation date from SOURCE_DATE_EPOCH, with ok false if it isn't set.
+func SourceDateEpoch() (t time.Time, ok bool, err error) {
+	s, ok := os.LookupEnv(SourceDateEpochEnv)
+	if !ok {
+		return
+	}
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("parsing %s: %w", SourceDateEpochEnv, err)
+		return
+	}
+	return time.Unix(secs, 0), true, nil
+}
@@ -0,0 +1,101 @@
+package metainfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+func TestBuildFromFilePathOpts(t *testing.T) {
+	td := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "a", "sub"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "skip"), 0o755))
+	for _, name := range []string{"a/x", "a/sub/y", "a-b", ".DS_Store", "skip/z"} {
+		require.NoError(t, touchFile(filepath.Join(td, filepath.FromSlash(name))))
+	}
+	build := func(opts BuildOpts) []FileInfo {
+		info := Info{PieceLength: 1}
+		require.NoError(t, info.BuildFromFilePathOpts(td, opts))
+		return info.Files
+	}
+	assert.EqualValues(t, []FileInfo{
+		{Path: []string{"a-b"}},
+		{Path: []string{"a", "sub", "y"}},
+		{Path: []string{"a", "x"}},
+	}, build(BuildOpts{Exclude: []string{".DS_Store", "skip"}}))
+	assert.EqualValues(t, []FileInfo{
+		{Path: []string{".DS_Store"}},
+		{Path: []string{"a", "sub", "y"}},
+		{Path: []string{"a", "x"}},
+		{Path: []string{"a-b"}},
+	}, build(BuildOpts{Exclude: []string{"skip/*"}, Less: FilesByPathComponents}))
+}
+
+func TestBuildFilters(t *testing.T) {
+	td := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "dir", ".git"), 0o755))
+	for _, name := range []string{"a.txt", "b.bin", "Thumbs.db", "dir/c.txt", "dir/.git/d.txt"} {
+		require.NoError(t, touchFile(filepath.Join(td, filepath.FromSlash(name))))
+	}
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(td, "link.txt")))
+	build := func(opts BuildOpts) (paths []string, err error) {
+		info := Info{PieceLength: 1}
+		err = info.BuildFromFilePathOpts(td, opts)
+		for _, fi := range info.Files {
+			paths = append(paths, path.Join(fi.Path...))
+		}
+		return
+	}
+	paths, err := build(BuildOpts{Exclude: JunkFiles, ExcludeHidden: true, Include: []string{"*.txt"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "dir/c.txt", "link.txt"}, paths)
+	paths, err = build(BuildOpts{Include: []string{"*.txt"}, Symlinks: SkipSymlinks})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "dir/.git/d.txt", "dir/c.txt"}, paths)
+	_, err = build(BuildOpts{Symlinks: RejectSymlinks})
+	assert.Error(t, err)
+	// A link to an ancestor directory would be followed forever.
+	require.NoError(t, os.Symlink("..", filepath.Join(td, "dir", "up")))
+	_, err = build(BuildOpts{})
+	assert.Error(t, err)
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	defer os.Unsetenv(SourceDateEpochEnv)
+	os.Setenv(SourceDateEpochEnv, "1600000000")
+	var mi MetaInfo
+	mi.SetDefaults()
+	assert.EqualValues(t, 1600000000, mi.CreationDate)
+	os.Setenv(SourceDateEpochEnv, "soon")
+	_, ok, err := SourceDateEpoch()
+	assert.True(t, ok)
+	assert.Error(t, err)
+}
+
+// The same tree gives the same bytes, however it was created.
+func TestBuildReproducible(t *testing.T) {
+	build := func(names ...string) []byte {
+		td := t.TempDir()
+		for _, name := range names {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(td, name), []byte(name), 0o644))
+		}
+		info := Info{PieceLength: 2}
+		require.NoError(t, info.BuildFromFilePath(td))
+		info.Name = "root"
+		mi := MetaInfo{CreationDate: 1}
+		var err error
+		mi.InfoBytes, err = bencode.Marshal(info)
+		require.NoError(t, err)
+		b, err := bencode.Marshal(mi)
+		require.NoError(t, err)
+		return b
+	}
+	assert.Equal(t, build("a", "b", "c"), build("c", "a", "b"))
+}
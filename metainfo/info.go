@@ -6,9 +6,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-
-	"github.com/anacrolix/missinggo/slices"
 )
 
 // The info dictionary.
@@ -21,44 +20,50 @@ type Info struct {
 	// TODO: Document this field.
 	Source string     `bencode:"source,omitempty"`
 	Files  []FileInfo `bencode:"files,omitempty"` // BEP3, mutually exclusive with Length
+	// BEP30: the root of a hash tree over the pieces, in place of Pieces. See the merkle package.
+	RootHash []byte `bencode:"root hash,omitempty"`
 }
 
 // This is a helper that sets Files and Pieces from a root path and its
 // children.
 func (info *Info) BuildFromFilePath(root string) (err error) {
+	return info.BuildFromFilePathOpts(root, BuildOpts{})
+}
+
+// Sets Files and Pieces from a root path and its children, as BuildFromFilePath does, with the
+// given options. The result only depends on the file names and contents, and the options, so the
+// same tree gives the same info, and infohash, wherever it's built.
+func (info *Info) BuildFromFilePathOpts(root string, opts BuildOpts) (err error) {
 	info.Name = filepath.Base(root)
 	info.Files = nil
-	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			// Directories are implicit in torrent files.
-			return nil
-		} else if path == root {
-			// The root is a file.
-			info.Length = fi.Size()
-			return nil
-		}
-		relPath, err := filepath.Rel(root, path)
-		if err != nil {
-			return fmt.Errorf("error getting relative path: %s", err)
-		}
-		info.Files = append(info.Files, FileInfo{
-			Path:   strings.Split(relPath, string(filepath.Separator)),
-			Length: fi.Size(),
-		})
-		return nil
-	})
+	fi, err := os.Stat(root)
 	if err != nil {
 		return
 	}
-	slices.Sort(info.Files, func(l, r FileInfo) bool {
-		return strings.Join(l.Path, "/") < strings.Join(r.Path, "/")
+	if !fi.IsDir() {
+		// The root is a file.
+		info.Length = fi.Size()
+	} else {
+		err = opts.walk(root, func(relPath string, length int64) {
+			info.Files = append(info.Files, FileInfo{
+				Path:   strings.Split(relPath, "/"),
+				Length: length,
+			})
+		})
+		if err != nil {
+			return
+		}
+	}
+	less := opts.Less
+	if less == nil {
+		less = FilesByPath
+	}
+	sort.SliceStable(info.Files, func(i, j int) bool {
+		return less(info.Files[i], info.Files[j])
 	})
-	err = info.GeneratePieces(func(fi FileInfo) (io.ReadCloser, error) {
+	err = info.generatePieces(func(fi FileInfo) (io.ReadCloser, error) {
 		return os.Open(filepath.Join(root, strings.Join(fi.Path, string(filepath.Separator))))
-	})
+	}, opts.PieceHasher)
 	if err != nil {
 		err = fmt.Errorf("error generating pieces: %s", err)
 	}
@@ -85,6 +90,10 @@ func (info *Info) writeFiles(w io.Writer, open func(fi FileInfo) (io.ReadCloser,
 // Sets Pieces (the block of piece hashes in the Info) by using the passed
 // function to get at the torrent data.
 func (info *Info) GeneratePieces(open func(fi FileInfo) (io.ReadCloser, error)) (err error) {
+	return info.generatePieces(open, nil)
+}
+
+func (info *Info) generatePieces(open func(fi FileInfo) (io.ReadCloser, error), hasher PieceHasher) (err error) {
 	if info.PieceLength == 0 {
 		return errors.New("piece length must be non-zero")
 	}
@@ -94,7 +103,7 @@ func (info *Info) GeneratePieces(open func(fi FileInfo) (io.ReadCloser, error))
 		pw.CloseWithError(err)
 	}()
 	defer pr.Close()
-	info.Pieces, err = GeneratePieces(pr, info.PieceLength, nil)
+	info.Pieces, err = GeneratePiecesHasher(pr, info.PieceLength, nil, hasher)
 	return
 }
 
@@ -110,9 +119,20 @@ func (info *Info) TotalLength() (ret int64) {
 }
 
 func (info *Info) NumPieces() int {
+	if info.IsMerkle() {
+		if info.PieceLength <= 0 {
+			return 0
+		}
+		return int((info.TotalLength() + info.PieceLength - 1) / info.PieceLength)
+	}
 	return len(info.Pieces) / 20
 }
 
+// Whether this is a BEP30 merkle torrent, which has a root hash instead of piece hashes.
+func (info *Info) IsMerkle() bool {
+	return len(info.RootHash) != 0
+}
+
 func (info *Info) IsDir() bool {
 	return len(info.Files) != 0
 }
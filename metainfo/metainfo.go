@@ -59,11 +59,16 @@ func (mi MetaInfo) Write(w io.Writer) error {
 	return bencode.NewEncoder(w).Encode(mi)
 }
 
-// Set good default values in preparation for creating a new MetaInfo file.
+// Set good default values in preparation for creating a new MetaInfo file. The creation date is
+// taken from SOURCE_DATE_EPOCH if it's a valid time, so builds can be reproduced, and is the
+// current time otherwise.
 func (mi *MetaInfo) SetDefaults() {
 	mi.Comment = "yoloham"
 	mi.CreatedBy = "github.com/anacrolix/torrent"
 	mi.CreationDate = time.Now().Unix()
+	if t, ok, err := SourceDateEpoch(); ok && err == nil {
+		mi.CreationDate = t.Unix()
+	}
 	// mi.Info.PieceLength = 256 * 1024
 }
 
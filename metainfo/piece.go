@@ -22,7 +22,11 @@ func (p Piece) Offset() int64 {
 	return int64(p.i) * p.Info.PieceLength
 }
 
+// The piece hash from the info. It's zero for merkle torrents, which don't include piece hashes.
 func (p Piece) Hash() (ret Hash) {
+	if p.Info.IsMerkle() {
+		return
+	}
 	missinggo.CopyExact(&ret, p.Info.Pieces[p.i*HashSize:(p.i+1)*HashSize])
 	return
 }
@@ -2,12 +2,32 @@ package metainfo
 
 import (
 	"crypto/sha1"
+	"hash"
 	"io"
 )
 
+// Creates the hash used for v1 piece hashes, which must compute SHA-1. The default, crypto/sha1,
+// detects the CPU's SIMD and SHA extensions itself, and uses them. Another implementation, such as
+// one for a hardware accelerator, can be given where pieces are hashed: BuildOpts.PieceHasher for
+// torrent creation, and the client's config for verification. See BenchmarkPieceHashers.
+type PieceHasher func() hash.Hash
+
+// Returns a hash for computing piece hashes with the default implementation.
+func NewPieceHash() hash.Hash {
+	return sha1.New()
+}
+
 func GeneratePieces(r io.Reader, pieceLength int64, b []byte) ([]byte, error) {
+	return GeneratePiecesHasher(r, pieceLength, b, nil)
+}
+
+// Like GeneratePieces, with the given hash implementation. A nil hasher uses NewPieceHash.
+func GeneratePiecesHasher(r io.Reader, pieceLength int64, b []byte, hasher PieceHasher) ([]byte, error) {
+	if hasher == nil {
+		hasher = NewPieceHash
+	}
 	for {
-		h := sha1.New()
+		h := hasher()
 		written, err := io.CopyN(h, r, pieceLength)
 		if written > 0 {
 			b = h.Sum(b)
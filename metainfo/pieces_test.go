@@ -0,0 +1,66 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingHash struct {
+	hash.Hash
+	written *int
+}
+
+func (me countingHash) Write(b []byte) (int, error) {
+	*me.written += len(b)
+	return me.Hash.Write(b)
+}
+
+func TestGeneratePiecesHasher(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100)
+	want, err := GeneratePieces(bytes.NewReader(data), 32, nil)
+	require.NoError(t, err)
+	written := 0
+	got, err := GeneratePiecesHasher(bytes.NewReader(data), 32, nil, func() hash.Hash {
+		return countingHash{sha1.New(), &written}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, len(data), written)
+	// The default implementation is unchanged.
+	again, err := GeneratePieces(bytes.NewReader(data), 32, nil)
+	require.NoError(t, err)
+	assert.Equal(t, want, again)
+}
+
+// Measures hash throughput at typical piece lengths, for choosing a PieceHasher. crypto/sha1 and
+// crypto/sha256 pick AVX2 or SHA-NI code at runtime where the CPU has them, so comparing with
+// GODEBUG=cpu.avx2=off,cpu.sha=off (or similar, per architecture) shows what they give.
+func BenchmarkPieceHashers(b *testing.B) {
+	for _, h := range []struct {
+		name string
+		new  PieceHasher
+	}{
+		{"sha1", sha1.New},
+		{"sha256", sha256.New},
+	} {
+		for _, pieceLength := range []int64{256 << 10, 4 << 20} {
+			b.Run(fmt.Sprintf("%s/%d", h.name, pieceLength), func(b *testing.B) {
+				data := make([]byte, 16<<20)
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					_, err := GeneratePiecesHasher(bytes.NewReader(data), pieceLength, nil, h.new)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
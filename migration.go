@@ -0,0 +1,306 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Snapshots carry a Torrent's state across a restart, such as when upgrading a long-running daemon:
+// freeze the torrents, serialize the snapshot (it's plain data, and encodes with encoding/json),
+// start the new process, and restore them there. Connections can't be carried over, so the peers
+// are redialled, and requests that were outstanding are made again. The new Client must use the
+// same storage, as written chunks and completion are taken to still be there.
+
+// Bumped when TorrentSnapshot changes incompatibly.
+const torrentSnapshotVersion = 1
+
+// The state of a Torrent, from Torrent.Snapshot, to be restored by Client.RestoreTorrent. Requests
+// outstanding with peers aren't included, as they belong to connections: their chunks are requested
+// again unless they're in DirtyChunks.
+type TorrentSnapshot struct {
+	Version     int
+	InfoHash    metainfo.Hash
+	InfoBytes   []byte
+	DisplayName string
+	Trackers    [][]string
+	WebSeeds    []string
+	Labels      []string
+	Priority    TorrentPriority
+	// By file index. Empty until the info is available.
+	FilePriorities []piecePriority
+
+	DisallowDataDownload bool
+	DisallowDataUpload   bool
+	DisallowDhtAnnounce  bool
+
+	// Indexes of the pieces that were complete. Pieces whose completion storage doesn't know, such
+	// as when it isn't persisted, are queued for verification, as with QueueDataVerification, rather
+	// than trusted. Storage that knows a piece's completion takes precedence.
+	CompletedPieces []int
+	// Chunk indexes written to incomplete pieces, by piece index. They're not requested again.
+	DirtyChunks map[int][]int
+
+	// Known peers, including those being dialled and connected to.
+	Peers []PeerSnapshot
+	// Added to the restored Torrent's stats, so totals continue across the restart.
+	Stats ConnStats
+}
+
+type PeerSnapshot struct {
+	Addr               string
+	Id                 PeerID
+	Source             PeerSource
+	SupportsEncryption bool
+	Trusted            bool
+	// There was a connection to the peer.
+	Connected bool
+}
+
+// The state of every Torrent in a Client. See Client.Snapshot.
+type ClientSnapshot struct {
+	Torrents []TorrentSnapshot
+	Stats    ConnStats
+}
+
+// Returns the Torrent's current state. See Freeze for a snapshot that doesn't go stale.
+func (t *Torrent) Snapshot() TorrentSnapshot {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.snapshot()
+}
+
+// Stops the Torrent downloading and uploading data, so no more chunks are written or pieces
+// completed, and returns its state. The Torrent stays added, and can be dropped once the snapshot
+// is saved.
+func (t *Torrent) Freeze() TorrentSnapshot {
+	t.cl.lock()
+	defer t.cl.unlock()
+	ret := t.snapshot()
+	// The snapshot keeps what was allowed before.
+	t.disallowDataDownloadLocked()
+	t.disallowDataUploadLocked()
+	return ret
+}
+
+func (t *Torrent) snapshot() (ret TorrentSnapshot) {
+	ret.Version = torrentSnapshotVersion
+	ret.InfoHash = t.infoHash
+	ret.DisplayName = t.displayName
+	ret.Trackers = t.metainfo.UpvertedAnnounceList()
+	ret.WebSeeds = t.webSeedUrls()
+	ret.Labels = append([]string(nil), t.labels...)
+	ret.Priority = t.Priority()
+	ret.DisallowDataDownload = t.dataDownloadDisallowed
+	ret.DisallowDataUpload = t.dataUploadDisallowed
+	ret.DisallowDhtAnnounce = t.dhtAnnouncesDisallowed.IsSet()
+	ret.Stats = t.stats.Copy()
+	if t.haveInfo() {
+		ret.InfoBytes = append([]byte(nil), t.metadataBytes...)
+		for _, f := range *t.files {
+			ret.FilePriorities = append(ret.FilePriorities, f.prio)
+		}
+		for i := range t.pieces {
+			if t.pieceComplete(i) {
+				ret.CompletedPieces = append(ret.CompletedPieces, i)
+				continue
+			}
+			p := t.piece(i)
+			if p.numDirtyChunks() == 0 {
+				continue
+			}
+			if ret.DirtyChunks == nil {
+				ret.DirtyChunks = make(map[int][]int)
+			}
+			p._dirtyChunks.IterTyped(func(chunk int) bool {
+				ret.DirtyChunks[i] = append(ret.DirtyChunks[i], chunk)
+				return true
+			})
+		}
+	}
+	ret.Peers = t.peerSnapshots()
+	return
+}
+
+// Connected peers come first, in the order of their addresses.
+func (t *Torrent) peerSnapshots() (ret []PeerSnapshot) {
+	for c := range t.conns {
+		if c.RemoteAddr == nil {
+			continue
+		}
+		ret = append(ret, PeerSnapshot{
+			Addr:               c.RemoteAddr.String(),
+			Id:                 c.PeerID,
+			Source:             c.Discovery,
+			SupportsEncryption: c.headerEncrypted || c.PeerPrefersEncryption,
+			Trusted:            c.trusted,
+			Connected:          true,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Addr < ret[j].Addr })
+	add := func(pi PeerInfo) {
+		ret = append(ret, PeerSnapshot{
+			Addr:               pi.Addr.String(),
+			Id:                 pi.Id,
+			Source:             pi.Source,
+			SupportsEncryption: pi.SupportsEncryption,
+			Trusted:            pi.Trusted,
+		})
+	}
+	// Being dialled.
+	for _, pi := range t.halfOpen {
+		add(pi)
+	}
+	t.peers.Each(add)
+	return
+}
+
+// Snapshots every Torrent. See Torrent.Snapshot.
+func (cl *Client) Snapshot() (ret ClientSnapshot) {
+	cl.rLock()
+	defer cl.rUnlock()
+	for _, t := range cl.torrentsAsSlice() {
+		ret.Torrents = append(ret.Torrents, t.snapshot())
+	}
+	ret.Stats = cl.stats.Copy()
+	return
+}
+
+// Freezes every Torrent. See Torrent.Freeze.
+func (cl *Client) Freeze() (ret ClientSnapshot) {
+	for _, t := range cl.Torrents() {
+		ret.Torrents = append(ret.Torrents, t.Freeze())
+	}
+	ret.Stats = cl.ConnStats()
+	return
+}
+
+// Adds the Torrent from the snapshot, with the state it had. It fails if the Torrent is already
+// added.
+func (cl *Client) RestoreTorrent(s TorrentSnapshot) (t *Torrent, err error) {
+	if s.Version != torrentSnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %v", s.Version)
+	}
+	cl.lock()
+	if cl.refuseBlockedInfoHash(s.InfoHash, "restore", nil) {
+		cl.unlock()
+		return nil, ErrInfoHashBlocked
+	}
+	// The snapshot replaces any dormant spec.
+	t, added, _ := cl.addTorrentInfoHashWithStorage(s.InfoHash, nil)
+	if !added {
+		cl.unlock()
+		return nil, errors.New("torrent already added")
+	}
+	// Applied when the info is set, before piece completion is checked.
+	t.restoredCompletion = &bitmap.Bitmap{}
+	for _, i := range s.CompletedPieces {
+		t.restoredCompletion.Add(i)
+	}
+	cl.unlock()
+	err = t.MergeSpec(&TorrentSpec{
+		InfoHash:             s.InfoHash,
+		InfoBytes:            s.InfoBytes,
+		DisplayName:          s.DisplayName,
+		Trackers:             s.Trackers,
+		Webseeds:             s.WebSeeds,
+		DisallowDataDownload: s.DisallowDataDownload,
+		DisallowDataUpload:   s.DisallowDataUpload,
+		DisallowDhtAnnounce:  s.DisallowDhtAnnounce,
+		Labels:               s.Labels,
+		Priority:             s.Priority,
+	})
+	cl.lock()
+	defer cl.unlock()
+	if err != nil {
+		cl.dropTorrent(s.InfoHash)
+		return nil, err
+	}
+	t.restoreSnapshot(s)
+	t.runEventCommands(TorrentEventAdded, nil)
+	return t, nil
+}
+
+// Restores the Torrents in the snapshot, stopping at the first error, and adds its stats to the
+// Client's.
+func (cl *Client) Restore(s ClientSnapshot) (ts []*Torrent, err error) {
+	for _, snapshot := range s.Torrents {
+		var t *Torrent
+		t, err = cl.RestoreTorrent(snapshot)
+		if err != nil {
+			err = fmt.Errorf("restoring %v: %w", snapshot.InfoHash, err)
+			return
+		}
+		ts = append(ts, t)
+	}
+	cl.stats.add(&s.Stats)
+	return
+}
+
+// Applies the runtime state that TorrentSpec doesn't cover.
+func (t *Torrent) restoreSnapshot(s TorrentSnapshot) {
+	t.stats.add(&s.Stats)
+	for _, ps := range s.Peers {
+		// Addresses with IPs are parsed back, so they get the IP checks in addPeer.
+		t.addPeer(PeerInfo{
+			Id:                 ps.Id,
+			Addr:               parseHostPortAddr(ps.Addr),
+			Source:             ps.Source,
+			SupportsEncryption: ps.SupportsEncryption,
+			Trusted:            ps.Trusted,
+		})
+	}
+	if !t.haveInfo() {
+		return
+	}
+	for i, prio := range s.FilePriorities {
+		if i >= len(*t.files) {
+			break
+		}
+		f := (*t.files)[i]
+		if f.prio != prio {
+			f.prio = prio
+			t.updatePiecePriorities(f.firstPieceIndex(), f.endPieceIndex())
+		}
+	}
+	for i, chunks := range s.DirtyChunks {
+		if i < 0 || i >= t.numPieces() || t.pieceComplete(i) {
+			continue
+		}
+		p := t.piece(i)
+		if p.hashing || p.queuedForHash() {
+			continue
+		}
+		for _, c := range chunks {
+			if c >= 0 && c < int(p.numChunks()) {
+				p.unpendChunkIndex(c)
+			}
+		}
+		if t.pieceAllDirty(i) {
+			t.queuePieceCheck(i)
+		}
+	}
+	t.maybeNewConns()
+}
+
+// Queues the pieces in the restored completion for verification, where storage doesn't know their
+// completion, so they're hashed before they're trusted, and again after another restart if that
+// happens first. Called when the info is set.
+func (t *Torrent) verifyRestoredCompletion() {
+	t.restoredCompletion.IterTyped(func(i int) bool {
+		if i >= len(t.pieces) {
+			return false
+		}
+		p := &t.pieces[i]
+		if p.hashing || p.queuedForHash() || t.pieceCompleteUncached(i).Ok {
+			return true
+		}
+		t.queueVerification(i)
+		return true
+	})
+	t.restoredCompletion = nil
+}
@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/storage"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	newClient := func() *Client {
+		cfg := TestingConfig()
+		// Completion isn't persisted, so the restored client only knows it from the snapshot.
+		cfg.DefaultStorage = storage.NewFileWithCompletion(dir, storage.NewMapPieceCompletion())
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		return cl
+	}
+	cl := newClient()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	require.EqualValues(t, tt.Length(), tt.BytesCompleted())
+	tt.AddLabels("greeting")
+	tt.AddPeers([]PeerInfo{{Addr: stringAddr("1.2.3.4:5"), Source: PeerSourceDirect}})
+	tt.stats.BytesReadData.Add(42)
+	snapshot := cl.Freeze()
+	assert.True(t, tt.dataDownloadDisallowed)
+	assert.True(t, tt.dataUploadDisallowed)
+	b, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	var decoded ClientSnapshot
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Len(t, decoded.Torrents, 1)
+	assert.Equal(t, []int{0, 1, 2}, decoded.Torrents[0].CompletedPieces)
+	// The snapshot is from before the freeze.
+	assert.False(t, decoded.Torrents[0].DisallowDataDownload)
+	cl.Close()
+
+	cl = newClient()
+	defer cl.Close()
+	restored, err := cl.Restore(decoded)
+	require.NoError(t, err)
+	require.Len(t, restored, 1)
+	tt = restored[0]
+	assert.Equal(t, mi.HashInfoBytes(), tt.InfoHash())
+	// The restored completion is verified rather than trusted.
+	waitVerificationQueue(t, cl)
+	assert.EqualValues(t, tt.Length(), tt.BytesCompleted())
+	cl.rLock()
+	for i := range tt.pieces {
+		assert.NotZero(t, tt.piece(i).numVerifies)
+	}
+	cl.rUnlock()
+	assert.Equal(t, []string{"greeting"}, tt.Labels())
+	assert.EqualValues(t, 42, tt.Stats().BytesReadData.Int64())
+	var addrs []net.Addr
+	for _, pi := range tt.KnownSwarm() {
+		addrs = append(addrs, pi.Addr)
+	}
+	assert.Contains(t, addrs, ipPortAddr{net.ParseIP("1.2.3.4"), 5})
+	_, err = cl.RestoreTorrent(decoded.Torrents[0])
+	assert.Error(t, err)
+}
+
+func TestParseHostPortAddr(t *testing.T) {
+	assert.Equal(t, ipPortAddr{net.ParseIP("1.2.3.4"), 5}, parseHostPortAddr("1.2.3.4:5"))
+	assert.Equal(t, ipPortAddr{net.ParseIP("::1"), 6881}, parseHostPortAddr("[::1]:6881"))
+	assert.Equal(t, stringAddr("example.com:80"), parseHostPortAddr("example.com:80"))
+	assert.Equal(t, stringAddr("abc.i2p"), parseHostPortAddr("abc.i2p"))
+}
@@ -86,6 +86,9 @@ func validateInfo(info *metainfo.Info) error {
 	if len(info.Pieces)%20 != 0 {
 		return errors.New("pieces has invalid length")
 	}
+	if info.IsMerkle() && len(info.RootHash) != 20 {
+		return errors.New("root hash has invalid length")
+	}
 	if info.PieceLength == 0 {
 		if info.TotalLength() != 0 {
 			return errors.New("zero piece length")
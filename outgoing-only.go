@@ -0,0 +1,41 @@
+package torrent
+
+import (
+	"net"
+
+	"github.com/anacrolix/log"
+)
+
+// Sets up the Client for ClientConfig.OutgoingOnly, in place of the sockets that would be listened
+// on. TCP peers are dialed from ephemeral ports. uTP is unavailable, as its connections require a
+// UDP socket that would also accept them. DHT servers are passive, so they don't respond to
+// queries, and use their own ephemeral UDP sockets.
+func (cl *Client) initOutgoingOnly() error {
+	for _, n := range allPeerNetworks {
+		if n.Tcp && peerNetworkEnabled(n, cl.config) {
+			cl.dialers = append(cl.dialers, NetDialer{Network: n.String()})
+		}
+	}
+	if cl.config.NoDHT {
+		return nil
+	}
+	for _, n := range allPeerNetworks {
+		if !n.Udp || n.Ipv4 && cl.config.DisableIPv4 || n.Ipv6 && cl.config.DisableIPv6 {
+			continue
+		}
+		pc, err := net.ListenPacket(n.String(), net.JoinHostPort(cl.config.ListenHost(n.String()), "0"))
+		if err != nil {
+			// The network may be unavailable, as IPv6 often is.
+			cl.logger.WithDefaultLevel(log.Warning).Printf("opening %s dht socket: %v", n, err)
+			continue
+		}
+		cl.onClose = append(cl.onClose, func() { pc.Close() })
+		ds, err := cl.newAnacrolixDhtServer(pc)
+		if err != nil {
+			return err
+		}
+		cl.dhtServers = append(cl.dhtServers, anacrolixDhtServerWrapper{ds})
+		cl.onClose = append(cl.onClose, func() { ds.Close() })
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package torrent
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestOutgoingOnly(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig()
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, _ := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	seederTorrent.VerifyData()
+	leecherDataDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(leecherDataDir)
+	cfg = TestingConfig()
+	cfg.DataDir = leecherDataDir
+	cfg.OutgoingOnly = true
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	assert.Empty(t, leecher.listeners)
+	assert.NotEmpty(t, leecher.dialers)
+	assert.EqualValues(t, 0, leecher.LocalPort())
+	assert.EqualValues(t, 0, leecher.dhtAnnouncePort())
+	leecherTorrent, _, _ := leecher.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	assert.EqualValues(t, 0, leecherTorrent.announceRequest(0).Port)
+	leecherTorrent.AddClientPeer(seeder)
+	r := leecherTorrent.NewReader()
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.EqualValues(t, testutil.GreetingFileContents, b)
+}
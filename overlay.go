@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Connects to peers on an overlay network whose addresses aren't IP addresses, such as I2P
+// destinations, onion services or overlay node IDs. See ClientConfig.OverlayNetworks.
+type OverlayNetwork interface {
+	// The network of the network's addresses, such as "i2p".
+	Network() string
+	// Returns the network's address for a peer whose host isn't an IP address, such as from the
+	// ip field of a non-compact tracker response. ok is false if the host isn't on the network.
+	ParseAddr(host string, port int) (addr string, ok bool)
+	// Connects to a peer at an address returned by ParseAddr.
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// The address of a peer on an OverlayNetwork. Peers can be added with these directly.
+type OverlayAddr struct {
+	// The OverlayNetwork's Network.
+	Net  string
+	Addr string
+}
+
+func (me OverlayAddr) Network() string {
+	return me.Net
+}
+
+func (me OverlayAddr) String() string {
+	return me.Addr
+}
+
+// A peer address with a host that isn't an IP address, before an OverlayNetwork claims it. If none
+// does, the peer is dropped: resolving and dialing the host would leak lookups and connections
+// outside the overlay, and a host can't be checked against the IP blocklist.
+type hostPortAddr struct {
+	Host string
+	Port int
+}
+
+func (hostPortAddr) Network() string {
+	return ""
+}
+
+func (me hostPortAddr) String() string {
+	return net.JoinHostPort(me.Host, strconv.FormatInt(int64(me.Port), 10))
+}
+
+// Gives the OverlayNetworks a chance to claim a peer address with a host name. ok is false if none
+// does.
+func (cl *Client) resolveOverlayAddr(addr net.Addr) (_ net.Addr, ok bool) {
+	hp, isHost := addr.(hostPortAddr)
+	if !isHost {
+		return addr, true
+	}
+	for _, on := range cl.config.OverlayNetworks {
+		if oa, ok := on.ParseAddr(hp.Host, hp.Port); ok {
+			return OverlayAddr{Net: on.Network(), Addr: oa}, true
+		}
+	}
+	return nil, false
+}
+
+func (cl *Client) overlayNetwork(network string) OverlayNetwork {
+	for _, on := range cl.config.OverlayNetworks {
+		if on.Network() == network {
+			return on
+		}
+	}
+	return nil
+}
+
+// Returns the OverlayNetwork that claims a tracker's host, if any.
+func (cl *Client) trackerOverlay(host string) OverlayNetwork {
+	for _, on := range cl.config.OverlayNetworks {
+		if _, ok := on.ParseAddr(host, 0); ok {
+			return on
+		}
+	}
+	return nil
+}
+
+// Dials host:port addresses through the OverlayNetwork, for HTTP trackers on it.
+func overlayDialContext(on OverlayNetwork) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, _ := strconv.Atoi(portStr)
+		oa, ok := on.ParseAddr(host, port)
+		if !ok {
+			return nil, fmt.Errorf("%q isn't on overlay network %q", host, on.Network())
+		}
+		torrent.Add("overlay tracker dials", 1)
+		return on.Dial(ctx, oa)
+	}
+}
+
+func (cl *Client) dialOverlay(ctx context.Context, addr OverlayAddr) (res dialResult, err error) {
+	on := cl.overlayNetwork(addr.Net)
+	if on == nil {
+		return res, fmt.Errorf("no overlay network %q", addr.Net)
+	}
+	torrent.Add("overlay dials", 1)
+	res.Network = addr.Net
+	res.Conn, err = on.Dial(ctx, addr.Addr)
+	return
+}
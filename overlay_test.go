@@ -0,0 +1,73 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+type testOverlayNetwork struct {
+	dialed chan string
+}
+
+func (testOverlayNetwork) Network() string {
+	return "i2p"
+}
+
+func (testOverlayNetwork) ParseAddr(host string, port int) (string, bool) {
+	return host, strings.HasSuffix(host, ".b32.i2p")
+}
+
+func (me testOverlayNetwork) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	me.dialed <- addr
+	return nil, errors.New("unreachable")
+}
+
+func TestOverlayPeers(t *testing.T) {
+	on := testOverlayNetwork{make(chan string, 1)}
+	cfg := TestingConfig()
+	cfg.OverlayNetworks = []OverlayNetwork{on}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _ := cl.AddTorrentInfoHash(metainfo.Hash{1})
+	tt.AddPeers(peerInfos(nil).AppendFromTracker([]tracker.Peer{
+		{Host: "abcdefgh.b32.i2p", Port: 6881},
+		{Host: "example.com", Port: 6881},
+	}))
+	var addrs []net.Addr
+	for _, pi := range tt.KnownSwarm() {
+		addrs = append(addrs, pi.Addr)
+	}
+	assert.Contains(t, addrs, net.Addr(OverlayAddr{Net: "i2p", Addr: "abcdefgh.b32.i2p"}))
+	// Not claimed by an overlay, so it's not resolved and dialed outside it.
+	assert.NotContains(t, addrs, net.Addr(hostPortAddr{"example.com", 6881}))
+	assert.Len(t, addrs, 1)
+	select {
+	case addr := <-on.dialed:
+		assert.Equal(t, "abcdefgh.b32.i2p", addr)
+	case <-time.After(10 * time.Second):
+		t.Fatal("overlay peer not dialed")
+	}
+}
+
+func TestOverlayTrackerDial(t *testing.T) {
+	on := testOverlayNetwork{make(chan string, 1)}
+	cl := &Client{config: &ClientConfig{OverlayNetworks: []OverlayNetwork{on}}}
+	assert.Nil(t, cl.trackerOverlay("tracker.example.com"))
+	require.Equal(t, OverlayNetwork(on), cl.trackerOverlay("tracker.b32.i2p"))
+	_, err := overlayDialContext(on)(context.Background(), "tcp", "tracker.b32.i2p:80")
+	assert.Error(t, err)
+	assert.Equal(t, "tracker.b32.i2p", <-on.dialed)
+	_, err = overlayDialContext(on)(context.Background(), "tcp", "tracker.example.com:80")
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package torrent
+
+import (
+	"net"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// What a peer advertised about itself when connecting, as received, for classifying the client
+// it's running.
+type PeerCapabilities struct {
+	PeerID     PeerID
+	RemoteAddr net.Addr
+	// The reserved bytes from the BitTorrent handshake, including bits this package doesn't know.
+	Reserved pp.PeerExtensionBits
+	// The latest BEP 10 extended handshake, or nil if the peer hasn't sent one. Its M is the 'm'
+	// dictionary, including extensions this package doesn't implement, and any the peer disabled
+	// with an ID of 0.
+	ExtendedHandshake *pp.ExtendedHandshakeMessage
+}
+
+// Whether the peer's latest extended handshake enabled the extension.
+func (me PeerCapabilities) SupportsExtension(name pp.ExtensionName) bool {
+	return me.ExtendedHandshake != nil && me.ExtendedHandshake.M[name] != 0
+}
+
+// Returns what the peer advertised. It's a copy, and doesn't change with later handshakes.
+func (cn *PeerConn) Capabilities() PeerCapabilities {
+	cn.locker().RLock()
+	defer cn.locker().RUnlock()
+	return cn.capabilities()
+}
+
+func (cn *PeerConn) capabilities() PeerCapabilities {
+	ret := PeerCapabilities{
+		PeerID:     cn.PeerID,
+		RemoteAddr: cn.RemoteAddr,
+		Reserved:   cn.PeerExtensionBytes,
+	}
+	if d := cn.peerExtendedHandshake; d != nil {
+		copied := *d
+		copied.M = make(map[pp.ExtensionName]pp.ExtensionNumber, len(d.M))
+		for name, id := range d.M {
+			copied.M[name] = id
+		}
+		ret.ExtendedHandshake = &copied
+	}
+	return ret
+}
+
+// Returns the capabilities of each of the Torrent's connected peers.
+func (t *Torrent) PeerCapabilities() (ret []PeerCapabilities) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	for c := range t.conns {
+		ret = append(ret, c.capabilities())
+	}
+	return
+}
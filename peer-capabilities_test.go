@@ -0,0 +1,49 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+func TestPeerCapabilities(t *testing.T) {
+	cl := Client{
+		config: TestingConfig(),
+	}
+	cl.config.DisablePEX = true
+	cl.initLogger()
+	c := cl.newConnection(nil, false, nil, "", "")
+	c.setTorrent(cl.newTorrent(metainfo.Hash{}, nil))
+	c.t.setInfo(&metainfo.Info{
+		Pieces: make([]byte, metainfo.HashSize),
+	})
+	c.PeerExtensionBytes = pp.NewPeerExtensionBytes(pp.ExtensionBitExtended, 63)
+	assert.Nil(t, c.Capabilities().ExtendedHandshake)
+	payload, err := bencode.Marshal(pp.ExtendedHandshakeMessage{
+		M: map[pp.ExtensionName]pp.ExtensionNumber{
+			pp.ExtensionNameMetadata: 2,
+			"lt_donthave":            0,
+			"x_unknown":              7,
+		},
+		V: "test 1.0",
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.onReadExtendedMsg(pp.HandshakeExtendedID, payload))
+	caps := c.Capabilities()
+	assert.True(t, caps.Reserved.GetBit(63))
+	assert.True(t, caps.Reserved.SupportsExtended())
+	require.NotNil(t, caps.ExtendedHandshake)
+	assert.Equal(t, "test 1.0", caps.ExtendedHandshake.V)
+	assert.Len(t, caps.ExtendedHandshake.M, 3)
+	assert.True(t, caps.SupportsExtension("x_unknown"))
+	assert.False(t, caps.SupportsExtension("lt_donthave"))
+	assert.False(t, caps.SupportsExtension(pp.ExtensionNamePex))
+	// The copy doesn't change with the connection.
+	caps.ExtendedHandshake.M["x_unknown"] = 0
+	assert.True(t, c.Capabilities().SupportsExtension("x_unknown"))
+}
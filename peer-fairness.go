@@ -0,0 +1,112 @@
+package torrent
+
+import (
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func (cl *Client) addPeerIDConn(c *PeerConn) {
+	if c.PeerID == (PeerID{}) {
+		return
+	}
+	if cl.connsByPeerID == nil {
+		cl.connsByPeerID = make(map[PeerID]map[*PeerConn]struct{})
+	}
+	conns := cl.connsByPeerID[c.PeerID]
+	if conns == nil {
+		conns = make(map[*PeerConn]struct{})
+		cl.connsByPeerID[c.PeerID] = conns
+	}
+	conns[c] = struct{}{}
+	cl.updateSiblingRequests(c)
+}
+
+func (cl *Client) deletePeerIDConn(c *PeerConn) {
+	conns := cl.connsByPeerID[c.PeerID]
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(cl.connsByPeerID, c.PeerID)
+	}
+	cl.updateSiblingRequests(c)
+}
+
+// The shares of the other connections to the same peer change when one is added or removed.
+func (cl *Client) updateSiblingRequests(c *PeerConn) {
+	if !cl.config.PeerRequestFairness {
+		return
+	}
+	for s := range cl.connsByPeerID[c.PeerID] {
+		if s != c {
+			s.updateRequests()
+		}
+	}
+}
+
+// The relative share of requests the torrent makes to peers that also serve other torrents, when
+// ClientConfig.PeerRequestFairness is set. Each TorrentPriority level doubles it.
+func (t *Torrent) requestWeight() int {
+	d := t.Priority() - TorrentPriorityLow
+	if d < 0 {
+		d = 0
+	}
+	if d > maxPriorityDeficit {
+		d = maxPriorityDeficit
+	}
+	return 1 << uint(d)
+}
+
+// Returns the connections of all torrents to the torrent's peers, whose shares depend on its weight.
+func (cl *Client) peerIDConns(t *Torrent) (ret []*PeerConn) {
+	for c := range t.conns {
+		for s := range cl.connsByPeerID[c.PeerID] {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+// Scales the requests the connection would make alone by its torrent's share of the weights of all
+// the torrents with connections to the same peer. Each still makes at least one request.
+func (c *PeerConn) fairRequestShare(max int) int {
+	siblings := c.t.cl.connsByPeerID[c.PeerID]
+	if len(siblings) < 2 {
+		return max
+	}
+	total := 0
+	for s := range siblings {
+		total += s.t.requestWeight()
+	}
+	weight := c.t.requestWeight()
+	share := (max*weight + total - 1) / total
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
+
+// Stats for a torrent's connection to a remote peer.
+type PeerTorrentStats struct {
+	InfoHash metainfo.Hash
+	ConnStats
+	// Useful data received per second of interest in the peer.
+	DownloadRate float64
+	// The current limit on outstanding requests to the peer for the torrent.
+	MaxRequests int
+	// The torrent's share of requests to the peer relative to the others, from its TorrentPriority.
+	RequestWeight int
+}
+
+// Returns stats for each torrent with a connection to the peer with the given ID.
+func (cl *Client) PeerTorrentStats(id PeerID) (ret []PeerTorrentStats) {
+	cl.rLock()
+	defer cl.rUnlock()
+	for c := range cl.connsByPeerID[id] {
+		ret = append(ret, PeerTorrentStats{
+			InfoHash:      c.t.infoHash,
+			ConnStats:     c._stats.Copy(),
+			DownloadRate:  c.downloadRate(),
+			MaxRequests:   c.nominalMaxRequests(),
+			RequestWeight: c.t.requestWeight(),
+		})
+	}
+	return
+}
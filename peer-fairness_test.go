@@ -0,0 +1,43 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairRequestShare(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.PeerRequestFairness = true
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	a, _ := cl.AddTorrentInfoHash([20]byte{1})
+	b, _ := cl.AddTorrentInfoHash([20]byte{2})
+	id := PeerID{1}
+	ac := &PeerConn{peer: peer{t: a}, PeerID: id}
+	bc := &PeerConn{peer: peer{t: b}, PeerID: id}
+	ac.peerImpl = ac
+	bc.peerImpl = bc
+	cl.lock()
+	cl.addPeerIDConn(ac)
+	cl.unlock()
+	// Alone, a connection makes as many requests as it would otherwise.
+	assert.Equal(t, 100, ac.fairRequestShare(100))
+	cl.lock()
+	cl.addPeerIDConn(bc)
+	cl.unlock()
+	assert.Equal(t, 50, ac.fairRequestShare(100))
+	assert.Equal(t, 50, bc.fairRequestShare(100))
+	b.SetPriority(TorrentPriorityHigh)
+	assert.Equal(t, 34, ac.fairRequestShare(100))
+	assert.Equal(t, 67, bc.fairRequestShare(100))
+	assert.Equal(t, 1, ac.fairRequestShare(1))
+	stats := cl.PeerTorrentStats(id)
+	assert.Len(t, stats, 2)
+	cl.lock()
+	cl.deletePeerIDConn(bc)
+	cl.unlock()
+	assert.Equal(t, 100, ac.fairRequestShare(100))
+}
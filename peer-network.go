@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"net"
+)
+
+// Distinguishes peers on the local network from those reached over the internet, for rate limiting
+// and accounting. See ClientConfig.ExemptLocalPeersFromRateLimits.
+type PeerNetworkClass int
+
+const (
+	PeerNetworkInternet PeerNetworkClass = iota
+	// Loopback, link-local and private (RFC 1918 and unique local) addresses.
+	PeerNetworkLocal
+
+	numPeerNetworkClasses = iota
+)
+
+func (me PeerNetworkClass) String() string {
+	switch me {
+	case PeerNetworkInternet:
+		return "internet"
+	case PeerNetworkLocal:
+		return "local"
+	default:
+		return "unknown"
+	}
+}
+
+var localNetworks = func() (ret []*net.IPNet) {
+	for _, s := range []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"100.64.0.0/10",
+		"fc00::/7",
+	} {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, n)
+	}
+	return
+}()
+
+// Peers without an IP, like webseeds, are on the internet.
+func peerNetworkClass(ip net.IP) PeerNetworkClass {
+	if ip == nil {
+		return PeerNetworkInternet
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return PeerNetworkLocal
+	}
+	for _, n := range localNetworks {
+		if n.Contains(ip) {
+			return PeerNetworkLocal
+		}
+	}
+	return PeerNetworkInternet
+}
+
+// Returns connection stats aggregated over all connections to peers in the network class.
+func (cl *Client) NetworkClassConnStats(class PeerNetworkClass) ConnStats {
+	return cl.networkClassStats[class].Copy()
+}
+
+func (cl *Client) rateLimitExempt(class PeerNetworkClass) bool {
+	return class == PeerNetworkLocal && cl.config.ExemptLocalPeersFromRateLimits
+}
+
+// Whether the peer at ip is preferred by ISP-friendly selection. See
+// ClientConfig.PreferredPeerNetworks and ClientConfig.PreferSameASNPeers.
+func (cl *Client) peerAffine(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range cl.config.PreferredPeerNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if !cl.config.PreferSameASNPeers || cl.config.GeoIP == nil {
+		return false
+	}
+	ourIp := cl.publicIp(ip)
+	if ourIp == nil || ourIp.IsUnspecified() {
+		return false
+	}
+	ours := cl.lookupGeoIP(ourIp)
+	return ours.ASN != 0 && cl.lookupGeoIP(ip).ASN == ours.ASN
+}
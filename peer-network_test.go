@@ -0,0 +1,18 @@
+package torrent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerNetworkClass(t *testing.T) {
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "172.31.0.1", "192.168.1.10", "169.254.0.1", "::1", "fd00::1", "fe80::1"} {
+		assert.Equal(t, PeerNetworkLocal, peerNetworkClass(net.ParseIP(s)), s)
+	}
+	for _, s := range []string{"8.8.8.8", "172.32.0.1", "2001:db8::1"} {
+		assert.Equal(t, PeerNetworkInternet, peerNetworkClass(net.ParseIP(s)), s)
+	}
+	assert.Equal(t, PeerNetworkInternet, peerNetworkClass(nil))
+}
@@ -0,0 +1,103 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// A chunk request we've sent to a peer that hasn't been fulfilled.
+type ChunkRequest struct {
+	Index  int
+	Begin  int
+	Length int
+}
+
+func (r ChunkRequest) request() request {
+	return newRequest(pp.Integer(r.Index), pp.Integer(r.Begin), pp.Integer(r.Length))
+}
+
+// Returns the requests outstanding to the peer, ordered by piece and offset.
+func (cn *peer) OutstandingRequests() (ret []ChunkRequest) {
+	cn.t.cl.rLock()
+	defer cn.t.cl.rUnlock()
+	for r := range cn.requests {
+		ret = append(ret, ChunkRequest{
+			Index:  int(r.Index),
+			Begin:  int(r.Begin),
+			Length: int(r.Length),
+		})
+	}
+	sortChunkRequests(ret)
+	return
+}
+
+func sortChunkRequests(rs []ChunkRequest) {
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Index != rs[j].Index {
+			return rs[i].Index < rs[j].Index
+		}
+		return rs[i].Begin < rs[j].Begin
+	})
+}
+
+// Cancels an outstanding request to the peer, making it available to other peers. Returns false if
+// the request wasn't outstanding.
+func (cn *peer) CancelRequest(r ChunkRequest) bool {
+	cn.t.cl.lock()
+	defer cn.t.cl.unlock()
+	return cn.postCancel(r.request())
+}
+
+// Cancels all outstanding requests to the peer, and doesn't make new ones to it for the holdoff
+// duration, so that other peers pick them up. This lets a scheduler move requests off a slow or
+// misbehaving peer without waiting for them to time out. Returns the number of requests
+// cancelled.
+func (cn *peer) CancelRequests(holdoff time.Duration) (cancelled int) {
+	cn.t.cl.lock()
+	defer cn.t.cl.unlock()
+	if holdoff > 0 {
+		cn.holdRequests(holdoff)
+	}
+	for r := range cn.requests {
+		if cn.postCancel(r) {
+			cancelled++
+		}
+	}
+	torrent.Add("requests cancelled by api", int64(cancelled))
+	return
+}
+
+func (cn *peer) holdRequests(d time.Duration) {
+	until := time.Now().Add(d)
+	if until.Before(cn.requestsHeldUntil) {
+		return
+	}
+	cn.requestsHeldUntil = until
+	time.AfterFunc(d, func() {
+		cn.t.cl.lock()
+		defer cn.t.cl.unlock()
+		if !cn.closed.IsSet() && !cn.requestsHeld() {
+			cn.updateRequests()
+		}
+	})
+}
+
+func (cn *peer) requestsHeld() bool {
+	return time.Now().Before(cn.requestsHeldUntil)
+}
+
+// Cancels all outstanding requests to the Torrent's peers, so they're reissued from scratch by the
+// request strategy. This can correct a distribution of requests that has drifted from what the
+// strategy would choose now, for example after peers have been banned or have slowed down.
+func (t *Torrent) RebalanceRequests() {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.iterPeers(func(p *peer) {
+		for r := range p.requests {
+			p.postCancel(r)
+		}
+	})
+	torrent.Add("request rebalances", 1)
+}
@@ -28,6 +28,9 @@ func (ret peerInfos) AppendFromTracker(ps []tracker.Peer) peerInfos {
 			Addr:   ipPortAddr{p.IP, p.Port},
 			Source: PeerSourceTracker,
 		}
+		if p.IP == nil && p.Host != "" {
+			_p.Addr = hostPortAddr{p.Host, p.Port}
+		}
 		copy(_p.Id[:], p.ID)
 		ret = append(ret, _p)
 	}
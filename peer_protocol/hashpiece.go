@@ -0,0 +1,86 @@
+package peer_protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+// http://bittorrent.org/beps/bep_0030.html
+const ExtensionNameHashPiece ExtensionName = "Tr_hashpiece"
+
+// A BEP 30 merkle tree node hash, sent with piece data.
+type HashPieceNode struct {
+	Index int
+	Hash  [20]byte
+}
+
+// Replaces the piece message for merkle torrents, carrying the tree hashes needed to verify the
+// piece along with a chunk of its data.
+type HashPieceMsg struct {
+	Index  Integer
+	Begin  Integer
+	Hashes []HashPieceNode
+	Piece  []byte
+}
+
+func (m *HashPieceMsg) Message(hashPieceExtendedId ExtensionNumber) Message {
+	hashes := make([][]interface{}, 0, len(m.Hashes))
+	for _, h := range m.Hashes {
+		hashes = append(hashes, []interface{}{h.Index, string(h.Hash[:])})
+	}
+	hashList := bencode.MustMarshal(hashes)
+	payload := make([]byte, 12, 12+len(hashList)+len(m.Piece))
+	binary.BigEndian.PutUint32(payload[0:], uint32(m.Index))
+	binary.BigEndian.PutUint32(payload[4:], uint32(m.Begin))
+	binary.BigEndian.PutUint32(payload[8:], uint32(len(hashList)))
+	payload = append(payload, hashList...)
+	payload = append(payload, m.Piece...)
+	return Message{
+		Type:            Extended,
+		ExtendedID:      hashPieceExtendedId,
+		ExtendedPayload: payload,
+	}
+}
+
+func LoadHashPieceMsg(b []byte) (ret HashPieceMsg, err error) {
+	if len(b) < 12 {
+		err = errors.New("short message")
+		return
+	}
+	ret.Index = Integer(binary.BigEndian.Uint32(b[0:]))
+	ret.Begin = Integer(binary.BigEndian.Uint32(b[4:]))
+	hashListLen := binary.BigEndian.Uint32(b[8:])
+	b = b[12:]
+	if uint64(hashListLen) > uint64(len(b)) {
+		err = errors.New("hash list exceeds message")
+		return
+	}
+	var hashes [][]interface{}
+	if hashListLen != 0 {
+		err = bencode.Unmarshal(b[:hashListLen], &hashes)
+		if err != nil {
+			err = fmt.Errorf("unmarshalling hash list: %w", err)
+			return
+		}
+	}
+	for _, h := range hashes {
+		if len(h) != 2 {
+			err = errors.New("bad hash list entry")
+			return
+		}
+		index, ok := h[0].(int64)
+		hash, ok1 := h[1].(string)
+		if !ok || !ok1 || len(hash) != 20 || index < 0 {
+			err = errors.New("bad hash list entry")
+			return
+		}
+		n := HashPieceNode{Index: int(index)}
+		copy(n.Hash[:], hash)
+		ret.Hashes = append(ret.Hashes, n)
+	}
+	ret.Piece = b[hashListLen:]
+	return
+}
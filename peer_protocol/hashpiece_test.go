@@ -0,0 +1,24 @@
+package peer_protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPieceMsgRoundTrip(t *testing.T) {
+	m := HashPieceMsg{
+		Index:  3,
+		Begin:  0,
+		Hashes: []HashPieceNode{{Index: 10, Hash: [20]byte{1}}, {Index: 9, Hash: [20]byte{2}}},
+		Piece:  []byte("data"),
+	}
+	msg := m.Message(5)
+	assert.EqualValues(t, 5, msg.ExtendedID)
+	m2, err := LoadHashPieceMsg(msg.ExtendedPayload)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+	_, err = LoadHashPieceMsg(msg.ExtendedPayload[:20])
+	assert.Error(t, err)
+}
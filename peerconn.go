@@ -20,8 +20,10 @@ import (
 	"github.com/anacrolix/multiless"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/geoip"
 	"github.com/anacrolix/torrent/mse"
 	pp "github.com/anacrolix/torrent/peer_protocol"
 )
@@ -53,6 +55,12 @@ type peer struct {
 	outgoing   bool
 	network    string
 	RemoteAddr net.Addr
+	// Determined from RemoteAddr.
+	networkClass PeerNetworkClass
+	// Set from ClientConfig.GeoIP when the connection is created.
+	geoIP geoip.Info
+	// Preferred by ISP-friendly selection. See Client.peerAffine.
+	affine bool
 	// True if the connection is operating over MSE obfuscation.
 	headerEncrypted bool
 	cryptoMethod    mse.CryptoMethod
@@ -80,6 +88,8 @@ type peer struct {
 	choking          bool
 	requests         map[request]struct{}
 	requestsLowWater int
+	// New requests aren't made to the peer until this time. See peer.CancelRequests.
+	requestsHeldUntil time.Time
 	// Chunks that we might reasonably expect to receive from the peer. Due to
 	// latency, buffering, and implementation differences, we may receive
 	// chunks that are no longer in the set of requests actually want.
@@ -88,6 +98,11 @@ type peer struct {
 	// response.
 	metadataRequests []bool
 	sentHaves        bitmap.Bitmap
+	// We told the peer we have every piece, with have-all or a full bitfield. sentHaves isn't
+	// maintained after that, to save memory on connections to seeds.
+	sentHaveAll bool
+	// Pieces still to be disclosed with BitfieldPolicyIncremental.
+	undisclosedPieces bitmap.Bitmap
 
 	// Stuff controlled by the remote peer.
 	peerInterested        bool
@@ -129,6 +144,8 @@ type PeerConn struct {
 	// See BEP 3 etc.
 	PeerID             PeerID
 	PeerExtensionBytes pp.PeerExtensionBits
+	// The latest extended handshake received. See Capabilities.
+	peerExtendedHandshake *pp.ExtendedHandshakeMessage
 
 	// The actual Conn, used for closing, and setting socket options.
 	conn net.Conn
@@ -331,6 +348,9 @@ func (cn *peer) writeStatus(w io.Writer, t *Torrent) {
 		cn.statusFlags(),
 		cn.downloadRate()/(1<<10),
 	)
+	if cn.geoIP != (geoip.Info{}) {
+		fmt.Fprintf(w, "    country: %q, asn: %d %q\n", cn.geoIP.Country, cn.geoIP.ASN, cn.geoIP.ASOrg)
+	}
 	fmt.Fprintf(w, "    next pieces: %v%s\n",
 		iter.ToSlice(iter.Head(10, cn.iterPendingPiecesUntyped)),
 		func() string {
@@ -431,11 +451,18 @@ func (cn *PeerConn) requestedMetadataPiece(index int) bool {
 
 // The actual value to use as the maximum outbound requests.
 func (cn *peer) nominalMaxRequests() (ret int) {
-	return int(clamp(
+	ret = int(clamp(
 		1,
 		int64(cn.PeerMaxRequests),
 		int64(cn.t.requestStrategy.nominalMaxRequests(cn.requestStrategyConnection())),
 	))
+	if pc, ok := cn.peerImpl.(*PeerConn); ok && cn.t.cl.config.PeerRequestFairness {
+		ret = pc.fairRequestShare(ret)
+	}
+	if ws, ok := cn.peerImpl.(*webseedPeer); ok {
+		ret = ws.arbitratedMaxRequests(ret)
+	}
+	return
 }
 
 func (cn *peer) totalExpectingTime() (ret time.Duration) {
@@ -573,7 +600,7 @@ func (me *PeerConn) cancel(r request) bool {
 }
 
 func (cn *peer) doRequestState() bool {
-	if !cn.t.networkingEnabled || cn.t.dataDownloadDisallowed {
+	if !cn.t.networkingEnabled || cn.t.dataDownloadDisallowed || cn.t.quarantined() || cn.t.cl.lowFreeSpace {
 		if !cn.setInterested(false) {
 			return false
 		}
@@ -586,6 +613,8 @@ func (cn *peer) doRequestState() bool {
 				}
 			}
 		}
+	} else if cn.requestsHeld() {
+		// Left for other peers to pick up. See peer.CancelRequests.
 	} else if len(cn.requests) <= cn.requestsLowWater {
 		filledBuffer := false
 		cn.iterPendingPieces(func(pieceIndex pieceIndex) bool {
@@ -633,6 +662,7 @@ func (cn *PeerConn) fillWriteBuffer() {
 		}
 	}
 	cn.upload(cn.write)
+	cn.discloseHaves(cn.write)
 }
 
 // Routine that writes to the peer. Some of what to write is buffered by
@@ -693,7 +723,7 @@ func (cn *PeerConn) writer(keepAliveTimeout time.Duration) {
 }
 
 func (cn *PeerConn) have(piece pieceIndex) {
-	if cn.sentHaves.Get(bitmap.BitIndex(piece)) {
+	if cn.sentHaveAll || cn.sentHaves.Get(bitmap.BitIndex(piece)) {
 		return
 	}
 	cn.post(pp.Message{
@@ -704,17 +734,38 @@ func (cn *PeerConn) have(piece pieceIndex) {
 }
 
 func (cn *PeerConn) postBitfield() {
-	if cn.sentHaves.Len() != 0 {
+	if cn.sentHaveAll || cn.sentHaves.Len() != 0 {
 		panic("bitfield must be first have-related message sent")
 	}
-	if !cn.t.haveAnyPieces() {
+	if !cn.t.advertiseAnyPieces() {
+		return
+	}
+	if cn.t.advertiseAllPieces() {
+		cn.post(pp.Message{
+			Type:     pp.Bitfield,
+			Bitfield: cn.t.allPiecesBitfield(),
+		})
+		cn.sentHaveAll = true
+		torrent.Add("full bitfields sent", 1)
 		return
 	}
 	cn.post(pp.Message{
 		Type:     pp.Bitfield,
 		Bitfield: cn.t.bitfield(),
 	})
-	cn.sentHaves = cn.t._completedPieces.Copy()
+	cn.sentHaves = cn.t.advertisedPieces()
+}
+
+// Tells the peer we have every piece. The peer must support the fast extension.
+func (cn *PeerConn) postHaveAll() {
+	cn.post(pp.Message{Type: pp.HaveAll})
+	cn.sentHaveAll = true
+	torrent.Add("have-alls sent", 1)
+	// Without have-all, postBitfield sends nothing when there are no pieces to advertise, such as
+	// for a torrent with no pieces.
+	if cn.t.advertiseAnyPieces() {
+		torrent.Add("bitfield bytes saved by have-all", int64(bitfieldMessageLen(cn.t.numPieces())-haveAllMessageLen))
+	}
 }
 
 func (cn *PeerConn) updateRequests() {
@@ -741,6 +792,20 @@ func iterBitmapsDistinct(skip *bitmap.Bitmap, bms ...bitmap.Bitmap) iter.Func {
 	}
 }
 
+// Iterates wanted pieces given to SetPieceOrder in that order, adding them to skip.
+func iterExplicitPieceOrder(t requestStrategyTorrent, skip *bitmap.Bitmap, f func(pieceIndex) bool) bool {
+	for _, piece := range t.explicitPieceOrder() {
+		if skip.Contains(piece) || !t.pendingPieces().Contains(piece) {
+			continue
+		}
+		if !f(piece) {
+			return false
+		}
+		skip.Add(piece)
+	}
+	return true
+}
+
 func iterUnbiasedPieceRequestOrder(cn requestStrategyConnection, f func(piece pieceIndex) bool) bool {
 	now, readahead := cn.torrent().readerPiecePriorities()
 	skip := bitmap.Flip(cn.peerPieces(), 0, cn.torrent().numPieces())
@@ -750,6 +815,12 @@ func iterUnbiasedPieceRequestOrder(cn requestStrategyConnection, f func(piece pi
 		func(_piece interface{}) bool {
 			return f(pieceIndex(_piece.(bitmap.BitIndex)))
 		},
+		// Pieces with an explicit order come before everything else.
+		func(cb iter.Callback) {
+			iterExplicitPieceOrder(cn.torrent(), &skip, func(piece pieceIndex) bool {
+				return cb(piece)
+			})
+		},
 		iterBitmapsDistinct(&skip, now, readahead),
 		// We have to iterate _pendingPieces separately because it isn't a Bitmap.
 		func(cb iter.Callback) {
@@ -811,8 +882,15 @@ func (cn *peer) updatePiecePriority(piece pieceIndex) bool {
 	if tpp == PiecePriorityNone {
 		return cn.stopRequestingPiece(piece)
 	}
-	prio := cn.getPieceInclination()[piece]
-	prio = cn.t.requestStrategy.piecePriority(cn, piece, tpp, prio)
+	var prio int
+	if rank, ok := cn.t.pieceOrderRank(piece); ok {
+		// Below anything the request strategy can produce, so these pieces are requested first
+		// and in the given order.
+		prio = rank - 3*cn.t.numPieces()
+	} else {
+		prio = cn.getPieceInclination()[piece]
+		prio = cn.t.requestStrategy.piecePriority(cn, piece, tpp, prio)
+	}
 	return cn._pieceRequestOrder.Set(bitmap.BitIndex(piece), prio) || cn.shouldRequestWithoutBias()
 }
 
@@ -860,6 +938,9 @@ func (cn *PeerConn) peerSentHave(piece pieceIndex) error {
 	}
 	cn.raisePeerMinPieces(piece + 1)
 	cn._peerPieces.Set(bitmap.BitIndex(piece), true)
+	if cn.active() && cn.t.haveInfo() {
+		cn.t.pieces[piece].availability++
+	}
 	if cn.updatePiecePriority(piece) {
 		cn.updateRequests()
 	}
@@ -867,10 +948,11 @@ func (cn *PeerConn) peerSentHave(piece pieceIndex) error {
 }
 
 func (cn *PeerConn) peerSentBitfield(bf []bool) error {
-	cn.peerSentHaveAll = false
 	if len(bf)%8 != 0 {
 		panic("expected bitfield length divisible by 8")
 	}
+	cn.decPieceAvailability()
+	cn.peerSentHaveAll = false
 	// We know that the last byte means that at most the last 7 bits are
 	// wasted.
 	cn.raisePeerMinPieces(pieceIndex(len(bf) - 7))
@@ -884,20 +966,25 @@ func (cn *PeerConn) peerSentBitfield(bf []bool) error {
 		}
 		cn._peerPieces.Set(i, have)
 	}
+	cn.incPieceAvailability()
 	cn.peerPiecesChanged()
 	return nil
 }
 
 func (cn *PeerConn) onPeerSentHaveAll() error {
+	cn.decPieceAvailability()
 	cn.peerSentHaveAll = true
 	cn._peerPieces.Clear()
+	cn.incPieceAvailability()
 	cn.peerPiecesChanged()
 	return nil
 }
 
 func (cn *PeerConn) peerSentHaveNone() error {
+	cn.decPieceAvailability()
 	cn._peerPieces.Clear()
 	cn.peerSentHaveAll = false
+	cn.incPieceAvailability()
 	cn.peerPiecesChanged()
 	return nil
 }
@@ -946,6 +1033,7 @@ func (cn *peer) postHandshakeStats(f func(*ConnStats)) {
 	t := cn.t
 	f(&t.stats)
 	f(&t.cl.stats)
+	f(&t.cl.networkClassStats[cn.networkClass])
 }
 
 // All ConnStats that include this connection. Some objects are not known
@@ -1019,7 +1107,7 @@ func (c *PeerConn) onReadRequest(r request) error {
 		}
 		return nil
 	}
-	if len(c.peerRequests) >= maxRequests {
+	if len(c.peerRequests) >= c.t.cl.maxPeerRequests() {
 		torrent.Add("requests received while queue full", 1)
 		if c.fastEnabled() {
 			c.reject(r)
@@ -1034,13 +1122,20 @@ func (c *PeerConn) onReadRequest(r request) error {
 		requestsReceivedForMissingPieces.Add(1)
 		return fmt.Errorf("peer requested piece we don't have: %v", r.Index.Int())
 	}
+	if !c.t.pieceScanned(pieceIndex(r.Index)) {
+		torrent.Add("requests received for unscanned pieces", 1)
+		if c.fastEnabled() {
+			c.reject(r)
+		}
+		return nil
+	}
 	// Check this after we know we have the piece, so that the piece length will be known.
 	if r.Begin+r.Length > c.t.pieceLength(pieceIndex(r.Index)) {
 		torrent.Add("bad requests received", 1)
 		return errors.New("bad request")
 	}
 	if c.peerRequests == nil {
-		c.peerRequests = make(map[request]*peerRequestState, maxRequests)
+		c.peerRequests = make(map[request]*peerRequestState, c.t.cl.maxPeerRequests())
 	}
 	value := &peerRequestState{}
 	c.peerRequests[r] = value
@@ -1068,8 +1163,13 @@ func (c *PeerConn) peerRequestDataReader(r request, prs *peerRequestState) {
 // chunk sending, the way it used to work.
 func (c *PeerConn) peerRequestDataReadFailed(err error, r request) {
 	c.logger.WithDefaultLevel(log.Warning).Printf("error reading chunk for peer request %v: %v", r, err)
+	c.t.cl.countError(ErrorCategoryStorage, err)
 	i := pieceIndex(r.Index)
-	if c.t.pieceComplete(i) {
+	if c.t.piece(i).assumedComplete {
+		// The piece was never verified (see TorrentSpec.SeedMode), so its data might not be there
+		// at all.
+		c.t.queuePieceCheck(i)
+	} else if c.t.pieceComplete(i) {
 		// There used to be more code here that just duplicated the following break. Piece
 		// completions are currently cached, so I'm not sure how helpful this update is, except to
 		// pull any completion changes pushed to the storage backend in failed reads that got us
@@ -1087,6 +1187,12 @@ func (c *PeerConn) peerRequestDataReadFailed(err error, r request) {
 }
 
 func readPeerRequestData(r request, c *PeerConn) ([]byte, error) {
+	if uc := c.t.cl.uploadCache; uc != nil {
+		b, ok, err := c.t.readCachedRequestData(uc, r)
+		if ok {
+			return b, err
+		}
+	}
 	b := make([]byte, r.Length)
 	p := c.t.info.Piece(int(r.Index))
 	n, err := c.t.readAt(b, p.Offset()+int64(r.Begin))
@@ -1279,6 +1385,7 @@ func (c *PeerConn) onReadExtendedMsg(id pp.ExtensionNumber, payload []byte) (err
 		if d.Reqq != 0 {
 			c.PeerMaxRequests = d.Reqq
 		}
+		c.peerExtendedHandshake = &d
 		c.PeerClientName = d.V
 		if c.PeerExtensionIDs == nil {
 			c.PeerExtensionIDs = make(map[pp.ExtensionName]pp.ExtensionNumber, len(d.M))
@@ -1313,6 +1420,11 @@ func (c *PeerConn) onReadExtendedMsg(id pp.ExtensionNumber, payload []byte) (err
 			return nil // or hang-up maybe?
 		}
 		return c.pex.Recv(payload)
+	case hashPieceExtendedId:
+		if !cl.config.EnableMerkleTorrents {
+			return errors.New("hash piece message but merkle torrents are disabled")
+		}
+		return c.onReadHashPiece(payload)
 	default:
 		return fmt.Errorf("unexpected extended message ID: %v", id)
 	}
@@ -1377,6 +1489,7 @@ func (c *peer) receiveChunk(msg *pp.Message) error {
 	c.allStats(add(1, func(cs *ConnStats) *Count { return &cs.ChunksReadUseful }))
 	c.allStats(add(int64(len(msg.Piece)), func(cs *ConnStats) *Count { return &cs.BytesReadUsefulData }))
 	c.lastUsefulChunkReceived = time.Now()
+	t.onUsefulData()
 	// if t.fastestPeer != c {
 	// log.Printf("setting fastest connection %p", c)
 	// }
@@ -1384,7 +1497,7 @@ func (c *peer) receiveChunk(msg *pp.Message) error {
 
 	// Need to record that it hasn't been written yet, before we attempt to do
 	// anything with it.
-	piece.incrementPendingWrites()
+	piece.incrementPendingWrites(req.chunkSpec)
 	// Record that we have the chunk, so we aren't trying to download it while
 	// waiting for it to be written to storage.
 	piece.unpendChunkIndex(chunkIndex(req.chunkSpec, t.chunkSize))
@@ -1407,7 +1520,7 @@ func (c *peer) receiveChunk(msg *pp.Message) error {
 		return t.writeChunk(int(msg.Index), int64(msg.Begin), msg.Piece)
 	}()
 
-	piece.decrementPendingWrites()
+	piece.decrementPendingWrites(req.chunkSpec)
 
 	if err != nil {
 		c.logger.WithDefaultLevel(log.Error).Printf("writing received chunk %v: %v", req, err)
@@ -1420,7 +1533,7 @@ func (c *peer) receiveChunk(msg *pp.Message) error {
 	c.onDirtiedPiece(pieceIndex(req.Index))
 
 	// We need to ensure the piece is only queued once, so only the last chunk writer gets this job.
-	if t.pieceAllDirty(pieceIndex(req.Index)) && piece.pendingWrites == 0 {
+	if t.pieceAllDirty(pieceIndex(req.Index)) && !piece.hasPendingWrites() {
 		t.queuePieceCheck(pieceIndex(req.Index))
 		// We don't pend all chunks here anymore because we don't want code dependent on the dirty
 		// chunk status (such as the haveChunk call above) to have to check all the various other
@@ -1451,13 +1564,13 @@ func (c *PeerConn) uploadAllowed() bool {
 	if c.t.cl.config.NoUpload {
 		return false
 	}
-	if c.t.dataUploadDisallowed {
+	if c.t.dataUploadDisallowed || c.t.quarantined() {
 		return false
 	}
 	if c.t.seeding() {
 		return true
 	}
-	if !c.peerHasWantedPieces() {
+	if !c.peerHasWantedPieces() && !c.bootstrapUploadAllowed() {
 		return false
 	}
 	// Don't upload more than 100 KiB more than we download.
@@ -1489,13 +1602,18 @@ another:
 			if state.data == nil {
 				continue
 			}
-			res := c.t.cl.config.UploadRateLimiter.ReserveN(time.Now(), int(r.Length))
-			if !res.OK() {
+			limiters := c.uploadLimiters()
+			cost := c.t.rateLimitCost()
+			for _, l := range limiters {
+				if l.Limit() == rate.Inf || int(r.Length)*cost > l.Burst() {
+					cost = 1
+				}
+			}
+			delay, ok := reserveUpload(limiters, int(r.Length)*cost)
+			if !ok {
 				panic(fmt.Sprintf("upload rate limiter burst size < %d", r.Length))
 			}
-			delay := res.Delay()
 			if delay > 0 {
-				res.Cancel()
 				c.setRetryUploadTimer(delay)
 				// Hard to say what to return here.
 				return true
@@ -1599,12 +1717,19 @@ func (c *PeerConn) _postCancel(r request) {
 
 func (c *PeerConn) sendChunk(r request, msg func(pp.Message) bool, state *peerRequestState) (more bool) {
 	c.lastChunkSent = time.Now()
-	return msg(pp.Message{
+	pieceMsg := pp.Message{
 		Type:  pp.Piece,
 		Index: r.Index,
 		Begin: r.Begin,
 		Piece: state.data,
-	})
+	}
+	if hashPieceMsg, ok := c.hashPieceMessage(r, state.data); ok {
+		more = msg(hashPieceMsg)
+		// Account for the data as if it were sent in a piece message.
+		c.allStats(func(cs *ConnStats) { cs.wroteMsg(&pieceMsg) })
+		return
+	}
+	return msg(pieceMsg)
 }
 
 func (c *PeerConn) setTorrent(t *Torrent) {
@@ -1620,6 +1745,11 @@ func (c *peer) peerPriority() (peerPriority, error) {
 	return bep40Priority(c.remoteIpPort(), c.t.cl.publicAddr(c.remoteIp()))
 }
 
+// Returns what ClientConfig.GeoIP knows about the peer's address.
+func (c *peer) GeoIP() geoip.Info {
+	return c.geoIP
+}
+
 func (c *peer) remoteIp() net.IP {
 	return addrIpOrNil(c.RemoteAddr)
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/anacrolix/missinggo/pubsub"
 	"github.com/bradfitz/iter"
 	"github.com/frankban/quicktest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/anacrolix/torrent/metainfo"
@@ -53,6 +54,39 @@ func TestSendBitfieldThenHave(t *testing.T) {
 	require.EqualValues(t, "\x00\x00\x00\x02\x05@\x00\x00\x00\x05\x04\x00\x00\x00\x02", string(b))
 }
 
+func TestSendFullBitfieldSkipsSentHaves(t *testing.T) {
+	cl := Client{
+		config: TestingConfig(),
+	}
+	cl.initLogger()
+	c := cl.newConnection(nil, false, nil, "", "")
+	c.setTorrent(cl.newTorrent(metainfo.Hash{}, nil))
+	c.t.setInfo(&metainfo.Info{
+		Pieces: make([]byte, metainfo.HashSize*3),
+	})
+	r, w := io.Pipe()
+	c.r = r
+	c.w = w
+	go c.writer(time.Minute)
+	c.locker().Lock()
+	c.t._completedPieces.AddRange(0, 3)
+	c.postBitfield()
+	require.True(t, c.sentHaveAll)
+	require.EqualValues(t, 0, c.sentHaves.Len())
+	// Already covered by the bitfield.
+	c.have(2)
+	c.post(pp.Message{Keepalive: true})
+	c.locker().Unlock()
+	b := make([]byte, 10)
+	n, err := io.ReadFull(r, b)
+	c.locker().Lock()
+	c.closed.Set()
+	c.locker().Unlock()
+	require.NoError(t, err)
+	require.EqualValues(t, 10, n)
+	require.EqualValues(t, "\x00\x00\x00\x02\x05\xe0\x00\x00\x00\x00", string(b))
+}
+
 type torrentStorage struct {
 	writeSem sync.Mutex
 }
@@ -208,3 +242,35 @@ func TestConnPexEvent(t *testing.T) {
 		require.EqualValues(t, tc.e, e, i)
 	}
 }
+
+func TestCancelOutstandingRequests(t *testing.T) {
+	cl := Client{
+		config: TestingConfig(),
+	}
+	cl.initLogger()
+	c := cl.newConnection(nil, false, nil, "", "")
+	c.setTorrent(cl.newTorrent(metainfo.Hash{}, nil))
+	c.t.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, metainfo.HashSize*2),
+		PieceLength: 1 << 15,
+		Length:      1 << 16,
+	})
+	reqs := []request{newRequest(1, 0, 1<<14), newRequest(0, 1<<14, 1<<14), newRequest(0, 0, 1<<14)}
+	c.requests = make(map[request]struct{})
+	c.t.pendingRequests = make(map[request]int)
+	for _, r := range reqs {
+		c.requests[r] = struct{}{}
+		c.t.pendingRequests[r]++
+	}
+	assert.EqualValues(t, []ChunkRequest{
+		{0, 0, 1 << 14},
+		{0, 1 << 14, 1 << 14},
+		{1, 0, 1 << 14},
+	}, c.OutstandingRequests())
+	assert.True(t, c.CancelRequest(ChunkRequest{1, 0, 1 << 14}))
+	assert.False(t, c.CancelRequest(ChunkRequest{1, 0, 1 << 14}))
+	assert.Equal(t, 2, c.CancelRequests(time.Minute))
+	assert.Empty(t, c.OutstandingRequests())
+	assert.Empty(t, c.t.pendingRequests)
+	assert.True(t, c.requestsHeld())
+}
@@ -0,0 +1,67 @@
+package torrent
+
+// Piece availability is the number of active peers that have a piece. It's kept up to date from
+// the have, bitfield, have-all and have-none messages of connections, and as peers come and go,
+// rather than counted over the peers each time it's needed. Peers with every piece are counted
+// once in Torrent.peersWithAllPieces instead of in each piece.
+
+// Returns the number of connected peers, including webseeds, that have the piece.
+func (t *Torrent) pieceAvailability(piece pieceIndex) int {
+	return t.peersWithAllPieces + t.pieces[piece].availability
+}
+
+// Adds the peer's pieces to the availability. The peer must be active, or becoming so. Pieces
+// sent before the info is known are only counted once it is, by initPieceAvailability.
+func (t *Torrent) incPeerPieceAvailability(p *peer) {
+	t.addPeerPieceAvailability(p, 1)
+}
+
+// Removes the peer's pieces from the availability, before it stops being active, or its pieces
+// are replaced.
+func (t *Torrent) decPeerPieceAvailability(p *peer) {
+	t.addPeerPieceAvailability(p, -1)
+}
+
+func (t *Torrent) addPeerPieceAvailability(p *peer, delta int) {
+	if p.peerSentHaveAll {
+		t.peersWithAllPieces += delta
+		return
+	}
+	if !t.haveInfo() {
+		return
+	}
+	p._peerPieces.IterTyped(func(piece int) bool {
+		t.pieces[piece].availability += delta
+		return true
+	})
+}
+
+// Counts the pieces of the active peers, once the info is known and their pieces have been
+// trimmed to the torrent's.
+func (t *Torrent) initPieceAvailability() {
+	t.peersWithAllPieces = 0
+	for i := range t.pieces {
+		t.pieces[i].availability = 0
+	}
+	t.iterPeers(t.incPeerPieceAvailability)
+}
+
+// Whether the connection is one of the torrent's active peers, whose pieces count toward their
+// availability.
+func (cn *PeerConn) active() bool {
+	_, ok := cn.t.conns[cn]
+	return ok
+}
+
+// Called before the connection's pieces are replaced, with incPieceAvailability after.
+func (cn *PeerConn) decPieceAvailability() {
+	if cn.active() {
+		cn.t.decPeerPieceAvailability(&cn.peer)
+	}
+}
+
+func (cn *PeerConn) incPieceAvailability() {
+	if cn.active() {
+		cn.t.incPeerPieceAvailability(&cn.peer)
+	}
+}
@@ -0,0 +1,60 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestPieceAvailability(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	info := metainfo.Info{
+		PieceLength: 1,
+		Pieces:      make([]byte, 40),
+		Files:       []metainfo.FileInfo{{Length: 2}},
+	}
+	infoBytes, err := bencode.Marshal(info)
+	require.NoError(t, err)
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoBytes: infoBytes,
+		InfoHash:  metainfo.HashBytes(infoBytes),
+		Storage:   badStorage{},
+	})
+	require.NoError(t, err)
+	defer tt.Drop()
+	cl.lock()
+	defer cl.unlock()
+	avail := func() []int {
+		return []int{tt.pieceAvailability(0), tt.pieceAvailability(1)}
+	}
+	a := &PeerConn{peer: peer{t: tt}}
+	b := &PeerConn{peer: peer{t: tt}}
+	for _, c := range []*PeerConn{a, b} {
+		tt.conns[c] = struct{}{}
+		tt.incPeerPieceAvailability(&c.peer)
+	}
+	require.NoError(t, a.peerSentHave(1))
+	assert.Equal(t, []int{0, 1}, avail())
+	require.NoError(t, b.peerSentBitfield([]bool{true, true, false, false, false, false, false, false}))
+	assert.Equal(t, []int{1, 2}, avail())
+	require.NoError(t, a.onPeerSentHaveAll())
+	assert.Equal(t, []int{2, 2}, avail())
+	require.NoError(t, b.peerSentHaveNone())
+	assert.Equal(t, []int{1, 1}, avail())
+	// Recounting from scratch agrees.
+	tt.initPieceAvailability()
+	assert.Equal(t, []int{1, 1}, avail())
+	delete(tt.conns, a)
+	tt.decPeerPieceAvailability(&a.peer)
+	assert.Equal(t, []int{0, 0}, avail())
+	// Connections that aren't active don't count.
+	c := &PeerConn{peer: peer{t: tt}}
+	require.NoError(t, c.peerSentHave(0))
+	assert.Equal(t, []int{0, 0}, avail())
+}
@@ -0,0 +1,142 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+)
+
+// The version byte leading streams written by ExportPieces. Increment it if the layout changes.
+const pieceExportVersion = 1
+
+// Writes the verified pieces that are missing from the remote bitfield, as returned by Bitfield on
+// the remote Torrent, to w. The stream can be passed to ImportPieces on the remote. This allows
+// replicating torrent data out-of-band, such as between datacenters, without the peer protocol.
+// Returns the number of pieces written.
+//
+// The layout is the version byte, then for each piece its index plus one as a uvarint followed by
+// the piece data, and finally a zero uvarint.
+func (t *Torrent) ExportPieces(w io.Writer, remoteBitfield []byte) (pieces int, err error) {
+	t.cl.rLock()
+	if !t.haveInfo() {
+		t.cl.rUnlock()
+		return 0, errors.New("torrent info not available")
+	}
+	remote, err := unmarshalBitfield(remoteBitfield, t.numPieces())
+	if err != nil {
+		t.cl.rUnlock()
+		return 0, fmt.Errorf("unmarshalling remote bitfield: %w", err)
+	}
+	var missing bitmap.Bitmap
+	t._completedPieces.IterTyped(func(piece int) bool {
+		if !remote.Get(bitmap.BitIndex(piece)) {
+			missing.Add(piece)
+		}
+		return true
+	})
+	t.cl.rUnlock()
+	if _, err = w.Write([]byte{pieceExportVersion}); err != nil {
+		return
+	}
+	var varint [binary.MaxVarintLen64]byte
+	missing.IterTyped(func(piece int) bool {
+		var data []byte
+		data, err = t.readVerifiedPiece(piece)
+		if err != nil {
+			err = fmt.Errorf("reading piece %d: %w", piece, err)
+			return false
+		}
+		n := binary.PutUvarint(varint[:], uint64(piece)+1)
+		if _, err = w.Write(varint[:n]); err != nil {
+			return false
+		}
+		if _, err = w.Write(data); err != nil {
+			return false
+		}
+		pieces++
+		return true
+	})
+	if err != nil {
+		return
+	}
+	_, err = w.Write([]byte{0})
+	return
+}
+
+// Reads the entire piece from storage, so that a failure doesn't leave a partial piece in an
+// export stream.
+func (t *Torrent) readVerifiedPiece(piece pieceIndex) ([]byte, error) {
+	t.cl.rLock()
+	p := t.piece(piece)
+	data := make([]byte, p.length())
+	t.cl.rUnlock()
+	t.storageLock.RLock()
+	defer t.storageLock.RUnlock()
+	_, err := io.ReadFull(io.NewSectionReader(p.Storage(), 0, int64(len(data))), data)
+	return data, err
+}
+
+// Reads a stream written by ExportPieces on another client, writing each piece to storage if its
+// hash is correct. Pieces that are already complete are skipped. Returns the number of pieces
+// imported. Pieces imported before an error remain complete.
+func (t *Torrent) ImportPieces(r io.Reader) (pieces int, err error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		// Buffering could consume data following the stream.
+		br = singleByteReader{r}
+	}
+	version, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("reading version: %w", err)
+	}
+	if version != pieceExportVersion {
+		return 0, fmt.Errorf("unsupported piece export version %d", version)
+	}
+	for {
+		var index uint64
+		index, err = binary.ReadUvarint(br)
+		if err != nil {
+			return pieces, fmt.Errorf("reading piece index: %w", err)
+		}
+		if index == 0 {
+			return pieces, nil
+		}
+		t.cl.rLock()
+		if !t.haveInfo() {
+			t.cl.rUnlock()
+			return pieces, errors.New("torrent info not available")
+		}
+		if index > uint64(t.numPieces()) {
+			t.cl.rUnlock()
+			return pieces, fmt.Errorf("piece index %d out of range", index-1)
+		}
+		piece := pieceIndex(index - 1)
+		data := make([]byte, t.pieceLength(piece))
+		complete := t.pieceComplete(piece)
+		t.cl.rUnlock()
+		if _, err = io.ReadFull(r, data); err != nil {
+			return pieces, fmt.Errorf("reading piece %d: %w", piece, err)
+		}
+		if complete {
+			continue
+		}
+		if err = t.ImportPiece(piece, bytes.NewReader(data)); err != nil {
+			return
+		}
+		pieces++
+	}
+}
+
+type singleByteReader struct {
+	io.Reader
+}
+
+func (me singleByteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(me.Reader, b[:])
+	return b[0], err
+}
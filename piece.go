@@ -1,9 +1,13 @@
 package torrent
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"sync"
 
+	"github.com/anacrolix/missinggo"
 	"github.com/anacrolix/missinggo/v2/bitmap"
 
 	"github.com/anacrolix/torrent/metainfo"
@@ -51,18 +55,99 @@ type Piece struct {
 	numVerifies         int64
 	hashing             bool
 	storageCompletionOk bool
+	// Marked complete without hashing by seed mode. Cleared when the piece is hashed.
+	assumedComplete bool
 
 	publicPieceState PieceState
 	priority         piecePriority
+	// Active peers that have the piece, not counting Torrent.peersWithAllPieces. See
+	// Torrent.pieceAvailability.
+	availability int
 
 	// This can be locked when the Client lock is taken, but probably not vice versa.
 	pendingWritesMutex sync.Mutex
-	pendingWrites      int
-	noPendingWrites    sync.Cond
+	// The chunks being written to storage. Reads only wait for those they overlap.
+	pendingWrites   []chunkSpec
+	noPendingWrites sync.Cond
 
 	// Connections that have written data to this piece since its last check.
 	// This can include connections that have closed.
 	dirtiers map[*peer]struct{}
+
+	// Registered with OnHashed.
+	hashedHandlers []*func(PieceHashResult)
+}
+
+// The outcome of hashing a piece's data from storage.
+type PieceHashResult struct {
+	Index pieceIndex
+	// The digest computed from the data in storage.
+	Hash metainfo.Hash
+	// The digest matched the piece hash in the metainfo.
+	Passed bool
+	// Set if reading the piece from storage failed.
+	Err error
+}
+
+// Registers f to be called with the result each time the piece is hashed. Handlers are run without
+// the Client lock, on the goroutine that hashed the piece, so handlers for different pieces may run
+// concurrently. The returned func unregisters f.
+func (p *Piece) OnHashed(f func(PieceHashResult)) (unregister func()) {
+	p.t.cl.lock()
+	defer p.t.cl.unlock()
+	h := &f
+	p.hashedHandlers = append(p.hashedHandlers, h)
+	return func() {
+		p.t.cl.lock()
+		defer p.t.cl.unlock()
+		for i, e := range p.hashedHandlers {
+			if e == h {
+				p.hashedHandlers = append(p.hashedHandlers[:i:i], p.hashedHandlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Returned by Piece.ReadUnverified when no data has been received at the offset.
+var ErrUnverifiedDataUnavailable = errors.New("no data received at offset")
+
+// Reads data that has been received for the piece but may not have passed its hash check. Reads
+// stop at the first chunk that hasn't been written. Data from incomplete pieces is unverified: it
+// may be corrupt or malicious, and may change if the piece fails its check. For complete pieces
+// this is the same as reading verified data.
+func (p *Piece) ReadUnverified(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+	p.t.cl.rLock()
+	avail := p.unverifiedBytesAvailable(off)
+	p.t.cl.rUnlock()
+	if avail <= 0 {
+		if off >= int64(p.length()) {
+			return 0, io.EOF
+		}
+		return 0, ErrUnverifiedDataUnavailable
+	}
+	b = missinggo.LimitLen(b, avail)
+	p.waitNoPendingWritesIn(off, int64(len(b)))
+	return p.Storage().ReadAt(b, off)
+}
+
+// Returns the number of contiguous bytes from off that have been written to storage.
+func (p *Piece) unverifiedBytesAvailable(off int64) int64 {
+	if p.t.pieceComplete(p.index) {
+		return int64(p.length()) - off
+	}
+	end := off
+	for ci := pp.Integer(off / int64(p.chunkSize())); ci < p.numChunks(); ci++ {
+		if !p.chunkIndexDirty(ci) {
+			break
+		}
+		cs := p.chunkIndexSpec(ci)
+		end = int64(cs.Begin + cs.Length)
+	}
+	return end - off
 }
 
 func (p *Piece) String() string {
@@ -106,32 +191,59 @@ func (p *Piece) numChunks() pp.Integer {
 	return p.t.pieceNumChunks(p.index)
 }
 
-func (p *Piece) incrementPendingWrites() {
+func (p *Piece) incrementPendingWrites(cs chunkSpec) {
 	p.pendingWritesMutex.Lock()
-	p.pendingWrites++
+	p.pendingWrites = append(p.pendingWrites, cs)
 	p.pendingWritesMutex.Unlock()
 }
 
-func (p *Piece) decrementPendingWrites() {
+func (p *Piece) decrementPendingWrites(cs chunkSpec) {
 	p.pendingWritesMutex.Lock()
-	if p.pendingWrites == 0 {
-		panic("assertion")
-	}
-	p.pendingWrites--
-	if p.pendingWrites == 0 {
-		p.noPendingWrites.Broadcast()
+	defer p.pendingWritesMutex.Unlock()
+	for i, e := range p.pendingWrites {
+		if e == cs {
+			p.pendingWrites = append(p.pendingWrites[:i], p.pendingWrites[i+1:]...)
+			// Readers may be waiting on any of the writes.
+			p.noPendingWrites.Broadcast()
+			return
+		}
 	}
-	p.pendingWritesMutex.Unlock()
+	panic("assertion")
+}
+
+func (p *Piece) hasPendingWrites() bool {
+	p.pendingWritesMutex.Lock()
+	defer p.pendingWritesMutex.Unlock()
+	return len(p.pendingWrites) != 0
 }
 
 func (p *Piece) waitNoPendingWrites() {
 	p.pendingWritesMutex.Lock()
-	for p.pendingWrites != 0 {
+	for len(p.pendingWrites) != 0 {
 		p.noPendingWrites.Wait()
 	}
 	p.pendingWritesMutex.Unlock()
 }
 
+// Waits for writes overlapping the n bytes at off in the piece, so that the rest of the piece can
+// be read while it's still being written.
+func (p *Piece) waitNoPendingWritesIn(off, n int64) {
+	p.pendingWritesMutex.Lock()
+	defer p.pendingWritesMutex.Unlock()
+	for p.pendingWriteOverlaps(off, n) {
+		p.noPendingWrites.Wait()
+	}
+}
+
+func (p *Piece) pendingWriteOverlaps(off, n int64) bool {
+	for _, cs := range p.pendingWrites {
+		if int64(cs.Begin) < off+n && off < int64(cs.Begin+cs.Length) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Piece) chunkIndexDirty(chunk pp.Integer) bool {
 	return p._dirtyChunks.Contains(bitmap.BitIndex(chunk))
 }
@@ -236,6 +348,9 @@ func (p *Piece) uncachedPriority() (ret piecePriority) {
 	if p.t.readerReadaheadPieces().Contains(bitmap.BitIndex(p.index)) {
 		ret.Raise(PiecePriorityReadahead)
 	}
+	if p.t.readerLingerPieces().Contains(bitmap.BitIndex(p.index)) {
+		ret.Raise(PiecePriorityNormal)
+	}
 	ret.Raise(p.priority)
 	return
 }
@@ -0,0 +1,137 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Adds a torrent of two 6 byte pieces of 2 byte chunks with the given data, which isn't written to
+// storage.
+func addPieceTestTorrent(t *testing.T, cl *Client, data string) *Torrent {
+	const pieceLength = 6
+	info := metainfo.Info{
+		Name:        "piece-test",
+		PieceLength: pieceLength,
+		Length:      int64(len(data)),
+	}
+	for off := 0; off < len(data); off += pieceLength {
+		h := sha1.Sum([]byte(data[off : off+pieceLength]))
+		info.Pieces = append(info.Pieces, h[:]...)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	require.NoError(t, err)
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoBytes: infoBytes,
+		InfoHash:  metainfo.HashBytes(infoBytes),
+		ChunkSize: 2,
+	})
+	require.NoError(t, err)
+	return tt
+}
+
+// Writes the chunk at begin in the piece to storage, and marks it received.
+func writeTestChunk(t *testing.T, tt *Torrent, piece int, begin int64, data string) {
+	require.NoError(t, tt.writeChunk(piece, begin, []byte(data)))
+	tt.cl.lock()
+	tt.piece(piece).unpendChunkIndex(int(begin / 2))
+	tt.cl.unlock()
+}
+
+func TestPieceReadUnverified(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := addPieceTestTorrent(t, cl, "abcdefghijkl")
+	p := tt.Piece(0)
+	writeTestChunk(t, tt, 0, 0, "ab")
+	writeTestChunk(t, tt, 0, 4, "ef")
+	b := make([]byte, 6)
+	// Reads stop at the first chunk that hasn't been written.
+	n, err := p.ReadUnverified(b, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ab", string(b[:n]))
+	n, err = p.ReadUnverified(b, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(b[:n]))
+	_, err = p.ReadUnverified(b, 2)
+	assert.Equal(t, ErrUnverifiedDataUnavailable, err)
+	n, err = p.ReadUnverified(b, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "ef", string(b[:n]))
+	_, err = p.ReadUnverified(b, 6)
+	assert.Equal(t, io.EOF, err)
+	// A complete piece reads like verified data.
+	p = tt.Piece(1)
+	writeTestChunk(t, tt, 1, 0, "gh")
+	writeTestChunk(t, tt, 1, 2, "ij")
+	writeTestChunk(t, tt, 1, 4, "kl")
+	p.VerifyData()
+	tt.cl.lock()
+	require.True(t, tt.pieceComplete(1))
+	tt.cl.unlock()
+	n, err = p.ReadUnverified(b, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "hijkl", string(b[:n]))
+	_, err = p.ReadUnverified(b, 6)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPieceOnHashed(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := addPieceTestTorrent(t, cl, "abcdefghijkl")
+	p := tt.Piece(0)
+	// Wait out the check queued when the torrent was added.
+	p.VerifyData()
+	results := make(chan PieceHashResult, 10)
+	unregister := p.OnHashed(func(res PieceHashResult) {
+		results <- res
+	})
+	// Registered after the handler above, so it runs after it for each hash.
+	others := make(chan PieceHashResult, 10)
+	p.OnHashed(func(res PieceHashResult) {
+		others <- res
+	})
+	next := func(c chan PieceHashResult) PieceHashResult {
+		select {
+		case res := <-c:
+			return res
+		case <-time.After(10 * time.Second):
+			t.Fatal("handler not called")
+			panic("unreachable")
+		}
+	}
+	verify := func() PieceHashResult {
+		p.VerifyData()
+		next(others)
+		return next(results)
+	}
+	writeTestChunk(t, tt, 0, 0, "ab")
+	writeTestChunk(t, tt, 0, 2, "xx")
+	writeTestChunk(t, tt, 0, 4, "ef")
+	res := verify()
+	assert.Equal(t, 0, res.Index)
+	assert.False(t, res.Passed)
+	assert.NoError(t, res.Err)
+	writeTestChunk(t, tt, 0, 2, "cd")
+	res = verify()
+	assert.True(t, res.Passed)
+	assert.EqualValues(t, sha1.Sum([]byte("abcdef")), res.Hash)
+	unregister()
+	p.VerifyData()
+	next(others)
+	select {
+	case <-results:
+		t.Fatal("unregistered handler called")
+	default:
+	}
+}
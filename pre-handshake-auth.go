@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Tokens sent before the BitTorrent handshake when ClientConfig.PreHandshakeAuthKey is set. A token
+// is a big-endian Unix timestamp in seconds, a random nonce, and an HMAC-SHA256 of them with the
+// key.
+const (
+	preHandshakeAuthNonceLen = 16
+	preHandshakeAuthTokenLen = 8 + preHandshakeAuthNonceLen + sha256.Size
+	// Tokens are accepted this far either side of the local time. Nonces are remembered for as
+	// long, so tokens can't be replayed.
+	preHandshakeAuthMaxSkew = 2 * time.Minute
+)
+
+func preHandshakeAuthToken(key []byte, now time.Time) []byte {
+	b := make([]byte, preHandshakeAuthTokenLen)
+	binary.BigEndian.PutUint64(b, uint64(now.Unix()))
+	if _, err := rand.Read(b[8 : 8+preHandshakeAuthNonceLen]); err != nil {
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b[:8+preHandshakeAuthNonceLen])
+	mac.Sum(b[:8+preHandshakeAuthNonceLen])
+	return b
+}
+
+// Remembers nonces of accepted tokens until they expire.
+type preHandshakeAuthNonces struct {
+	mu     sync.Mutex
+	expiry map[[preHandshakeAuthNonceLen]byte]time.Time
+}
+
+func (me *preHandshakeAuthNonces) check(key, token []byte, now time.Time) error {
+	if len(token) != preHandshakeAuthTokenLen {
+		return errors.New("bad token length")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(token[:8+preHandshakeAuthNonceLen])
+	if !hmac.Equal(mac.Sum(nil), token[8+preHandshakeAuthNonceLen:]) {
+		return errors.New("bad token mac")
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(token)), 0)
+	if issued.Before(now.Add(-preHandshakeAuthMaxSkew)) || issued.After(now.Add(preHandshakeAuthMaxSkew)) {
+		return errors.New("token expired")
+	}
+	var nonce [preHandshakeAuthNonceLen]byte
+	copy(nonce[:], token[8:])
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for n, e := range me.expiry {
+		if now.After(e) {
+			delete(me.expiry, n)
+		}
+	}
+	if _, ok := me.expiry[nonce]; ok {
+		return errors.New("token replayed")
+	}
+	if me.expiry == nil {
+		me.expiry = make(map[[preHandshakeAuthNonceLen]byte]time.Time)
+	}
+	me.expiry[nonce] = issued.Add(preHandshakeAuthMaxSkew)
+	return nil
+}
+
+// Reads and checks the token at the start of an accepted connection, if required.
+func (cl *Client) receivePreHandshakeAuth(nc net.Conn) error {
+	key := cl.config.PreHandshakeAuthKey
+	if key == nil {
+		return nil
+	}
+	err := nc.SetReadDeadline(time.Now().Add(cl.config.HandshakesTimeout))
+	if err != nil {
+		return err
+	}
+	token := make([]byte, preHandshakeAuthTokenLen)
+	if _, err := io.ReadFull(nc, token); err != nil {
+		return err
+	}
+	return cl.preHandshakeAuthNonces.check(key, token, time.Now())
+}
+
+// Sends a token at the start of an outgoing connection, if required.
+func (cl *Client) sendPreHandshakeAuth(nc net.Conn) error {
+	key := cl.config.PreHandshakeAuthKey
+	if key == nil {
+		return nil
+	}
+	_, err := nc.Write(preHandshakeAuthToken(key, time.Now()))
+	return err
+}
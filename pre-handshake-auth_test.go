@@ -0,0 +1,48 @@
+package torrent
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreHandshakeAuthToken(t *testing.T) {
+	key := []byte("secret")
+	now := time.Now()
+	var nonces preHandshakeAuthNonces
+	token := preHandshakeAuthToken(key, now)
+	assert.NoError(t, nonces.check(key, token, now))
+	assert.Error(t, nonces.check(key, token, now), "replayed")
+	assert.Error(t, nonces.check([]byte("other"), preHandshakeAuthToken(key, now), now))
+	assert.Error(t, nonces.check(key, preHandshakeAuthToken(key, now.Add(-time.Hour)), now))
+	tampered := preHandshakeAuthToken(key, now)
+	tampered[8] ^= 1
+	assert.Error(t, nonces.check(key, tampered, now))
+	assert.Error(t, nonces.check(key, token[:10], now))
+}
+
+func TestPreHandshakeAuthRejectsUnauthorized(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.PreHandshakeAuthKey = []byte("secret")
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	nc, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cl.LocalPort()))
+	require.NoError(t, err)
+	defer nc.Close()
+	// A BitTorrent handshake, which is long enough to be taken as a token, is closed without a
+	// response.
+	_, err = nc.Write(append(append([]byte{19}, "BitTorrent protocol"...), make([]byte, 48)...))
+	require.NoError(t, err)
+	nc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := nc.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	require.Error(t, err)
+	if ne, ok := err.(net.Error); ok {
+		assert.False(t, ne.Timeout())
+	}
+}
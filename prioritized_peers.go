@@ -11,7 +11,9 @@ import (
 // change if our apparent IP changes, we don't currently handle that.
 type prioritizedPeersItem struct {
 	prio peerPriority
-	p    PeerInfo
+	// Preferred by ISP-friendly selection.
+	affine bool
+	p      PeerInfo
 }
 
 var hashSeed = maphash.MakeSeed()
@@ -26,7 +28,8 @@ func (me prioritizedPeersItem) addrHash() int64 {
 func (me prioritizedPeersItem) Less(than btree.Item) bool {
 	other := than.(prioritizedPeersItem)
 	return multiless.New().Bool(
-		me.p.Trusted, other.p.Trusted).Uint32(
+		me.p.Trusted, other.p.Trusted).Bool(
+		me.affine, other.affine).Uint32(
 		me.prio, other.prio).Int64(
 		me.addrHash(), other.addrHash(),
 	).Less()
@@ -35,6 +38,16 @@ func (me prioritizedPeersItem) Less(than btree.Item) bool {
 type prioritizedPeers struct {
 	om      *btree.BTree
 	getPrio func(PeerInfo) peerPriority
+	// Optional.
+	isAffine func(PeerInfo) bool
+}
+
+func (me *prioritizedPeers) item(p PeerInfo) prioritizedPeersItem {
+	ret := prioritizedPeersItem{prio: me.getPrio(p), p: p}
+	if me.isAffine != nil {
+		ret.affine = me.isAffine(p)
+	}
+	return ret
 }
 
 func (me *prioritizedPeers) Each(f func(PeerInfo)) {
@@ -50,12 +63,12 @@ func (me *prioritizedPeers) Len() int {
 
 // Returns true if a peer is replaced.
 func (me *prioritizedPeers) Add(p PeerInfo) bool {
-	return me.om.ReplaceOrInsert(prioritizedPeersItem{me.getPrio(p), p}) != nil
+	return me.om.ReplaceOrInsert(me.item(p)) != nil
 }
 
 // Returns true if a peer is replaced.
 func (me *prioritizedPeers) AddReturningReplacedPeer(p PeerInfo) (ret PeerInfo, ok bool) {
-	item := me.om.ReplaceOrInsert(prioritizedPeersItem{me.getPrio(p), p})
+	item := me.om.ReplaceOrInsert(me.item(p))
 	if item == nil {
 		return
 	}
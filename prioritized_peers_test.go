@@ -53,3 +53,25 @@ func TestPrioritizedPeers(t *testing.T) {
 	min(nil)
 	pop(nil)
 }
+
+func TestPrioritizedPeersAffinity(t *testing.T) {
+	_, preferred, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := prioritizedPeers{
+		om: btree.New(3),
+		getPrio: func(p PeerInfo) peerPriority {
+			return bep40PriorityIgnoreError(p.addr(), IpPort{IP: net.ParseIP("0.0.0.0")})
+		},
+		isAffine: func(p PeerInfo) bool {
+			return preferred.Contains(addrIpOrNil(p.Addr))
+		},
+	}
+	affine := PeerInfo{Addr: ipPortAddr{IP: net.ParseIP("10.1.2.3")}}
+	for _, ip := range []string{"1.2.3.4", "5.6.7.8", "200.1.2.3"} {
+		pp.Add(PeerInfo{Addr: ipPortAddr{IP: net.ParseIP(ip)}})
+	}
+	pp.Add(affine)
+	assert.Equal(t, affine, pp.PopMax())
+}
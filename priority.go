@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// A torrent's precedence over the others in its Client. See Torrent.SetPriority.
+type TorrentPriority int32
+
+const (
+	TorrentPriorityLow    TorrentPriority = -1
+	TorrentPriorityNormal TorrentPriority = 0
+	TorrentPriorityHigh   TorrentPriority = 1
+)
+
+// Each level a torrent is below the highest priority downloading torrent doubles what it pays from
+// the rate limiters, up to this many levels.
+const maxPriorityDeficit = 4
+
+// How long a torrent counts as downloading after it last received useful data.
+const priorityIdleTimeout = 10 * time.Second
+
+// Sets the torrent's priority. While a torrent of higher priority in the Client is downloading, that
+// is, it's incomplete and received piece data in the last 10 seconds, this torrent pays more from
+// the shared upload and download rate limiters for the same data. Half-open connection slots freed
+// in the Client are offered to torrents in priority order, requests to peers shared with other
+// torrents are weighted by priority if ClientConfig.PeerRequestFairness is set, and if activated
+// from dormancy, the torrent is returned to it before torrents of higher priority. The default is
+// TorrentPriorityNormal.
+func (t *Torrent) SetPriority(p TorrentPriority) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.setPriority(p)
+}
+
+func (t *Torrent) setPriority(p TorrentPriority) {
+	atomic.StoreInt32(&t.priority, int32(p))
+	t.cl.updateTopPriority()
+	if t.cl.config.PeerRequestFairness {
+		for _, c := range t.cl.peerIDConns(t) {
+			c.updateRequests()
+		}
+	}
+}
+
+func (t *Torrent) Priority() TorrentPriority {
+	return TorrentPriority(atomic.LoadInt32(&t.priority))
+}
+
+func (t *Torrent) incomplete() bool {
+	return !t.haveInfo() || !t.haveAllPieces()
+}
+
+// Whether the torrent should hold the other torrents to its priority.
+func (t *Torrent) downloadingForPriority(now time.Time) bool {
+	return t.incomplete() && now.Sub(t.lastUsefulData) < priorityIdleTimeout
+}
+
+// Called with the Client lock held when the torrent receives useful piece data.
+func (t *Torrent) onUsefulData() {
+	t.lastUsefulData = time.Now()
+	top := t.cl.getTopPriority()
+	if t.Priority() > top {
+		t.cl.updateTopPriority()
+	} else if t.Priority() == top {
+		t.cl.resetTopPriorityTimer()
+	}
+}
+
+// Recomputes the highest priority of the downloading torrents, which other torrents yield to. Idle
+// and complete torrents don't count, so torrents below them aren't held back while nothing at the
+// higher priority is being transferred.
+func (cl *Client) updateTopPriority() {
+	now := time.Now()
+	top := TorrentPriorityLow
+	downloading := false
+	for _, t := range cl.torrents {
+		if p := t.Priority(); (p > top || !downloading) && t.downloadingForPriority(now) {
+			top = p
+			downloading = true
+		}
+	}
+	atomic.StoreInt32(&cl.topPriority, int32(top))
+	if downloading && !cl.closed.IsSet() {
+		cl.resetTopPriorityTimer()
+	}
+}
+
+// Arranges for the top priority to be recomputed once the torrents at it have been idle for
+// priorityIdleTimeout.
+func (cl *Client) resetTopPriorityTimer() {
+	if cl.topPriorityTimer == nil {
+		cl.topPriorityTimer = time.AfterFunc(priorityIdleTimeout, func() {
+			cl.lock()
+			defer cl.unlock()
+			cl.updateTopPriority()
+		})
+		return
+	}
+	cl.topPriorityTimer.Reset(priorityIdleTimeout)
+}
+
+func (cl *Client) getTopPriority() TorrentPriority {
+	return TorrentPriority(atomic.LoadInt32(&cl.topPriority))
+}
+
+// The number of priority levels the torrent is below the highest priority downloading torrent, up
+// to maxPriorityDeficit. This is safe to call without the Client lock.
+func (t *Torrent) priorityDeficit() uint {
+	d := atomic.LoadInt32(&t.cl.topPriority) - atomic.LoadInt32(&t.priority)
+	if d <= 0 {
+		return 0
+	}
+	if d > maxPriorityDeficit {
+		return maxPriorityDeficit
+	}
+	return uint(d)
+}
+
+// The multiple of the data transferred that's taken from rate limiters for the torrent.
+func (t *Torrent) rateLimitCost() int {
+	return 1 << t.priorityDeficit()
+}
+
+// The rate limiter cost for data read from the connection. It's 1 until the torrent is known.
+func (c *PeerConn) rateLimitCost() int {
+	if c.t == nil {
+		return 1
+	}
+	return c.t.rateLimitCost()
+}
+
+// The Client's torrents, highest priority first, for handing out shared resources such as
+// half-open connection slots.
+func (cl *Client) torrentsByPriority() []*Torrent {
+	ret := make([]*Torrent, 0, len(cl.torrents))
+	for _, t := range cl.torrents {
+		ret = append(ret, t)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Priority() > ret[j].Priority()
+	})
+	return ret
+}
+
+func (cl *Client) torrentPriority(ih InfoHash) TorrentPriority {
+	if t, ok := cl.torrents[ih]; ok {
+		return t.Priority()
+	}
+	return TorrentPriorityNormal
+}
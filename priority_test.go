@@ -0,0 +1,57 @@
+package torrent
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestTorrentPriority(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	a, _ := cl.AddTorrentInfoHash([20]byte{1})
+	b, _ := cl.AddTorrentInfoHash([20]byte{2})
+	assert.Equal(t, TorrentPriorityNormal, b.Priority())
+	assert.Equal(t, 1, b.rateLimitCost())
+	a.SetPriority(TorrentPriorityHigh)
+	// a isn't receiving anything, so b isn't held back.
+	assert.Equal(t, 1, b.rateLimitCost())
+	cl.lock()
+	a.onUsefulData()
+	cl.unlock()
+	assert.Equal(t, 1, a.rateLimitCost())
+	assert.Equal(t, 2, b.rateLimitCost())
+	b.SetPriority(TorrentPriorityLow)
+	assert.Equal(t, 4, b.rateLimitCost())
+	assert.Equal(t, []*Torrent{a, b}, cl.torrentsByPriority())
+	// a goes idle.
+	cl.lock()
+	a.lastUsefulData = time.Now().Add(-priorityIdleTimeout)
+	cl.updateTopPriority()
+	cl.unlock()
+	assert.Equal(t, 1, b.rateLimitCost())
+	cl.lock()
+	a.onUsefulData()
+	cl.unlock()
+	assert.Equal(t, 4, b.rateLimitCost())
+	a.Drop()
+	// b is now the highest priority torrent.
+	assert.Equal(t, 1, b.rateLimitCost())
+}
+
+func TestRateLimitedReaderCost(t *testing.T) {
+	r := rateLimitedReader{
+		l:    rate.NewLimiter(1e9, 10),
+		r:    bytes.NewReader(make([]byte, 100)),
+		cost: func() int { return 2 },
+	}
+	n, err := r.Read(make([]byte, 100))
+	require.NoError(t, err)
+	// Reads are limited so their cost fits in the burst.
+	assert.Equal(t, 5, n)
+}
@@ -12,6 +12,8 @@ import (
 type rateLimitedReader struct {
 	l *rate.Limiter
 	r io.Reader
+	// If non-nil, returns the multiple of the bytes read to take from the limiter.
+	cost func() int
 
 	// This is the time of the last Read's reservation.
 	lastRead time.Time
@@ -36,13 +38,21 @@ func (me *rateLimitedReader) Read(b []byte) (n int, err error) {
 			panic(fmt.Sprintf("burst exceeded?: %d", n-1))
 		}
 	} else {
+		cost := 1
+		if me.cost != nil && me.l.Limit() != rate.Inf {
+			cost = me.cost()
+		}
 		// Limit the read to within the burst.
-		if me.l.Limit() != rate.Inf && len(b) > me.l.Burst() {
-			b = b[:me.l.Burst()]
+		if me.l.Limit() != rate.Inf && len(b)*cost > me.l.Burst() {
+			if me.l.Burst() < cost {
+				// The cost can't be taken in one reservation.
+				cost = 1
+			}
+			b = b[:me.l.Burst()/cost]
 		}
 		n, err = me.r.Read(b)
 		now := time.Now()
-		r := me.l.ReserveN(now, n)
+		r := me.l.ReserveN(now, n*cost)
 		if !r.OK() {
 			panic(n)
 		}
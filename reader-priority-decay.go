@@ -0,0 +1,120 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+)
+
+// A piece range a reader has moved away from, which keeps a reduced priority until the deadline.
+type lingeringPieceRange struct {
+	pieceRange
+	until time.Time
+}
+
+// Readers that are idle, such as ones that were abandoned without being closed, don't raise piece
+// priorities until they're used again.
+func (t *Torrent) readerIdle(r *reader) bool {
+	d := t.cl.config.ReaderIdleTimeout
+	return d > 0 && r.waiting == 0 && time.Since(r.lastUsed) >= d
+}
+
+// Records use of the reader, restoring its priorities if it had gone idle.
+func (r *reader) markUsed() {
+	wasIdle := r.t.readerIdle(r)
+	r.lastUsed = time.Now()
+	if wasIdle {
+		r.t.readersChanged()
+	}
+}
+
+// Keeps the pieces a reader moved away from at Normal priority for a while, so seeking away and
+// back again doesn't abandon partially downloaded pieces. This is below the priorities readers
+// give to their current windows, so stale regions don't compete with them. Ranges that overlap or
+// touch the new one are merged into it, so a reader moving steadily forward leaves a single range.
+func (t *Torrent) addLingeringReaderPieces(from pieceRange) {
+	d := t.cl.config.ReaderPriorityLinger
+	if d <= 0 || from.begin >= from.end {
+		return
+	}
+	now := time.Now()
+	kept := t.lingeringReaderPieces[:0]
+	for _, lr := range t.lingeringReaderPieces {
+		if !now.Before(lr.until) {
+			continue
+		}
+		if lr.begin > from.end || lr.end < from.begin {
+			kept = append(kept, lr)
+			continue
+		}
+		if lr.begin < from.begin {
+			from.begin = lr.begin
+		}
+		if lr.end > from.end {
+			from.end = lr.end
+		}
+	}
+	t.lingeringReaderPieces = append(kept, lingeringPieceRange{
+		pieceRange: from,
+		until:      now.Add(d),
+	})
+}
+
+// Drops expired lingering ranges, and returns the pieces in those remaining.
+func (t *Torrent) readerLingerPiecePriorities() (ret bitmap.Bitmap) {
+	now := time.Now()
+	kept := t.lingeringReaderPieces[:0]
+	for _, lr := range t.lingeringReaderPieces {
+		if !now.Before(lr.until) {
+			continue
+		}
+		kept = append(kept, lr)
+		ret.AddRange(bitmap.BitIndex(lr.begin), bitmap.BitIndex(lr.end))
+	}
+	t.lingeringReaderPieces = kept
+	return
+}
+
+// Arranges for reader priorities to be recalculated when the next lingering range expires or
+// reader goes idle.
+func (t *Torrent) scheduleReaderPriorityDecay() {
+	var next time.Time
+	consider := func(at time.Time) {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	for _, lr := range t.lingeringReaderPieces {
+		consider(lr.until)
+	}
+	if d := t.cl.config.ReaderIdleTimeout; d > 0 {
+		for r := range t.readers {
+			// Readers waiting for data don't go idle.
+			if r.waiting == 0 && !t.readerIdle(r) {
+				consider(r.lastUsed.Add(d))
+			}
+		}
+	}
+	if next.IsZero() {
+		if t.readerDecayTimer != nil {
+			t.readerDecayTimer.Stop()
+		}
+		return
+	}
+	delay := time.Until(next)
+	if t.readerDecayTimer == nil {
+		t.readerDecayTimer = time.AfterFunc(delay, t.onReaderPriorityDecay)
+	} else {
+		t.readerDecayTimer.Reset(delay)
+	}
+}
+
+func (t *Torrent) onReaderPriorityDecay() {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if t.closed.IsSet() {
+		return
+	}
+	torrent.Add("reader priority decays", 1)
+	t.readersChanged()
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/anacrolix/log"
 	"github.com/anacrolix/missinggo"
@@ -47,6 +48,10 @@ type reader struct {
 	// We cache this so that changes can be detected, and bubbled up to the Torrent only as
 	// required.
 	pieces pieceRange
+	// The last time the reader was read or moved, and the number of reads waiting for data. See
+	// ClientConfig.ReaderIdleTimeout.
+	lastUsed time.Time
+	waiting  int
 }
 
 var _ io.ReadCloser = (*reader)(nil)
@@ -168,6 +173,13 @@ func (r *reader) ReadContext(ctx context.Context, b []byte) (n int, err error) {
 func (r *reader) waitAvailable(pos, wanted int64, ctxErr *error, wait bool) (avail int64, err error) {
 	r.t.cl.lock()
 	defer r.t.cl.unlock()
+	r.markUsed()
+	r.waiting++
+	defer func() {
+		r.waiting--
+		r.lastUsed = time.Now()
+		r.t.scheduleReaderPriorityDecay()
+	}()
 	for {
 		avail = r.available(pos, wanted)
 		if avail != 0 {
@@ -181,10 +193,14 @@ func (r *reader) waitAvailable(pos, wanted int64, ctxErr *error, wait bool) (ava
 			err = *ctxErr
 			return
 		}
-		if r.t.dataDownloadDisallowed || !r.t.networkingEnabled {
+		if r.t.dataDownloadDisallowed || !r.t.networkingEnabled || r.t.quarantined() {
 			err = errors.New("downloading disabled and data not already available")
 			return
 		}
+		if r.t.cl.lowFreeSpace {
+			err = errLowFreeSpace
+			return
+		}
 		if !wait {
 			return
 		}
@@ -248,6 +264,7 @@ func (r *reader) Close() error {
 }
 
 func (r *reader) posChanged() {
+	r.markUsed()
 	to := r.piecesUncached()
 	from := r.pieces
 	if to == from {
@@ -2,6 +2,7 @@ package torrent
 
 import (
 	"math"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,8 +23,11 @@ type requestStrategyTorrent interface {
 	numReaders() int
 	numPieces() int
 	readerPiecePriorities() (now, readahead bitmap.Bitmap)
+	readerNowPieces() bitmap.Bitmap
 	ignorePieces() bitmap.Bitmap
 	pendingPieces() *prioritybitmap.PriorityBitmap
+	explicitPieceOrder() []pieceIndex
+	pieceAvailability(pieceIndex) int
 }
 
 type requestStrategyConnection interface {
@@ -81,6 +85,75 @@ func RequestStrategyFuzzing() requestStrategyMaker {
 	return newRequestStrategyMaker(requestStrategyFuzzing{})
 }
 
+// Pieces held by fewer than availabilityThreshold peers are requested first, rarest first. Once
+// every wanted piece is at or above the threshold, pieces are requested in priority and then index
+// order, which suits streaming.
+type requestStrategyRarestFirst struct {
+	requestStrategyDefaults
+	availabilityThreshold int
+}
+
+// Requests rare pieces first while any are available from fewer than availabilityThreshold peers,
+// then switches to sequential order. This guards against seeds disappearing before the rare pieces
+// are replicated, without giving up in-order playback.
+func RequestStrategyRarestFirst(availabilityThreshold int) requestStrategyMaker {
+	return newRequestStrategyMaker(requestStrategyRarestFirst{
+		availabilityThreshold: availabilityThreshold,
+	})
+}
+
+func (rs requestStrategyRarestFirst) iterPendingPieces(cn requestStrategyConnection, f func(pieceIndex) bool) bool {
+	t := cn.torrent()
+	skip := bitmap.Flip(cn.peerPieces(), 0, t.numPieces())
+	skip.Union(t.ignorePieces())
+	if !iterExplicitPieceOrder(t, &skip, f) {
+		return false
+	}
+	// Pieces readers are blocked on can't wait for anything else.
+	more := true
+	t.readerNowPieces().Iter(func(_piece interface{}) bool {
+		piece := _piece.(int)
+		if skip.Contains(piece) {
+			return true
+		}
+		skip.Add(piece)
+		more = f(piece)
+		return more
+	})
+	if !more {
+		return false
+	}
+	type rarePiece struct {
+		index        pieceIndex
+		availability int
+	}
+	var rare []rarePiece
+	t.pendingPieces().IterTyped(func(piece int) bool {
+		if skip.Contains(piece) {
+			return true
+		}
+		if avail := t.pieceAvailability(piece); avail < rs.availabilityThreshold {
+			rare = append(rare, rarePiece{piece, avail})
+		}
+		return true
+	})
+	sort.SliceStable(rare, func(i, j int) bool {
+		return rare[i].availability < rare[j].availability
+	})
+	for _, p := range rare {
+		if !f(p.index) {
+			return false
+		}
+		skip.Add(p.index)
+	}
+	return t.pendingPieces().IterTyped(func(piece int) bool {
+		if skip.Contains(piece) {
+			return true
+		}
+		return f(piece)
+	})
+}
+
 func (requestStrategyFastest) shouldRequestWithoutBias(cn requestStrategyConnection) bool {
 	if cn.torrent().numReaders() == 0 {
 		return false
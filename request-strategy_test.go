@@ -0,0 +1,90 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+	"github.com/anacrolix/missinggo/v2/prioritybitmap"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRequestStrategyTorrent struct {
+	requestStrategyTorrent
+	pending      prioritybitmap.PriorityBitmap
+	availability []int
+	now          bitmap.Bitmap
+}
+
+func (me *testRequestStrategyTorrent) numPieces() int {
+	return len(me.availability)
+}
+
+func (me *testRequestStrategyTorrent) readerNowPieces() bitmap.Bitmap {
+	return me.now
+}
+
+func (me *testRequestStrategyTorrent) ignorePieces() bitmap.Bitmap {
+	return bitmap.Bitmap{}
+}
+
+func (me *testRequestStrategyTorrent) explicitPieceOrder() []pieceIndex {
+	return nil
+}
+
+func (me *testRequestStrategyTorrent) pendingPieces() *prioritybitmap.PriorityBitmap {
+	return &me.pending
+}
+
+func (me *testRequestStrategyTorrent) pieceAvailability(piece pieceIndex) int {
+	return me.availability[piece]
+}
+
+type testRequestStrategyConn struct {
+	requestStrategyConnection
+	t *testRequestStrategyTorrent
+}
+
+func (me testRequestStrategyConn) torrent() requestStrategyTorrent {
+	return me.t
+}
+
+func (me testRequestStrategyConn) peerPieces() (ret bitmap.Bitmap) {
+	ret.AddRange(0, me.t.numPieces())
+	return
+}
+
+func TestRequestStrategyRarestFirstOrder(t *testing.T) {
+	tt := &testRequestStrategyTorrent{}
+	// Each piece has its own priority, so the order doesn't depend on how pieces of the same
+	// priority are iterated.
+	for piece, prio := range []piecePriority{
+		PiecePriorityNext,
+		PiecePriorityReadahead,
+		PiecePriorityHigh,
+		PiecePriorityNormal,
+		PiecePriorityNow,
+	} {
+		tt.pending.Set(piece, prio.BitmapPriority())
+	}
+	cn := testRequestStrategyConn{t: tt}
+	rs := requestStrategyRarestFirst{availabilityThreshold: 2}
+	order := func() (ret []pieceIndex) {
+		rs.iterPendingPieces(cn, func(piece pieceIndex) bool {
+			ret = append(ret, piece)
+			return true
+		})
+		return
+	}
+	// Pieces below the threshold come first, rarest first, then the rest by priority.
+	tt.availability = []int{3, 1, 0, 2, 5}
+	assert.Equal(t, []pieceIndex{2, 1, 4, 0, 3}, order())
+	// With every piece at the threshold, it's priority order.
+	tt.availability = []int{2, 2, 2, 2, 2}
+	assert.Equal(t, []pieceIndex{4, 0, 1, 2, 3}, order())
+	// Pieces readers are waiting on come before rare pieces.
+	tt.availability = []int{3, 1, 0, 2, 5}
+	tt.now.Add(3)
+	assert.Equal(t, []pieceIndex{3, 2, 1, 4, 0}, order())
+	// Stopping early is reported.
+	assert.False(t, rs.iterPendingPieces(cn, func(pieceIndex) bool { return false }))
+}
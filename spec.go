@@ -27,6 +27,20 @@ type TorrentSpec struct {
 	// Whether to allow data download or upload
 	DisallowDataUpload   bool
 	DisallowDataDownload bool
+	// Don't announce the torrent to the DHT. See Torrent.AllowDhtAnnounces.
+	DisallowDhtAnnounce bool
+	// Overrides the OS paths of files, by index into the info's files. This requires storage that
+	// supports it (see storage.FileRelocator). Data already at a path is used.
+	FilePaths map[int]string
+	// Assume the data is complete and don't hash it, such as when the torrent was just created
+	// from it. Pieces are only checked if reading them for a peer fails. Incorrect data in storage
+	// will be uploaded to peers.
+	SeedMode bool
+	// Arbitrary tags for the torrent, such as for matching LifecycleRules. They're added to any
+	// existing labels.
+	Labels []string
+	// Sets the torrent's priority if it's not TorrentPriorityNormal. See Torrent.SetPriority.
+	Priority TorrentPriority
 }
 
 func TorrentSpecFromMagnetUri(uri string) (spec *TorrentSpec, err error) {
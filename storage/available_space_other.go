@@ -0,0 +1,9 @@
+// +build !linux,!darwin
+
+package storage
+
+import "errors"
+
+func availableSpace(root string) (int64, error) {
+	return 0, errors.New("not supported on this platform")
+}
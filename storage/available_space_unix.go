@@ -0,0 +1,14 @@
+// +build linux darwin
+
+package storage
+
+import "syscall"
+
+func availableSpace(root string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(root, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
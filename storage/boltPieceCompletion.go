@@ -17,14 +17,20 @@ const (
 )
 
 var (
-	completionBucketKey = []byte("completion")
+	completionBucketKey          = []byte("completion")
+	fileRootsBucketKey           = []byte("file roots")
+	verificationPendingBucketKey = []byte("verification pending")
 )
 
 type boltPieceCompletion struct {
 	db *bbolt.DB
 }
 
-var _ PieceCompletion = (*boltPieceCompletion)(nil)
+var (
+	_ PieceCompletion      = (*boltPieceCompletion)(nil)
+	_ FilePlacements       = (*boltPieceCompletion)(nil)
+	_ PendingVerifications = (*boltPieceCompletion)(nil)
+)
 
 func NewBoltPieceCompletion(dir string) (ret PieceCompletion, err error) {
 	os.MkdirAll(dir, 0770)
@@ -88,6 +94,86 @@ func (me boltPieceCompletion) Set(pk metainfo.PieceKey, b bool) error {
 	})
 }
 
+func (me boltPieceCompletion) GetFileRoot(infoHash metainfo.Hash, fileIndex int) (root string, ok bool, err error) {
+	err = me.db.View(func(tx *bbolt.Tx) error {
+		rb := tx.Bucket(fileRootsBucketKey)
+		if rb == nil {
+			return nil
+		}
+		ih := rb.Bucket(infoHash[:])
+		if ih == nil {
+			return nil
+		}
+		var key [4]byte
+		binary.BigEndian.PutUint32(key[:], uint32(fileIndex))
+		v := ih.Get(key[:])
+		root, ok = string(v), v != nil
+		return nil
+	})
+	return
+}
+
+func (me boltPieceCompletion) SetFileRoot(infoHash metainfo.Hash, fileIndex int, root string) error {
+	return me.db.Update(func(tx *bbolt.Tx) error {
+		rb, err := tx.CreateBucketIfNotExists(fileRootsBucketKey)
+		if err != nil {
+			return err
+		}
+		ih, err := rb.CreateBucketIfNotExists(infoHash[:])
+		if err != nil {
+			return err
+		}
+		var key [4]byte
+		binary.BigEndian.PutUint32(key[:], uint32(fileIndex))
+		return ih.Put(key[:], []byte(root))
+	})
+}
+
+func (me boltPieceCompletion) SetVerificationPending(infoHash metainfo.Hash, pieces []int, pending bool) error {
+	return me.db.Update(func(tx *bbolt.Tx) error {
+		vb, err := tx.CreateBucketIfNotExists(verificationPendingBucketKey)
+		if err != nil {
+			return err
+		}
+		ih, err := vb.CreateBucketIfNotExists(infoHash[:])
+		if err != nil {
+			return err
+		}
+		for _, i := range pieces {
+			var key [4]byte
+			binary.BigEndian.PutUint32(key[:], uint32(i))
+			if pending {
+				err = ih.Put(key[:], nil)
+			} else {
+				err = ih.Delete(key[:])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (me boltPieceCompletion) GetVerificationPending(infoHash metainfo.Hash) (pieces []int, err error) {
+	err = me.db.View(func(tx *bbolt.Tx) error {
+		vb := tx.Bucket(verificationPendingBucketKey)
+		if vb == nil {
+			return nil
+		}
+		ih := vb.Bucket(infoHash[:])
+		if ih == nil {
+			return nil
+		}
+		// Keys are big-endian, so they're iterated in order.
+		return ih.ForEach(func(k, _ []byte) error {
+			pieces = append(pieces, int(binary.BigEndian.Uint32(k)))
+			return nil
+		})
+	})
+	return
+}
+
 func (me *boltPieceCompletion) Close() error {
 	return me.db.Close()
 }
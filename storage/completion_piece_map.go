@@ -9,9 +9,14 @@ import (
 type mapPieceCompletion struct {
 	mu sync.Mutex
 	m  map[metainfo.PieceKey]bool
+	// See PendingVerifications.
+	pending map[metainfo.PieceKey]struct{}
 }
 
-var _ PieceCompletion = (*mapPieceCompletion)(nil)
+var (
+	_ PieceCompletion      = (*mapPieceCompletion)(nil)
+	_ PendingVerifications = (*mapPieceCompletion)(nil)
+)
 
 func NewMapPieceCompletion() PieceCompletion {
 	return &mapPieceCompletion{m: make(map[metainfo.PieceKey]bool)}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/anacrolix/missinggo"
 	"github.com/anacrolix/torrent/common"
@@ -30,6 +31,10 @@ func infoHashPathMaker(baseDir string, info *metainfo.Info, infoHash metainfo.Ha
 	return filepath.Join(baseDir, infoHash.HexString())
 }
 
+func (me *fileClientImpl) AvailableSpace() (int64, error) {
+	return availableSpace(me.baseDir)
+}
+
 // All Torrent data stored in this baseDir
 func NewFile(baseDir string) ClientImplCloser {
 	return NewFileWithCompletion(baseDir, pieceCompletionForDir(baseDir))
@@ -88,10 +93,10 @@ func (fs *fileClientImpl) OpenTorrent(info *metainfo.Info, infoHash metainfo.Has
 		files = append(files, f)
 	}
 	return &fileTorrentImpl{
-		files,
-		segments.NewIndex(common.LengthIterFromUpvertedFiles(upvertedFiles)),
-		infoHash,
-		fs.pc,
+		files:          files,
+		segmentLocater: segments.NewIndex(common.LengthIterFromUpvertedFiles(upvertedFiles)),
+		infoHash:       infoHash,
+		completion:     fs.pc,
 	}, nil
 }
 
@@ -102,12 +107,72 @@ type file struct {
 }
 
 type fileTorrentImpl struct {
+	// Guards file paths, which can change with RelocateFile.
+	filesMu        sync.RWMutex
 	files          []file
 	segmentLocater segments.Index
 	infoHash       metainfo.Hash
 	completion     PieceCompletion
 }
 
+var _ FileRelocator = (*fileTorrentImpl)(nil)
+
+func (fts *fileTorrentImpl) file(index int) file {
+	fts.filesMu.RLock()
+	defer fts.filesMu.RUnlock()
+	return fts.files[index]
+}
+
+func (fts *fileTorrentImpl) RelocateFile(fileIndex int, path string, move bool) error {
+	if fileIndex < 0 || fileIndex >= len(fts.files) {
+		return fmt.Errorf("file index %d out of range", fileIndex)
+	}
+	fts.filesMu.Lock()
+	defer fts.filesMu.Unlock()
+	f := &fts.files[fileIndex]
+	if move && f.path != path {
+		err := moveFile(f.path, path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("moving %q: %w", f.path, err)
+		}
+	}
+	f.path = path
+	if f.length == 0 {
+		return CreateNativeZeroLengthFile(path)
+	}
+	return nil
+}
+
+// Renames a file, falling back to copying for moves across filesystems.
+func moveFile(from, to string) error {
+	err := os.MkdirAll(filepath.Dir(to), 0777)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(from, to)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
 func (fts *fileTorrentImpl) Piece(p metainfo.Piece) PieceImpl {
 	// Create a view onto the file-based torrent storage.
 	_io := fileTorrentImplIO{fts}
@@ -174,7 +239,7 @@ func (fst *fileTorrentImplIO) readFileAt(file file, b []byte, off int64) (n int,
 // Only returns EOF at the end of the torrent. Premature EOF is ErrUnexpectedEOF.
 func (fst fileTorrentImplIO) ReadAt(b []byte, off int64) (n int, err error) {
 	fst.fts.segmentLocater.Locate(segments.Extent{off, int64(len(b))}, func(i int, e segments.Extent) bool {
-		n1, err1 := fst.readFileAt(fst.fts.files[i], b[:e.Length], e.Start)
+		n1, err1 := fst.readFileAt(fst.fts.file(i), b[:e.Length], e.Start)
 		n += n1
 		b = b[n1:]
 		err = err1
@@ -189,7 +254,7 @@ func (fst fileTorrentImplIO) ReadAt(b []byte, off int64) (n int, err error) {
 func (fst fileTorrentImplIO) WriteAt(p []byte, off int64) (n int, err error) {
 	//log.Printf("write at %v: %v bytes", off, len(p))
 	fst.fts.segmentLocater.Locate(segments.Extent{off, int64(len(p))}, func(i int, e segments.Extent) bool {
-		name := fst.fts.files[i].path
+		name := fst.fts.file(i).path
 		os.MkdirAll(filepath.Dir(name), 0777)
 		var f *os.File
 		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0666)
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/anacrolix/torrent/common"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/segments"
+)
+
+// Records which root path each file of a torrent is stored under. PieceCompletion implementations
+// may implement this to persist placements with completion state.
+type FilePlacements interface {
+	GetFileRoot(infoHash metainfo.Hash, fileIndex int) (root string, ok bool, err error)
+	SetFileRoot(infoHash metainfo.Hash, fileIndex int, root string) error
+}
+
+type MultiPathOpts struct {
+	// Explicitly chooses a root for a file. Returning "" falls back to placement by available
+	// space.
+	Place func(info *metainfo.Info, infoHash metainfo.Hash, fileIndex int) string
+	// Returns the free space under a root. Defaults to querying the filesystem where supported.
+	AvailableSpace func(root string) (int64, error)
+}
+
+// File-based storage that spreads the files of torrents across several root paths, such as one per
+// disk. Each file is placed under the root it already exists in, or the one given by
+// MultiPathOpts.Place, or else the root with the most available space. Placements are recorded in
+// the completion if it implements FilePlacements, and otherwise only for the life of the storage.
+type multiPathClientImpl struct {
+	roots []string
+	opts  MultiPathOpts
+	pc    PieceCompletion
+
+	mu         sync.Mutex
+	placements FilePlacements
+	// Bytes assigned to each root that may not be written yet.
+	reserved map[string]int64
+}
+
+func NewMultiPathFile(roots []string, completion PieceCompletion, opts MultiPathOpts) ClientImplCloser {
+	if opts.AvailableSpace == nil {
+		opts.AvailableSpace = availableSpace
+	}
+	placements, ok := completion.(FilePlacements)
+	if !ok {
+		placements = &mapFilePlacements{}
+	}
+	return &multiPathClientImpl{
+		roots:      roots,
+		opts:       opts,
+		pc:         completion,
+		placements: placements,
+		reserved:   make(map[string]int64),
+	}
+}
+
+func (me *multiPathClientImpl) Close() error {
+	return me.pc.Close()
+}
+
+func (me *multiPathClientImpl) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (TorrentImpl, error) {
+	if len(me.roots) == 0 {
+		return nil, errors.New("no root paths")
+	}
+	upvertedFiles := info.UpvertedFiles()
+	files := make([]file, 0, len(upvertedFiles))
+	for i, fileInfo := range upvertedFiles {
+		s, err := ToSafeFilePath(append([]string{info.Name}, fileInfo.Path...)...)
+		if err != nil {
+			return nil, fmt.Errorf("file %v has unsafe path %q: %w", i, fileInfo.Path, err)
+		}
+		root, err := me.fileRoot(info, infoHash, i, s, fileInfo.Length)
+		if err != nil {
+			return nil, fmt.Errorf("placing file %v: %w", i, err)
+		}
+		f := file{
+			path:   filepath.Join(root, s),
+			length: fileInfo.Length,
+		}
+		if f.length == 0 {
+			err = CreateNativeZeroLengthFile(f.path)
+			if err != nil {
+				return nil, fmt.Errorf("creating zero length file: %w", err)
+			}
+		}
+		files = append(files, f)
+	}
+	return &fileTorrentImpl{
+		files:          files,
+		segmentLocater: segments.NewIndex(common.LengthIterFromUpvertedFiles(upvertedFiles)),
+		infoHash:       infoHash,
+		completion:     me.pc,
+	}, nil
+}
+
+// Returns the most space available under any root, less what's been assigned to files there.
+func (me *multiPathClientImpl) AvailableSpace() (avail int64, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	found := false
+	for _, root := range me.roots {
+		space, rootErr := me.opts.AvailableSpace(root)
+		if rootErr != nil {
+			err = rootErr
+			continue
+		}
+		space -= me.reserved[root]
+		if !found || space > avail {
+			avail = space
+			found = true
+		}
+	}
+	if found {
+		err = nil
+	}
+	return
+}
+
+func (me *multiPathClientImpl) isRoot(root string) bool {
+	for _, r := range me.roots {
+		if r == root {
+			return true
+		}
+	}
+	return false
+}
+
+func (me *multiPathClientImpl) fileRoot(info *metainfo.Info, infoHash metainfo.Hash, fileIndex int, path string, length int64) (string, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	root, ok, err := me.placements.GetFileRoot(infoHash, fileIndex)
+	if err != nil {
+		return "", err
+	}
+	if ok && me.isRoot(root) {
+		return root, nil
+	}
+	root = me.chooseRoot(info, infoHash, fileIndex, path, length)
+	err = me.placements.SetFileRoot(infoHash, fileIndex, root)
+	if err != nil {
+		return "", err
+	}
+	me.reserved[root] += length
+	return root, nil
+}
+
+func (me *multiPathClientImpl) chooseRoot(info *metainfo.Info, infoHash metainfo.Hash, fileIndex int, path string, length int64) string {
+	if me.opts.Place != nil {
+		if root := me.opts.Place(info, infoHash, fileIndex); root != "" {
+			return root
+		}
+	}
+	// Data from before placements were recorded, or moved by the user.
+	for _, root := range me.roots {
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			return root
+		}
+	}
+	best := me.roots[0]
+	var bestSpace int64
+	for i, root := range me.roots {
+		space, err := me.opts.AvailableSpace(root)
+		if err != nil {
+			continue
+		}
+		space -= me.reserved[root]
+		if i == 0 || space > bestSpace {
+			best = root
+			bestSpace = space
+		}
+	}
+	return best
+}
+
+type mapFilePlacements struct {
+	mu sync.Mutex
+	m  map[mapFilePlacementKey]string
+}
+
+type mapFilePlacementKey struct {
+	infoHash  metainfo.Hash
+	fileIndex int
+}
+
+func (me *mapFilePlacements) GetFileRoot(infoHash metainfo.Hash, fileIndex int) (root string, ok bool, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	root, ok = me.m[mapFilePlacementKey{infoHash, fileIndex}]
+	return
+}
+
+func (me *mapFilePlacements) SetFileRoot(infoHash metainfo.Hash, fileIndex int, root string) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[mapFilePlacementKey]string)
+	}
+	me.m[mapFilePlacementKey{infoHash, fileIndex}] = root
+	return nil
+}
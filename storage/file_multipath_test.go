@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestMultiPathFilePlacement(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	roots := []string{filepath.Join(td, "a"), filepath.Join(td, "b")}
+	space := map[string]int64{roots[0]: 10, roots[1]: 15}
+	pc := NewMapPieceCompletion()
+	newStorage := func() ClientImplCloser {
+		return NewMultiPathFile(roots, pc, MultiPathOpts{
+			AvailableSpace: func(root string) (int64, error) { return space[root], nil },
+		})
+	}
+	info := &metainfo.Info{
+		Name:        "t",
+		PieceLength: 4,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"x"}, Length: 8},
+			{Path: []string{"y"}, Length: 4},
+		},
+	}
+	s := newStorage()
+	ts, err := s.OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	files := ts.(*fileTorrentImpl).files
+	// The first file goes to the root with the most space, and the second to the other, since the
+	// first file's length is reserved.
+	assert.Equal(t, filepath.Join(roots[1], "t", "x"), files[0].path)
+	assert.Equal(t, filepath.Join(roots[0], "t", "y"), files[1].path)
+	// Existing data is found where it is, regardless of space.
+	require.NoError(t, os.MkdirAll(filepath.Join(roots[0], "t"), 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(roots[0], "t", "x"), nil, 0666))
+	ts, err = newStorage().OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(roots[0], "t", "x"), ts.(*fileTorrentImpl).files[0].path)
+}
@@ -31,7 +31,7 @@ func (fs *filePieceImpl) Completion() Completion {
 	if c.Complete {
 		// If it's allegedly complete, check that its constituent files have the necessary length.
 		for _, fi := range extentCompleteRequiredLengths(fs.p.Info, fs.p.Offset(), fs.p.Length()) {
-			s, err := os.Stat(fs.files[fi.fileIndex].path)
+			s, err := os.Stat(fs.file(fi.fileIndex).path)
 			if err != nil || s.Size() < fi.length {
 				c.Complete = false
 				break
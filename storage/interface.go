@@ -24,6 +24,11 @@ type TorrentImpl interface {
 
 // Interacts with torrent piece data. Optional interfaces to implement include io.WriterTo, such as
 // when a piece supports a more efficient way to write out incomplete chunks
+//
+// ReadAt may be called while WriteAt is writing another range of the piece, or while MarkComplete
+// is running, and must return the data written to the range read. The client doesn't read ranges
+// that are being written. The file and mmap storages write in place, and resource pieces fall back
+// to the completed data if the chunks are merged into it during a read.
 type PieceImpl interface {
 	// These interfaces are not as strict as normally required. They can
 	// assume that the parameters are appropriate for the dimensions of the
@@ -39,6 +44,50 @@ type PieceImpl interface {
 	Completion() Completion
 }
 
+// Optionally implemented by PieceImpl to store the piece's data and mark it complete in one step.
+// The data must already be verified against the piece hash. This is for importing pieces from other
+// storage.
+type CompletePieceWriter interface {
+	WriteComplete(b []byte) error
+}
+
+// Optionally implemented by TorrentImpl to allow individual files to be stored at arbitrary paths.
+type FileRelocator interface {
+	// Sets the OS path of the file with the given index in the info. If move is true, existing
+	// data is moved there, otherwise data already at the path is used.
+	RelocateFile(fileIndex int, path string, move bool) error
+}
+
+// Optionally implemented by TorrentImpl to remove the torrent's data from storage. It's called after
+// Close, when the torrent is dropped with Torrent.DropAndDeleteData.
+type DataDeleter interface {
+	DeleteData() error
+}
+
+// Optionally implemented by ClientImpl to check that the storage backend is reachable, such as for
+// health checks.
+type Pinger interface {
+	Ping() error
+}
+
+// Optionally implemented by ClientImpl to copy the stored data to path while it remains in use, such
+// as to move it to another host without stopping seeding.
+type Backuper interface {
+	BackupToPath(path string) error
+}
+
+// Optionally implemented by ClientImpl to report the free space where data is stored, so that
+// downloading can be paused before it runs out.
+type SpaceReporter interface {
+	AvailableSpace() (int64, error)
+}
+
+// Optionally implemented by ClientImpl to report how reads were served, such as to size its caches.
+// See ReadStats.
+type ReadStatsReporter interface {
+	ReadStats() ReadStats
+}
+
 type Completion struct {
 	Complete bool
 	Ok       bool
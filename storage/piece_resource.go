@@ -2,10 +2,13 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"os"
 	"path"
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/anacrolix/missinggo/v2/resource"
 
@@ -13,25 +16,132 @@ import (
 )
 
 type piecePerResource struct {
-	p PieceProvider
+	p    PieceProvider
+	opts ResourcePiecesOpts
+	// Pieces are stored by hash, so torrents with the same pieces share their data.
+	refs *pieceRefs
+}
+
+type ResourcePiecesOpts struct {
+	// Stores each torrent's pieces under its hex infohash, as "<infohash>/completed/<piece hash>"
+	// and so on, rather than sharing pieces with the same hash between torrents. Providers can then
+	// treat a torrent's pieces together by their prefix, such as with sqliteProvider.SetPrefixQuota.
+	// Pieces already stored without the prefix aren't found.
+	PerTorrentPrefix bool
 }
 
 func NewResourcePieces(p PieceProvider) ClientImpl {
+	return NewResourcePiecesOpts(p, ResourcePiecesOpts{})
+}
+
+func NewResourcePiecesOpts(p PieceProvider, opts ResourcePiecesOpts) ClientImpl {
 	return &piecePerResource{
-		p: p,
+		p:    p,
+		opts: opts,
+		refs: &pieceRefs{},
+	}
+}
+
+// Returns the prefix of the names of a torrent's pieces. See ResourcePiecesOpts.PerTorrentPrefix.
+func TorrentPiecesPrefix(infoHash metainfo.Hash) string {
+	return infoHash.HexString() + "/"
+}
+
+// Counts the open torrents with each piece hash, so deleting a torrent's data leaves pieces that
+// others still use.
+type pieceRefs struct {
+	mu sync.Mutex
+	m  map[metainfo.Hash]int
+}
+
+func (me *pieceRefs) add(info *metainfo.Info, delta int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[metainfo.Hash]int)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		h := info.Piece(i).Hash()
+		me.m[h] += delta
+		if me.m[h] <= 0 {
+			delete(me.m, h)
+		}
 	}
 }
 
+func (me *pieceRefs) referenced(h metainfo.Hash) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.m[h] != 0
+}
+
 type piecePerResourceTorrentImpl struct {
 	piecePerResource
+	info     *metainfo.Info
+	infoHash metainfo.Hash
+	// Done when the torrent is closed, to abandon its storage operations. See ContextPieceProvider.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Releases the torrent's piece references once.
+	release *sync.Once
+}
+
+func (s piecePerResourceTorrentImpl) Piece(p metainfo.Piece) PieceImpl {
+	piece := s.piece(p)
+	piece.ctx = s.ctx
+	return piece
+}
+
+func (s piecePerResourceTorrentImpl) piece(p metainfo.Piece) piecePerResourcePiece {
+	piece := s.piecePerResource.Piece(p).(piecePerResourcePiece)
+	if s.opts.PerTorrentPrefix {
+		piece.prefix = TorrentPiecesPrefix(s.infoHash)
+	}
+	return piece
 }
 
-func (piecePerResourceTorrentImpl) Close() error {
+func (s piecePerResourceTorrentImpl) Close() error {
+	s.cancel()
+	s.release.Do(func() { s.refs.add(s.info, -1) })
+	return nil
+}
+
+var _ DataDeleter = piecePerResourceTorrentImpl{}
+
+// Deletes the completed and incomplete data of each piece, except pieces with the same hash in
+// other open torrents, which share their data unless ResourcePiecesOpts.PerTorrentPrefix is set.
+// Torrents opened from another ClientImpl on the same provider aren't known. This follows Close, so
+// the pieces don't use the torrent's context.
+func (s piecePerResourceTorrentImpl) DeleteData() error {
+	var pieces []piecePerResourcePiece
+	for i := 0; i < s.info.NumPieces(); i++ {
+		mp := s.info.Piece(i)
+		if !s.opts.PerTorrentPrefix && s.refs.referenced(mp.Hash()) {
+			continue
+		}
+		pieces = append(pieces, s.piece(mp))
+	}
+	if pd, ok := s.p.(PrefixDeleter); ok {
+		var prefixes []string
+		for _, p := range pieces {
+			prefixes = append(prefixes, p.completedInstancePath(), p.incompleteDirPath()+"/")
+		}
+		_, err := pd.DeletePrefixes(prefixes)
+		return err
+	}
+	for _, p := range pieces {
+		err := p.delete()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (s piecePerResource) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (TorrentImpl, error) {
-	return piecePerResourceTorrentImpl{s}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	s.refs.add(info, 1)
+	return piecePerResourceTorrentImpl{s, info, infoHash, ctx, cancel, &sync.Once{}}, nil
 }
 
 func (s piecePerResource) Piece(p metainfo.Piece) PieceImpl {
@@ -45,13 +155,51 @@ type PieceProvider interface {
 	resource.Provider
 }
 
+// Optionally implemented by a PieceProvider to create instances whose operations are abandoned when
+// ctx is done. A torrent's pieces use a context that's done when its TorrentImpl is closed, so
+// storage calls blocked on a dropped torrent or a closing Client return.
+type ContextPieceProvider interface {
+	NewInstanceContext(ctx context.Context, name string) (resource.Instance, error)
+}
+
 type ConsecutiveChunkWriter interface {
 	WriteConsecutiveChunks(prefix string, _ io.Writer) (int64, error)
 }
 
+// Optionally implemented by a PieceProvider to merge the chunks of a completed piece, the instances
+// with names starting with prefix in offset order, into the named instance, and delete them. It
+// should change nothing if the merged data isn't length bytes. Otherwise MarkComplete reads the
+// chunks, puts the completed instance, and deletes each chunk.
+type ChunkCoalescer interface {
+	CoalesceChunks(ctx context.Context, prefix, name string, length int64) error
+}
+
+// Optionally implemented by a PieceProvider to delete all instances with names starting with any of
+// the prefixes at once. Otherwise instances are deleted one at a time.
+type PrefixDeleter interface {
+	DeletePrefixes(prefixes []string) (deleted int64, err error)
+}
+
+// Optionally implemented by a PieceProvider to read ranges of several instances at once, such as in
+// a single query. The chunks of incomplete pieces are read this way, rather than one at a time.
+type RangeReader interface {
+	ReadRanges(ranges []ReadRange) error
+}
+
 type piecePerResourcePiece struct {
 	mp metainfo.Piece
 	rp resource.Provider
+	// Before the names of the piece's instances. See ResourcePiecesOpts.PerTorrentPrefix.
+	prefix string
+	// The torrent's context, or nil. See ContextPieceProvider.
+	ctx context.Context
+}
+
+func (s piecePerResourcePiece) newInstance(name string) (resource.Instance, error) {
+	if cp, ok := s.rp.(ContextPieceProvider); ok && s.ctx != nil {
+		return cp.NewInstanceContext(s.ctx, name)
+	}
+	return s.rp.NewInstance(name)
 }
 
 var _ io.WriterTo = piecePerResourcePiece{}
@@ -86,6 +234,13 @@ func (s piecePerResourcePiece) Completion() Completion {
 }
 
 func (s piecePerResourcePiece) MarkComplete() error {
+	if cc, ok := s.rp.(ChunkCoalescer); ok {
+		ctx := s.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return cc.CoalesceChunks(ctx, s.incompleteDirPath()+"/", s.completedInstancePath(), s.mp.Length())
+	}
 	incompleteChunks := s.getChunks()
 	err := s.completed().Put(io.NewSectionReader(incompleteChunks, 0, s.mp.Length()))
 	if err == nil {
@@ -96,19 +251,65 @@ func (s piecePerResourcePiece) MarkComplete() error {
 	return err
 }
 
+var _ CompletePieceWriter = piecePerResourcePiece{}
+
+// Puts the data as the completed instance directly, without writing chunks first, and discards any
+// incomplete chunks.
+func (s piecePerResourcePiece) WriteComplete(b []byte) error {
+	err := s.completed().Put(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if pd, ok := s.rp.(PrefixDeleter); ok {
+		_, err = pd.DeletePrefixes([]string{s.incompleteDirPath() + "/"})
+		return err
+	}
+	for _, c := range s.getChunks() {
+		c.instance.Delete()
+	}
+	return nil
+}
+
 func (s piecePerResourcePiece) MarkNotComplete() error {
 	return s.completed().Delete()
 }
 
+func (s piecePerResourcePiece) delete() error {
+	err := s.completed().Delete()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, c := range s.getChunks() {
+		err = c.instance.Delete()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s piecePerResourcePiece) ReadAt(b []byte, off int64) (int, error) {
 	if s.mustIsComplete() {
 		return s.completed().ReadAt(b, off)
 	}
-	return s.getChunks().ReadAt(b, off)
+	var n int
+	var err error
+	if rr, ok := s.rp.(RangeReader); ok {
+		n, err = s.getChunks().readRanges(rr, b, off)
+	} else {
+		n, err = s.getChunks().ReadAt(b, off)
+	}
+	if n < len(b) && s.mustIsComplete() {
+		// The piece was marked complete during the read, and its chunks merged into the completed
+		// instance.
+		n1, err := s.completed().ReadAt(b[n:], off+int64(n))
+		return n + n1, err
+	}
+	return n, err
 }
 
 func (s piecePerResourcePiece) WriteAt(b []byte, off int64) (n int, err error) {
-	i, err := s.rp.NewInstance(path.Join(s.incompleteDirPath(), strconv.FormatInt(off, 10)))
+	i, err := s.newInstance(path.Join(s.incompleteDirPath(), strconv.FormatInt(off, 10)))
 	if err != nil {
 		panic(err)
 	}
@@ -120,6 +321,7 @@ func (s piecePerResourcePiece) WriteAt(b []byte, off int64) (n int, err error) {
 
 type chunk struct {
 	offset   int64
+	name     string
 	instance resource.Instance
 }
 
@@ -146,6 +348,55 @@ func (me chunks) ReadAt(b []byte, off int64) (int, error) {
 	return n, err
 }
 
+// Like ReadAt, but reads the part of each chunk up to the next chunk's offset with a single
+// ReadRanges. Chunks written by the client don't overlap.
+func (me chunks) readRanges(rr RangeReader, b []byte, off int64) (n int, err error) {
+	end := off + int64(len(b))
+	var ranges []ReadRange
+	// Where each range starts in b.
+	var starts []int64
+	for i, c := range me {
+		if c.offset >= end {
+			break
+		}
+		rangeEnd := end
+		if i+1 < len(me) && me[i+1].offset < rangeEnd {
+			rangeEnd = me[i+1].offset
+		}
+		start := off
+		if c.offset > start {
+			start = c.offset
+		}
+		if start >= rangeEnd {
+			continue
+		}
+		ranges = append(ranges, ReadRange{
+			Name: c.name,
+			Off:  start - c.offset,
+			Buf:  b[start-off : rangeEnd-off],
+		})
+		starts = append(starts, start-off)
+	}
+	err = rr.ReadRanges(ranges)
+	if err != nil {
+		return
+	}
+	// Only data contiguous from off counts.
+	for i, r := range ranges {
+		if int64(n) != starts[i] {
+			break
+		}
+		n += r.N
+		if r.N < len(r.Buf) {
+			break
+		}
+	}
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
 func (s piecePerResourcePiece) getChunks() (chunks chunks) {
 	names, err := s.incompleteDir().Readdirnames()
 	if err != nil {
@@ -156,11 +407,11 @@ func (s piecePerResourcePiece) getChunks() (chunks chunks) {
 		if err != nil {
 			panic(err)
 		}
-		i, err := s.rp.NewInstance(path.Join(s.incompleteDirPath(), n))
+		i, err := s.newInstance(path.Join(s.incompleteDirPath(), n))
 		if err != nil {
 			panic(err)
 		}
-		chunks = append(chunks, chunk{offset, i})
+		chunks = append(chunks, chunk{offset, path.Join(s.incompleteDirPath(), n), i})
 	}
 	sort.Slice(chunks, func(i, j int) bool {
 		return chunks[i].offset < chunks[j].offset
@@ -169,11 +420,11 @@ func (s piecePerResourcePiece) getChunks() (chunks chunks) {
 }
 
 func (s piecePerResourcePiece) completedInstancePath() string {
-	return path.Join("completed", s.mp.Hash().HexString())
+	return s.prefix + path.Join("completed", s.mp.Hash().HexString())
 }
 
 func (s piecePerResourcePiece) completed() resource.Instance {
-	i, err := s.rp.NewInstance(s.completedInstancePath())
+	i, err := s.newInstance(s.completedInstancePath())
 	if err != nil {
 		panic(err)
 	}
@@ -181,11 +432,11 @@ func (s piecePerResourcePiece) completed() resource.Instance {
 }
 
 func (s piecePerResourcePiece) incompleteDirPath() string {
-	return path.Join("incompleted", s.mp.Hash().HexString())
+	return s.prefix + path.Join("incompleted", s.mp.Hash().HexString())
 }
 
 func (s piecePerResourcePiece) incompleteDir() resource.DirInstance {
-	i, err := s.rp.NewInstance(s.incompleteDirPath())
+	i, err := s.newInstance(s.incompleteDirPath())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"testing"
+
+	"github.com/anacrolix/missinggo/v2/filecache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Deleting a torrent's data leaves the pieces of other open torrents with the same hash.
+func TestResourcePiecesDeleteDataShared(t *testing.T) {
+	fc, err := filecache.NewCache(t.TempDir())
+	require.NoError(t, err)
+	c := NewResourcePieces(fc.AsResourceProvider())
+	h1 := sha1.Sum([]byte("hello"))
+	h2 := sha1.Sum([]byte("world"))
+	i1 := &metainfo.Info{PieceLength: 5, Length: 10, Pieces: append(h1[:], h2[:]...)}
+	i2 := &metainfo.Info{PieceLength: 5, Length: 5, Pieces: h1[:]}
+	t1, err := c.OpenTorrent(i1, metainfo.Hash{1})
+	require.NoError(t, err)
+	t2, err := c.OpenTorrent(i2, metainfo.Hash{2})
+	require.NoError(t, err)
+	for i, data := range []string{"hello", "world"} {
+		p := t1.Piece(i1.Piece(i))
+		_, err := p.WriteAt([]byte(data), 0)
+		require.NoError(t, err)
+		require.NoError(t, p.MarkComplete())
+	}
+	require.NoError(t, t1.Close())
+	require.NoError(t, t1.(DataDeleter).DeleteData())
+	assert.True(t, t2.Piece(i2.Piece(0)).Completion().Complete)
+	t3, err := c.OpenTorrent(i1, metainfo.Hash{1})
+	require.NoError(t, err)
+	assert.False(t, t3.Piece(i1.Piece(1)).Completion().Complete)
+}
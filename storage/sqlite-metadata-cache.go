@@ -0,0 +1,65 @@
+// +build cgo
+
+package storage
+
+import (
+	"path/filepath"
+	"sync"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Caches torrent info bytes by infohash for torrent.ClientConfig.MetadataCache, in the same database
+// as NewSqlitePieceCompletion.
+type sqliteMetadataCache struct {
+	mu sync.Mutex
+	db *sqlite.Conn
+}
+
+func NewSqliteMetadataCache(dir string) (ret *sqliteMetadataCache, err error) {
+	p := filepath.Join(dir, ".torrent.db")
+	db, err := sqlite.OpenConn(p, 0)
+	if err != nil {
+		return
+	}
+	err = sqlitex.ExecScript(db, `create table if not exists metadata(infohash primary key, info_bytes)`)
+	if err != nil {
+		db.Close()
+		return
+	}
+	ret = &sqliteMetadataCache{db: db}
+	return
+}
+
+func (me *sqliteMetadataCache) GetInfoBytes(ih metainfo.Hash) (b []byte, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err = sqlitex.Exec(
+		me.db, `select info_bytes from metadata where infohash=?`,
+		func(stmt *sqlite.Stmt) error {
+			b = make([]byte, stmt.ColumnLen(0))
+			stmt.ColumnBytes(0, b)
+			return nil
+		},
+		ih.HexString())
+	return
+}
+
+func (me *sqliteMetadataCache) SetInfoBytes(ih metainfo.Hash, b []byte) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return sqlitex.Exec(
+		me.db,
+		`insert or replace into metadata(infohash, info_bytes) values(?, ?)`,
+		nil,
+		ih.HexString(), b)
+}
+
+func (me *sqliteMetadataCache) Close() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.db.Close()
+}
@@ -15,7 +15,10 @@ type sqlitePieceCompletion struct {
 	db *sqlite.Conn
 }
 
-var _ PieceCompletion = (*sqlitePieceCompletion)(nil)
+var (
+	_ PieceCompletion      = (*sqlitePieceCompletion)(nil)
+	_ PendingVerifications = (*sqlitePieceCompletion)(nil)
+)
 
 func NewSqlitePieceCompletion(dir string) (ret *sqlitePieceCompletion, err error) {
 	p := filepath.Join(dir, ".torrent.db")
@@ -23,7 +26,9 @@ func NewSqlitePieceCompletion(dir string) (ret *sqlitePieceCompletion, err error
 	if err != nil {
 		return
 	}
-	err = sqlitex.ExecScript(db, `create table if not exists piece_completion(infohash, "index", complete, unique(infohash, "index"))`)
+	err = sqlitex.ExecScript(db, `
+		create table if not exists piece_completion(infohash, "index", complete, unique(infohash, "index"));
+		create table if not exists verification_pending(infohash, "index", unique(infohash, "index"));`)
 	if err != nil {
 		db.Close()
 		return
@@ -52,6 +57,32 @@ func (me *sqlitePieceCompletion) Set(pk metainfo.PieceKey, b bool) error {
 		pk.InfoHash.HexString(), pk.Index, b)
 }
 
+func (me *sqlitePieceCompletion) SetVerificationPending(infoHash metainfo.Hash, pieces []int, pending bool) (err error) {
+	defer sqlitex.Save(me.db)(&err)
+	query := `insert or ignore into verification_pending(infohash, "index") values(?, ?)`
+	if !pending {
+		query = `delete from verification_pending where infohash=? and "index"=?`
+	}
+	for _, i := range pieces {
+		err = sqlitex.Exec(me.db, query, nil, infoHash.HexString(), i)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (me *sqlitePieceCompletion) GetVerificationPending(infoHash metainfo.Hash) (pieces []int, err error) {
+	err = sqlitex.Exec(
+		me.db, `select "index" from verification_pending where infohash=? order by "index"`,
+		func(stmt *sqlite.Stmt) error {
+			pieces = append(pieces, stmt.ColumnInt(0))
+			return nil
+		},
+		infoHash.HexString())
+	return
+}
+
 func (me *sqlitePieceCompletion) Close() error {
 	return me.db.Close()
 }
@@ -0,0 +1,88 @@
+package sqliteStorage
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// The pieces handled by ImportFromFileStorage.
+type ImportStats struct {
+	// Pieces stored in the database.
+	Imported int
+	// Pieces that were already complete in the database, and weren't read.
+	AlreadyPresent int
+	// Pieces with missing or short files in the data directory.
+	Missing int
+	// Pieces whose data didn't match their hash.
+	Invalid int
+}
+
+// Copies the torrent's pieces from file storage in dataDir, as laid out by storage.NewFile, into the
+// database opened with opts, as stored by NewPiecesStorage. Each piece is read and hashed, and only
+// pieces that match their hash are stored, so the file storage's own completion state isn't
+// trusted. A stored piece is complete in the database, so the piece completion of storage opened
+// on it afterwards is seeded by the import. Pieces already complete in the database are skipped, so
+// an interrupted import can be run again. The data directory isn't modified.
+func ImportFromFileStorage(mi *metainfo.MetaInfo, dataDir string, opts NewPoolOpts) (stats ImportStats, err error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return stats, fmt.Errorf("unmarshalling info: %w", err)
+	}
+	infoHash := mi.HashInfoBytes()
+	prov, err := newProvider(opts)
+	if err != nil {
+		return
+	}
+	defer func() {
+		closeErr := prov.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	// An in-memory completion, so nothing is written to the data directory.
+	src, err := storage.NewFileWithCompletion(dataDir, storage.NewMapPieceCompletion()).OpenTorrent(&info, infoHash)
+	if err != nil {
+		return stats, fmt.Errorf("opening file storage: %w", err)
+	}
+	defer src.Close()
+	dst, err := resourcePieces(prov, opts).OpenTorrent(&info, infoHash)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+	buf := make([]byte, info.PieceLength)
+	for i := 0; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		dp := dst.Piece(p)
+		if dp.Completion().Complete {
+			stats.AlreadyPresent++
+			continue
+		}
+		b := buf[:p.Length()]
+		n, _ := src.Piece(p).ReadAt(b, 0)
+		if n < len(b) {
+			stats.Missing++
+			continue
+		}
+		if metainfo.Hash(sha1.Sum(b)) != p.Hash() {
+			stats.Invalid++
+			continue
+		}
+		if cpw, ok := dp.(storage.CompletePieceWriter); ok {
+			err = cpw.WriteComplete(b)
+		} else {
+			_, err = dp.WriteAt(b, 0)
+			if err == nil {
+				err = dp.MarkComplete()
+			}
+		}
+		if err != nil {
+			return stats, fmt.Errorf("importing piece %v: %w", i, err)
+		}
+		stats.Imported++
+	}
+	return
+}
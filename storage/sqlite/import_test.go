@@ -0,0 +1,58 @@
+package sqliteStorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestImportFromFileStorage(t *testing.T) {
+	dataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dataDir)
+	opts := NewPoolOpts{Path: filepath.Join(t.TempDir(), "sqlite3.db")}
+	stats, err := ImportFromFileStorage(mi, dataDir, opts)
+	require.NoError(t, err)
+	assert.EqualValues(t, ImportStats{Imported: 3}, stats)
+	// Importing again reads nothing.
+	stats, err = ImportFromFileStorage(mi, dataDir, opts)
+	require.NoError(t, err)
+	assert.EqualValues(t, ImportStats{AlreadyPresent: 3}, stats)
+
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	ci, err := NewPiecesStorage(opts)
+	require.NoError(t, err)
+	defer ci.Close()
+	ti, err := ci.OpenTorrent(&info, mi.HashInfoBytes())
+	require.NoError(t, err)
+	defer ti.Close()
+	var got []byte
+	for i := 0; i < info.NumPieces(); i++ {
+		p := ti.Piece(info.Piece(i))
+		assert.True(t, p.Completion().Complete)
+		b := make([]byte, info.Piece(i).Length())
+		_, err := p.ReadAt(b, 0)
+		require.NoError(t, err)
+		got = append(got, b...)
+	}
+	assert.EqualValues(t, testutil.GreetingFileContents, got)
+}
+
+func TestImportFromFileStorageInvalid(t *testing.T) {
+	dataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dataDir)
+	// Corrupt the first piece, and truncate the last.
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dataDir, testutil.GreetingFileName),
+		[]byte("HELLO,\x00worl"),
+		0o644))
+	stats, err := ImportFromFileStorage(mi, dataDir, NewPoolOpts{Path: filepath.Join(t.TempDir(), "sqlite3.db")})
+	require.NoError(t, err)
+	assert.EqualValues(t, ImportStats{Imported: 1, Invalid: 1, Missing: 1}, stats)
+}
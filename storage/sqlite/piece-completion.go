@@ -0,0 +1,48 @@
+package sqliteStorage
+
+import (
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Implemented by Provider and ShardedProvider.
+type PieceCompletionProvider interface {
+	SetPieceCompletion(infoHash string, index int, complete bool) error
+	GetPieceCompletion(infoHash string, index int) (complete, ok bool, err error)
+}
+
+type pieceCompletion struct {
+	prov  PieceCompletionProvider
+	close func() error
+}
+
+var _ storage.PieceCompletion = pieceCompletion{}
+
+// Returns a storage.PieceCompletion kept in the provider's database, for storage that keeps piece
+// data elsewhere, such as storage.NewFileWithCompletion. Closing it leaves the provider open.
+func NewPieceCompletion(prov PieceCompletionProvider) storage.PieceCompletion {
+	return pieceCompletion{prov, func() error { return nil }}
+}
+
+// Opens a provider as NewPiecesStorage does, and returns a storage.PieceCompletion kept in its
+// database. Closing it closes the provider.
+func NewPieceCompletionStorage(opts NewPoolOpts) (storage.PieceCompletion, error) {
+	prov, err := newProvider(opts)
+	if err != nil {
+		return nil, err
+	}
+	return pieceCompletion{prov, prov.Close}, nil
+}
+
+func (me pieceCompletion) Get(pk metainfo.PieceKey) (c storage.Completion, err error) {
+	c.Complete, c.Ok, err = me.prov.GetPieceCompletion(pk.InfoHash.HexString(), pk.Index)
+	return
+}
+
+func (me pieceCompletion) Set(pk metainfo.PieceKey, b bool) error {
+	return me.prov.SetPieceCompletion(pk.InfoHash.HexString(), pk.Index, b)
+}
+
+func (me pieceCompletion) Close() error {
+	return me.close()
+}
@@ -0,0 +1,72 @@
+package sqliteProvider
+
+import (
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Blob access times are used to order eviction. Updating them on every read turns reads into
+// writes, so with ProviderOpts.LastUsedStaleness they're collected here and flushed together
+// through the write batcher instead.
+
+// Returns true if the access was recorded for a later flush, and false if it should be written now.
+func (p *Provider) recordAccess(name string) bool {
+	staleness := p.opts.LastUsedStaleness
+	if staleness <= 0 {
+		return false
+	}
+	p.accessMu.Lock()
+	defer p.accessMu.Unlock()
+	if p.accessed == nil {
+		p.accessed = make(map[string]int)
+	}
+	p.accessed[name]++
+	if !p.accessFlushScheduled {
+		p.accessFlushScheduled = true
+		time.AfterFunc(staleness, func() {
+			err := p.flushAccesses()
+			if err != nil {
+				expvars.Add("lastUsedFlushErrors", 1)
+			}
+		})
+	}
+	return true
+}
+
+// Writes the recorded access times.
+func (p *Provider) flushAccesses() error {
+	p.accessMu.Lock()
+	names := p.accessed
+	p.accessed = nil
+	p.accessFlushScheduled = false
+	p.accessMu.Unlock()
+	if len(names) == 0 {
+		return nil
+	}
+	expvars.Add("lastUsedFlushes", 1)
+	expvars.Add("lastUsedUpdatesFlushed", int64(len(names)))
+	return p.withConn(func(conn conn) error {
+		for name, count := range names {
+			// The blob might have been deleted since. That's fine.
+			err := sqlitex.Exec(conn, `
+				update blob set last_used=datetime('now'), access_count=access_count+?1
+				where name=?2 and (?3 is null or last_used<datetime('now', ?3))`,
+				nil, count, name, p.lastUsedModifier())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, true)
+}
+
+// The datetime modifier for the time before which access times are updated, or nil if they're
+// always updated. See ProviderOpts.LastUsedInterval.
+func (p *Provider) lastUsedModifier() interface{} {
+	if p.opts.LastUsedInterval <= 0 {
+		return nil
+	}
+	return fmt.Sprintf("-%d seconds", int64(p.opts.LastUsedInterval/time.Second))
+}
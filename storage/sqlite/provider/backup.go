@@ -0,0 +1,56 @@
+package sqliteProvider
+
+import (
+	"fmt"
+
+	"crawshaw.io/sqlite"
+)
+
+// Copies the database to the main database of dst using sqlite's online backup API, after flushing
+// queued writes. The copy is a consistent snapshot, and the Provider remains usable throughout.
+func (p *Provider) BackupTo(dst *sqlite.Conn) error {
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	return p.withConn(func(conn conn) error {
+		b, err := conn.BackupInit("main", "main", dst)
+		if err != nil {
+			return err
+		}
+		// Copying everything in one step holds a single read transaction, rather than restarting
+		// each time a write intervenes.
+		_, err = b.Step(-1)
+		finishErr := b.Finish()
+		if err == nil {
+			err = finishErr
+		}
+		return err
+	}, false)
+}
+
+// Backs up the database to a new database file at path, replacing any existing database there. See
+// BackupTo.
+func (p *Provider) BackupToPath(path string) error {
+	dst, err := sqlite.OpenConn(path, 0)
+	if err != nil {
+		return fmt.Errorf("opening %q: %w", path, err)
+	}
+	err = p.BackupTo(dst)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Backs up each shard to path with the shard index appended, matching the layout
+// NewShardedProvider expects. Shards are snapshotted one after another, so a write that spans
+// shards may be partially included.
+func (me *ShardedProvider) BackupToPath(path string) error {
+	for i, s := range me.shards {
+		if err := s.BackupToPath(fmt.Sprintf("%s.%d", path, i)); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
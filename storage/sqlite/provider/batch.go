@@ -0,0 +1,36 @@
+package sqliteProvider
+
+import (
+	"time"
+)
+
+// Without limits, the writer batches whatever writes are queued when it gets to them, so under
+// constant writes a transaction can grow without bound, taking the WAL and the latency of the first
+// write with it. The limits end a transaction early, leaving the rest for the next.
+
+// From ProviderOpts.MaxBatchWrites, MaxBatchBytes and MaxBatchLatency.
+type batchLimits struct {
+	writes  int
+	bytes   int64
+	latency time.Duration
+}
+
+func (opts ProviderOpts) batchLimits() batchLimits {
+	return batchLimits{
+		writes:  opts.MaxBatchWrites,
+		bytes:   opts.MaxBatchBytes,
+		latency: opts.MaxBatchLatency,
+	}
+}
+
+// Whether a transaction begun at started, with the writes and bytes so far, should be committed
+// without taking more writes.
+func (me batchLimits) reached(writes int, bytes int64, started time.Time) bool {
+	reached := me.writes > 0 && writes >= me.writes ||
+		me.bytes > 0 && bytes >= me.bytes ||
+		me.latency > 0 && time.Since(started) >= me.latency
+	if reached {
+		expvars.Add("limitedBatches", 1)
+	}
+	return reached
+}
@@ -0,0 +1,54 @@
+package sqliteProvider
+
+import (
+	"errors"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// Retries operations that fail with SQLITE_BUSY, waiting Initial and doubling each time up to Max,
+// for up to Attempts tries in all. Zero fields take the defaults: 10 attempts, waiting from 10ms up
+// to a second. Retries and exhaustion are counted in expvars.
+type BusyBackoff struct {
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+}
+
+func isBusy(err error) bool {
+	var se sqlite.Error
+	return errors.As(err, &se) && se.Code&0xff == sqlite.SQLITE_BUSY
+}
+
+func (me BusyBackoff) retry(f func() error) (err error) {
+	attempts := me.Attempts
+	if attempts <= 0 {
+		attempts = 10
+	}
+	wait := me.Initial
+	if wait <= 0 {
+		wait = 10 * time.Millisecond
+	}
+	max := me.Max
+	if max <= 0 {
+		max = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if !isBusy(err) {
+			return
+		}
+		expvars.Add("busyErrors", 1)
+		if attempt >= attempts {
+			expvars.Add("busyRetriesExhausted", 1)
+			return
+		}
+		expvars.Add("busyRetries", 1)
+		time.Sleep(wait)
+		wait *= 2
+		if wait > max {
+			wait = max
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package sqliteProvider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Blobs can be stored split into fixed-size rows in blob_chunk, keyed by the blob name and the
+// sequence of the row within it. The blob row then has empty data, and holds only the name and
+// access time. Reads select just the rows they overlap, rather than taking a substr of a whole
+// piece. With ProviderOpts.Compression or EncryptionKey, each row is encoded on its own, and the
+// original size is kept alongside. See encodeChunk.
+
+// Returns the chunk size used by the database, recording the one given if it hasn't got one.
+func initChunkSize(conn conn, want int64) (chunkSize int64, err error) {
+	err = sqlitex.Exec(conn, "select value from setting where name='chunk_size'", func(stmt *sqlite.Stmt) error {
+		chunkSize = stmt.ColumnInt64(0)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if want == 0 || want == chunkSize {
+		return
+	}
+	if chunkSize != 0 {
+		err = fmt.Errorf("database uses chunk size %v", chunkSize)
+		return
+	}
+	if want < 0 {
+		err = fmt.Errorf("invalid chunk size %v", want)
+		return
+	}
+	err = sqlitex.Exec(conn, "insert into setting values ('chunk_size', ?)", nil, want)
+	chunkSize = want
+	return
+}
+
+func (i instance) putChunked(conn conn, b []byte) (err error) {
+	// Replacing the blob row deletes any existing chunks.
+	err = sqlitex.Exec(conn, "insert or replace into blob(name, data) values(?, x'')", nil, i.location)
+	if err != nil {
+		return
+	}
+	chunkSize := i.p.opts.ChunkSize
+	for seq := int64(0); seq*chunkSize < int64(len(b)); seq++ {
+		end := (seq + 1) * chunkSize
+		if end > int64(len(b)) {
+			end = int64(len(b))
+		}
+		data, size, err := i.p.encodeChunk(i.location, seq, b[seq*chunkSize:end])
+		if err != nil {
+			return err
+		}
+		err = sqlitex.Exec(conn,
+			"insert into blob_chunk(name, seq, data, uncompressed_size) values(?, ?, cast(? as blob), ?)",
+			nil,
+			i.location, seq, data, size)
+		if err != nil {
+			return fmt.Errorf("inserting chunk %v: %w", seq, err)
+		}
+	}
+	return
+}
+
+// Returns ok false if no chunks overlap the read, in which case the blob row should be used.
+func (i instance) readChunksAt(conn conn, p []byte, off int64) (n int, ok bool, err error) {
+	if len(p) == 0 {
+		return
+	}
+	chunkSize := i.p.opts.ChunkSize
+	first := off / chunkSize
+	last := (off + int64(len(p)) - 1) / chunkSize
+	err = sqlitex.Exec(conn,
+		"select seq, data, uncompressed_size from blob_chunk where name=? and seq>=? and seq<=? order by seq",
+		func(stmt *sqlite.Stmt) error {
+			ok = true
+			start := stmt.ColumnInt64(0) * chunkSize
+			pos := off + int64(n)
+			if pos < start {
+				return fmt.Errorf("missing chunk before %v", stmt.ColumnInt64(0))
+			}
+			var r io.ReaderAt
+			var size int64
+			if stmt.ColumnType(2) == sqlite.SQLITE_NULL {
+				cr := stmt.ColumnReader(1)
+				r, size = cr, cr.Size()
+			} else {
+				data, err := i.p.decodeChunk(stmt, i.location, stmt.ColumnInt64(0), 1, 2)
+				if err != nil {
+					return fmt.Errorf("decoding chunk %v: %w", stmt.ColumnInt64(0), err)
+				}
+				r, size = bytes.NewReader(data), int64(len(data))
+			}
+			if pos >= start+size {
+				// Past the end of the last chunk.
+				return nil
+			}
+			m, _ := r.ReadAt(p[n:], pos-start)
+			n += m
+			return nil
+		},
+		i.location, first, last)
+	if err == nil && ok && n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (i instance) getChunked() (io.ReadCloser, error) {
+	var size int64
+	err := i.withConn(func(conn conn) (err error) {
+		fi, err := i.stat(conn)
+		size = fi.size
+		if err != nil || i.p.recordAccess(i.location) {
+			return
+		}
+		return sqlitex.Exec(conn, "update blob set last_used=datetime('now'), access_count=access_count+1 where name=?", nil, i.location)
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(io.NewSectionReader(i, 0, size)), nil
+}
+
+func (p *Provider) writeConsecutiveChunksChunked(conn conn, prefix string, w io.Writer) (written int64, err error) {
+	err = sqlitex.Exec(conn, `
+			select data, uncompressed_size, name, seq from (
+				select
+					cast(data as blob) as data,
+					null as uncompressed_size,
+					name,
+					cast(substr(name, ?1+1) as integer) as offset,
+					-1 as seq
+				from blob
+				where name>=?2 and name<?3
+				union all
+				select
+					data,
+					uncompressed_size,
+					name,
+					cast(substr(name, ?1+1) as integer),
+					seq
+				from blob_chunk
+				where name>=?2 and name<?3
+			)
+			order by offset, seq`,
+		func(stmt *sqlite.Stmt) error {
+			if stmt.ColumnType(1) == sqlite.SQLITE_NULL {
+				w1, err := io.Copy(w, stmt.ColumnReader(0))
+				written += w1
+				return err
+			}
+			data, err := p.decodeChunk(stmt, stmt.ColumnText(2), stmt.ColumnInt64(3), 0, 1)
+			if err != nil {
+				return err
+			}
+			w1, err := w.Write(data)
+			written += int64(w1)
+			return err
+		},
+		len(prefix),
+		prefix,
+		prefixEnd(prefix),
+	)
+	return
+}
+
+// Rewrites the chunks overlapping the write, and any between the current end and the write, which
+// are zero-filled.
+func (i instance) writeChunksAt(conn conn, b []byte, off int64) (err error) {
+	err = sqlitex.Exec(conn, "insert or ignore into blob(name, data) values(?, x'')", nil, i.location)
+	if err != nil {
+		return
+	}
+	var size, rowSize int64
+	err = sqlitex.Exec(conn, `
+		select
+			(select coalesce(sum(`+chunkDataSizeExpr+`), 0) from blob_chunk where name=?1),
+			length(cast(data as blob))
+		from blob where name=?1`,
+		func(stmt *sqlite.Stmt) error {
+			size = stmt.ColumnInt64(0)
+			rowSize = stmt.ColumnInt64(1)
+			return nil
+		},
+		i.location)
+	if err != nil {
+		return
+	}
+	if size == 0 && rowSize != 0 {
+		// Stored before the database used chunks.
+		var data []byte
+		err = sqlitex.Exec(conn, "select cast(data as blob) from blob where name=?", func(stmt *sqlite.Stmt) error {
+			data = make([]byte, stmt.ColumnLen(0))
+			stmt.ColumnBytes(0, data)
+			return nil
+		}, i.location)
+		if err != nil {
+			return
+		}
+		err = i.putChunked(conn, data)
+		if err != nil {
+			return
+		}
+		size = rowSize
+	}
+	chunkSize := i.p.opts.ChunkSize
+	end := off + int64(len(b))
+	newSize := size
+	if end > newSize {
+		newSize = end
+	}
+	start := off
+	if size < start {
+		start = size
+	}
+	for seq := start / chunkSize; seq*chunkSize < end; seq++ {
+		chunkStart := seq * chunkSize
+		chunkEnd := chunkStart + chunkSize
+		if chunkEnd > newSize {
+			chunkEnd = newSize
+		}
+		data := make([]byte, chunkEnd-chunkStart)
+		err = sqlitex.Exec(conn, "select data, uncompressed_size from blob_chunk where name=? and seq=?", func(stmt *sqlite.Stmt) error {
+			existing, err := i.p.decodeChunk(stmt, i.location, seq, 0, 1)
+			copy(data, existing)
+			return err
+		}, i.location, seq)
+		if err != nil {
+			return
+		}
+		if off < chunkEnd {
+			var dst, src int64
+			if off > chunkStart {
+				dst = off - chunkStart
+			} else {
+				src = chunkStart - off
+			}
+			copy(data[dst:], b[src:])
+		}
+		stored, size, err := i.p.encodeChunk(i.location, seq, data)
+		if err != nil {
+			return err
+		}
+		err = sqlitex.Exec(conn,
+			"insert or replace into blob_chunk(name, seq, data, uncompressed_size) values(?, ?, cast(? as blob), ?)",
+			nil,
+			i.location, seq, stored, size)
+		if err != nil {
+			return fmt.Errorf("writing chunk %v: %w", seq, err)
+		}
+	}
+	return
+}
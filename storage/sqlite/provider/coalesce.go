@@ -0,0 +1,80 @@
+package sqliteProvider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Pieces are written as a blob per chunk, named by offset under a prefix. Once a piece completes,
+// its chunks are merged into a single blob, so the database doesn't keep a row, and index entries,
+// for every chunk, and reads of the piece are a single query.
+
+// Merges the blobs with names starting with prefix, in offset order, into the named blob, and
+// deletes them, in a single write transaction. Nothing is changed if the merged data isn't length
+// bytes. See storage.ChunkCoalescer.
+func (p *Provider) CoalesceChunks(ctx context.Context, prefix, name string, length int64) (err error) {
+	started := time.Now()
+	defer func() {
+		p.recordOperation("coalesce", time.Since(started), 0, err)
+	}()
+	prefix, name = p.name(prefix), p.name(name)
+	var evictions, chunks int64
+	var evicted evictionCounts
+	err = p.withConnContext(ctx, func(conn conn) (err error) {
+		defer sqlitex.Save(conn)(&err)
+		before, err := p.evictionCounts(conn)
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		_, err = p.writeConsecutiveChunks(conn, prefix, &buf)
+		if err != nil {
+			return
+		}
+		if int64(buf.Len()) != length {
+			return fmt.Errorf("chunks have %v bytes, expected %v", buf.Len(), length)
+		}
+		// Delete the chunks first, so they don't count toward the capacity when the blob is
+		// inserted.
+		err = sqlitex.Exec(conn, "delete from blob where name>=? and name<?", nil, prefix, prefixEnd(prefix))
+		if err != nil {
+			return
+		}
+		chunks = int64(conn.Changes())
+		err = checkCapacity(conn, name, length)
+		if err != nil {
+			return
+		}
+		if p.opts.ChunkSize != 0 {
+			err = instance{location: name, p: p}.putChunked(conn, buf.Bytes())
+		} else {
+			err = sqlitex.Exec(conn,
+				"insert or replace into blob(name, data) values(?, cast(? as blob))",
+				nil,
+				name, buf.Bytes())
+		}
+		if err != nil {
+			return
+		}
+		evicted, err = p.evictionsSince(conn, before)
+		if err != nil {
+			return
+		}
+		return p.readCacheEvictions(conn, &evictions)
+	}, true)
+	if p.readCache != nil {
+		p.readCache.invalidatePrefixes(prefix)
+	}
+	p.invalidateReads(name, evictions, err)
+	if err != nil {
+		return storageError(err)
+	}
+	expvars.Add("coalescedChunks", chunks)
+	p.noteWritten(length)
+	p.observeEvictions(evicted)
+	return p.syncCompletion(ctx)
+}
@@ -0,0 +1,100 @@
+package sqliteProvider
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+
+	"crawshaw.io/sqlite"
+)
+
+// Compresses blob chunks before they're stored. See ProviderOpts.Compression.
+type Compression interface {
+	Compress(b []byte) ([]byte, error)
+	// Returns the original data, given its size.
+	Decompress(b []byte, size int) ([]byte, error)
+}
+
+// DEFLATE, from the standard library, at the given level. Other algorithms, such as zstd or lz4,
+// can be used by implementing Compression.
+type FlateCompression int
+
+var _ Compression = FlateCompression(flate.BestSpeed)
+
+func (level FlateCompression) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, int(level))
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (FlateCompression) Decompress(b []byte, size int) ([]byte, error) {
+	ret := make([]byte, size)
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	_, err := io.ReadFull(r, ret)
+	return ret, err
+}
+
+// The uncompressed size of a blob_chunk row. uncompressed_size is null for chunks stored as is.
+const chunkDataSizeExpr = "coalesce(uncompressed_size, length(data))"
+
+// Returns the data to store for a chunk of the named blob, and the uncompressed size to store with
+// it. The size is nil if the stored data is the chunk as is. Chunks that don't get smaller are
+// stored uncompressed, so compressed chunks are those with less data than their size once
+// decrypted. Encrypted chunks always have the size, as encryption adds to their length.
+func (p *Provider) encodeChunk(name string, seq int64, b []byte) (data []byte, size interface{}, err error) {
+	data = b
+	if p.opts.Compression != nil {
+		var c []byte
+		c, err = p.opts.Compression.Compress(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("compressing chunk: %w", err)
+		}
+		if len(c) < len(b) {
+			expvars.Add("chunksCompressed", 1)
+			expvars.Add("chunkBytesSavedByCompression", int64(len(b)-len(c)))
+			data, size = c, len(b)
+		} else {
+			expvars.Add("chunksStoredUncompressed", 1)
+		}
+	}
+	if p.aead != nil {
+		data, err = p.sealChunk(name, seq, data)
+		size = len(b)
+	}
+	return
+}
+
+// Returns the original data for a chunk row of the named blob, from the data and
+// uncompressed_size columns. See encodeChunk.
+func (p *Provider) decodeChunk(stmt *sqlite.Stmt, name string, seq int64, dataCol, sizeCol int) (data []byte, err error) {
+	data = make([]byte, stmt.ColumnLen(dataCol))
+	stmt.ColumnBytes(dataCol, data)
+	if stmt.ColumnType(sizeCol) == sqlite.SQLITE_NULL {
+		return
+	}
+	if p.aead != nil {
+		data, err = p.openChunk(name, seq, data)
+		if err != nil {
+			return
+		}
+	}
+	size := stmt.ColumnInt(sizeCol)
+	if len(data) == size {
+		return
+	}
+	if p.opts.Compression == nil {
+		return nil, errors.New("chunk is compressed but no compression is configured")
+	}
+	return p.opts.Compression.Decompress(data, size)
+}
@@ -0,0 +1,55 @@
+package sqliteProvider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Makes the writes committed so far survive an OS crash or power loss, whatever the
+// ConnOpts.Synchronous. It commits a small write with synchronous=full on a connection outside the
+// write batcher. In WAL mode that syncs the log, and otherwise the database file, either of which
+// holds every earlier commit.
+func (p *Provider) Sync(ctx context.Context) (err error) {
+	started := time.Now()
+	defer func() {
+		p.recordOperation("sync", time.Since(started), 0, err)
+	}()
+	err = p.withConnContext(ctx, func(conn conn) (err error) {
+		err = sqlitex.ExecTransient(conn, "pragma synchronous=full", nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			restoreErr := sqlitex.ExecTransient(conn, "pragma synchronous="+p.opts.synchronous(), nil)
+			if err == nil && restoreErr != nil {
+				err = fmt.Errorf("restoring synchronous: %w", restoreErr)
+			}
+		}()
+		return p.retryBusy(func() error {
+			return sqlitex.Exec(conn, "insert into setting values ('last_sync', datetime('now'))", nil)
+		})
+	}, false)
+	return storageError(err)
+}
+
+// Syncs after a piece completion if ProviderOpts.DurableCompletion is set.
+func (p *Provider) syncCompletion(ctx context.Context) error {
+	if !p.opts.DurableCompletion {
+		return nil
+	}
+	expvars.Add("completionSyncs", 1)
+	return p.Sync(ctx)
+}
+
+// Syncs each shard in turn.
+func (me *ShardedProvider) Sync(ctx context.Context) error {
+	for i, s := range me.shards {
+		if err := s.Sync(ctx); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package sqliteProvider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Chunks are encrypted with AES-GCM when ProviderOpts.EncryptionKey is set. Each is stored as a
+// random nonce followed by the sealed data, authenticated with the blob name and chunk sequence so
+// that chunks can't be swapped between positions. A MAC of a constant under the key is kept in the
+// setting table to detect the wrong key being given, or none. Blob names and access times aren't
+// encrypted, since queries depend on them.
+
+const encryptionCheckMessage = "sqlite storage encryption check"
+
+func encryptionCheck(key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(encryptionCheckMessage))
+	return h.Sum(nil)
+}
+
+// Returns the AEAD for the key, or nil if there isn't one, after checking the key matches the
+// database. A database is marked as encrypted when it's first given a key, which requires that it
+// doesn't yet hold any data.
+func initEncryption(conn conn, key []byte) (aead cipher.AEAD, err error) {
+	var check []byte
+	err = sqlitex.Exec(conn, "select value from setting where name='encryption_check'", func(stmt *sqlite.Stmt) error {
+		check = make([]byte, stmt.ColumnLen(0))
+		stmt.ColumnBytes(0, check)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if key == nil {
+		if check != nil {
+			err = errors.New("database is encrypted")
+		}
+		return
+	}
+	if check == nil {
+		var hasData bool
+		err = sqlitex.Exec(conn, `
+			select exists(select 1 from blob where length(cast(data as blob))!=0)
+				or exists(select 1 from blob_chunk)`,
+			func(stmt *sqlite.Stmt) error {
+				hasData = stmt.ColumnInt(0) != 0
+				return nil
+			})
+		if err != nil {
+			return
+		}
+		if hasData {
+			err = errors.New("database holds unencrypted data")
+			return
+		}
+		err = sqlitex.Exec(conn, "insert into setting values ('encryption_check', ?)", nil, encryptionCheck(key))
+		if err != nil {
+			return
+		}
+	} else if !hmac.Equal(check, encryptionCheck(key)) {
+		err = errors.New("wrong encryption key")
+		return
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkAdditionalData(name string, seq int64) []byte {
+	ret := make([]byte, 8, 8+len(name))
+	binary.BigEndian.PutUint64(ret, uint64(seq))
+	return append(ret, name...)
+}
+
+func (p *Provider) sealChunk(name string, seq int64, b []byte) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize(), p.aead.NonceSize()+len(b)+p.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return p.aead.Seal(nonce, nonce, b, chunkAdditionalData(name, seq)), nil
+}
+
+func (p *Provider) openChunk(name string, seq int64, b []byte) ([]byte, error) {
+	if len(b) < p.aead.NonceSize() {
+		return nil, errors.New("encrypted chunk too short")
+	}
+	nonce := b[:p.aead.NonceSize()]
+	data, err := p.aead.Open(nil, nonce, b[len(nonce):], chunkAdditionalData(name, seq))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting chunk: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,111 @@
+package sqliteProvider
+
+import (
+	"fmt"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/anacrolix/torrent/storage/sqlite/schema"
+)
+
+// Determines the order blobs are evicted in when the capacity is exceeded. Only the policies here
+// are supported, as each is put into the database's views as SQL.
+type EvictionPolicy string
+
+const (
+	// Least recently used. This is the default.
+	EvictLRU EvictionPolicy = "lru"
+	// Least frequently used, counting accesses since the blob was last written.
+	EvictLFU EvictionPolicy = "lfu"
+	// First in, first out.
+	EvictFIFO EvictionPolicy = "fifo"
+	// Largest first, such as to keep many small blobs over few large ones.
+	EvictLargest EvictionPolicy = "largest"
+)
+
+// SQL expressions over a row of the blob table for each EvictionPolicy. Blobs with the lowest values
+// are evicted first, with ties going to the earliest inserted. The blob_last_used and blob_neg_size
+// indexes are on the keys for EvictLRU and EvictLargest.
+var evictionKeys = map[EvictionPolicy]string{
+	EvictLRU:     "last_used",
+	EvictLFU:     "access_count",
+	EvictFIFO:    "0",
+	EvictLargest: "-size",
+}
+
+// The size of a blob row, including any chunks.
+const blobSizeExpr = schema.BlobSizeExpr
+
+// The setting the EvictionPolicy is stored in, so its views can be recreated after migrations.
+const evictionPolicySetting = "eviction_policy"
+
+// Replaces the deletable_blob and over_quota_blob views, which order blobs for eviction by the
+// triggers on writes.
+func setEvictionPolicy(conn conn, policy EvictionPolicy) (err error) {
+	key, ok := evictionKeys[policy]
+	if !ok {
+		return fmt.Errorf("unknown eviction policy %q", policy)
+	}
+	defer sqlitex.Save(conn)(&err)
+	err = sqlitex.Exec(conn, "insert into setting values (?, ?)", nil, evictionPolicySetting, string(policy))
+	if err != nil {
+		return
+	}
+	err = setOverQuotaView(conn, key)
+	if err != nil {
+		return
+	}
+	err = sqlitex.ExecTransient(conn, "drop view if exists deletable_blob", nil)
+	if err != nil {
+		return
+	}
+	return sqlitex.ExecScript(conn, fmt.Sprintf(`
+create view deletable_blob as
+with recursive excess (
+	usage_with,
+	eviction_key,
+	blob_rowid,
+	data_length
+) as (
+	select * 
+	from (
+		select 
+			(select value from blob_meta where key='size') as usage_with,
+			%[1]s,
+			rowid,
+			size
+		from blob order by 2, rowid limit 1
+	)
+	where usage_with >= (select value from setting where name='capacity')
+	union all
+	select 
+		excess.usage_with-excess.data_length,
+		%[1]s,
+		blob.rowid,
+		blob.size
+	from excess join blob
+	on blob.rowid=(
+		select rowid from blob
+		where %[1]s >= excess.eviction_key and (%[1]s, rowid) > (excess.eviction_key, excess.blob_rowid)
+		order by %[1]s, rowid limit 1
+	)
+	where excess.usage_with-excess.data_length >= (select value from setting where name='capacity')
+)
+select * from excess;
+`, key))
+}
+
+// Recreates the views for the stored EvictionPolicy, such as after migrations replace them with
+// those for EvictLRU.
+func restoreEvictionPolicy(conn conn) (err error) {
+	var policy EvictionPolicy
+	err = sqlitex.Exec(conn, "select value from setting where name=?", func(stmt *sqlite.Stmt) error {
+		policy = EvictionPolicy(stmt.ColumnText(0))
+		return nil
+	}, evictionPolicySetting)
+	if err != nil || policy == "" {
+		return
+	}
+	return setEvictionPolicy(conn, policy)
+}
@@ -0,0 +1,91 @@
+package sqliteProvider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// The most blobs deleted in a single write by DeleteExpired.
+const expiryStepBlobs = 1000
+
+// Deletes blobs that haven't been accessed for maxAge, returning how many were deleted. Recorded
+// accesses are flushed first, so blobs read recently aren't deleted. The deletes are done in steps
+// through the write path, and stop early if ctx is done.
+func (p *Provider) DeleteExpired(ctx context.Context, maxAge time.Duration) (deleted int64, err error) {
+	err = p.flushAccesses()
+	if err != nil {
+		err = fmt.Errorf("flushing last used times: %w", err)
+		return
+	}
+	// Access times have a resolution of seconds.
+	modifier := fmt.Sprintf("-%d seconds", int64(maxAge/time.Second))
+	defer func() {
+		if deleted != 0 && p.readCache != nil {
+			p.readCache.invalidateAll()
+		}
+		expvars.Add("expiredBlobs", deleted)
+	}()
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var n int64
+		err = p.withConnContext(ctx, func(conn conn) error {
+			err := sqlitex.Exec(conn, `
+				delete from blob where rowid in (
+					select rowid from blob where last_used<datetime('now', ?) limit ?)`,
+				nil, modifier, expiryStepBlobs)
+			n = int64(conn.Changes())
+			return err
+		}, true)
+		deleted += n
+		if err != nil || n < expiryStepBlobs {
+			return
+		}
+	}
+}
+
+// Runs DeleteExpired with ProviderOpts.MaxAge every ProviderOpts.ExpiryInterval until stop is
+// closed.
+func (p *Provider) expirer(stop <-chan struct{}) {
+	interval := p.opts.ExpiryInterval
+	if interval <= 0 {
+		interval = p.opts.MaxAge / 10
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		_, err := p.DeleteExpired(ctx, p.opts.MaxAge)
+		if err != nil && ctx.Err() == nil {
+			expvars.Add("expiryErrors", 1)
+		}
+	}
+}
+
+// Deletes expired blobs from each shard in turn.
+func (me *ShardedProvider) DeleteExpired(ctx context.Context, maxAge time.Duration) (deleted int64, err error) {
+	for i, s := range me.shards {
+		var n int64
+		n, err = s.DeleteExpired(ctx, maxAge)
+		deleted += n
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
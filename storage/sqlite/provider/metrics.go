@@ -0,0 +1,92 @@
+package sqliteProvider
+
+import (
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Receives measurements from a Provider as they're made, for exporting to a metrics system such as
+// Prometheus, where the expvars and Stats are awkward to scrape. Methods are called concurrently,
+// and shouldn't block. See ProviderOpts.Metrics.
+type Metrics interface {
+	// Called after each operation, with the Operation Kind, such as "read", "write" or "delete",
+	// and also "coalesce" and "sync".
+	ObserveOperation(kind string, dur time.Duration, err error)
+	// Called after each batch transaction, with the number of writes committed in it.
+	ObserveBatch(writes int)
+	// Called with the blobs and bytes evicted by a write, TrimToSize or EvictPrefix, once it's
+	// committed.
+	ObserveEvictions(blobs, bytes int64)
+	// Called for each SQLITE_BUSY error from a write, including those that are retried.
+	ObserveBusy()
+}
+
+// Blobs and bytes evicted, from the running totals in blob_meta.
+type evictionCounts struct {
+	blobs int64
+	bytes int64
+}
+
+func getEvictionCounts(conn conn) (ret evictionCounts, err error) {
+	err = sqlitex.Exec(conn, `
+		select
+			(select value from blob_meta where key='evictions'),
+			(select value from blob_meta where key='evicted_bytes')`,
+		func(stmt *sqlite.Stmt) error {
+			ret.blobs = stmt.ColumnInt64(0)
+			ret.bytes = stmt.ColumnInt64(1)
+			return nil
+		})
+	return
+}
+
+// Reads the eviction counts at the start of a write, for evictionsSince. They're only read if
+// there's a Metrics to pass evictions on to.
+func (p *Provider) evictionCounts(conn conn) (evictionCounts, error) {
+	if p.opts.Metrics == nil {
+		return evictionCounts{}, nil
+	}
+	return getEvictionCounts(conn)
+}
+
+// Returns what the write evicted in its transaction, since the counts from evictionCounts.
+func (p *Provider) evictionsSince(conn conn, before evictionCounts) (evictionCounts, error) {
+	if p.opts.Metrics == nil {
+		return evictionCounts{}, nil
+	}
+	after, err := getEvictionCounts(conn)
+	return evictionCounts{
+		blobs: after.blobs - before.blobs,
+		bytes: after.bytes - before.bytes,
+	}, err
+}
+
+// Passes evictions from a write on to Metrics. It's only called once the write is committed, so
+// they're never from a transaction that's rolled back.
+func (p *Provider) observeEvictions(evicted evictionCounts) {
+	m := p.opts.Metrics
+	if m == nil || evicted == (evictionCounts{}) {
+		return
+	}
+	m.ObserveEvictions(evicted.blobs, evicted.bytes)
+}
+
+func (p *Provider) recordOperation(kind string, dur time.Duration, bytes int64, err error) {
+	p.stats.recordOperation(kind, dur, bytes, err)
+	if m := p.opts.Metrics; m != nil {
+		m.ObserveOperation(kind, dur, err)
+	}
+}
+
+// Retries f per ProviderOpts.BusyBackoff, reporting each SQLITE_BUSY to Metrics.
+func (p *Provider) retryBusy(f func() error) error {
+	return p.opts.BusyBackoff.retry(func() error {
+		err := f()
+		if m := p.opts.Metrics; m != nil && isBusy(err) {
+			m.ObserveBusy()
+		}
+		return err
+	})
+}
@@ -0,0 +1,72 @@
+package sqliteProvider
+
+import (
+	"fmt"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/anacrolix/torrent/storage/sqlite/schema"
+)
+
+// A change to the schema after the baseline one, schema.Base, which databases without a version
+// have. The version of a database, stored in its user_version, is the number of migrations that
+// have been applied to it.
+type schemaMigration struct {
+	name  string
+	apply func(conn) error
+}
+
+func migrationScript(script string) func(conn) error {
+	return func(conn conn) error {
+		return sqlitex.ExecScript(conn, script)
+	}
+}
+
+// Applied in order to existing databases. See schema.Migrations.
+var schemaMigrations = func() (ret []schemaMigration) {
+	for _, m := range schema.Migrations {
+		ret = append(ret, schemaMigration{name: m.Name, apply: migrationScript(m.Script)})
+	}
+	return
+}()
+
+func schemaVersion(conn conn) (version int, err error) {
+	err = sqlitex.ExecTransient(conn, "pragma user_version", func(stmt *sqlite.Stmt) error {
+		version = stmt.ColumnInt(0)
+		return nil
+	})
+	return
+}
+
+func setSchemaVersion(conn conn, version int) error {
+	// Pragmas don't take parameters.
+	return sqlitex.ExecTransient(conn, fmt.Sprintf("pragma user_version=%d", version), nil)
+}
+
+// Applies the migrations the database hasn't had yet. Each is applied in its own savepoint with the
+// version update, so a failure leaves the database at the last migration that succeeded.
+func migrateSchema(conn conn, migrations []schemaMigration) (err error) {
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return fmt.Errorf("getting schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %v is newer than supported version %v", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		err = func() (err error) {
+			defer sqlitex.Save(conn)(&err)
+			err = m.apply(conn)
+			if err != nil {
+				return
+			}
+			return setSchemaVersion(conn, version+1)
+		}()
+		if err != nil {
+			return fmt.Errorf("applying schema migration %v (%s): %w", version+1, m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package sqliteProvider
+
+import (
+	"errors"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemoryConn(t *testing.T) conn {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestMigrateSchemaStopsAtFailure(t *testing.T) {
+	conn := newMemoryConn(t)
+	migrations := []schemaMigration{
+		{"create a", migrationScript("create table a (x)")},
+		{"fail", func(conn conn) error {
+			err := sqlitex.ExecScript(conn, "create table b (x)")
+			if err != nil {
+				return err
+			}
+			return errors.New("oops")
+		}},
+	}
+	assert.Error(t, migrateSchema(conn, migrations))
+	version, err := schemaVersion(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+	// The failed migration was rolled back.
+	assert.Error(t, sqlitex.Exec(conn, "select * from b", nil))
+	migrations[1].apply = migrationScript("create table b (x)")
+	require.NoError(t, migrateSchema(conn, migrations))
+	version, err = schemaVersion(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	// Databases from a newer version aren't touched.
+	assert.Error(t, migrateSchema(conn, migrations[:1]))
+}
@@ -0,0 +1,188 @@
+package sqliteProvider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Namespaces let several Providers, such as for different torrent.Clients, share a database
+// without seeing each other's blobs. See ProviderOpts.Namespace. Each namespace is registered in
+// the setting table when a Provider opens it, so they can be listed and removed by other
+// Providers.
+
+// Namespaces are registered in the setting table with this before the namespace.
+const namespaceSettingPrefix = "namespace:"
+
+// Returns the prefix of the names of the blobs in the namespace.
+func NamespacePrefix(namespace string) string {
+	return "ns/" + namespace + "/"
+}
+
+func checkNamespace(namespace string) error {
+	if strings.Contains(namespace, "/") {
+		return fmt.Errorf("namespace %q contains a slash", namespace)
+	}
+	return nil
+}
+
+// Registers the namespace and sets its quota, if one is given.
+func initNamespace(conn conn, namespace string, capacity int64) (err error) {
+	err = checkNamespace(namespace)
+	if err != nil {
+		return
+	}
+	defer sqlitex.Save(conn)(&err)
+	err = sqlitex.Exec(conn, "insert into setting values (?, null)", nil, namespaceSettingPrefix+namespace)
+	if err != nil {
+		return
+	}
+	if capacity != 0 {
+		return SetPrefixQuota(conn, NamespacePrefix(namespace), capacity)
+	}
+	return
+}
+
+// Returns the registered namespaces, sorted.
+func Namespaces(conn conn) (namespaces []string, err error) {
+	err = sqlitex.Exec(conn, "select name from setting where name glob 'namespace:*'", func(stmt *sqlite.Stmt) error {
+		namespaces = append(namespaces, strings.TrimPrefix(stmt.ColumnText(0), namespaceSettingPrefix))
+		return nil
+	})
+	sort.Strings(namespaces)
+	return
+}
+
+// Deletes the namespace's blobs, piece completions and quota, and unregisters it, returning how
+// many blobs were deleted. A Provider still using the namespace registers it again when it's next
+// opened.
+func DeleteNamespace(conn conn, namespace string) (deleted int64, err error) {
+	err = checkNamespace(namespace)
+	if err != nil {
+		return
+	}
+	prefix := NamespacePrefix(namespace)
+	defer sqlitex.Save(conn)(&err)
+	err = sqlitex.Exec(conn, "delete from blob where name>=? and name<?", nil, prefix, prefixEnd(prefix))
+	if err != nil {
+		return
+	}
+	deleted = int64(conn.Changes())
+	err = sqlitex.Exec(conn, "delete from piece_completion where infohash>=? and infohash<?", nil, prefix, prefixEnd(prefix))
+	if err != nil {
+		return
+	}
+	err = UnsetPrefixQuota(conn, prefix)
+	if err != nil {
+		return
+	}
+	err = sqlitex.Exec(conn, "delete from setting where name=?", nil, namespaceSettingPrefix+namespace)
+	return
+}
+
+// Returns the size of the blobs in the namespace.
+func NamespaceUsage(conn conn, namespace string) (size int64, err error) {
+	prefix := NamespacePrefix(namespace)
+	err = sqlitex.Exec(conn, "select coalesce(sum("+blobSizeExpr+"), 0) from blob where name>=? and name<?",
+		func(stmt *sqlite.Stmt) error {
+			size = stmt.ColumnInt64(0)
+			return nil
+		},
+		prefix, prefixEnd(prefix))
+	return
+}
+
+// Maps a name given to the Provider to the name stored in the database.
+func (p *Provider) name(s string) string {
+	if p.opts.Namespace == "" {
+		return s
+	}
+	return NamespacePrefix(p.opts.Namespace) + s
+}
+
+func (p *Provider) names(ss []string) (ret []string) {
+	for _, s := range ss {
+		ret = append(ret, p.name(s))
+	}
+	return
+}
+
+// Lists the namespaces registered in the database.
+func (p *Provider) Namespaces() (namespaces []string, err error) {
+	err = p.withConn(func(conn conn) (err error) {
+		namespaces, err = Namespaces(conn)
+		return
+	}, false)
+	return
+}
+
+// Deletes the namespace from the database. See DeleteNamespace.
+func (p *Provider) DeleteNamespace(namespace string) (deleted int64, err error) {
+	err = p.withConn(func(conn conn) (err error) {
+		deleted, err = DeleteNamespace(conn, namespace)
+		return
+	}, true)
+	if p.readCache != nil {
+		p.readCache.invalidatePrefixes(NamespacePrefix(namespace))
+	}
+	return
+}
+
+// Returns the size of the blobs in the namespace, as Usage does for the whole database.
+func (p *Provider) NamespaceUsage(namespace string) (size int64, err error) {
+	err = p.withConn(func(conn conn) (err error) {
+		size, err = NamespaceUsage(conn, namespace)
+		return
+	}, false)
+	return
+}
+
+// Lists the namespaces registered in any shard.
+func (me *ShardedProvider) Namespaces() (namespaces []string, err error) {
+	seen := make(map[string]struct{})
+	for i, s := range me.shards {
+		var shardNamespaces []string
+		shardNamespaces, err = s.Namespaces()
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+		for _, ns := range shardNamespaces {
+			if _, ok := seen[ns]; !ok {
+				seen[ns] = struct{}{}
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+	return
+}
+
+func (me *ShardedProvider) DeleteNamespace(namespace string) (deleted int64, err error) {
+	for i, s := range me.shards {
+		var n int64
+		n, err = s.DeleteNamespace(namespace)
+		deleted += n
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
+
+func (me *ShardedProvider) NamespaceUsage(namespace string) (size int64, err error) {
+	for i, s := range me.shards {
+		var n int64
+		n, err = s.NamespaceUsage(namespace)
+		size += n
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package sqliteProvider
+
+import (
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Piece completion for storage that keeps piece data elsewhere, such as in files, so it needn't be
+// paired with a separate completion database. Storage using NewResourcePieces doesn't need this:
+// it records completion by storing the completed piece blob, in the same transaction as its data.
+
+// Records whether the piece at index of the torrent with the hex infohash is complete. The write is
+// batched and ordered with the Provider's other writes.
+func (p *Provider) SetPieceCompletion(infoHash string, index int, complete bool) error {
+	err := p.withConn(func(conn conn) error {
+		return sqlitex.Exec(conn,
+			`insert or replace into piece_completion(infohash, "index", complete) values (?, ?, ?)`,
+			nil, p.name(infoHash), index, complete)
+	}, true)
+	if err != nil || !complete {
+		return err
+	}
+	return p.syncCompletion(p.ctx)
+}
+
+// Returns the completion set by SetPieceCompletion, and ok false if there isn't one.
+func (p *Provider) GetPieceCompletion(infoHash string, index int) (complete, ok bool, err error) {
+	err = p.withConn(func(conn conn) error {
+		return sqlitex.Exec(conn,
+			`select complete from piece_completion where infohash=? and "index"=?`,
+			func(stmt *sqlite.Stmt) error {
+				complete = stmt.ColumnInt(0) != 0
+				ok = true
+				return nil
+			},
+			p.name(infoHash), index)
+	}, false)
+	return
+}
+
+// Removes the completion recorded for each piece of the torrent.
+func (p *Provider) DeletePieceCompletions(infoHash string) error {
+	return p.withConn(func(conn conn) error {
+		return sqlitex.Exec(conn, "delete from piece_completion where infohash=?", nil, p.name(infoHash))
+	}, true)
+}
+
+// Each torrent's completion is kept in the shard for the infohash.
+
+func (me *ShardedProvider) SetPieceCompletion(infoHash string, index int, complete bool) error {
+	return me.shard(infoHash).SetPieceCompletion(infoHash, index, complete)
+}
+
+func (me *ShardedProvider) GetPieceCompletion(infoHash string, index int) (complete, ok bool, err error) {
+	return me.shard(infoHash).GetPieceCompletion(infoHash, index)
+}
+
+func (me *ShardedProvider) DeletePieceCompletions(infoHash string) error {
+	return me.shard(infoHash).DeletePieceCompletions(infoHash)
+}
@@ -0,0 +1,1334 @@
+// Package sqliteProvider is a capacity-limited blob store in a sqlite database, that implements
+// missinggo's resource.Provider. Blobs are evicted in least recently used order when the capacity
+// is exceeded. It's used for torrent piece storage by the parent package, but only depends on the
+// storage package for its error kinds.
+package sqliteProvider
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/anacrolix/missinggo/iter"
+	"github.com/anacrolix/missinggo/v2/resource"
+
+	"github.com/anacrolix/torrent/storage/sqlite/schema"
+	"github.com/anacrolix/torrent/storage/types"
+)
+
+type conn = *sqlite.Conn
+
+// Tunes sqlite connections, trading durability for throughput. See
+// https://www.sqlite.org/pragma.html. Zero values keep the defaults described.
+type ConnOpts struct {
+	// The synchronous pragma, such as "normal" or "full". Defaults to "off", which risks corruption
+	// if the OS crashes or loses power, but not if the application does.
+	Synchronous string
+	// The journal_mode pragma, such as "wal" or "delete". Defaults to the mode the pool opens the
+	// database with.
+	JournalMode string
+	// The page_size pragma. This only applies to new databases, or after a vacuum.
+	PageSize int
+	// The cache_size pragma, in pages if positive, or KiB if negative.
+	CacheSize int64
+	// The mmap_size pragma. Defaults to 1e12, and negative values disable memory mapping.
+	MmapSize int64
+	// The busy_timeout pragma: how long sqlite waits on locks held by other connections before
+	// returning SQLITE_BUSY. Zero leaves sqlite's default of not waiting.
+	BusyTimeout time.Duration
+	// The journal_size_limit pragma: the size, in bytes, the WAL or rollback journal is truncated
+	// to after checkpoints or transactions, when it's left on disk. Negative values don't limit it.
+	JournalSizeLimit int64
+}
+
+func (me ConnOpts) synchronous() string {
+	if me.Synchronous == "" {
+		return "off"
+	}
+	return me.Synchronous
+}
+
+// Pragma values are interpolated, as pragmas don't take parameters, so only allow keywords.
+func pragmaKeyword(s string) error {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("bad pragma value %q", s)
+		}
+	}
+	return nil
+}
+
+func initConn(conn conn, wal bool, opts ConnOpts) error {
+	// Recursive triggers are required because we need to trim the blob_meta size after trimming to
+	// capacity. Hopefully we don't hit the recursion limit, and if we do, there's an error thrown.
+	pragmas := []string{"recursive_triggers=on"}
+	synchronous := opts.synchronous()
+	if err := pragmaKeyword(synchronous); err != nil {
+		return err
+	}
+	pragmas = append(pragmas, "synchronous="+synchronous)
+	if opts.JournalMode != "" {
+		if err := pragmaKeyword(opts.JournalMode); err != nil {
+			return err
+		}
+		pragmas = append(pragmas, "journal_mode="+opts.JournalMode)
+	} else if !wal {
+		pragmas = append(pragmas, "journal_mode=off")
+	}
+	if opts.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("cache_size=%d", opts.CacheSize))
+	}
+	mmapSize := opts.MmapSize
+	switch {
+	case mmapSize == 0:
+		mmapSize = 1000000000000
+	case mmapSize < 0:
+		mmapSize = 0
+	}
+	pragmas = append(pragmas, fmt.Sprintf("mmap_size=%d", mmapSize))
+	if opts.BusyTimeout != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout=%d", opts.BusyTimeout.Milliseconds()))
+	}
+	if opts.JournalSizeLimit != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("journal_size_limit=%d", opts.JournalSizeLimit))
+	}
+	for _, p := range pragmas {
+		err := sqlitex.ExecTransient(conn, "pragma "+p, nil)
+		if err != nil {
+			return fmt.Errorf("pragma %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func initSchema(conn conn) error {
+	err := sqlitex.ExecScript(conn, schema.Base)
+	if err != nil {
+		return err
+	}
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+	err = migrateSchema(conn, schemaMigrations)
+	if err != nil || version == len(schemaMigrations) {
+		return err
+	}
+	return restoreEvictionPolicy(conn)
+}
+
+type NewPoolOpts struct {
+	Path     string
+	Memory   bool
+	NumConns int
+	// Forces WAL, disables shared caching.
+	ConcurrentBlobReads bool
+	DontInitSchema      bool
+	// If non-zero, overrides the existing setting.
+	Capacity int64
+	// See ProviderOpts.ChunkSize.
+	ChunkSize int64
+	// See ProviderOpts.Compression.
+	Compression Compression
+	// See ProviderOpts.EncryptionKey.
+	EncryptionKey []byte
+	// See ProviderOpts.OnOperation.
+	OnOperation func(Operation)
+	// See ProviderOpts.Metrics.
+	Metrics Metrics
+	// See ProviderOpts.LastUsedStaleness.
+	LastUsedStaleness time.Duration
+	// See ProviderOpts.LastUsedInterval.
+	LastUsedInterval time.Duration
+	// See ProviderOpts.BusyBackoff.
+	BusyBackoff BusyBackoff
+	// See ProviderOpts.MaxBatchWrites.
+	MaxBatchWrites int
+	// See ProviderOpts.MaxBatchBytes.
+	MaxBatchBytes int64
+	// See ProviderOpts.MaxBatchLatency.
+	MaxBatchLatency time.Duration
+	// See ProviderOpts.VacuumInterval.
+	VacuumInterval time.Duration
+	// See ProviderOpts.VacuumPages.
+	VacuumPages int
+	// See ProviderOpts.Namespace.
+	Namespace string
+	// See ProviderOpts.NamespaceCapacity.
+	NamespaceCapacity int64
+	// See ProviderOpts.MaxAge.
+	MaxAge time.Duration
+	// See ProviderOpts.ExpiryInterval.
+	ExpiryInterval time.Duration
+	// See ProviderOpts.WalCheckpointBytes.
+	WalCheckpointBytes int64
+	// See ProviderOpts.ReadCacheSize.
+	ReadCacheSize int64
+	// See ProviderOpts.DurableCompletion.
+	DurableCompletion bool
+	// See ProviderOpts.ScrubInterval.
+	ScrubInterval time.Duration
+	// See ProviderOpts.ScrubVerifier.
+	ScrubVerifier BlobVerifier
+	// See ProviderOpts.ScrubDelete.
+	ScrubDelete bool
+	// See ProviderOpts.ScrubRate.
+	ScrubRate int64
+	// See ProviderOpts.OnCorruptBlob.
+	OnCorruptBlob func(CorruptBlob)
+	// The number of database files to spread blobs across, for NewShardedProvider.
+	Shards int
+	// Stores each torrent's pieces under its infohash, for sqliteStorage.NewPiecesStorage, so that
+	// quotas can apply to a torrent. See storage.ResourcePiecesOpts.PerTorrentPrefix.
+	PerTorrentPrefix bool
+	// If not empty, overrides the existing eviction policy, which is EvictLRU for new databases.
+	// Requires the schema to be initialized.
+	EvictionPolicy EvictionPolicy
+	// See ProviderOpts.ConnOpts. ConnOpts.PageSize is applied before the schema is initialized.
+	ConnOpts
+}
+
+// There's some overlap here with NewPoolOpts, and I haven't decided what needs to be done. For now,
+// the fact that the pool opts are a superset, means our helper NewPiecesStorage can just take the
+// top-level option type.
+type ProviderOpts struct {
+	NumConns int
+	// Concurrent blob reads require WAL.
+	ConcurrentBlobRead bool
+	BatchWrites        bool
+	// If positive, each batch transaction commits after this many writes, leaving any others queued
+	// for the next.
+	MaxBatchWrites int
+	// If positive, each batch transaction commits once the writes in it have put or written this
+	// many bytes of blob data, to keep the WAL small.
+	MaxBatchBytes int64
+	// If positive, each batch transaction commits once it has been open this long, even if writes
+	// are still queued, so the first write in it isn't held up by those after it.
+	MaxBatchLatency time.Duration
+	// If non-zero, blobs are stored split into rows of this many bytes, so that reads only fetch
+	// the rows they need. Once a database has stored blobs this way it keeps doing so, and this
+	// can't be changed for it.
+	ChunkSize int64
+	// If non-nil, chunks are compressed before they're stored, and capacity and eviction count the
+	// compressed sizes. Requires a ChunkSize, as each chunk is compressed on its own. Chunks stored
+	// compressed can't be read if this is later unset.
+	Compression Compression
+	// If non-nil, chunks are encrypted with AES-GCM under this 16, 24 or 32 byte key before they're
+	// stored, so the database file doesn't expose their data. Blob names aren't encrypted. Requires
+	// a ChunkSize. The key must be given from when the database is created, and always after.
+	EncryptionKey []byte
+	// Called after each operation on an instance, such as for collecting metrics. It's called
+	// concurrently.
+	OnOperation func(Operation)
+	// If non-nil, receives operation latencies, batch sizes, evictions and busy errors as they
+	// happen.
+	Metrics Metrics
+	// If non-zero, updates to blob access times, which order eviction, are delayed by up to this
+	// long and written together. This avoids turning each read into a write. Reads with ReadAt
+	// also count as accesses then.
+	LastUsedStaleness time.Duration
+	// If non-zero, a blob's access time is only updated if it's older than this, so blobs read
+	// repeatedly aren't written each time. The check is part of the update. The access count is
+	// only incremented with the access time then.
+	LastUsedInterval time.Duration
+	// How writes are retried when the database is busy, after any ConnOpts.BusyTimeout.
+	BusyBackoff BusyBackoff
+	// If non-zero, Vacuum is run this often in the background, so the database file shrinks after
+	// blobs are deleted or evicted.
+	VacuumInterval time.Duration
+	// The most pages freed by each scheduled Vacuum. All free pages if not positive.
+	VacuumPages int
+	// If not empty, blob names are prefixed with NamespacePrefix(Namespace), and piece completions
+	// are kept apart the same way, so Providers with different namespaces can share a database.
+	// Capacity, eviction and expiry still apply to the whole database. The namespace can't contain
+	// a slash.
+	Namespace string
+	// If non-zero, the namespace's blobs are limited to this size with a prefix quota. See
+	// SetPrefixQuota.
+	NamespaceCapacity int64
+	// If non-zero, blobs not accessed for this long are deleted in the background, whether or not
+	// the capacity is reached. See DeleteExpired. Access times are only as fresh as
+	// LastUsedStaleness and LastUsedInterval allow, so those should be much shorter.
+	MaxAge time.Duration
+	// How often blobs older than MaxAge are looked for. Defaults to a tenth of MaxAge.
+	ExpiryInterval time.Duration
+	// If positive, the WAL is checkpointed and truncated each time this many bytes of blob data
+	// have been written, so it doesn't grow without bound under constant writes. The writer that
+	// crosses the limit runs the checkpoint after its write. See Checkpoint.
+	WalCheckpointBytes int64
+	// If positive, up to this many bytes of ReadAt results are cached in memory, by blob name and
+	// range. Writes through the Provider invalidate them. The cache can't see changes made to the
+	// database by other means.
+	ReadCacheSize int64
+	// If true, piece completions, by CoalesceChunks or SetPieceCompletion, are synced to disk
+	// before they return, so completed data survives an OS crash or power loss even with a
+	// ConnOpts.Synchronous of "off". Chunks of incomplete pieces may still be lost. See Sync.
+	DurableCompletion bool
+	// If non-zero, Scrub is run this often in the background, with ScrubVerifier and ScrubDelete.
+	ScrubInterval time.Duration
+	// Checks the data of the blobs it recognizes when scrubbing. Without one, only sqlite's
+	// quick_check is run.
+	ScrubVerifier BlobVerifier
+	// If true, corrupt blobs found by scheduled scrubs are deleted, so their data isn't read again.
+	ScrubDelete bool
+	// If positive, scrubs read at most this many bytes per second, so they don't compete with other
+	// reads.
+	ScrubRate int64
+	// Called with each corrupt blob found by a scheduled scrub.
+	OnCorruptBlob func(CorruptBlob)
+	// Applied to each connection in the pool.
+	ConnOpts
+}
+
+// Describes a completed operation on an instance, for ProviderOpts.OnOperation.
+type Operation struct {
+	// One of "get", "put", "read", "write", "stat", "delete" or "readdir".
+	Kind string
+	// The instance location.
+	Name string
+	// Bytes transferred, for "put", "read" and "write".
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// Remove any capacity limits.
+func UnlimitCapacity(conn conn) error {
+	return sqlitex.Exec(conn, "delete from setting where key='capacity'", nil)
+}
+
+// Set the capacity limit to exactly this value.
+func SetCapacity(conn conn, cap int64) error {
+	return sqlitex.Exec(conn, "insert into setting values ('capacity', ?)", nil, cap)
+}
+
+func NewPool(opts NewPoolOpts) (_ ConnPool, _ ProviderOpts, err error) {
+	if opts.NumConns == 0 {
+		opts.NumConns = runtime.NumCPU()
+	}
+	if opts.Memory {
+		opts.Path = ":memory:"
+	}
+	values := make(url.Values)
+	if !opts.ConcurrentBlobReads {
+		values.Add("cache", "shared")
+	}
+	path := fmt.Sprintf("file:%s?%s", opts.Path, values.Encode())
+	conns, err := func() (ConnPool, error) {
+		switch opts.NumConns {
+		case 1:
+			conn, err := sqlite.OpenConn(path, 0)
+			return &poolFromConn{conn: conn}, err
+		default:
+			return sqlitex.Open(path, 0, opts.NumConns)
+		}
+	}()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conns.Close()
+		}
+	}()
+	conn := conns.Get(context.TODO())
+	defer conns.Put(conn)
+	if opts.PageSize != 0 {
+		err = sqlitex.ExecTransient(conn, fmt.Sprintf("pragma page_size=%d", opts.PageSize), nil)
+		if err != nil {
+			return
+		}
+	}
+	if !opts.DontInitSchema {
+		err = initSchema(conn)
+		if err != nil {
+			return
+		}
+	}
+	if opts.Capacity != 0 {
+		err = SetCapacity(conn, opts.Capacity)
+		if err != nil {
+			return
+		}
+	}
+	if opts.EvictionPolicy != "" {
+		err = setEvictionPolicy(conn, opts.EvictionPolicy)
+		if err != nil {
+			err = fmt.Errorf("setting eviction policy: %w", err)
+			return
+		}
+	}
+	return conns, ProviderOpts{
+		NumConns:           opts.NumConns,
+		ConcurrentBlobRead: opts.ConcurrentBlobReads,
+		BatchWrites:        true,
+		ChunkSize:          opts.ChunkSize,
+		Compression:        opts.Compression,
+		EncryptionKey:      opts.EncryptionKey,
+		OnOperation:        opts.OnOperation,
+		Metrics:            opts.Metrics,
+		LastUsedStaleness:  opts.LastUsedStaleness,
+		LastUsedInterval:   opts.LastUsedInterval,
+		VacuumInterval:     opts.VacuumInterval,
+		VacuumPages:        opts.VacuumPages,
+		MaxAge:             opts.MaxAge,
+		Namespace:          opts.Namespace,
+		NamespaceCapacity:  opts.NamespaceCapacity,
+		ExpiryInterval:     opts.ExpiryInterval,
+		WalCheckpointBytes: opts.WalCheckpointBytes,
+		ReadCacheSize:      opts.ReadCacheSize,
+		DurableCompletion:  opts.DurableCompletion,
+		ScrubInterval:      opts.ScrubInterval,
+		ScrubVerifier:      opts.ScrubVerifier,
+		ScrubDelete:        opts.ScrubDelete,
+		ScrubRate:          opts.ScrubRate,
+		OnCorruptBlob:      opts.OnCorruptBlob,
+		BusyBackoff:        opts.BusyBackoff,
+		MaxBatchWrites:     opts.MaxBatchWrites,
+		MaxBatchBytes:      opts.MaxBatchBytes,
+		MaxBatchLatency:    opts.MaxBatchLatency,
+		ConnOpts:           opts.ConnOpts,
+	}, nil
+}
+
+// Emulates a ConnPool from a single Conn. Might be faster than using a sqlitex.Pool.
+type poolFromConn struct {
+	mu   sync.Mutex
+	conn conn
+}
+
+func (me *poolFromConn) Get(ctx context.Context) conn {
+	me.mu.Lock()
+	return me.conn
+}
+
+func (me *poolFromConn) Put(conn conn) {
+	if conn != me.conn {
+		panic("expected to same conn")
+	}
+	me.mu.Unlock()
+}
+
+func (me *poolFromConn) Close() error {
+	return me.conn.Close()
+}
+
+// Needs the ConnPool size so it can initialize all the connections with pragmas. Takes ownership of
+// the ConnPool (since it has to initialize all the connections anyway).
+func NewProvider(pool ConnPool, opts ProviderOpts) (_ *Provider, err error) {
+	_, err = initPoolConns(context.TODO(), pool, opts.NumConns, true, opts.ConnOpts)
+	if err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	err = func() error {
+		conn := pool.Get(context.TODO())
+		if conn == nil {
+			return errors.New("couldn't get pool conn")
+		}
+		defer pool.Put(conn)
+		opts.ChunkSize, err = initChunkSize(conn, opts.ChunkSize)
+		if err != nil {
+			return fmt.Errorf("initing chunk size: %w", err)
+		}
+		if opts.Compression != nil && opts.ChunkSize == 0 {
+			return errors.New("compression requires a chunk size")
+		}
+		if opts.EncryptionKey != nil && opts.ChunkSize == 0 {
+			return errors.New("encryption requires a chunk size")
+		}
+		aead, err = initEncryption(conn, opts.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("initing encryption: %w", err)
+		}
+		if opts.Namespace != "" {
+			err = initNamespace(conn, opts.Namespace, opts.NamespaceCapacity)
+			if err != nil {
+				return fmt.Errorf("initing namespace: %w", err)
+			}
+		} else if opts.NamespaceCapacity != 0 {
+			return errors.New("namespace capacity requires a namespace")
+		}
+		return nil
+	}()
+	if err != nil {
+		return
+	}
+	writes := make(chan writeRequest, 1<<(20-14))
+	writerDone := make(chan struct{})
+	prov := &Provider{
+		pool:           pool,
+		writes:         writes,
+		writerDone:     writerDone,
+		opts:           opts,
+		aead:           aead,
+		stopBackground: make(chan struct{}),
+	}
+	prov.ctx, prov.cancel = context.WithCancel(context.Background())
+	prov.stats.batches.metrics = opts.Metrics
+	if opts.ReadCacheSize > 0 {
+		prov.readCache = newReadCache(opts.ReadCacheSize)
+	}
+	go func() {
+		defer close(writerDone)
+		providerWriter(writes, prov.pool, &prov.stats.batches, opts.batchLimits())
+	}()
+	if opts.VacuumInterval > 0 {
+		prov.goBackground(prov.vacuumer)
+	}
+	if opts.MaxAge > 0 {
+		prov.goBackground(prov.expirer)
+	}
+	if opts.ScrubInterval > 0 {
+		prov.goBackground(prov.scrubber)
+	}
+	return prov, nil
+}
+
+func initPoolConns(ctx context.Context, pool ConnPool, numConn int, wal bool, opts ConnOpts) (numInited int, err error) {
+	var conns []conn
+	defer func() {
+		for _, c := range conns {
+			pool.Put(c)
+		}
+	}()
+	for range iter.N(numConn) {
+		conn := pool.Get(ctx)
+		if conn == nil {
+			break
+		}
+		conns = append(conns, conn)
+		err = initConn(conn, wal, opts)
+		if err != nil {
+			err = fmt.Errorf("initing conn %v: %w", len(conns), err)
+			return
+		}
+		numInited++
+	}
+	return
+}
+
+type ConnPool interface {
+	Get(context.Context) conn
+	Put(conn)
+	Close() error
+}
+
+// A resource.Provider backed by a sqlite database. It also implements WriteConsecutiveChunks for use
+// with torrent piece storage.
+type Provider struct {
+	// Bytes written since the last checkpoint, for ProviderOpts.WalCheckpointBytes. Accessed
+	// atomically, so it's first for 64-bit alignment on 32-bit platforms.
+	walWritten int64
+
+	pool ConnPool
+	// Held for reading while sending to writes, and for writing to close it.
+	writesMu   sync.RWMutex
+	writes     chan<- writeRequest
+	closed     bool
+	writerDone <-chan struct{}
+	opts       ProviderOpts
+	// Set from ProviderOpts.EncryptionKey.
+	aead cipher.AEAD
+	// Done when the Provider is closed. Used by operations not given a context.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Nil unless ProviderOpts.ReadCacheSize is set.
+	readCache *readCache
+
+	accessMu             sync.Mutex
+	accessed             map[string]int
+	accessFlushScheduled bool
+
+	stats providerStats
+	// Closed by Close to stop background tasks, such as the scheduled Vacuum.
+	stopBackground     chan struct{}
+	stopBackgroundOnce sync.Once
+	background         sync.WaitGroup
+}
+
+// Runs f in a goroutine until the Provider is closed. f should return soon after stop is closed.
+func (p *Provider) goBackground(f func(stop <-chan struct{})) {
+	p.background.Add(1)
+	go func() {
+		defer p.background.Done()
+		f(p.stopBackground)
+	}()
+}
+
+func (p *Provider) WriteConsecutiveChunks(prefix string, w io.Writer) (written int64, err error) {
+	prefix = p.name(prefix)
+	err = p.withConn(func(conn conn) (err error) {
+		written, err = p.writeConsecutiveChunks(conn, prefix, w)
+		return
+	}, false)
+	return
+}
+
+func (p *Provider) writeConsecutiveChunks(conn conn, prefix string, w io.Writer) (written int64, err error) {
+	if p.opts.ChunkSize != 0 {
+		return p.writeConsecutiveChunksChunked(conn, prefix, w)
+	}
+	err = sqlitex.Exec(conn, `
+			select
+				cast(data as blob),
+				cast(substr(name, ?+1) as integer) as offset
+			from blob
+			where name>=? and name<?
+			order by offset`,
+		func(stmt *sqlite.Stmt) error {
+			r := stmt.ColumnReader(0)
+			//offset := stmt.ColumnInt64(1)
+			//log.Printf("got %v bytes at offset %v", r.Len(), offset)
+			w1, err := io.Copy(w, r)
+			written += w1
+			return err
+		},
+		len(prefix),
+		prefix,
+		prefixEnd(prefix),
+	)
+	return
+}
+
+var errClosed = types.Error{Kind: types.ErrClosed, Err: errors.New("provider closed")}
+
+// Wraps os.ErrNotExist, so callers can check for it with errors.Is, as for files.
+var errBlobNotFound = fmt.Errorf("blob not found: %w", os.ErrNotExist)
+
+// Gives sqlite errors their storage error kind. See types.ClassifyError.
+func storageError(err error) error {
+	var se sqlite.Error
+	if !errors.As(err, &se) {
+		return err
+	}
+	// Extended result codes have the primary code in the low byte.
+	switch se.Code & 0xff {
+	case sqlite.SQLITE_FULL:
+		return types.Error{Kind: types.ErrDiskFull, Err: err}
+	case sqlite.SQLITE_READONLY:
+		return types.Error{Kind: types.ErrReadOnly, Err: err}
+	case sqlite.SQLITE_BUSY, sqlite.SQLITE_LOCKED:
+		return types.Error{Kind: types.ErrBusy, Err: err}
+	}
+	return err
+}
+
+// Returns an error if the named blob of the given size can't be stored, as it would be evicted
+// immediately.
+func checkCapacity(conn conn, name string, size int64) error {
+	capacity, ok, err := getCapacity(conn)
+	if err != nil {
+		return err
+	}
+	if ok && size > capacity {
+		return types.Error{
+			Kind: types.ErrCapacityExceeded,
+			Err:  fmt.Errorf("%v bytes exceeds capacity of %v", size, capacity),
+		}
+	}
+	return checkPrefixQuotas(conn, name, size)
+}
+
+// Returns the capacity, and false if it's unlimited.
+func getCapacity(conn conn) (capacity int64, ok bool, err error) {
+	err = sqlitex.Exec(conn, "select value from setting where name='capacity'", func(stmt *sqlite.Stmt) error {
+		capacity = stmt.ColumnInt64(0)
+		ok = true
+		return nil
+	})
+	return
+}
+
+// Flushes recorded accesses, waits for queued writes to be committed, and then closes the ConnPool.
+// Writes after Close fail.
+func (me *Provider) Close() error {
+	me.stopBackgroundOnce.Do(func() {
+		close(me.stopBackground)
+	})
+	me.background.Wait()
+	flushErr := me.flushAccesses()
+	me.writesMu.Lock()
+	if me.closed {
+		me.writesMu.Unlock()
+		return errClosed
+	}
+	me.closed = true
+	close(me.writes)
+	me.writesMu.Unlock()
+	<-me.writerDone
+	// Abandon anything still waiting on the pool.
+	me.cancel()
+	err := me.pool.Close()
+	if err == nil && flushErr != nil {
+		err = fmt.Errorf("flushing last used times: %w", flushErr)
+	}
+	return err
+}
+
+// Blocks until recorded accesses and all writes queued before the call have been committed.
+func (me *Provider) Flush() error {
+	err := me.flushAccesses()
+	if err != nil {
+		return fmt.Errorf("flushing last used times: %w", err)
+	}
+	if !me.opts.BatchWrites {
+		// Writes are made directly.
+		return nil
+	}
+	// The writer handles requests in order, so this is done after those before it.
+	return me.withConn(func(conn) error { return nil }, true)
+}
+
+type writeRequest struct {
+	// Requests whose context is done by the time the writer reaches them are skipped.
+	ctx   context.Context
+	query withConn
+	done  chan<- error
+	// The blob data written, for ProviderOpts.MaxBatchBytes.
+	bytes int64
+}
+
+// Runs the request's query, unless its context is already done.
+func (wr writeRequest) run(conn conn) error {
+	if err := wr.ctx.Err(); err != nil {
+		return err
+	}
+	return wr.query(conn)
+}
+
+var expvars = expvar.NewMap("sqliteStorage")
+
+// Runs until writes is closed. Intentionally avoids holding a reference to *Provider to have stronger
+// typing on the writes channel.
+//
+// There's deliberately one writer. sqlite allows one write transaction at a time, even with WAL, so
+// writers on several connections only take turns at the write lock while each holds a pool
+// connection. Batching 16 KiB blobs from 8 goroutines into WAL with synchronous off, one writer
+// managed 309-361 MB/s, 2 writers 288-301 MB/s, and 4 writers 255-272 MB/s.
+func providerWriter(writes <-chan writeRequest, pool ConnPool, stats *batchStats, limits batchLimits) {
+	for {
+		first, ok := <-writes
+		if !ok {
+			return
+		}
+		var buf []func()
+		var cantFail error
+		func() {
+			conn := pool.Get(context.TODO())
+			if conn == nil {
+				buf = append(buf, func() { first.done <- errors.New("couldn't get pool conn") })
+				return
+			}
+			defer pool.Put(conn)
+			defer sqlitex.Save(conn)(&cantFail)
+			started := time.Now()
+			firstErr := first.run(conn)
+			buf = append(buf, func() { first.done <- firstErr })
+			bytes := first.bytes
+			for !limits.reached(len(buf), bytes, started) {
+				select {
+				case wr, ok := <-writes:
+					if ok {
+						err := wr.run(conn)
+						buf = append(buf, func() { wr.done <- err })
+						bytes += wr.bytes
+						continue
+					}
+				default:
+				}
+				break
+			}
+		}()
+		// Not sure what to do if this failed.
+		if cantFail != nil {
+			expvars.Add("batchTransactionErrors", 1)
+		}
+		// Signal done after we know the transaction succeeded.
+		for _, done := range buf {
+			done()
+		}
+		expvars.Add("batchTransactions", 1)
+		expvars.Add("batchedQueries", int64(len(buf)))
+		stats.record(len(buf))
+		//log.Printf("batched %v write queries", len(buf))
+	}
+}
+
+func (p *Provider) NewInstance(s string) (resource.Instance, error) {
+	return instance{location: p.name(s), p: p}, nil
+}
+
+// Returns an instance whose operations are abandoned when ctx is done: waits for a connection or
+// for a queued write end with the context's error, and queries in progress are interrupted. A
+// write that was already being committed may still complete.
+func (p *Provider) NewInstanceContext(ctx context.Context, s string) (resource.Instance, error) {
+	return instance{location: p.name(s), p: p, ctx: ctx}, nil
+}
+
+type instance struct {
+	location string
+	p        *Provider
+	// Nil for the Provider's context.
+	ctx context.Context
+}
+
+func (i instance) context() context.Context {
+	if i.ctx == nil {
+		return i.p.ctx
+	}
+	return i.ctx
+}
+
+func (p *Provider) withConn(with withConn, write bool) error {
+	return p.withConnContext(p.ctx, with, write)
+}
+
+// Stops waiting for a connection, or for a queued write, when ctx is done. Pooled connections are
+// also interrupted then.
+func (p *Provider) withConnContext(ctx context.Context, with withConn, write bool) error {
+	return p.withConnBytes(ctx, with, write, 0)
+}
+
+// Like withConnContext, for a write of the given bytes of blob data, which count toward
+// ProviderOpts.MaxBatchBytes.
+func (p *Provider) withConnBytes(ctx context.Context, with withConn, write bool, bytes int64) error {
+	if write && p.opts.BatchWrites {
+		// Buffered, so the writer isn't held up if we stop waiting.
+		done := make(chan error, 1)
+		p.writesMu.RLock()
+		if p.closed {
+			p.writesMu.RUnlock()
+			return errClosed
+		}
+		select {
+		case p.writes <- writeRequest{
+			ctx:   ctx,
+			query: with,
+			done:  done,
+			bytes: bytes,
+		}:
+		case <-ctx.Done():
+			p.writesMu.RUnlock()
+			return ctx.Err()
+		}
+		p.writesMu.RUnlock()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		conn := p.pool.Get(ctx)
+		if conn == nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return errors.New("couldn't get pool conn")
+		}
+		defer p.pool.Put(conn)
+		return with(conn)
+	}
+}
+
+type withConn func(conn) error
+
+func (i instance) withConn(with withConn, write bool) error {
+	return i.p.withConnContext(i.context(), with, write)
+}
+
+func (i instance) getConn() *sqlite.Conn {
+	return i.p.pool.Get(i.context())
+}
+
+func (i instance) putConn(conn *sqlite.Conn) {
+	i.p.pool.Put(conn)
+}
+
+// Returns the names of the instance's immediate children, treating names as slash-separated paths.
+// A name with further path elements under the instance is given as the first of them, as for a
+// directory.
+func (i instance) Readdirnames() (names []string, err error) {
+	defer i.observe("readdir", time.Now(), nil, &err)
+	prefix := i.location + "/"
+	seen := make(map[string]struct{})
+	err = i.withConn(func(conn conn) error {
+		// Rather than scanning every descendant, skip over the names below each child once it's
+		// found. Each step is a seek on the primary key index.
+		lower := prefix
+		for {
+			var name string
+			var ok bool
+			err := sqlitex.Exec(
+				conn,
+				"select name from blob where name>=? and name<? order by name limit 1",
+				func(stmt *sqlite.Stmt) error {
+					name = stmt.ColumnText(0)
+					ok = true
+					return nil
+				},
+				lower, prefixEnd(prefix))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			child := name[len(prefix):]
+			if slash := strings.IndexByte(child, '/'); slash >= 0 {
+				child = child[:slash]
+				lower = prefixEnd(prefix + child + "/")
+			} else {
+				lower = name + "\x00"
+			}
+			if _, ok := seen[child]; !ok {
+				seen[child] = struct{}{}
+				names = append(names, child)
+			}
+		}
+	}, false)
+	// Files and directories with the same prefix aren't found in order, like "a/b-c" and "a/b/d".
+	sort.Strings(names)
+	//log.Printf("readdir %q gave %q", i.location, names)
+	return
+}
+
+// Returns the names of all the instance's descendants, relative to it.
+func (i instance) ReaddirnamesRecursive() (names []string, err error) {
+	prefix := i.location + "/"
+	err = i.withConn(func(conn conn) error {
+		return sqlitex.Exec(
+			conn,
+			"select name from blob where name>=? and name<? order by name",
+			func(stmt *sqlite.Stmt) error {
+				names = append(names, stmt.ColumnText(0)[len(prefix):])
+				return nil
+			},
+			prefix, prefixEnd(prefix))
+	}, false)
+	return
+}
+
+// Returns the least string greater than all strings with the given prefix, which must end with a
+// byte that can be incremented, such as a slash or a hex digit. Comparing names against a range
+// like this uses the primary key index, which like doesn't, since it's case-insensitive.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	b[len(b)-1]++
+	return string(b)
+}
+
+func (i instance) getBlobRowid(conn conn) (rowid int64, err error) {
+	rows := 0
+	err = sqlitex.Exec(conn, "select rowid from blob where name=?", func(stmt *sqlite.Stmt) error {
+		rowid = stmt.ColumnInt64(0)
+		rows++
+		return nil
+	}, i.location)
+	if err != nil {
+		return
+	}
+	if rows == 1 {
+		return
+	}
+	if rows == 0 {
+		err = errBlobNotFound
+		return
+	}
+	panic(rows)
+}
+
+type connBlob struct {
+	*sqlite.Blob
+	onClose func()
+}
+
+func (me connBlob) Close() error {
+	err := me.Blob.Close()
+	me.onClose()
+	return err
+}
+
+func (i instance) Get() (ret io.ReadCloser, err error) {
+	defer i.observe("get", time.Now(), nil, &err)
+	if i.p.opts.ChunkSize != 0 {
+		return i.getChunked()
+	}
+	conn := i.getConn()
+	if conn == nil {
+		if err = i.context().Err(); err != nil {
+			return
+		}
+		panic("nil sqlite conn")
+	}
+	blob, err := i.openBlob(conn, false, true)
+	if err != nil {
+		i.putConn(conn)
+		return
+	}
+	var once sync.Once
+	return connBlob{blob, func() {
+		once.Do(func() { i.putConn(conn) })
+	}}, nil
+}
+
+func (i instance) openBlob(conn conn, write, updateAccess bool) (*sqlite.Blob, error) {
+	rowid, err := i.getBlobRowid(conn)
+	if err != nil {
+		return nil, err
+	}
+	// This seems to cause locking issues with in-memory databases. Is it something to do with not
+	// having WAL?
+	if updateAccess && !i.p.recordAccess(i.location) {
+		err = sqlitex.Exec(conn, `
+			update blob set last_used=datetime('now'), access_count=access_count+1
+			where rowid=?1 and (?2 is null or last_used<datetime('now', ?2))`,
+			nil, rowid, i.p.lastUsedModifier())
+		if err != nil {
+			err = fmt.Errorf("updating last_used: %w", err)
+			return nil, err
+		}
+		if conn.Changes() > 1 {
+			panic(conn.Changes())
+		}
+	}
+	return conn.OpenBlob("main", "blob", "data", rowid, write)
+}
+
+func (i instance) Put(reader io.Reader) (err error) {
+	var buf bytes.Buffer
+	defer i.observe("put", time.Now(), func() int64 { return int64(buf.Len()) }, &err)
+	_, err = io.Copy(&buf, reader)
+	if err != nil {
+		return err
+	}
+	var evictions int64
+	var evicted evictionCounts
+	err = i.p.withConnBytes(i.context(), func(conn conn) error {
+		before, err := i.p.evictionCounts(conn)
+		if err != nil {
+			return err
+		}
+		err = checkCapacity(conn, i.location, int64(buf.Len()))
+		if err != nil {
+			return err
+		}
+		err = i.p.retryBusy(func() error {
+			if i.p.opts.ChunkSize != 0 {
+				return i.putChunked(conn, buf.Bytes())
+			}
+			return sqlitex.Exec(conn,
+				"insert or replace into blob(name, data) values(?, cast(? as blob))",
+				nil,
+				i.location, buf.Bytes())
+		})
+		if err != nil {
+			return err
+		}
+		evicted, err = i.p.evictionsSince(conn, before)
+		if err != nil {
+			return err
+		}
+		return i.p.readCacheEvictions(conn, &evictions)
+	}, true, int64(buf.Len()))
+	i.p.invalidateReads(i.location, evictions, err)
+	if err == nil {
+		i.p.observeEvictions(evicted)
+	}
+	return storageError(err)
+}
+
+// Describes a blob as a read-only regular file. The modification time is when the blob was last
+// used, which orders eviction.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f fileInfo) Name() string {
+	return f.name
+}
+
+func (f fileInfo) Size() int64 {
+	return f.size
+}
+
+func (f fileInfo) Mode() os.FileMode {
+	return 0444
+}
+
+func (f fileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f fileInfo) IsDir() bool {
+	return false
+}
+
+func (f fileInfo) Sys() interface{} {
+	return nil
+}
+
+// The format of datetime('now'), which is in UTC.
+const sqliteDatetimeLayout = "2006-01-02 15:04:05"
+
+func (i instance) stat(conn conn) (ret fileInfo, err error) {
+	sizeExpr := "length(cast(data as blob))"
+	if i.p.opts.ChunkSize != 0 {
+		// Includes data in the blob row, for blobs stored before the database used chunks.
+		sizeExpr += "+(select coalesce(sum(" + chunkDataSizeExpr + "), 0) from blob_chunk where name=blob.name)"
+	}
+	found := false
+	err = sqlitex.Exec(conn, "select "+sizeExpr+", last_used from blob where name=?", func(stmt *sqlite.Stmt) error {
+		found = true
+		ret.size = stmt.ColumnInt64(0)
+		// Access times from elsewhere might not parse. They're informational here.
+		ret.modTime, _ = time.ParseInLocation(sqliteDatetimeLayout, stmt.ColumnText(1), time.UTC)
+		return nil
+	}, i.location)
+	if err == nil && !found {
+		err = errBlobNotFound
+	}
+	ret.name = path.Base(i.location)
+	return
+}
+
+func (i instance) Stat() (ret os.FileInfo, err error) {
+	defer i.observe("stat", time.Now(), nil, &err)
+	err = i.withConn(func(conn conn) (err error) {
+		ret, err = i.stat(conn)
+		return
+	}, false)
+	return
+}
+
+func (i instance) ReadAt(p []byte, off int64) (n int, err error) {
+	defer i.observe("read", time.Now(), func() int64 { return int64(n) }, &err)
+	i.p.recordAccess(i.location)
+	if c := i.p.readCache; c != nil {
+		hit, generation := c.get(i.location, p, off)
+		if hit {
+			return len(p), nil
+		}
+		defer func() {
+			if err == nil {
+				c.put(i.location, p[:n], off, generation)
+			}
+		}()
+	}
+	err = i.withConn(func(conn conn) (err error) {
+		n, err = i.readAt(conn, p, off)
+		return
+	}, false)
+	return
+}
+
+// Reads from the blob without recording an access or using the read cache.
+func (i instance) readAt(conn conn, p []byte, off int64) (n int, err error) {
+	if i.p.opts.ChunkSize != 0 {
+		var ok bool
+		n, ok, err = i.readChunksAt(conn, p, off)
+		if ok || err != nil {
+			return
+		}
+		// Stored before the database used chunks, or past the end.
+	}
+	if false {
+		var blob *sqlite.Blob
+		blob, err = i.openBlob(conn, false, true)
+		if err != nil {
+			return
+		}
+		defer blob.Close()
+		if off >= blob.Size() {
+			err = io.EOF
+			return
+		}
+		if off+int64(len(p)) > blob.Size() {
+			p = p[:blob.Size()-off]
+		}
+		n, err = blob.ReadAt(p, off)
+	} else {
+		gotRow := false
+		err = sqlitex.Exec(
+			conn,
+			"select substr(cast(data as blob), ?, ?) from blob where name=?",
+			func(stmt *sqlite.Stmt) error {
+				if gotRow {
+					panic("found multiple matching blobs")
+				} else {
+					gotRow = true
+				}
+				n = stmt.ColumnBytes(0, p)
+				return nil
+			},
+			off+1, len(p), i.location,
+		)
+		if err != nil {
+			return
+		}
+		if !gotRow {
+			err = errBlobNotFound
+			return
+		}
+		if n < len(p) {
+			err = io.EOF
+		}
+	}
+	return
+}
+
+// Writes at an offset into the blob, creating or extending it with zeroes as needed. Like Put, it
+// goes through the write batcher.
+func (i instance) WriteAt(b []byte, off int64) (n int, err error) {
+	defer i.observe("write", time.Now(), func() int64 { return int64(n) }, &err)
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+	var evictions int64
+	var evicted evictionCounts
+	err = i.p.withConnBytes(i.context(), func(conn conn) error {
+		before, err := i.p.evictionCounts(conn)
+		if err != nil {
+			return err
+		}
+		err = checkCapacity(conn, i.location, off+int64(len(b)))
+		if err != nil {
+			return err
+		}
+		err = i.p.retryBusy(func() error {
+			if i.p.opts.ChunkSize != 0 {
+				return i.writeChunksAt(conn, b, off)
+			}
+			return i.writeBlobAt(conn, b, off)
+		})
+		if err != nil {
+			return err
+		}
+		evicted, err = i.p.evictionsSince(conn, before)
+		if err != nil {
+			return err
+		}
+		return i.p.readCacheEvictions(conn, &evictions)
+	}, true, int64(len(b)))
+	i.p.invalidateReads(i.location, evictions, err)
+	if err != nil {
+		return 0, storageError(err)
+	}
+	i.p.observeEvictions(evicted)
+	return len(b), nil
+}
+
+// Ensures the blob row is long enough, and then writes with incremental blob I/O.
+func (i instance) writeBlobAt(conn conn, b []byte, off int64) error {
+	end := off + int64(len(b))
+	err := sqlitex.Exec(conn, "insert or ignore into blob(name, data) values(?, zeroblob(?))", nil, i.location, end)
+	if err != nil {
+		return err
+	}
+	err = sqlitex.Exec(conn, `
+		update blob set data=cast(data as blob)||zeroblob(?1-length(cast(data as blob)))
+		where name=?2 and length(cast(data as blob))<?1`,
+		nil, end, i.location)
+	if err != nil {
+		return err
+	}
+	blob, err := i.openBlob(conn, true, false)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	_, err = blob.WriteAt(b, off)
+	return err
+}
+
+func (i instance) Delete() (err error) {
+	defer i.observe("delete", time.Now(), nil, &err)
+	err = i.withConn(func(conn conn) error {
+		return sqlitex.Exec(conn, "delete from blob where name=?", nil, i.location)
+	}, true)
+	if c := i.p.readCache; c != nil {
+		c.invalidate(i.location)
+	}
+	return
+}
+
+// Checks that the database can be queried.
+func (p *Provider) Ping() error {
+	return p.withConn(func(conn conn) error {
+		return sqlitex.ExecTransient(conn, "select 1", nil)
+	}, false)
+}
+
+// Returns the size of blobs counted toward the capacity.
+func (p *Provider) Usage() (size int64, err error) {
+	err = p.withConn(func(conn conn) (err error) {
+		size, err = usedBytes(conn)
+		return
+	}, false)
+	return
+}
+
+// Deletes all blobs with names starting with the prefix, returning how many were deleted.
+func (p *Provider) DeletePrefix(prefix string) (deleted int64, err error) {
+	return p.DeletePrefixes([]string{prefix})
+}
+
+// Deletes all blobs with names starting with any of the prefixes, in a single transaction.
+func (p *Provider) DeletePrefixes(prefixes []string) (deleted int64, err error) {
+	prefixes = p.names(prefixes)
+	err = p.withConn(func(conn conn) (err error) {
+		defer sqlitex.Save(conn)(&err)
+		for _, prefix := range prefixes {
+			err = sqlitex.Exec(conn, "delete from blob where name>=? and name<?", nil, prefix, prefixEnd(prefix))
+			if err != nil {
+				return
+			}
+			deleted += int64(conn.Changes())
+		}
+		return
+	}, true)
+	if p.readCache != nil {
+		p.readCache.invalidatePrefixes(prefixes...)
+	}
+	return
+}
+
+func (i instance) observe(kind string, started time.Time, n func() int64, err *error) {
+	dur := time.Since(started)
+	var transferred int64
+	if n != nil {
+		transferred = n()
+	}
+	i.p.recordOperation(kind, dur, transferred, *err)
+	if *err == nil && (kind == "put" || kind == "write") {
+		i.p.noteWritten(transferred)
+	}
+	f := i.p.opts.OnOperation
+	if f == nil {
+		return
+	}
+	f(Operation{
+		Kind:     kind,
+		Name:     i.location,
+		Bytes:    transferred,
+		Duration: dur,
+		Err:      *err,
+	})
+}
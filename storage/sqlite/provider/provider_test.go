@@ -0,0 +1,1124 @@
+package sqliteProvider
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	_ "github.com/anacrolix/envpprof"
+	"github.com/anacrolix/missinggo/v2/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/storage/types"
+)
+
+func newConnsAndProv(t *testing.T, opts NewPoolOpts) (ConnPool, *Provider) {
+	opts.Path = filepath.Join(t.TempDir(), "sqlite3.db")
+	conns, provOpts, err := NewPool(opts)
+	require.NoError(t, err)
+	// sqlitex.Pool.Close doesn't like being called more than once. Let it slide for now.
+	//t.Cleanup(func() { conns.Close() })
+	prov, err := NewProvider(conns, provOpts)
+	require.NoError(t, err)
+	t.Cleanup(func() { prov.Close() })
+	return conns, prov
+}
+
+func TestTextBlobSize(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{})
+	a, _ := prov.NewInstance("a")
+	a.Put(bytes.NewBufferString("\x00hello"))
+	fi, _ := a.Stat()
+	assert.EqualValues(t, 6, fi.Size())
+}
+
+func TestFileInfo(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{})
+	a, _ := prov.NewInstance("dir/a")
+	require.NoError(t, a.Put(bytes.NewBufferString("hello")))
+	fi, err := a.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, "a", fi.Name())
+	assert.True(t, fi.Mode().IsRegular())
+	assert.False(t, fi.IsDir())
+	assert.WithinDuration(t, time.Now(), fi.ModTime(), time.Minute)
+	assert.Nil(t, fi.Sys())
+	b, _ := prov.NewInstance("dir/b")
+	_, err = b.Stat()
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestSimultaneousIncrementalBlob(t *testing.T) {
+	_, p := newConnsAndProv(t, NewPoolOpts{
+		NumConns:            2,
+		ConcurrentBlobReads: true,
+	})
+	a, err := p.NewInstance("a")
+	require.NoError(t, err)
+	const contents = "hello, world"
+	require.NoError(t, a.Put(bytes.NewReader([]byte("hello, world"))))
+	rc1, err := a.Get()
+	require.NoError(t, err)
+	rc2, err := a.Get()
+	require.NoError(t, err)
+	var b1, b2 []byte
+	var e1, e2 error
+	var wg sync.WaitGroup
+	doRead := func(b *[]byte, e *error, rc io.ReadCloser, n int) {
+		defer wg.Done()
+		defer rc.Close()
+		*b, *e = ioutil.ReadAll(rc)
+		require.NoError(t, *e, n)
+		assert.EqualValues(t, contents, *b)
+	}
+	wg.Add(2)
+	go doRead(&b2, &e2, rc2, 2)
+	go doRead(&b1, &e1, rc1, 1)
+	wg.Wait()
+}
+
+func TestReaddirnames(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{})
+	for _, name := range []string{"a/b", "a/c/d", "a/c/e", "a/c-f", "a/g/h/i", "a-j", "ab/k", "a"} {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(bytes.NewBufferString(name)))
+	}
+	a, err := prov.NewInstance("a")
+	require.NoError(t, err)
+	names, err := a.Readdirnames()
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"b", "c", "c-f", "g"}, names)
+	names, err = a.(instance).ReaddirnamesRecursive()
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"b", "c-f", "c/d", "c/e", "g/h/i"}, names)
+	c, err := prov.NewInstance("a/c")
+	require.NoError(t, err)
+	names, err = c.Readdirnames()
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"d", "e"}, names)
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	conns, _ := newConnsAndProv(t, NewPoolOpts{NumConns: 1})
+	conn := conns.Get(context.Background())
+	defer conns.Put(conn)
+	version, err := schemaVersion(conn)
+	require.NoError(t, err)
+	assert.Equal(t, len(schemaMigrations), version)
+	// Reapplying is a no-op.
+	require.NoError(t, initSchema(conn))
+	version, err = schemaVersion(conn)
+	require.NoError(t, err)
+	assert.Equal(t, len(schemaMigrations), version)
+	queryPlan := func(query string, args ...interface{}) (plan string) {
+		require.NoError(t, sqlitex.Exec(conn, "explain query plan "+query, func(stmt *sqlite.Stmt) error {
+			plan += stmt.ColumnText(3) + "\n"
+			return nil
+		}, args...))
+		return
+	}
+	assert.Contains(t,
+		queryPlan("select rowid from blob where name>=? and name<?", "a/", "a0"),
+		"USING INDEX sqlite_autoindex_blob_1")
+	assert.Contains(t,
+		queryPlan("select rowid from blob order by last_used, rowid limit 1"),
+		"USING INDEX blob_last_used")
+	// Without the index, finding the first blob to evict sorts the table, and each step to the next
+	// blob scans it.
+	evictionPlan := queryPlan("select * from deletable_blob")
+	assert.Contains(t, evictionPlan, "USING COVERING INDEX blob_last_used (last_used>?)")
+	assert.NotContains(t, evictionPlan, "USE TEMP B-TREE")
+}
+
+func TestChunkedBlobs(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{ChunkSize: 4})
+	a, err := prov.NewInstance("a/0")
+	require.NoError(t, err)
+	const contents = "hello, world"
+	require.NoError(t, a.Put(bytes.NewBufferString(contents)))
+	fi, err := a.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, len(contents), fi.Size())
+	b := make([]byte, 6)
+	n, err := a.ReadAt(b, 3)
+	require.NoError(t, err)
+	assert.Equal(t, contents[3:9], string(b[:n]))
+	n, err = a.ReadAt(b, 9)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, contents[9:], string(b[:n]))
+	rc, err := a.Get()
+	require.NoError(t, err)
+	all, err := ioutil.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(all))
+	a1, _ := prov.NewInstance("a/12")
+	require.NoError(t, a1.Put(bytes.NewBufferString("!")))
+	var buf bytes.Buffer
+	_, err = prov.WriteConsecutiveChunks("a/", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, contents+"!", buf.String())
+	// Replacing and deleting take the chunks with them.
+	require.NoError(t, a.Put(bytes.NewBufferString("hi")))
+	fi, err = a.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, fi.Size())
+	require.NoError(t, a.Delete())
+	_, err = a.Stat()
+	assert.Error(t, err)
+}
+
+func TestOnOperation(t *testing.T) {
+	var mu sync.Mutex
+	var ops []Operation
+	_, prov := newConnsAndProv(t, NewPoolOpts{
+		OnOperation: func(op Operation) {
+			mu.Lock()
+			ops = append(ops, op)
+			mu.Unlock()
+		},
+	})
+	a, err := prov.NewInstance("a")
+	require.NoError(t, err)
+	require.NoError(t, a.Put(bytes.NewBufferString("hello")))
+	b := make([]byte, 3)
+	_, err = a.ReadAt(b, 1)
+	require.NoError(t, err)
+	b1, err := prov.NewInstance("b")
+	require.NoError(t, err)
+	_, err = b1.Stat()
+	assert.Error(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, ops, 3)
+	assert.Equal(t, "put", ops[0].Kind)
+	assert.Equal(t, "a", ops[0].Name)
+	assert.EqualValues(t, 5, ops[0].Bytes)
+	assert.Equal(t, "read", ops[1].Kind)
+	assert.EqualValues(t, 3, ops[1].Bytes)
+	assert.NoError(t, ops[1].Err)
+	assert.Equal(t, "stat", ops[2].Kind)
+	assert.Error(t, ops[2].Err)
+}
+
+func TestDeletePrefixes(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{ChunkSize: 2})
+	for _, name := range []string{"a/1", "a/2", "ab", "b/1", "c"} {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(bytes.NewBufferString("hello")))
+	}
+	deleted, err := prov.DeletePrefixes([]string{"a/", "c"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, deleted)
+	root, err := prov.NewInstance("a")
+	require.NoError(t, err)
+	names, err := root.Readdirnames()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+	ab, _ := prov.NewInstance("ab")
+	fi, err := ab.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, fi.Size())
+}
+
+func TestLastUsedBatching(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{
+		NumConns:          1,
+		LastUsedStaleness: time.Hour,
+	})
+	a, err := prov.NewInstance("a")
+	require.NoError(t, err)
+	require.NoError(t, a.Put(bytes.NewBufferString("hello")))
+	lastUsed := func() (ret string) {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, sqlitex.Exec(conn, "select last_used from blob where name='a'", func(stmt *sqlite.Stmt) error {
+			ret = stmt.ColumnText(0)
+			return nil
+		}))
+		return
+	}
+	func() {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, sqlitex.Exec(conn, "update blob set last_used='2000-01-01 00:00:00'", nil))
+	}()
+	rc, err := a.Get()
+	require.NoError(t, err)
+	rc.Close()
+	_, err = a.ReadAt(make([]byte, 1), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "2000-01-01 00:00:00", lastUsed())
+	require.NoError(t, prov.flushAccesses())
+	assert.NotEqual(t, "2000-01-01 00:00:00", lastUsed())
+}
+
+func TestLastUsedInterval(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{
+		NumConns:         1,
+		LastUsedInterval: time.Hour,
+	})
+	a, err := prov.NewInstance("a")
+	require.NoError(t, err)
+	require.NoError(t, a.Put(bytes.NewBufferString("hello")))
+	exec := func(query string, result func(stmt *sqlite.Stmt) error) {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, sqlitex.Exec(conn, query, result))
+	}
+	lastUsed := func() (ret string) {
+		exec("select last_used from blob where name='a'", func(stmt *sqlite.Stmt) error {
+			ret = stmt.ColumnText(0)
+			return nil
+		})
+		return
+	}
+	read := func() {
+		rc, err := a.Get()
+		require.NoError(t, err)
+		rc.Close()
+	}
+	// Recently used, so it's left alone.
+	exec("update blob set last_used=datetime('now', '-1 minutes')", nil)
+	recent := lastUsed()
+	read()
+	assert.Equal(t, recent, lastUsed())
+	exec("update blob set last_used='2000-01-01 00:00:00'", nil)
+	read()
+	assert.NotEqual(t, "2000-01-01 00:00:00", lastUsed())
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	blobNames := func(conns ConnPool) (ret []string) {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, sqlitex.Exec(conn, "select name from blob order by name", func(stmt *sqlite.Stmt) error {
+			ret = append(ret, stmt.ColumnText(0))
+			return nil
+		}))
+		return
+	}
+	put := func(prov *Provider, name, data string) {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(bytes.NewBufferString(data)))
+	}
+	t.Run("Largest", func(t *testing.T) {
+		conns, prov := newConnsAndProv(t, NewPoolOpts{
+			NumConns:       1,
+			Capacity:       12,
+			EvictionPolicy: EvictLargest,
+		})
+		put(prov, "a", "hello")
+		put(prov, "b", "hi")
+		put(prov, "c", "howdy")
+		assert.Equal(t, []string{"b", "c"}, blobNames(conns))
+		// Migrations replace the views with those for EvictLRU, so the stored policy's are
+		// recreated after them.
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, setSchemaVersion(conn, len(schemaMigrations)-1))
+		require.NoError(t, initSchema(conn))
+		var viewSql string
+		require.NoError(t, sqlitex.Exec(conn, "select sql from sqlite_master where name='deletable_blob'", func(stmt *sqlite.Stmt) error {
+			viewSql = stmt.ColumnText(0)
+			return nil
+		}))
+		assert.Contains(t, viewSql, "-size")
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		_, _, err := NewPool(NewPoolOpts{Memory: true, EvictionPolicy: "random()"})
+		assert.Error(t, err)
+	})
+	t.Run("LFU", func(t *testing.T) {
+		conns, prov := newConnsAndProv(t, NewPoolOpts{
+			NumConns:       1,
+			Capacity:       12,
+			EvictionPolicy: EvictLFU,
+		})
+		put(prov, "a", "hello")
+		put(prov, "b", "hi")
+		a, _ := prov.NewInstance("a")
+		for range make([]struct{}, 2) {
+			rc, err := a.Get()
+			require.NoError(t, err)
+			rc.Close()
+		}
+		put(prov, "c", "howdy")
+		assert.Equal(t, []string{"a", "c"}, blobNames(conns))
+	})
+}
+
+func TestTrimToSize(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{NumConns: 1, Capacity: 100})
+	for _, name := range []string{"a", "b", "c"} {
+		i, _ := prov.NewInstance(name)
+		require.NoError(t, i.Put(bytes.NewBufferString("hello")))
+	}
+	evicted, err := prov.TrimToSize(10)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, evicted)
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, stats.UsedBytes)
+	assert.EqualValues(t, 2, stats.Evictions)
+	// The capacity is left as it was.
+	assert.EqualValues(t, 100, stats.Capacity)
+	c, _ := prov.NewInstance("c")
+	_, err = c.Stat()
+	assert.NoError(t, err)
+}
+
+func TestEvictPrefix(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{NumConns: 1})
+	for _, name := range []string{"a/1", "a/2", "b/1"} {
+		i, _ := prov.NewInstance(name)
+		require.NoError(t, i.Put(bytes.NewBufferString("hello")))
+	}
+	evicted, err := prov.EvictPrefix("a/")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, evicted)
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stats.Blobs)
+	assert.EqualValues(t, 2, stats.Evictions)
+}
+
+func TestNamespaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sqlite3.db")
+	open := func(namespace string) *Provider {
+		conns, provOpts, err := NewPool(NewPoolOpts{
+			Path:              path,
+			NumConns:          1,
+			Namespace:         namespace,
+			NamespaceCapacity: 8,
+		})
+		require.NoError(t, err)
+		prov, err := NewProvider(conns, provOpts)
+		require.NoError(t, err)
+		t.Cleanup(func() { prov.Close() })
+		return prov
+	}
+	a := open("a")
+	b := open("b")
+	put := func(prov *Provider, name, data string) {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(bytes.NewBufferString(data)))
+	}
+	get := func(prov *Provider, name string) string {
+		i, _ := prov.NewInstance(name)
+		rc, err := i.Get()
+		if err != nil {
+			return ""
+		}
+		defer rc.Close()
+		b, _ := ioutil.ReadAll(rc)
+		return string(b)
+	}
+	put(a, "x", "hello")
+	put(b, "x", "world")
+	assert.Equal(t, "hello", get(a, "x"))
+	assert.Equal(t, "world", get(b, "x"))
+	// Each namespace is evicted within its own capacity.
+	put(a, "y", "howdy")
+	assert.Equal(t, "", get(a, "x"))
+	assert.Equal(t, "world", get(b, "x"))
+	usage, err := a.NamespaceUsage("b")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, usage)
+	namespaces, err := a.Namespaces()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, namespaces)
+	deleted, err := a.DeleteNamespace("b")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, deleted)
+	assert.Equal(t, "", get(b, "x"))
+	namespaces, err = a.Namespaces()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, namespaces)
+}
+
+func TestShardedProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sqlite3.db")
+	prov, err := NewShardedProvider(NewPoolOpts{Path: path, NumConns: 1, Shards: 3})
+	require.NoError(t, err)
+	defer prov.Close()
+	for name, data := range map[string]string{
+		"incompleted/a/0": "hel",
+		"incompleted/a/3": "lo",
+		"incompleted/b/0": "x",
+		"completed/a":     "hello",
+		"completed/b":     "bye",
+	} {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(bytes.NewBufferString(data)))
+	}
+	for i := 0; i < 3; i++ {
+		assert.FileExists(t, fmt.Sprintf("%s.%d", path, i))
+	}
+	dir, err := prov.NewInstance("incompleted/a")
+	require.NoError(t, err)
+	names, err := dir.(resource.DirInstance).Readdirnames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "3"}, names)
+	completed, err := prov.NewInstance("completed")
+	require.NoError(t, err)
+	names, err = completed.(resource.DirInstance).Readdirnames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+	var buf bytes.Buffer
+	_, err = prov.WriteConsecutiveChunks("incompleted/a/", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", buf.String())
+	usage, err := prov.Usage()
+	require.NoError(t, err)
+	assert.EqualValues(t, 14, usage)
+	deleted, err := prov.DeletePrefixes([]string{"incompleted/"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, deleted)
+	require.NoError(t, prov.Ping())
+}
+
+func TestConnOpts(t *testing.T) {
+	conns, _ := newConnsAndProv(t, NewPoolOpts{
+		NumConns: 1,
+		ConnOpts: ConnOpts{
+			Synchronous:      "normal",
+			PageSize:         8192,
+			CacheSize:        -2000,
+			MmapSize:         -1,
+			BusyTimeout:      5 * time.Second,
+			JournalSizeLimit: 1 << 20,
+		},
+	})
+	conn := conns.Get(context.Background())
+	defer conns.Put(conn)
+	pragma := func(name string) (ret int64) {
+		require.NoError(t, sqlitex.ExecTransient(conn, "pragma "+name, func(stmt *sqlite.Stmt) error {
+			ret = stmt.ColumnInt64(0)
+			return nil
+		}))
+		return
+	}
+	// Normal is 1.
+	assert.EqualValues(t, 1, pragma("synchronous"))
+	assert.EqualValues(t, 8192, pragma("page_size"))
+	assert.EqualValues(t, -2000, pragma("cache_size"))
+	assert.EqualValues(t, 0, pragma("mmap_size"))
+	assert.EqualValues(t, 5000, pragma("busy_timeout"))
+	assert.EqualValues(t, 1<<20, pragma("journal_size_limit"))
+	assert.Error(t, initConn(conn, true, ConnOpts{Synchronous: "off; drop table blob"}))
+}
+
+func TestFlushAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sqlite3.db")
+	conns, provOpts, err := NewPool(NewPoolOpts{Path: path})
+	require.NoError(t, err)
+	prov, err := NewProvider(conns, provOpts)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			i, _ := prov.NewInstance(name)
+			assert.NoError(t, i.Put(bytes.NewBufferString(name)))
+		}(name)
+	}
+	wg.Wait()
+	require.NoError(t, prov.Flush())
+	require.NoError(t, prov.Close())
+	a, _ := prov.NewInstance("a")
+	assert.Equal(t, errClosed, a.Put(bytes.NewBufferString("after")))
+	assert.Equal(t, errClosed, prov.Close())
+	// The writes were committed before closing.
+	conns, provOpts, err = NewPool(NewPoolOpts{Path: path})
+	require.NoError(t, err)
+	prov, err = NewProvider(conns, provOpts)
+	require.NoError(t, err)
+	defer prov.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		i, _ := prov.NewInstance(name)
+		fi, err := i.Stat()
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, fi.Size())
+	}
+}
+
+func TestBusyBackoff(t *testing.T) {
+	busy := sqlite.Error{Code: sqlite.SQLITE_BUSY}
+	calls := 0
+	err := BusyBackoff{Attempts: 3, Initial: time.Millisecond}.retry(func() error {
+		calls++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 3, calls)
+	calls = 0
+	err = BusyBackoff{Initial: time.Millisecond}.retry(func() error {
+		calls++
+		if calls < 2 {
+			return fmt.Errorf("wrapped: %w", busy)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	// Other errors aren't retried.
+	calls = 0
+	err = BusyBackoff{}.retry(func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestStorageErrorKinds(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{Capacity: 4})
+	a, _ := prov.NewInstance("a")
+	err := a.Put(bytes.NewBufferString("hello"))
+	assert.True(t, errors.Is(err, types.ErrCapacityExceeded))
+	assert.NoError(t, a.Put(bytes.NewBufferString("hi")))
+	require.NoError(t, prov.Close())
+	assert.True(t, errors.Is(a.Put(bytes.NewBufferString("hi")), types.ErrClosed))
+}
+
+func TestWriteAt(t *testing.T) {
+	for _, chunkSize := range []int64{0, 4} {
+		t.Run(fmt.Sprintf("ChunkSize=%v", chunkSize), func(t *testing.T) {
+			_, prov := newConnsAndProv(t, NewPoolOpts{ChunkSize: chunkSize})
+			i, _ := prov.NewInstance("a")
+			n, err := i.WriteAt([]byte("world"), 7)
+			require.NoError(t, err)
+			assert.Equal(t, 5, n)
+			fi, err := i.Stat()
+			require.NoError(t, err)
+			assert.EqualValues(t, 12, fi.Size())
+			_, err = i.WriteAt([]byte("hello,"), 0)
+			require.NoError(t, err)
+			_, err = i.WriteAt([]byte("!"), 12)
+			require.NoError(t, err)
+			rc, err := i.Get()
+			require.NoError(t, err)
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			require.NoError(t, err)
+			assert.Equal(t, "hello,\x00world!", string(b))
+		})
+	}
+}
+
+func TestBackupTo(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{NumConns: 2})
+	for _, name := range []string{"a", "b/c"} {
+		i, _ := prov.NewInstance(name)
+		require.NoError(t, i.Put(bytes.NewBufferString(name)))
+	}
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	require.NoError(t, prov.BackupToPath(backupPath))
+	// The source is still usable.
+	i, _ := prov.NewInstance("d")
+	require.NoError(t, i.Put(bytes.NewBufferString("d")))
+	conns, provOpts, err := NewPool(NewPoolOpts{Path: backupPath})
+	require.NoError(t, err)
+	backup, err := NewProvider(conns, provOpts)
+	require.NoError(t, err)
+	defer backup.Close()
+	for _, name := range []string{"a", "b/c"} {
+		i, _ := backup.NewInstance(name)
+		r, err := i.Get()
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, name, string(b))
+	}
+	d, _ := backup.NewInstance("d")
+	_, err = d.Stat()
+	assert.Error(t, err)
+}
+
+func TestPrefixQuota(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{})
+	require.NoError(t, prov.SetPrefixQuota("a/", 5))
+	put := func(name, data string) error {
+		i, _ := prov.NewInstance(name)
+		return i.Put(bytes.NewBufferString(name + data))
+	}
+	exists := func(name string) bool {
+		i, _ := prov.NewInstance(name)
+		_, err := i.Stat()
+		return err == nil
+	}
+	require.NoError(t, put("b/1", "zz"))
+	require.NoError(t, put("a/1", "x"))
+	require.NoError(t, put("a/2", "y"))
+	// The earlier blob under a/ was evicted to fit the quota, and others were untouched.
+	assert.False(t, exists("a/1"))
+	assert.True(t, exists("a/2"))
+	assert.True(t, exists("b/1"))
+	assert.True(t, errors.Is(put("a/3", "toolong"), types.ErrCapacityExceeded))
+	// Setting a quota trims blobs that are already over it.
+	require.NoError(t, prov.SetPrefixQuota("b/", 1))
+	assert.False(t, exists("b/1"))
+	conn := conns.Get(context.Background())
+	quotas, err := PrefixQuotas(conn)
+	conns.Put(conn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"a/": 5, "b/": 1}, quotas)
+	require.NoError(t, prov.UnsetPrefixQuota("a/"))
+	assert.NoError(t, put("a/3", "toolong"))
+}
+
+func TestCompression(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{ChunkSize: 1 << 10, Compression: FlateCompression(flate.BestSpeed)})
+	data := bytes.Repeat([]byte("compressible "), 1000)
+	i, _ := prov.NewInstance("a/0")
+	require.NoError(t, i.Put(bytes.NewReader(data)))
+	usage, err := prov.Usage()
+	require.NoError(t, err)
+	assert.Less(t, usage, int64(len(data)/4))
+	fi, err := i.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, len(data), fi.Size())
+	b := make([]byte, 20)
+	_, err = i.ReadAt(b, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, data[2000:2020], b)
+	_, err = i.WriteAt([]byte("hello"), 1022)
+	require.NoError(t, err)
+	copy(data[1022:], "hello")
+	var buf bytes.Buffer
+	_, err = prov.WriteConsecutiveChunks("a/", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+	// Each chunk is compressed on its own, which requires chunks.
+	conns, provOpts, err := NewPool(NewPoolOpts{Memory: true, Compression: FlateCompression(flate.BestSpeed)})
+	require.NoError(t, err)
+	defer conns.Close()
+	_, err = NewProvider(conns, provOpts)
+	assert.Error(t, err)
+}
+
+func TestEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sqlite3.db")
+	key := bytes.Repeat([]byte{1}, 32)
+	open := func(key []byte) (*Provider, error) {
+		conns, provOpts, err := NewPool(NewPoolOpts{
+			Path:          path,
+			ChunkSize:     4,
+			EncryptionKey: key,
+			Compression:   FlateCompression(flate.BestSpeed),
+		})
+		require.NoError(t, err)
+		prov, err := NewProvider(conns, provOpts)
+		if err != nil {
+			conns.Close()
+		}
+		return prov, err
+	}
+	prov, err := open(key)
+	require.NoError(t, err)
+	i, _ := prov.NewInstance("a")
+	require.NoError(t, i.Put(bytes.NewBufferString("secret data, secret data")))
+	_, err = i.WriteAt([]byte("S"), 0)
+	require.NoError(t, err)
+	var plain bool
+	require.NoError(t, prov.withConn(func(conn conn) error {
+		return sqlitex.Exec(conn, "select count(*) from blob_chunk where instr(data, 'ecre')", func(stmt *sqlite.Stmt) error {
+			plain = stmt.ColumnInt(0) != 0
+			return nil
+		})
+	}, false))
+	assert.False(t, plain)
+	require.NoError(t, prov.Close())
+	_, err = open(nil)
+	assert.Error(t, err)
+	_, err = open(bytes.Repeat([]byte{2}, 32))
+	assert.Error(t, err)
+	prov, err = open(key)
+	require.NoError(t, err)
+	defer prov.Close()
+	i, _ = prov.NewInstance("a")
+	fi, err := i.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, 24, fi.Size())
+	rc, err := i.Get()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "Secret data, secret data", string(b))
+}
+
+func TestStats(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{Capacity: 4})
+	put := func(name, data string) {
+		i, _ := prov.NewInstance(name)
+		require.NoError(t, i.Put(bytes.NewBufferString(data)))
+	}
+	put("a", "xy")
+	put("b", "z")
+	put("c", "zz")
+	i, _ := prov.NewInstance("c")
+	_, err := i.ReadAt(make([]byte, 2), 0)
+	require.NoError(t, err)
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, stats.UsedBytes)
+	assert.EqualValues(t, 2, stats.Blobs)
+	assert.EqualValues(t, 4, stats.Capacity)
+	assert.True(t, stats.CapacityLimited)
+	assert.EqualValues(t, 1, stats.Evictions)
+	assert.EqualValues(t, 2, stats.EvictedBytes)
+	assert.EqualValues(t, 3, stats.Operations["put"].Count)
+	assert.EqualValues(t, 0, stats.Operations["put"].Errors)
+	assert.EqualValues(t, 1, stats.Operations["read"].Count)
+	assert.True(t, stats.Operations["put"].MaxDuration >= stats.Operations["put"].MeanDuration())
+	// Recorded accesses may be flushed in their own batches.
+	assert.True(t, stats.Batches.Writes >= 3)
+	assert.True(t, stats.Batches.MaxWrites >= 1)
+}
+
+type testMetrics struct {
+	mu           sync.Mutex
+	operations   map[string]int
+	batchWrites  int
+	evictedBlobs int64
+	evictedBytes int64
+}
+
+func (me *testMetrics) ObserveOperation(kind string, dur time.Duration, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.operations[kind]++
+}
+
+func (me *testMetrics) ObserveBatch(writes int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.batchWrites += writes
+}
+
+func (me *testMetrics) ObserveEvictions(blobs, bytes int64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.evictedBlobs += blobs
+	me.evictedBytes += bytes
+}
+
+func (me *testMetrics) ObserveBusy() {}
+
+func TestMetrics(t *testing.T) {
+	m := &testMetrics{operations: make(map[string]int)}
+	_, prov := newConnsAndProv(t, NewPoolOpts{Capacity: 5, Metrics: m})
+	for _, name := range []string{"a", "b", "c"} {
+		i, _ := prov.NewInstance(name)
+		require.NoError(t, i.Put(bytes.NewBufferString("xy")))
+	}
+	evicted, err := prov.EvictPrefix("c")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, evicted)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assert.Equal(t, 3, m.operations["put"])
+	assert.True(t, m.batchWrites >= 3)
+	// "a" for the capacity, and then "c".
+	assert.EqualValues(t, 2, m.evictedBlobs)
+	assert.EqualValues(t, 4, m.evictedBytes)
+}
+
+func TestVacuum(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{})
+	for i := 0; i < 10; i++ {
+		inst, _ := prov.NewInstance(fmt.Sprintf("a/%d", i))
+		require.NoError(t, inst.Put(bytes.NewReader(make([]byte, 1<<15))))
+	}
+	_, err := prov.DeletePrefix("a/")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = prov.Vacuum(ctx, 0)
+	assert.Equal(t, context.Canceled, err)
+	freed, err := prov.Vacuum(context.Background(), 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, freed)
+	freed, err = prov.Vacuum(context.Background(), 0)
+	require.NoError(t, err)
+	assert.True(t, freed > 0)
+	conn := conns.Get(context.Background())
+	free, err := freelistCount(conn)
+	conns.Put(conn)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, free)
+}
+
+func TestScheduledVacuum(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{VacuumInterval: time.Millisecond})
+	inst, _ := prov.NewInstance("a")
+	require.NoError(t, inst.Put(bytes.NewReader(make([]byte, 1<<15))))
+	require.NoError(t, inst.Delete())
+	assert.Eventually(t, func() bool {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		free, err := freelistCount(conn)
+		return err == nil && free == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestCheckpoint(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{})
+	inst, _ := prov.NewInstance("a")
+	require.NoError(t, inst.Put(bytes.NewReader(make([]byte, 1<<15))))
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	assert.True(t, stats.Wal.Bytes > 0)
+	_, err = prov.Checkpoint(context.Background(), "truncate; drop table blob")
+	assert.Error(t, err)
+	cp, err := prov.Checkpoint(context.Background(), "TRUNCATE")
+	require.NoError(t, err)
+	assert.False(t, cp.Busy)
+	stats, err = prov.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, stats.Wal.Bytes)
+	// The bad mode was rejected before running.
+	assert.EqualValues(t, 1, stats.Wal.Checkpoints)
+	assert.Equal(t, cp, stats.Wal.Last)
+}
+
+func TestWalCheckpointBytes(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{WalCheckpointBytes: 1 << 16})
+	for i := 0; i < 3; i++ {
+		inst, _ := prov.NewInstance(fmt.Sprintf("a/%d", i))
+		require.NoError(t, inst.Put(bytes.NewReader(make([]byte, 1<<15))))
+	}
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stats.Wal.Checkpoints)
+}
+
+func TestDeleteExpired(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{ReadCacheSize: 8})
+	old, _ := prov.NewInstance("old")
+	require.NoError(t, old.Put(bytes.NewBufferString("hello")))
+	new, _ := prov.NewInstance("new")
+	require.NoError(t, new.Put(bytes.NewBufferString("hello")))
+	func() {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, sqlitex.Exec(conn, "update blob set last_used=datetime('now', '-2 days') where name='old'", nil))
+	}()
+	deleted, err := prov.DeleteExpired(context.Background(), 24*time.Hour)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, deleted)
+	_, err = old.Stat()
+	assert.Error(t, err)
+	_, err = new.Stat()
+	assert.NoError(t, err)
+	deleted, err = prov.DeleteExpired(context.Background(), 24*time.Hour)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, deleted)
+}
+
+func TestScheduledExpiry(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{MaxAge: time.Hour, ExpiryInterval: time.Millisecond})
+	inst, _ := prov.NewInstance("a")
+	require.NoError(t, inst.Put(bytes.NewBufferString("hello")))
+	func() {
+		conn := conns.Get(context.Background())
+		defer conns.Put(conn)
+		require.NoError(t, sqlitex.Exec(conn, "update blob set last_used='2000-01-01 00:00:00'", nil))
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := inst.Stat()
+		return err != nil
+	}, time.Second, time.Millisecond)
+}
+
+func TestPieceCompletion(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{})
+	_, ok, err := prov.GetPieceCompletion("abc", 0)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, prov.SetPieceCompletion("abc", 0, true))
+	require.NoError(t, prov.SetPieceCompletion("abc", 1, false))
+	complete, ok, err := prov.GetPieceCompletion("abc", 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, complete)
+	require.NoError(t, prov.SetPieceCompletion("abc", 0, false))
+	complete, ok, err = prov.GetPieceCompletion("abc", 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, complete)
+	require.NoError(t, prov.DeletePieceCompletions("abc"))
+	_, ok, err = prov.GetPieceCompletion("abc", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestInstanceContext(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{})
+	ctx, cancel := context.WithCancel(context.Background())
+	i, err := prov.NewInstanceContext(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, i.Put(bytes.NewBufferString("hello")))
+	cancel()
+	err = i.Put(bytes.NewBufferString("world"))
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	_, err = i.ReadAt(make([]byte, 5), 0)
+	assert.Error(t, err)
+	// Instances without the context are unaffected, and the cancelled write wasn't made.
+	other, _ := prov.NewInstance("a")
+	b := make([]byte, 5)
+	n, _ := other.ReadAt(b, 0)
+	assert.Equal(t, "hello", string(b[:n]))
+}
+
+func TestReadCache(t *testing.T) {
+	_, prov := newConnsAndProv(t, NewPoolOpts{ReadCacheSize: 8, Capacity: 10})
+	a, _ := prov.NewInstance("a")
+	require.NoError(t, a.Put(bytes.NewBufferString("hello")))
+	read := func(i resource.Instance) string {
+		b := make([]byte, 4)
+		n, _ := i.ReadAt(b, 1)
+		return string(b[:n])
+	}
+	assert.Equal(t, "ello", read(a))
+	assert.Equal(t, "ello", read(a))
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stats.ReadCache.Hits)
+	assert.EqualValues(t, 4, stats.ReadCache.Bytes)
+	rs := prov.ReadStats()
+	assert.EqualValues(t, 2, rs.Reads)
+	assert.EqualValues(t, 1, rs.CacheHits)
+	assert.EqualValues(t, 1, rs.CacheMisses)
+	assert.EqualValues(t, 4, rs.CacheBytes)
+	assert.EqualValues(t, 4, rs.BackingBytes)
+	// Writes invalidate the blob's cached reads.
+	_, err = a.WriteAt([]byte("E"), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Ello", read(a))
+	// Eviction of a by a write to another blob invalidates the cache too.
+	b, _ := prov.NewInstance("b")
+	require.NoError(t, b.Put(bytes.NewBufferString("worlds")))
+	assert.Equal(t, "", read(a))
+	require.NoError(t, b.Delete())
+	assert.Equal(t, "", read(b))
+}
+
+func TestCoalesceChunks(t *testing.T) {
+	for _, opts := range []NewPoolOpts{{}, {ChunkSize: 3}} {
+		_, prov := newConnsAndProv(t, opts)
+		for _, c := range []struct {
+			name, data string
+		}{{"incompleted/a/0", "hel"}, {"incompleted/a/3", "lo w"}, {"incompleted/a/7", "orld"}} {
+			i, err := prov.NewInstance(c.name)
+			require.NoError(t, err)
+			require.NoError(t, i.Put(bytes.NewBufferString(c.data)))
+		}
+		ctx := context.Background()
+		err := prov.CoalesceChunks(ctx, "incompleted/a/", "completed/a", 12)
+		assert.Error(t, err)
+		require.NoError(t, prov.CoalesceChunks(ctx, "incompleted/a/", "completed/a", 11))
+		stats, err := prov.Stats()
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, stats.Blobs)
+		i, err := prov.NewInstance("completed/a")
+		require.NoError(t, err)
+		b := make([]byte, 11)
+		n, _ := i.ReadAt(b, 0)
+		assert.Equal(t, "hello world", string(b[:n]))
+		dir, err := prov.NewInstance("incompleted/a")
+		require.NoError(t, err)
+		names, _ := dir.(resource.DirInstance).Readdirnames()
+		assert.Empty(t, names)
+	}
+}
+
+func TestDurableCompletion(t *testing.T) {
+	conns, prov := newConnsAndProv(t, NewPoolOpts{
+		NumConns:          1,
+		DurableCompletion: true,
+	})
+	i, err := prov.NewInstance("incompleted/a/0")
+	require.NoError(t, err)
+	require.NoError(t, i.Put(bytes.NewBufferString("hello")))
+	require.NoError(t, prov.CoalesceChunks(context.Background(), "incompleted/a/", "completed/a", 5))
+	require.NoError(t, prov.SetPieceCompletion("a", 0, false))
+	stats, err := prov.Stats()
+	require.NoError(t, err)
+	// Marking a piece not complete doesn't sync.
+	assert.EqualValues(t, 1, stats.Operations["sync"].Count)
+	conn := conns.Get(context.Background())
+	defer conns.Put(conn)
+	var synchronous int
+	require.NoError(t, sqlitex.ExecTransient(conn, "pragma synchronous", func(stmt *sqlite.Stmt) error {
+		synchronous = stmt.ColumnInt(0)
+		return nil
+	}))
+	// Restored to the default of off.
+	assert.Equal(t, 0, synchronous)
+}
+
+func TestReadRanges(t *testing.T) {
+	for _, chunkSize := range []int64{0, 4} {
+		_, prov := newConnsAndProv(t, NewPoolOpts{ChunkSize: chunkSize})
+		for name, data := range map[string]string{"a": "hello", "b": ", world"} {
+			i, err := prov.NewInstance(name)
+			require.NoError(t, err)
+			require.NoError(t, i.Put(bytes.NewBufferString(data)))
+		}
+		ranges := []types.ReadRange{
+			{Name: "a", Off: 1, Buf: make([]byte, 3)},
+			{Name: "b", Off: 2, Buf: make([]byte, 10)},
+			{Name: "c", Buf: make([]byte, 1)},
+		}
+		require.NoError(t, prov.ReadRanges(ranges), chunkSize)
+		assert.Equal(t, "ell", string(ranges[0].Buf[:ranges[0].N]), chunkSize)
+		assert.Equal(t, "world", string(ranges[1].Buf[:ranges[1].N]), chunkSize)
+		assert.Equal(t, 0, ranges[2].N, chunkSize)
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	for _, opts := range []NewPoolOpts{
+		{MaxBatchWrites: 2},
+		{MaxBatchBytes: 1500},
+	} {
+		_, prov := newConnsAndProv(t, opts)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				inst, _ := prov.NewInstance(fmt.Sprintf("a/%d", i))
+				assert.NoError(t, inst.Put(bytes.NewReader(make([]byte, 1000))))
+			}(i)
+		}
+		wg.Wait()
+		require.NoError(t, prov.Flush())
+		stats, err := prov.Stats()
+		require.NoError(t, err)
+		assert.EqualValues(t, 21, stats.Batches.Writes)
+		assert.True(t, stats.Batches.MaxWrites <= 2, stats.Batches.MaxWrites)
+	}
+}
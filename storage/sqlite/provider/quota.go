@@ -0,0 +1,95 @@
+package sqliteProvider
+
+import (
+	"fmt"
+	"strings"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/anacrolix/torrent/storage/sqlite/schema"
+	"github.com/anacrolix/torrent/storage/types"
+)
+
+// Quotas are stored in the setting table with this before the prefix in the name.
+const quotaSettingPrefix = "quota:"
+
+const overQuotaViewFormat = schema.OverQuotaViewFormat
+
+// Replaces the over_quota_blob view, which orders blobs for eviction by the key.
+func setOverQuotaView(conn conn, key string) error {
+	err := sqlitex.ExecTransient(conn, "drop view if exists over_quota_blob", nil)
+	if err != nil {
+		return err
+	}
+	return sqlitex.ExecScript(conn, fmt.Sprintf(overQuotaViewFormat, key, blobSizeExpr))
+}
+
+// Limits the total size of blobs with names starting with prefix. When a write takes the blobs with
+// a prefix over their quota, they're evicted in the order of the EvictionPolicy until they fit,
+// before any blobs are evicted for the overall capacity. This keeps blobs under one prefix from
+// evicting everything else. The parent package stores pieces by their hash, shared between
+// torrents, unless NewPoolOpts.PerTorrentPrefix puts them under their torrent's infohash (see
+// sqliteStorage.SetTorrentQuota). While no quota is set, writes don't check for blobs over them.
+func SetPrefixQuota(conn conn, prefix string, quota int64) (err error) {
+	defer sqlitex.Save(conn)(&err)
+	err = sqlitex.Exec(conn, "insert into setting values (?, ?)", nil, quotaSettingPrefix+prefix, quota)
+	if err != nil {
+		return
+	}
+	// Blobs already over the new quota are evicted now, rather than on the next write.
+	_, err = evictBlobs(conn, "delete from blob where rowid in (select blob_rowid from over_quota_blob)")
+	return
+}
+
+// Removes the quota for the prefix.
+func UnsetPrefixQuota(conn conn, prefix string) error {
+	return sqlitex.Exec(conn, "delete from setting where name=?", nil, quotaSettingPrefix+prefix)
+}
+
+// Returns the quotas set by SetPrefixQuota, by prefix.
+func PrefixQuotas(conn conn) (quotas map[string]int64, err error) {
+	quotas = make(map[string]int64)
+	err = sqlitex.Exec(conn, "select name, value from setting where name glob 'quota:*'", func(stmt *sqlite.Stmt) error {
+		quotas[strings.TrimPrefix(stmt.ColumnText(0), quotaSettingPrefix)] = stmt.ColumnInt64(1)
+		return nil
+	})
+	return
+}
+
+// Returns an error if a blob of the given size would exceed the quota of a prefix of its name by
+// itself.
+func checkPrefixQuotas(conn conn, name string, size int64) error {
+	quotas, err := PrefixQuotas(conn)
+	if err != nil {
+		return err
+	}
+	for prefix, quota := range quotas {
+		if strings.HasPrefix(name, prefix) && size > quota {
+			return types.Error{
+				Kind: types.ErrCapacityExceeded,
+				Err:  fmt.Errorf("%v bytes exceeds quota of %v for prefix %q", size, quota, prefix),
+			}
+		}
+	}
+	return nil
+}
+
+// Sets the quota for the prefix. See SetPrefixQuota.
+func (p *Provider) SetPrefixQuota(prefix string, quota int64) error {
+	err := p.withConn(func(conn conn) error {
+		return SetPrefixQuota(conn, p.name(prefix), quota)
+	}, true)
+	if p.readCache != nil {
+		// Blobs over the quota were evicted.
+		p.readCache.invalidateAll()
+	}
+	return err
+}
+
+// Removes the quota for the prefix.
+func (p *Provider) UnsetPrefixQuota(prefix string) error {
+	return p.withConn(func(conn conn) error {
+		return UnsetPrefixQuota(conn, p.name(prefix))
+	}, true)
+}
@@ -0,0 +1,99 @@
+package sqliteProvider
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/anacrolix/torrent/storage/types"
+)
+
+// The most ranges read by each query in ReadRanges. Each takes 4 parameters, and sqlite allows 999
+// by default.
+const readRangesStep = 200
+
+// Reads the ranges with a query per readRangesStep ranges, rather than one each. With a
+// ChunkSize, the ranges are read one at a time, but with a single connection. Each range is
+// recorded as a read of its instance, and doesn't use the read cache.
+func (p *Provider) ReadRanges(ranges []types.ReadRange) (err error) {
+	started := time.Now()
+	defer func() {
+		for _, r := range ranges {
+			rangeErr := err
+			instance{location: p.name(r.Name), p: p}.observe("read", started, func() int64 { return int64(r.N) }, &rangeErr)
+		}
+	}()
+	for _, r := range ranges {
+		p.recordAccess(p.name(r.Name))
+	}
+	return p.withConn(func(conn conn) error {
+		if p.opts.ChunkSize != 0 {
+			for j := range ranges {
+				r := &ranges[j]
+				var err error
+				r.N, err = instance{location: p.name(r.Name), p: p}.readAt(conn, r.Buf, r.Off)
+				if err != nil && err != io.EOF && err != errBlobNotFound {
+					return fmt.Errorf("reading %q: %w", r.Name, err)
+				}
+			}
+			return nil
+		}
+		for len(ranges) != 0 {
+			step := ranges
+			if len(step) > readRangesStep {
+				step = step[:readRangesStep]
+			}
+			err := p.readRangesStep(conn, step)
+			if err != nil {
+				return err
+			}
+			ranges = ranges[len(step):]
+		}
+		return nil
+	}, false)
+}
+
+// Reads the ranges with a single query, of a select per range.
+func (p *Provider) readRangesStep(conn conn, ranges []types.ReadRange) error {
+	var selects []string
+	var args []interface{}
+	for j, r := range ranges {
+		selects = append(selects, "select ?, substr(cast(data as blob), ?, ?) from blob where name=?")
+		args = append(args, j, r.Off+1, len(r.Buf), p.name(r.Name))
+	}
+	for j := range ranges {
+		ranges[j].N = 0
+	}
+	return sqlitex.Exec(conn, strings.Join(selects, " union all "), func(stmt *sqlite.Stmt) error {
+		r := &ranges[stmt.ColumnInt(0)]
+		r.N = stmt.ColumnBytes(1, r.Buf)
+		return nil
+	}, args...)
+}
+
+// Reads the ranges from the shards their names are in.
+func (me *ShardedProvider) ReadRanges(ranges []types.ReadRange) error {
+	byShard := make(map[*Provider][]int)
+	for j, r := range ranges {
+		s := me.shard(r.Name)
+		byShard[s] = append(byShard[s], j)
+	}
+	for s, indexes := range byShard {
+		shardRanges := make([]types.ReadRange, 0, len(indexes))
+		for _, j := range indexes {
+			shardRanges = append(shardRanges, ranges[j])
+		}
+		err := s.ReadRanges(shardRanges)
+		for k, j := range indexes {
+			ranges[j].N = shardRanges[k].N
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
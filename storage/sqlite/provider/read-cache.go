@@ -0,0 +1,201 @@
+package sqliteProvider
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// A bounded LRU cache of ReadAt results, so that data read repeatedly, such as a piece uploaded to
+// several peers, isn't queried each time. See ProviderOpts.ReadCacheSize.
+type readCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      list.List
+	entries  map[readCacheKey]*list.Element
+	byName   map[string]map[*list.Element]struct{}
+	// Incremented by each invalidation. Reads only fill the cache if it's unchanged since they
+	// started, so they can't insert data a concurrent write replaced.
+	generation uint64
+	// The eviction count last seen. See noteEvictions.
+	evictions int64
+
+	hits, misses int64
+	hitBytes     int64
+}
+
+type readCacheKey struct {
+	name string
+	off  int64
+	len  int
+}
+
+type readCacheEntry struct {
+	key  readCacheKey
+	data []byte
+}
+
+func newReadCache(capacity int64) *readCache {
+	return &readCache{
+		capacity:  capacity,
+		entries:   make(map[readCacheKey]*list.Element),
+		byName:    make(map[string]map[*list.Element]struct{}),
+		evictions: -1,
+	}
+}
+
+// Copies the cached data for the read into p, returning false if there isn't any. Otherwise, it
+// returns the generation to pass to put.
+func (me *readCache) get(name string, p []byte, off int64) (ok bool, generation uint64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	e, ok := me.entries[readCacheKey{name, off, len(p)}]
+	if !ok {
+		me.misses++
+		expvars.Add("readCacheMisses", 1)
+		return false, me.generation
+	}
+	me.hits++
+	me.hitBytes += int64(len(p))
+	expvars.Add("readCacheHits", 1)
+	me.lru.MoveToFront(e)
+	copy(p, e.Value.(*readCacheEntry).data)
+	return true, 0
+}
+
+func (me *readCache) put(name string, data []byte, off int64, generation uint64) {
+	if int64(len(data)) > me.capacity {
+		return
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if generation != me.generation {
+		return
+	}
+	key := readCacheKey{name, off, len(data)}
+	if _, ok := me.entries[key]; ok {
+		return
+	}
+	e := me.lru.PushFront(&readCacheEntry{key, append([]byte(nil), data...)})
+	me.entries[key] = e
+	if me.byName[name] == nil {
+		me.byName[name] = make(map[*list.Element]struct{})
+	}
+	me.byName[name][e] = struct{}{}
+	me.size += int64(len(data))
+	for me.size > me.capacity {
+		me.remove(me.lru.Back())
+	}
+}
+
+func (me *readCache) remove(e *list.Element) {
+	entry := me.lru.Remove(e).(*readCacheEntry)
+	delete(me.entries, entry.key)
+	elems := me.byName[entry.key.name]
+	delete(elems, e)
+	if len(elems) == 0 {
+		delete(me.byName, entry.key.name)
+	}
+	me.size -= int64(len(entry.data))
+}
+
+// Drops cached reads of blobs with names starting with any of the prefixes.
+func (me *readCache) invalidatePrefixes(prefixes ...string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.generation++
+	for name, elems := range me.byName {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				for e := range elems {
+					me.remove(e)
+				}
+				break
+			}
+		}
+	}
+}
+
+// Drops cached reads of the named blob.
+func (me *readCache) invalidate(name string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.generation++
+	for e := range me.byName[name] {
+		me.remove(e)
+	}
+}
+
+func (me *readCache) invalidateAll() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.generation++
+	me.lru.Init()
+	me.entries = make(map[readCacheKey]*list.Element)
+	me.byName = make(map[string]map[*list.Element]struct{})
+	me.size = 0
+}
+
+// Blobs are evicted by triggers, so the cache can't tell which. Everything is dropped when the
+// eviction count changes.
+func (me *readCache) noteEvictions(evictions int64) {
+	me.mu.Lock()
+	changed := evictions != me.evictions
+	me.evictions = evictions
+	me.mu.Unlock()
+	if changed {
+		me.invalidateAll()
+	}
+}
+
+// Gets the eviction count within a write, if the read cache needs it.
+func (p *Provider) readCacheEvictions(conn conn, evictions *int64) (err error) {
+	if p.readCache == nil {
+		return nil
+	}
+	*evictions, err = getEvictions(conn)
+	return
+}
+
+// Invalidates cached reads after a write to the named blob, with the eviction count from
+// readCacheEvictions. If the write failed, it's unknown what was evicted.
+func (p *Provider) invalidateReads(name string, evictions int64, writeErr error) {
+	c := p.readCache
+	if c == nil {
+		return
+	}
+	if writeErr != nil {
+		c.invalidateAll()
+		return
+	}
+	c.invalidate(name)
+	c.noteEvictions(evictions)
+}
+
+func getEvictions(conn conn) (evictions int64, err error) {
+	err = sqlitex.Exec(conn, "select value from blob_meta where key='evictions'", func(stmt *sqlite.Stmt) error {
+		evictions = stmt.ColumnInt64(0)
+		return nil
+	})
+	return
+}
+
+func (me *readCache) stats() ReadCacheStats {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return ReadCacheStats{Hits: me.hits, Misses: me.misses, HitBytes: me.hitBytes, Bytes: me.size}
+}
+
+// See ProviderOpts.ReadCacheSize.
+type ReadCacheStats struct {
+	Hits   int64
+	Misses int64
+	// The data copied out of the cache by hits.
+	HitBytes int64
+	// The size of the data cached.
+	Bytes int64
+}
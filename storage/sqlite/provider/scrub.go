@@ -0,0 +1,233 @@
+package sqliteProvider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Scrubbing looks for data that has been silently corrupted on disk, so it isn't served again.
+// sqlite's quick_check finds damage to the database structure, and a BlobVerifier, given the data
+// of the blobs it recognizes, finds blobs that no longer hold what they should. Blobs are read one
+// at a time, each with its own connection, so other operations aren't held up for long.
+
+// Checks the data of blobs when scrubbing. See ProviderOpts.ScrubVerifier. Methods are called
+// concurrently for different Providers.
+type BlobVerifier interface {
+	// Whether the blob with the name, as given to NewInstance, can be checked. Other blobs aren't
+	// read.
+	Verifies(name string) bool
+	// Returns an error if the data isn't what the blob should hold.
+	Verify(name string, data []byte) error
+}
+
+// A blob found to be corrupt by Scrub.
+type CorruptBlob struct {
+	// As given to NewInstance.
+	Name string
+	// From the BlobVerifier, or from reading the data.
+	Err     error
+	Deleted bool
+}
+
+type ScrubResult struct {
+	// Messages from quick_check. Empty if it found no problems.
+	Integrity []string
+	// Blobs whose data was checked, and the bytes read from them.
+	Checked      int64
+	CheckedBytes int64
+	Corrupt      []CorruptBlob
+}
+
+func (me *ScrubResult) add(other ScrubResult) {
+	me.Integrity = append(me.Integrity, other.Integrity...)
+	me.Checked += other.Checked
+	me.CheckedBytes += other.CheckedBytes
+	me.Corrupt = append(me.Corrupt, other.Corrupt...)
+}
+
+// The most names listed by each query while scrubbing.
+const scrubStepBlobs = 100
+
+func quickCheck(conn conn) (problems []string, err error) {
+	err = sqlitex.ExecTransient(conn, "pragma quick_check", func(stmt *sqlite.Stmt) error {
+		if msg := stmt.ColumnText(0); msg != "ok" {
+			problems = append(problems, msg)
+		}
+		return nil
+	})
+	return
+}
+
+// Runs quick_check, then reads each blob the verifier recognizes and verifies its data. Blobs that
+// can't be read are corrupt too. If del is true, corrupt blobs are deleted. Reads are limited to
+// ProviderOpts.ScrubRate. Stops early if ctx is done. A nil verifier only runs quick_check.
+func (p *Provider) Scrub(ctx context.Context, verifier BlobVerifier, del bool) (ret ScrubResult, err error) {
+	defer func() {
+		expvars.Add("scrubbedBlobs", ret.Checked)
+		expvars.Add("corruptBlobs", int64(len(ret.Corrupt)))
+	}()
+	err = p.withConnContext(ctx, func(conn conn) (err error) {
+		ret.Integrity, err = quickCheck(conn)
+		return
+	}, false)
+	if err != nil {
+		err = fmt.Errorf("quick check: %w", err)
+		return
+	}
+	if verifier == nil {
+		return
+	}
+	prefix := p.name("")
+	lower := prefix
+	for {
+		var names []string
+		err = p.withConnContext(ctx, func(conn conn) error {
+			query := "select name from blob where name>=? order by name limit ?"
+			args := []interface{}{lower, scrubStepBlobs}
+			if prefix != "" {
+				query = "select name from blob where name>=? and name<? order by name limit ?"
+				args = []interface{}{lower, prefixEnd(prefix), scrubStepBlobs}
+			}
+			return sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+				names = append(names, stmt.ColumnText(0))
+				return nil
+			}, args...)
+		}, false)
+		if err != nil {
+			return
+		}
+		for _, stored := range names {
+			name := strings.TrimPrefix(stored, prefix)
+			if !verifier.Verifies(name) {
+				continue
+			}
+			i := instance{location: stored, p: p, ctx: ctx}
+			data, found, readErr := i.readAll()
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			if !found {
+				// Deleted since it was listed.
+				continue
+			}
+			ret.Checked++
+			ret.CheckedBytes += int64(len(data))
+			if readErr == nil {
+				readErr = verifier.Verify(name, data)
+			}
+			if readErr != nil {
+				corrupt := CorruptBlob{Name: name, Err: readErr}
+				if del {
+					err = i.Delete()
+					if err != nil {
+						err = fmt.Errorf("deleting corrupt blob %q: %w", name, err)
+						return
+					}
+					corrupt.Deleted = true
+				}
+				ret.Corrupt = append(ret.Corrupt, corrupt)
+			}
+			err = p.scrubPause(ctx, len(data))
+			if err != nil {
+				return
+			}
+		}
+		if len(names) < scrubStepBlobs {
+			return
+		}
+		lower = names[len(names)-1] + "\x00"
+	}
+}
+
+// Reads the blob's data, without counting it as an access. found is false if the blob doesn't
+// exist. An error reading the data is returned with what could be read.
+func (i instance) readAll() (data []byte, found bool, err error) {
+	err = i.withConn(func(conn conn) error {
+		fi, err := i.stat(conn)
+		if err == errBlobNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		data = make([]byte, fi.size)
+		n, err := i.readAt(conn, data, 0)
+		if errors.Is(err, io.EOF) && int64(n) == fi.size {
+			err = nil
+		}
+		data = data[:n]
+		return err
+	}, false)
+	return
+}
+
+// Waits long enough after reading n bytes to keep to ProviderOpts.ScrubRate.
+func (p *Provider) scrubPause(ctx context.Context, n int) error {
+	rate := p.opts.ScrubRate
+	if rate <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(time.Duration(int64(n) * int64(time.Second) / rate))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Runs Scrub every ProviderOpts.ScrubInterval until stop is closed, passing corrupt blobs to
+// ProviderOpts.OnCorruptBlob.
+func (p *Provider) scrubber(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.opts.ScrubInterval)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		expvars.Add("scheduledScrubs", 1)
+		res, err := p.Scrub(ctx, p.opts.ScrubVerifier, p.opts.ScrubDelete)
+		if err != nil && ctx.Err() == nil {
+			expvars.Add("scheduledScrubErrors", 1)
+		}
+		if len(res.Integrity) != 0 {
+			expvars.Add("integrityCheckFailures", 1)
+		}
+		if f := p.opts.OnCorruptBlob; f != nil {
+			for _, cb := range res.Corrupt {
+				f(cb)
+			}
+		}
+	}
+}
+
+// Scrubs each shard in turn.
+func (me *ShardedProvider) Scrub(ctx context.Context, verifier BlobVerifier, del bool) (ret ScrubResult, err error) {
+	for i, s := range me.shards {
+		var res ScrubResult
+		res, err = s.Scrub(ctx, verifier, del)
+		ret.add(res)
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,232 @@
+package sqliteProvider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/missinggo/v2/resource"
+)
+
+// Spreads blobs across several Providers, each with its own database file, to avoid a single
+// database becoming a write bottleneck or growing unwieldy. Blobs are assigned to a shard by a hash
+// of their name up to the second "/", so that a piece's chunks, and the directory listing them,
+// share a shard.
+type ShardedProvider struct {
+	shards []*Provider
+}
+
+var _ resource.Provider = (*ShardedProvider)(nil)
+
+// Opens NewPoolOpts.Shards databases at the path with the shard index appended, such as
+// "storage.db.0". The capacity, if set, is divided evenly between the shards.
+func NewShardedProvider(opts NewPoolOpts) (_ *ShardedProvider, err error) {
+	if opts.Shards < 1 {
+		return nil, errors.New("no shards")
+	}
+	if opts.Memory {
+		// In-memory databases with a shared cache are the same database.
+		return nil, errors.New("sharding requires a path")
+	}
+	ret := &ShardedProvider{}
+	defer func() {
+		if err != nil {
+			ret.Close()
+		}
+	}()
+	path := opts.Path
+	opts.Capacity /= int64(opts.Shards)
+	opts.NamespaceCapacity /= int64(opts.Shards)
+	for i := 0; i < opts.Shards; i++ {
+		opts.Path = fmt.Sprintf("%s.%d", path, i)
+		conns, provOpts, err := NewPool(opts)
+		if err != nil {
+			return nil, fmt.Errorf("opening shard %d: %w", i, err)
+		}
+		prov, err := NewProvider(conns, provOpts)
+		if err != nil {
+			conns.Close()
+			return nil, fmt.Errorf("opening shard %d: %w", i, err)
+		}
+		ret.shards = append(ret.shards, prov)
+	}
+	return ret, nil
+}
+
+func shardKey(name string) string {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return name
+	}
+	if j := strings.IndexByte(name[i+1:], '/'); j >= 0 {
+		return name[:i+1+j]
+	}
+	return name
+}
+
+func (me *ShardedProvider) shard(name string) *Provider {
+	h := fnv.New32a()
+	h.Write([]byte(shardKey(name)))
+	return me.shards[h.Sum32()%uint32(len(me.shards))]
+}
+
+// Returns the shards that may contain names with the prefix.
+func (me *ShardedProvider) prefixShards(prefix string) []*Provider {
+	if strings.Count(prefix, "/") >= 2 {
+		return []*Provider{me.shard(prefix)}
+	}
+	return me.shards
+}
+
+func (me *ShardedProvider) NewInstance(name string) (resource.Instance, error) {
+	i, err := me.shard(name).NewInstance(name)
+	if err != nil {
+		return nil, err
+	}
+	return shardedInstance{i, me, name, nil}, nil
+}
+
+// See Provider.NewInstanceContext.
+func (me *ShardedProvider) NewInstanceContext(ctx context.Context, name string) (resource.Instance, error) {
+	i, err := me.shard(name).NewInstanceContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return shardedInstance{i, me, name, ctx}, nil
+}
+
+type shardedInstance struct {
+	resource.Instance
+	p    *ShardedProvider
+	name string
+	// Nil for each shard's own context.
+	ctx context.Context
+}
+
+func (i shardedInstance) Readdirnames() (names []string, err error) {
+	seen := make(map[string]struct{})
+	for _, s := range i.p.prefixShards(i.name + "/") {
+		var shardNames []string
+		shardNames, err = instance{location: s.name(i.name), p: s, ctx: i.ctx}.Readdirnames()
+		if err != nil {
+			return
+		}
+		for _, n := range shardNames {
+			if _, ok := seen[n]; !ok {
+				seen[n] = struct{}{}
+				names = append(names, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// Writes the blobs from each shard that may have the prefix in turn. This is only ordered if the
+// blobs with the prefix are all on one shard, which is the case for piece storage.
+func (me *ShardedProvider) WriteConsecutiveChunks(prefix string, w io.Writer) (written int64, err error) {
+	for _, s := range me.prefixShards(prefix) {
+		var n int64
+		n, err = s.WriteConsecutiveChunks(prefix, w)
+		written += n
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Coalesces within a shard if the chunks and the blob share one. Otherwise the blob is put on its
+// shard before the chunks are deleted from theirs, so the data isn't lost if either fails.
+func (me *ShardedProvider) CoalesceChunks(ctx context.Context, prefix, name string, length int64) error {
+	shards := me.prefixShards(prefix)
+	dest := me.shard(name)
+	if len(shards) == 1 && shards[0] == dest {
+		return dest.CoalesceChunks(ctx, prefix, name, length)
+	}
+	var buf bytes.Buffer
+	n, err := me.WriteConsecutiveChunks(prefix, &buf)
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("chunks have %v bytes, expected %v", n, length)
+	}
+	i, err := dest.NewInstanceContext(ctx, name)
+	if err != nil {
+		return err
+	}
+	err = i.Put(&buf)
+	if err != nil {
+		return err
+	}
+	err = dest.syncCompletion(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = me.DeletePrefixes([]string{prefix})
+	return err
+}
+
+func (me *ShardedProvider) DeletePrefixes(prefixes []string) (deleted int64, err error) {
+	byShard := make(map[*Provider][]string)
+	for _, prefix := range prefixes {
+		for _, s := range me.prefixShards(prefix) {
+			byShard[s] = append(byShard[s], prefix)
+		}
+	}
+	for s, prefixes := range byShard {
+		var n int64
+		n, err = s.DeletePrefixes(prefixes)
+		deleted += n
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (me *ShardedProvider) Ping() error {
+	for i, s := range me.shards {
+		if err := s.Ping(); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (me *ShardedProvider) Flush() error {
+	for i, s := range me.shards {
+		if err := s.Flush(); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Returns the total size of blobs across the shards, as counted toward their capacities.
+func (me *ShardedProvider) Usage() (total int64, err error) {
+	for _, s := range me.shards {
+		var n int64
+		n, err = s.Usage()
+		total += n
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (me *ShardedProvider) Close() (err error) {
+	for i, s := range me.shards {
+		if closeErr := s.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing shard %d: %w", i, closeErr)
+		}
+	}
+	return
+}
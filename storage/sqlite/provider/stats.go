@@ -0,0 +1,234 @@
+package sqliteProvider
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/anacrolix/torrent/storage/types"
+)
+
+// A snapshot of a Provider's storage and activity, for monitoring or for adjusting capacity. Usage
+// and eviction counts are read from the database, and persist across restarts. Batch and operation
+// counts are for the lifetime of the Provider.
+type Stats struct {
+	// Bytes of blob data stored, as for Usage.
+	UsedBytes int64
+	Blobs     int64
+	// The capacity set by SetCapacity. Zero if CapacityLimited is false.
+	Capacity        int64
+	CapacityLimited bool
+	// Blobs evicted to stay within the capacity or a prefix quota, and their size.
+	Evictions    int64
+	EvictedBytes int64
+	Batches      BatchStats
+	ReadCache    ReadCacheStats
+	Wal          WalStats
+	// By the Operation Kind, such as "read", "write", "get" and "put".
+	Operations map[string]OperationStats
+}
+
+// Write transactions committed by the writer when ProviderOpts.BatchWrites is set.
+type BatchStats struct {
+	Transactions int64
+	// The total writes across all transactions.
+	Writes int64
+	// The most writes committed in a single transaction.
+	MaxWrites int64
+}
+
+func (me *BatchStats) add(other BatchStats) {
+	me.Transactions += other.Transactions
+	me.Writes += other.Writes
+	if other.MaxWrites > me.MaxWrites {
+		me.MaxWrites = other.MaxWrites
+	}
+}
+
+type OperationStats struct {
+	Count  int64
+	Errors int64
+	// Bytes read or written, for the operations that transfer data.
+	Bytes int64
+	// The sum of the durations of all the operations.
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+}
+
+func (me OperationStats) MeanDuration() time.Duration {
+	if me.Count == 0 {
+		return 0
+	}
+	return me.TotalDuration / time.Duration(me.Count)
+}
+
+func (me *OperationStats) add(other OperationStats) {
+	me.Count += other.Count
+	me.Errors += other.Errors
+	me.Bytes += other.Bytes
+	me.TotalDuration += other.TotalDuration
+	if other.MaxDuration > me.MaxDuration {
+		me.MaxDuration = other.MaxDuration
+	}
+}
+
+type batchStats struct {
+	mu sync.Mutex
+	BatchStats
+	// From ProviderOpts.Metrics.
+	metrics Metrics
+}
+
+func (me *batchStats) record(writes int) {
+	if me.metrics != nil {
+		me.metrics.ObserveBatch(writes)
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.add(BatchStats{Transactions: 1, Writes: int64(writes), MaxWrites: int64(writes)})
+}
+
+// Counters kept in memory by the Provider.
+type providerStats struct {
+	batches      batchStats
+	wal          walStats
+	operationsMu sync.Mutex
+	operations   map[string]OperationStats
+}
+
+func (me *providerStats) recordOperation(kind string, dur time.Duration, bytes int64, err error) {
+	op := OperationStats{Count: 1, Bytes: bytes, TotalDuration: dur, MaxDuration: dur}
+	if err != nil {
+		op.Errors = 1
+	}
+	me.operationsMu.Lock()
+	defer me.operationsMu.Unlock()
+	if me.operations == nil {
+		me.operations = make(map[string]OperationStats)
+	}
+	cur := me.operations[kind]
+	cur.add(op)
+	me.operations[kind] = cur
+}
+
+func (me *providerStats) operation(kind string) OperationStats {
+	me.operationsMu.Lock()
+	defer me.operationsMu.Unlock()
+	return me.operations[kind]
+}
+
+func (me *providerStats) copyInto(s *Stats) {
+	me.batches.mu.Lock()
+	s.Batches.add(me.batches.BatchStats)
+	me.batches.mu.Unlock()
+	wal := me.wal.copy()
+	wal.Bytes = s.Wal.Bytes
+	s.Wal = wal
+	me.operationsMu.Lock()
+	defer me.operationsMu.Unlock()
+	s.addOperations(me.operations)
+}
+
+func (me *Stats) addOperations(ops map[string]OperationStats) {
+	if me.Operations == nil {
+		me.Operations = make(map[string]OperationStats, len(ops))
+	}
+	for kind, op := range ops {
+		cur := me.Operations[kind]
+		cur.add(op)
+		me.Operations[kind] = cur
+	}
+}
+
+func (p *Provider) Stats() (ret Stats, err error) {
+	err = p.withConn(func(conn conn) (err error) {
+		err = sqlitex.Exec(conn, `
+			select
+				(select value from blob_meta where key='size'),
+				(select count(*) from blob),
+				(select value from blob_meta where key='evictions'),
+				(select value from blob_meta where key='evicted_bytes')`,
+			func(stmt *sqlite.Stmt) error {
+				ret.UsedBytes = stmt.ColumnInt64(0)
+				ret.Blobs = stmt.ColumnInt64(1)
+				ret.Evictions = stmt.ColumnInt64(2)
+				ret.EvictedBytes = stmt.ColumnInt64(3)
+				return nil
+			})
+		if err != nil {
+			return
+		}
+		ret.Capacity, ret.CapacityLimited, err = getCapacity(conn)
+		if err != nil {
+			return
+		}
+		ret.Wal.Bytes, err = walBytes(conn)
+		return
+	}, false)
+	if err != nil {
+		return
+	}
+	p.stats.copyInto(&ret)
+	if p.readCache != nil {
+		ret.ReadCache = p.readCache.stats()
+	}
+	return
+}
+
+// Sums the Stats of each shard. The capacity is only limited if it is for every shard.
+func (me *ShardedProvider) Stats() (ret Stats, err error) {
+	ret.CapacityLimited = true
+	for i, s := range me.shards {
+		var ss Stats
+		ss, err = s.Stats()
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+		ret.UsedBytes += ss.UsedBytes
+		ret.Blobs += ss.Blobs
+		ret.Capacity += ss.Capacity
+		ret.CapacityLimited = ret.CapacityLimited && ss.CapacityLimited
+		ret.Evictions += ss.Evictions
+		ret.EvictedBytes += ss.EvictedBytes
+		ret.Batches.add(ss.Batches)
+		ret.ReadCache.Hits += ss.ReadCache.Hits
+		ret.ReadCache.Misses += ss.ReadCache.Misses
+		ret.ReadCache.HitBytes += ss.ReadCache.HitBytes
+		ret.ReadCache.Bytes += ss.ReadCache.Bytes
+		ret.Wal.add(ss.Wal)
+		ret.addOperations(ss.Operations)
+	}
+	if !ret.CapacityLimited {
+		ret.Capacity = 0
+	}
+	return
+}
+
+// Reports ReadAt activity, without querying the database as Stats does.
+func (p *Provider) ReadStats() types.ReadStats {
+	read := p.stats.operation("read")
+	ret := types.ReadStats{
+		Reads:        read.Count,
+		BackingBytes: read.Bytes,
+		ReadDuration: read.TotalDuration,
+	}
+	if p.readCache != nil {
+		rc := p.readCache.stats()
+		ret.CacheHits = rc.Hits
+		ret.CacheMisses = rc.Misses
+		ret.CacheBytes = rc.HitBytes
+		ret.BackingBytes -= rc.HitBytes
+	}
+	return ret
+}
+
+func (me *ShardedProvider) ReadStats() (ret types.ReadStats) {
+	for _, s := range me.shards {
+		ret.Add(s.ReadStats())
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package sqliteProvider
+
+import (
+	"fmt"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Blobs are usually only evicted by the triggers on writes. These evict them on demand, such as to
+// free space before the database's disk is resized. Blobs removed here count as evictions in Stats.
+
+// Evicts blobs in the order of the EvictionPolicy until their total size is below size, as the
+// triggers would if the capacity were size, and returns how many were evicted. The capacity itself
+// isn't changed, so later writes can grow the usage back to it.
+func (p *Provider) TrimToSize(size int64) (evicted int64, err error) {
+	var counts evictionCounts
+	err = p.withConn(func(conn conn) (err error) {
+		defer sqlitex.Save(conn)(&err)
+		before, err := p.evictionCounts(conn)
+		if err != nil {
+			return
+		}
+		capacity, limited, err := getCapacity(conn)
+		if err != nil {
+			return
+		}
+		// The deletable_blob view evicts down to the capacity setting, so it's swapped for the
+		// transaction.
+		err = SetCapacity(conn, size)
+		if err != nil {
+			return
+		}
+		evicted, err = evictBlobs(conn, "delete from blob where rowid in (select blob_rowid from deletable_blob)")
+		if err != nil {
+			return
+		}
+		if limited {
+			err = SetCapacity(conn, capacity)
+		} else {
+			err = sqlitex.Exec(conn, "delete from setting where name='capacity'", nil)
+		}
+		if err != nil {
+			return
+		}
+		counts, err = p.evictionsSince(conn, before)
+		return
+	}, true)
+	if err != nil {
+		// The transaction was rolled back.
+		evicted = 0
+	}
+	expvars.Add("manualEvictions", evicted)
+	if evicted != 0 && p.readCache != nil {
+		p.readCache.invalidateAll()
+	}
+	if err == nil {
+		p.observeEvictions(counts)
+	}
+	return
+}
+
+// Evicts all blobs with names starting with the prefix, returning how many were evicted. It's
+// DeletePrefix, except the blobs count as evictions.
+func (p *Provider) EvictPrefix(prefix string) (evicted int64, err error) {
+	prefix = p.name(prefix)
+	var counts evictionCounts
+	err = p.withConn(func(conn conn) (err error) {
+		defer sqlitex.Save(conn)(&err)
+		before, err := p.evictionCounts(conn)
+		if err != nil {
+			return
+		}
+		evicted, err = evictBlobs(conn, "delete from blob where name>=? and name<?", prefix, prefixEnd(prefix))
+		if err != nil {
+			return
+		}
+		counts, err = p.evictionsSince(conn, before)
+		return
+	}, true)
+	if p.readCache != nil {
+		p.readCache.invalidatePrefixes(prefix)
+	}
+	expvars.Add("manualEvictions", evicted)
+	if err == nil {
+		p.observeEvictions(counts)
+	}
+	return
+}
+
+// Deletes blobs with the query, counting them and their bytes as evictions, as the triggers do.
+// Returns how many were deleted.
+func evictBlobs(conn conn, query string, args ...interface{}) (evicted int64, err error) {
+	before, err := usedBytes(conn)
+	if err != nil {
+		return
+	}
+	err = sqlitex.Exec(conn, query, nil, args...)
+	if err != nil {
+		return
+	}
+	evicted = int64(conn.Changes())
+	after, err := usedBytes(conn)
+	if err != nil {
+		return
+	}
+	err = sqlitex.Exec(conn, "update blob_meta set value=value+? where key='evictions'", nil, evicted)
+	if err != nil {
+		return
+	}
+	err = sqlitex.Exec(conn, "update blob_meta set value=value+? where key='evicted_bytes'", nil, before-after)
+	return
+}
+
+func usedBytes(conn conn) (size int64, err error) {
+	err = sqlitex.Exec(conn, "select value from blob_meta where key='size'", func(stmt *sqlite.Stmt) error {
+		size = stmt.ColumnInt64(0)
+		return nil
+	})
+	return
+}
+
+// Trims each shard to an equal part of size, as the capacity is split between them.
+func (me *ShardedProvider) TrimToSize(size int64) (evicted int64, err error) {
+	for i, s := range me.shards {
+		var n int64
+		n, err = s.TrimToSize(size / int64(len(me.shards)))
+		evicted += n
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
+
+// Evicts blobs with the prefix from every shard, as a prefix can span shards.
+func (me *ShardedProvider) EvictPrefix(prefix string) (evicted int64, err error) {
+	for i, s := range me.shards {
+		var n int64
+		n, err = s.EvictPrefix(prefix)
+		evicted += n
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package sqliteProvider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// The schema enables auto_vacuum=incremental, so pages freed by deletes and eviction stay in the
+// file until an incremental vacuum returns them. Vacuums are done in steps through the write path,
+// so they don't hold up other writes for long.
+
+// The most pages freed in a single write.
+const vacuumStepPages = 256
+
+func freelistCount(conn conn) (count int64, err error) {
+	err = sqlitex.ExecTransient(conn, "pragma freelist_count", func(stmt *sqlite.Stmt) error {
+		count = stmt.ColumnInt64(0)
+		return nil
+	})
+	return
+}
+
+// Returns up to pages free pages to the filesystem, or all of them if pages isn't positive, and
+// returns how many were freed. Stops early if ctx is done.
+func (p *Provider) Vacuum(ctx context.Context, pages int) (freed int64, err error) {
+	for pages <= 0 || freed < int64(pages) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		step := int64(vacuumStepPages)
+		if pages > 0 && int64(pages)-freed < step {
+			step = int64(pages) - freed
+		}
+		var n int64
+		err = p.withConnContext(ctx, func(conn conn) error {
+			before, err := freelistCount(conn)
+			if err != nil || before == 0 {
+				return err
+			}
+			// Pragmas don't take parameters.
+			err = sqlitex.ExecTransient(conn, fmt.Sprintf("pragma incremental_vacuum(%d)", step), nil)
+			if err != nil {
+				return err
+			}
+			after, err := freelistCount(conn)
+			n = before - after
+			return err
+		}, true)
+		freed += n
+		if err != nil || n == 0 {
+			break
+		}
+	}
+	expvars.Add("vacuumedPages", freed)
+	return
+}
+
+// Runs Vacuum every ProviderOpts.VacuumInterval until stop is closed.
+func (p *Provider) vacuumer(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.opts.VacuumInterval)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		expvars.Add("scheduledVacuums", 1)
+		_, err := p.Vacuum(ctx, p.opts.VacuumPages)
+		if err != nil && ctx.Err() == nil {
+			expvars.Add("scheduledVacuumErrors", 1)
+		}
+	}
+}
+
+// Vacuums each shard in turn, with pages applying to each.
+func (me *ShardedProvider) Vacuum(ctx context.Context, pages int) (freed int64, err error) {
+	for i, s := range me.shards {
+		var n int64
+		n, err = s.Vacuum(ctx, pages)
+		freed += n
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+	}
+	return
+}
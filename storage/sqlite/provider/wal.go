@@ -0,0 +1,172 @@
+package sqliteProvider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// sqlite checkpoints the WAL into the database file itself when it reaches 1000 pages, but only
+// truncates it with journal_size_limit, and can't checkpoint fully while readers are using the old
+// pages. Under constant writes the WAL can grow without bound. These let the WAL be checkpointed
+// and truncated on demand, or after a given amount of data is written.
+
+// The result of a checkpoint. See https://www.sqlite.org/pragma.html#pragma_wal_checkpoint.
+type WalCheckpoint struct {
+	// The checkpoint couldn't complete, as other connections were reading or writing.
+	Busy bool
+	// Frames in the WAL, and how many of them are now in the database file. Both are -1 if the
+	// database isn't in WAL mode.
+	LogFrames          int64
+	CheckpointedFrames int64
+}
+
+// WAL activity for Stats.
+type WalStats struct {
+	// The size of the WAL file, or zero if there isn't one.
+	Bytes       int64
+	Checkpoints int64
+	// Checkpoints that couldn't complete. See WalCheckpoint.Busy.
+	BusyCheckpoints int64
+	Errors          int64
+	// The result of the most recent checkpoint. For a ShardedProvider, of the last shard
+	// checkpointed.
+	Last WalCheckpoint
+	// The sum of the durations of all the checkpoints.
+	TotalDuration time.Duration
+}
+
+func (me *WalStats) add(other WalStats) {
+	me.Bytes += other.Bytes
+	me.Checkpoints += other.Checkpoints
+	me.BusyCheckpoints += other.BusyCheckpoints
+	me.Errors += other.Errors
+	me.TotalDuration += other.TotalDuration
+	if other.Checkpoints != 0 {
+		me.Last = other.Last
+	}
+}
+
+type walStats struct {
+	mu sync.Mutex
+	WalStats
+}
+
+func (me *walStats) record(cp WalCheckpoint, dur time.Duration, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.Checkpoints++
+	me.TotalDuration += dur
+	if err != nil {
+		me.Errors++
+		return
+	}
+	if cp.Busy {
+		me.BusyCheckpoints++
+	}
+	me.Last = cp
+}
+
+func (me *walStats) copy() WalStats {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.WalStats
+}
+
+var walCheckpointModes = map[string]bool{
+	"passive":  true,
+	"full":     true,
+	"restart":  true,
+	"truncate": true,
+}
+
+// Runs a WAL checkpoint with the given mode, one of "passive", "full", "restart" or "truncate". A
+// truncate checkpoint that isn't busy leaves the WAL file empty. It uses a connection outside the
+// write batcher, as checkpoints can't run in a transaction.
+func (p *Provider) Checkpoint(ctx context.Context, mode string) (ret WalCheckpoint, err error) {
+	mode = strings.ToLower(mode)
+	if !walCheckpointModes[mode] {
+		err = fmt.Errorf("unknown checkpoint mode %q", mode)
+		return
+	}
+	started := time.Now()
+	defer func() {
+		p.stats.wal.record(ret, time.Since(started), err)
+	}()
+	err = p.withConnContext(ctx, func(conn conn) error {
+		return sqlitex.ExecTransient(conn, fmt.Sprintf("pragma wal_checkpoint(%s)", mode), func(stmt *sqlite.Stmt) error {
+			ret.Busy = stmt.ColumnInt(0) != 0
+			ret.LogFrames = stmt.ColumnInt64(1)
+			ret.CheckpointedFrames = stmt.ColumnInt64(2)
+			return nil
+		})
+	}, false)
+	expvars.Add("walCheckpoints", 1)
+	return
+}
+
+// Counts bytes written, and truncates the WAL once ProviderOpts.WalCheckpointBytes have been
+// written since the last time. The checkpoint runs in the writer that crosses the limit, after its
+// write is committed.
+func (p *Provider) noteWritten(n int64) {
+	limit := p.opts.WalCheckpointBytes
+	if limit <= 0 || n <= 0 {
+		return
+	}
+	written := atomic.AddInt64(&p.walWritten, n)
+	if written < limit || written-n >= limit {
+		// Below the limit, or another write crossed it and is checkpointing.
+		return
+	}
+	_, err := p.Checkpoint(p.ctx, "truncate")
+	atomic.AddInt64(&p.walWritten, -written)
+	if err != nil {
+		expvars.Add("walCheckpointErrors", 1)
+	}
+}
+
+// Returns the size of the WAL file of the connection's main database, or zero if there isn't one,
+// such as for in-memory databases or other journal modes.
+func walBytes(conn conn) (size int64, err error) {
+	var path string
+	err = sqlitex.ExecTransient(conn, "pragma database_list", func(stmt *sqlite.Stmt) error {
+		if stmt.ColumnText(1) == "main" {
+			path = stmt.ColumnText(2)
+		}
+		return nil
+	})
+	if err != nil || path == "" {
+		return
+	}
+	fi, err := os.Stat(path + "-wal")
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	return fi.Size(), nil
+}
+
+// Checkpoints each shard in turn, returning the result for the last. Busy is set if any were.
+func (me *ShardedProvider) Checkpoint(ctx context.Context, mode string) (ret WalCheckpoint, err error) {
+	for i, s := range me.shards {
+		var cp WalCheckpoint
+		cp, err = s.Checkpoint(ctx, mode)
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", i, err)
+			return
+		}
+		busy := ret.Busy || cp.Busy
+		ret = cp
+		ret.Busy = busy
+	}
+	return
+}
@@ -0,0 +1,15 @@
+module github.com/anacrolix/torrent/storage/sqlite/purego
+
+require (
+	github.com/anacrolix/missinggo/v2 v2.4.1-0.20201115225934-0b235ba7a31c
+	github.com/anacrolix/torrent v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.5.1
+	modernc.org/sqlite v1.7.4
+)
+
+go 1.13
+
+// The package is developed with the torrent module it's in.
+replace github.com/anacrolix/torrent => ../../..
+
+replace crawshaw.io/sqlite => github.com/getlantern/sqlite v0.3.3-0.20201116012831-1a85f453b62f
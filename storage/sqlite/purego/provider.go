@@ -0,0 +1,797 @@
+// Package sqlitePureGo is a resource.Provider with the same database schema and write batching as
+// the sqliteProvider package, using the modernc.org/sqlite driver, which doesn't require cgo. It's
+// a module of its own, so the torrent module doesn't depend on the driver.
+//
+// Databases can be opened by either package. This one only stores blobs in a single row each, so
+// it refuses databases that sqliteProvider has stored in chunks (see
+// sqliteProvider.ProviderOpts.ChunkSize).
+package sqlitePureGo
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/missinggo/v2/resource"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/anacrolix/torrent/storage"
+	"github.com/anacrolix/torrent/storage/sqlite/schema"
+)
+
+type conn = *sql.Conn
+
+type NewProviderOpts struct {
+	Path string
+	// Memory databases use a single connection, as connections to them don't share the database
+	// unless they share a cache.
+	Memory   bool
+	NumConns int
+	// If non-zero, overrides the existing setting.
+	Capacity int64
+	// The synchronous pragma. Defaults to "off", as for sqliteProvider.ConnOpts.
+	Synchronous string
+	// The journal_mode pragma. Defaults to "wal", or "off" for memory databases.
+	JournalMode string
+	// The busy_timeout pragma. Zero leaves sqlite's default of not waiting.
+	BusyTimeout time.Duration
+}
+
+var expvars = expvar.NewMap("sqlitePureGo")
+
+// Pragma values are interpolated, as pragmas don't take parameters, so only allow keywords.
+func pragmaKeyword(s string) error {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("bad pragma value %q", s)
+		}
+	}
+	return nil
+}
+
+func initConn(ctx context.Context, conn conn, opts NewProviderOpts) error {
+	synchronous := opts.Synchronous
+	if synchronous == "" {
+		synchronous = "off"
+	}
+	journalMode := opts.JournalMode
+	if journalMode == "" {
+		journalMode = "wal"
+		if opts.Memory {
+			journalMode = "off"
+		}
+	}
+	for _, s := range []string{synchronous, journalMode} {
+		if err := pragmaKeyword(s); err != nil {
+			return err
+		}
+	}
+	// Recursive triggers are required to trim the blob_meta size after trimming to capacity, as in
+	// sqliteProvider.
+	pragmas := []string{
+		"recursive_triggers=on",
+		"synchronous=" + synchronous,
+		"journal_mode=" + journalMode,
+	}
+	if opts.BusyTimeout != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout=%d", opts.BusyTimeout.Milliseconds()))
+	}
+	for _, p := range pragmas {
+		_, err := conn.ExecContext(ctx, "pragma "+p)
+		if err != nil {
+			return fmt.Errorf("pragma %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Runs f in a savepoint, which is released if f succeeds, and rolled back otherwise. Savepoints
+// nest, and begin a transaction if there isn't one.
+func save(ctx context.Context, conn conn, f func() error) (err error) {
+	_, err = conn.ExecContext(ctx, "savepoint save")
+	if err != nil {
+		return
+	}
+	err = f()
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "release save")
+		if err == nil {
+			return
+		}
+	}
+	// Use a context that isn't done, so a cancelled operation still rolls back.
+	conn.ExecContext(context.Background(), "rollback to save")
+	conn.ExecContext(context.Background(), "release save")
+	return
+}
+
+// Creates the schema, and applies the migrations the database hasn't had, as sqliteProvider does.
+func initSchema(ctx context.Context, conn conn) error {
+	_, err := conn.ExecContext(ctx, schema.Base)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = conn.QueryRowContext(ctx, "pragma user_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("getting schema version: %w", err)
+	}
+	if version > len(schema.Migrations) {
+		return fmt.Errorf("schema version %v is newer than supported version %v", version, len(schema.Migrations))
+	}
+	for ; version < len(schema.Migrations); version++ {
+		m := schema.Migrations[version]
+		err = save(ctx, conn, func() error {
+			_, err := conn.ExecContext(ctx, m.Script)
+			if err != nil {
+				return err
+			}
+			_, err = conn.ExecContext(ctx, fmt.Sprintf("pragma user_version=%d", version+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("applying schema migration %v (%s): %w", version+1, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Opens the database, initializing its schema and each connection.
+func NewProvider(opts NewProviderOpts) (_ *Provider, err error) {
+	if opts.NumConns == 0 {
+		opts.NumConns = runtime.NumCPU()
+	}
+	if opts.Memory {
+		opts.Path = ":memory:"
+		opts.NumConns = 1
+	}
+	db, err := sql.Open("sqlite", "file:"+opts.Path)
+	if err != nil {
+		return
+	}
+	// The pool holds every connection for the life of the Provider.
+	db.SetMaxOpenConns(opts.NumConns)
+	db.SetMaxIdleConns(opts.NumConns)
+	ctx := context.Background()
+	pool := make(chan conn, opts.NumConns)
+	defer func() {
+		if err != nil {
+			close(pool)
+			for c := range pool {
+				c.Close()
+			}
+			db.Close()
+		}
+	}()
+	for i := 0; i < opts.NumConns; i++ {
+		var c conn
+		c, err = db.Conn(ctx)
+		if err != nil {
+			return
+		}
+		pool <- c
+		err = initConn(ctx, c, opts)
+		if err != nil {
+			err = fmt.Errorf("initing conn %v: %w", i+1, err)
+			return
+		}
+	}
+	err = func() error {
+		c := <-pool
+		defer func() { pool <- c }()
+		err := initSchema(ctx, c)
+		if err != nil {
+			return fmt.Errorf("initing schema: %w", err)
+		}
+		var chunkSize int64
+		err = c.QueryRowContext(ctx, "select value from setting where name='chunk_size'").Scan(&chunkSize)
+		if err == nil && chunkSize != 0 {
+			return fmt.Errorf("database uses chunk size %v, which isn't supported", chunkSize)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if opts.Capacity != 0 {
+			_, err = c.ExecContext(ctx, "insert into setting values ('capacity', ?)", opts.Capacity)
+			return err
+		}
+		return nil
+	}()
+	if err != nil {
+		return
+	}
+	writes := make(chan writeRequest, 1<<(20-14))
+	writerDone := make(chan struct{})
+	prov := &Provider{db: db, pool: pool, writes: writes, writerDone: writerDone}
+	prov.ctx, prov.cancel = context.WithCancel(context.Background())
+	go func() {
+		defer close(writerDone)
+		providerWriter(writes, pool)
+	}()
+	return prov, nil
+}
+
+// A resource.Provider backed by a sqlite database through a pure-Go driver. It also implements
+// WriteConsecutiveChunks for use with torrent piece storage.
+type Provider struct {
+	db   *sql.DB
+	pool chan conn
+	// Held for reading while sending to writes, and for writing to close it.
+	writesMu   sync.RWMutex
+	writes     chan<- writeRequest
+	closed     bool
+	writerDone <-chan struct{}
+	// Done when the Provider is closed. Used by operations not given a context.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var errClosed = storage.Error{Kind: storage.ErrClosed, Err: errors.New("provider closed")}
+
+// Wraps os.ErrNotExist, so callers can check for it with errors.Is, as for files.
+var errBlobNotFound = fmt.Errorf("blob not found: %w", os.ErrNotExist)
+
+// Gives sqlite errors their storage error kind. See storage.ClassifyError.
+func storageError(err error) error {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return err
+	}
+	// Extended result codes have the primary code in the low byte.
+	switch se.Code() & 0xff {
+	case sqlite3.SQLITE_FULL:
+		return storage.Error{Kind: storage.ErrDiskFull, Err: err}
+	case sqlite3.SQLITE_READONLY:
+		return storage.Error{Kind: storage.ErrReadOnly, Err: err}
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return storage.Error{Kind: storage.ErrBusy, Err: err}
+	}
+	return err
+}
+
+// Returns an error if a blob of the given size can't be stored, as it would be evicted immediately.
+func checkCapacity(ctx context.Context, conn conn, name string, size int64) error {
+	rows, err := conn.QueryContext(ctx, "select name, value from setting where name='capacity' or name glob 'quota:*'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var limit int64
+		err = rows.Scan(&key, &limit)
+		if err != nil {
+			return err
+		}
+		if size <= limit {
+			continue
+		}
+		if key == "capacity" {
+			return storage.Error{
+				Kind: storage.ErrCapacityExceeded,
+				Err:  fmt.Errorf("%v bytes exceeds capacity of %v", size, limit),
+			}
+		}
+		if prefix := strings.TrimPrefix(key, "quota:"); strings.HasPrefix(name, prefix) {
+			return storage.Error{
+				Kind: storage.ErrCapacityExceeded,
+				Err:  fmt.Errorf("%v bytes exceeds quota of %v for prefix %q", size, limit, prefix),
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// Waits for queued writes to be committed, and then closes the database. Writes after Close fail.
+func (me *Provider) Close() error {
+	me.writesMu.Lock()
+	if me.closed {
+		me.writesMu.Unlock()
+		return errClosed
+	}
+	me.closed = true
+	close(me.writes)
+	me.writesMu.Unlock()
+	<-me.writerDone
+	// Abandon anything still waiting on the pool.
+	me.cancel()
+	for i := 0; i < cap(me.pool); i++ {
+		(<-me.pool).Close()
+	}
+	return me.db.Close()
+}
+
+// Blocks until all writes queued before the call have been committed.
+func (me *Provider) Flush() error {
+	// The writer handles requests in order, so this is done after those before it.
+	return me.withConn(func(conn) error { return nil }, true)
+}
+
+type writeRequest struct {
+	// Requests whose context is done by the time the writer reaches them are skipped.
+	ctx   context.Context
+	query withConn
+	done  chan<- error
+}
+
+// Runs the request's query, unless its context is already done.
+func (wr writeRequest) run(conn conn) error {
+	if err := wr.ctx.Err(); err != nil {
+		return err
+	}
+	return wr.query(wr.ctx, conn)
+}
+
+// Runs until writes is closed, committing the requests queued at the time in a single transaction,
+// like sqliteProvider's writer.
+func providerWriter(writes <-chan writeRequest, pool chan conn) {
+	for {
+		first, ok := <-writes
+		if !ok {
+			return
+		}
+		var buf []func()
+		conn := <-pool
+		cantFail := save(context.Background(), conn, func() error {
+			firstErr := first.run(conn)
+			buf = append(buf, func() { first.done <- firstErr })
+			for {
+				select {
+				case wr, ok := <-writes:
+					if ok {
+						err := wr.run(conn)
+						buf = append(buf, func() { wr.done <- err })
+						continue
+					}
+				default:
+				}
+				return nil
+			}
+		})
+		pool <- conn
+		// Not sure what to do if this failed.
+		if cantFail != nil {
+			expvars.Add("batchTransactionErrors", 1)
+		}
+		// Signal done after we know the transaction succeeded.
+		for _, done := range buf {
+			done()
+		}
+		expvars.Add("batchTransactions", 1)
+		expvars.Add("batchedQueries", int64(len(buf)))
+	}
+}
+
+type withConn func(context.Context, conn) error
+
+func (p *Provider) withConn(with withConn, write bool) error {
+	return p.withConnContext(p.ctx, with, write)
+}
+
+// Stops waiting for a connection, or for a queued write, when ctx is done.
+func (p *Provider) withConnContext(ctx context.Context, with withConn, write bool) error {
+	if write {
+		// Buffered, so the writer isn't held up if we stop waiting.
+		done := make(chan error, 1)
+		p.writesMu.RLock()
+		if p.closed {
+			p.writesMu.RUnlock()
+			return errClosed
+		}
+		select {
+		case p.writes <- writeRequest{
+			ctx:   ctx,
+			query: with,
+			done:  done,
+		}:
+		case <-ctx.Done():
+			p.writesMu.RUnlock()
+			return ctx.Err()
+		}
+		p.writesMu.RUnlock()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case conn := <-p.pool:
+		defer func() { p.pool <- conn }()
+		return with(ctx, conn)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Provider) NewInstance(s string) (resource.Instance, error) {
+	return instance{location: s, p: p}, nil
+}
+
+// Returns an instance whose operations are abandoned when ctx is done.
+func (p *Provider) NewInstanceContext(ctx context.Context, s string) (resource.Instance, error) {
+	return instance{location: s, p: p, ctx: ctx}, nil
+}
+
+type instance struct {
+	location string
+	p        *Provider
+	// Nil for the Provider's context.
+	ctx context.Context
+}
+
+func (i instance) context() context.Context {
+	if i.ctx == nil {
+		return i.p.ctx
+	}
+	return i.ctx
+}
+
+func (i instance) withConn(with withConn, write bool) error {
+	return i.p.withConnContext(i.context(), with, write)
+}
+
+// Returns the least string greater than all strings with the given prefix, which must end with a
+// byte that can be incremented, such as a slash or a hex digit.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	b[len(b)-1]++
+	return string(b)
+}
+
+func (p *Provider) WriteConsecutiveChunks(prefix string, w io.Writer) (written int64, err error) {
+	err = p.withConn(func(ctx context.Context, conn conn) (err error) {
+		written, err = writeConsecutiveChunks(ctx, conn, prefix, w)
+		return
+	}, false)
+	return
+}
+
+func writeConsecutiveChunks(ctx context.Context, conn conn, prefix string, w io.Writer) (written int64, err error) {
+	rows, err := conn.QueryContext(ctx, `
+			select
+				cast(data as blob),
+				cast(substr(name, ?+1) as integer) as offset
+			from blob
+			where name>=? and name<?
+			order by offset`,
+		len(prefix), prefix, prefixEnd(prefix))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		var offset int64
+		err = rows.Scan(&b, &offset)
+		if err != nil {
+			return
+		}
+		var w1 int
+		w1, err = w.Write(b)
+		written += int64(w1)
+		if err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// Merges the blobs with names starting with prefix, in offset order, into the named blob, and
+// deletes them, in a single write transaction. See storage.ChunkCoalescer.
+func (p *Provider) CoalesceChunks(ctx context.Context, prefix, name string, length int64) error {
+	err := p.withConnContext(ctx, func(ctx context.Context, conn conn) error {
+		return save(ctx, conn, func() error {
+			var buf bytes.Buffer
+			_, err := writeConsecutiveChunks(ctx, conn, prefix, &buf)
+			if err != nil {
+				return err
+			}
+			if int64(buf.Len()) != length {
+				return fmt.Errorf("chunks have %v bytes, expected %v", buf.Len(), length)
+			}
+			_, err = conn.ExecContext(ctx, "delete from blob where name>=? and name<?", prefix, prefixEnd(prefix))
+			if err != nil {
+				return err
+			}
+			err = checkCapacity(ctx, conn, name, length)
+			if err != nil {
+				return err
+			}
+			_, err = conn.ExecContext(ctx,
+				"insert or replace into blob(name, data) values(?, cast(? as blob))",
+				name, buf.Bytes())
+			return err
+		})
+	}, true)
+	return storageError(err)
+}
+
+// Returns the names of the instance's immediate children, treating names as slash-separated paths,
+// as sqliteProvider does.
+func (i instance) Readdirnames() (names []string, err error) {
+	prefix := i.location + "/"
+	seen := make(map[string]struct{})
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		// Skip over the names below each child once it's found.
+		lower := prefix
+		for {
+			var name string
+			err := conn.QueryRowContext(ctx,
+				"select name from blob where name>=? and name<? order by name limit 1",
+				lower, prefixEnd(prefix),
+			).Scan(&name)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			child := name[len(prefix):]
+			if slash := strings.IndexByte(child, '/'); slash >= 0 {
+				child = child[:slash]
+				lower = prefixEnd(prefix + child + "/")
+			} else {
+				lower = name + "\x00"
+			}
+			if _, ok := seen[child]; !ok {
+				seen[child] = struct{}{}
+				names = append(names, child)
+			}
+		}
+	}, false)
+	sort.Strings(names)
+	return
+}
+
+// Returns the names of all the instance's descendants, relative to it.
+func (i instance) ReaddirnamesRecursive() (names []string, err error) {
+	prefix := i.location + "/"
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		rows, err := conn.QueryContext(ctx, "select name from blob where name>=? and name<? order by name", prefix, prefixEnd(prefix))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name[len(prefix):])
+		}
+		return rows.Err()
+	}, false)
+	return
+}
+
+func (i instance) Get() (ret io.ReadCloser, err error) {
+	var b []byte
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		err := conn.QueryRowContext(ctx, "select cast(data as blob) from blob where name=?", i.location).Scan(&b)
+		if err == sql.ErrNoRows {
+			return errBlobNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx,
+			"update blob set last_used=datetime('now'), access_count=access_count+1 where name=?",
+			i.location)
+		if err != nil {
+			return fmt.Errorf("updating last_used: %w", err)
+		}
+		return nil
+	}, false)
+	if err != nil {
+		return
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (i instance) Put(reader io.Reader) (err error) {
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, reader)
+	if err != nil {
+		return err
+	}
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		err := checkCapacity(ctx, conn, i.location, int64(buf.Len()))
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx,
+			"insert or replace into blob(name, data) values(?, cast(? as blob))",
+			i.location, buf.Bytes())
+		return err
+	}, true)
+	return storageError(err)
+}
+
+// Describes a blob as a read-only regular file. The modification time is when the blob was last
+// used, which orders eviction.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() os.FileMode  { return 0444 }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+func (f fileInfo) IsDir() bool        { return false }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+// The format of datetime('now'), which is in UTC.
+const sqliteDatetimeLayout = "2006-01-02 15:04:05"
+
+func (i instance) Stat() (ret os.FileInfo, err error) {
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		var fi fileInfo
+		var lastUsed string
+		err := conn.QueryRowContext(ctx,
+			"select length(cast(data as blob)), last_used from blob where name=?",
+			i.location,
+		).Scan(&fi.size, &lastUsed)
+		if err == sql.ErrNoRows {
+			return errBlobNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// Access times from elsewhere might not parse. They're informational here.
+		fi.modTime, _ = time.ParseInLocation(sqliteDatetimeLayout, lastUsed, time.UTC)
+		fi.name = path.Base(i.location)
+		ret = fi
+		return nil
+	}, false)
+	return
+}
+
+func (i instance) ReadAt(p []byte, off int64) (n int, err error) {
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		var b []byte
+		err := conn.QueryRowContext(ctx,
+			"select substr(cast(data as blob), ?, ?) from blob where name=?",
+			off+1, len(p), i.location,
+		).Scan(&b)
+		if err == sql.ErrNoRows {
+			return errBlobNotFound
+		}
+		if err != nil {
+			return err
+		}
+		n = copy(p, b)
+		if n < len(p) {
+			return io.EOF
+		}
+		return nil
+	}, false)
+	return
+}
+
+// Writes at an offset into the blob, creating or extending it with zeroes as needed. Like Put, it
+// goes through the write batcher.
+func (i instance) WriteAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+	end := off + int64(len(b))
+	err = i.withConn(func(ctx context.Context, conn conn) error {
+		err := checkCapacity(ctx, conn, i.location, end)
+		if err != nil {
+			return err
+		}
+		return save(ctx, conn, func() error {
+			_, err := conn.ExecContext(ctx, "insert or ignore into blob(name, data) values(?, zeroblob(?))", i.location, end)
+			if err != nil {
+				return err
+			}
+			// There's no incremental blob I/O through database/sql, so the blob is spliced.
+			_, err = conn.ExecContext(ctx, `
+				update blob set data=
+					substr(cast(data as blob)||zeroblob(max(?1-length(cast(data as blob)), 0)), 1, ?2)||
+					cast(?3 as blob)||
+					substr(cast(data as blob), ?1+1)
+				where name=?4`,
+				end, off, b, i.location)
+			return err
+		})
+	}, true)
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return len(b), nil
+}
+
+func (i instance) Delete() error {
+	return i.withConn(func(ctx context.Context, conn conn) error {
+		_, err := conn.ExecContext(ctx, "delete from blob where name=?", i.location)
+		return err
+	}, true)
+}
+
+// Checks that the database can be queried.
+func (p *Provider) Ping() error {
+	return p.withConn(func(ctx context.Context, conn conn) error {
+		return conn.PingContext(ctx)
+	}, false)
+}
+
+// Returns the size of blobs counted toward the capacity.
+func (p *Provider) Usage() (size int64, err error) {
+	err = p.withConn(func(ctx context.Context, conn conn) error {
+		return conn.QueryRowContext(ctx, "select value from blob_meta where key='size'").Scan(&size)
+	}, false)
+	return
+}
+
+// Deletes all blobs with names starting with any of the prefixes, in a single transaction.
+func (p *Provider) DeletePrefixes(prefixes []string) (deleted int64, err error) {
+	err = p.withConn(func(ctx context.Context, conn conn) error {
+		return save(ctx, conn, func() error {
+			for _, prefix := range prefixes {
+				res, err := conn.ExecContext(ctx, "delete from blob where name>=? and name<?", prefix, prefixEnd(prefix))
+				if err != nil {
+					return err
+				}
+				n, _ := res.RowsAffected()
+				deleted += n
+			}
+			return nil
+		})
+	}, true)
+	return
+}
+
+// Writes a copy of the database to path, which mustn't exist, with vacuum into. Writes continue
+// meanwhile.
+func (p *Provider) BackupToPath(path string) error {
+	return p.withConn(func(ctx context.Context, conn conn) error {
+		_, err := conn.ExecContext(ctx, "vacuum into ?", path)
+		return err
+	}, false)
+}
+
+var (
+	_ storage.ConsecutiveChunkWriter = (*Provider)(nil)
+	_ storage.ChunkCoalescer         = (*Provider)(nil)
+	_ storage.PrefixDeleter          = (*Provider)(nil)
+	_ storage.Backuper               = (*Provider)(nil)
+	_ storage.Pinger                 = (*Provider)(nil)
+)
+
+// Opens a Provider, and returns pieces storage backed by it, as sqliteStorage.NewPiecesStorage does.
+func NewPiecesStorage(opts NewProviderOpts) (storage.ClientImplCloser, error) {
+	prov, err := NewProvider(opts)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		storage.ClientImpl
+		io.Closer
+		storage.Pinger
+		storage.Backuper
+	}{
+		storage.NewResourcePieces(prov),
+		prov,
+		prov,
+		prov,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package sqlitePureGo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/storage/sqlite/schema"
+)
+
+func newTestProvider(t *testing.T, opts NewProviderOpts) *Provider {
+	if opts.Path == "" {
+		opts.Path = filepath.Join(t.TempDir(), "sqlite3.db")
+	}
+	prov, err := NewProvider(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { prov.Close() })
+	return prov
+}
+
+func TestSchemaVersion(t *testing.T) {
+	prov := newTestProvider(t, NewProviderOpts{})
+	var version int
+	require.NoError(t, prov.withConn(func(ctx context.Context, conn conn) error {
+		return conn.QueryRowContext(ctx, "pragma user_version").Scan(&version)
+	}, false))
+	assert.Equal(t, len(schema.Migrations), version)
+}
+
+func TestInstanceReadWrite(t *testing.T) {
+	prov := newTestProvider(t, NewProviderOpts{NumConns: 2})
+	i, err := prov.NewInstance("a/b")
+	require.NoError(t, err)
+	require.NoError(t, i.Put(bytes.NewReader([]byte("hello"))))
+	r, err := i.Get()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	_, err = i.WriteAt([]byte("p!"), 3)
+	require.NoError(t, err)
+	_, err = i.WriteAt([]byte("x"), 7)
+	require.NoError(t, err)
+	p := make([]byte, 10)
+	n, err := i.ReadAt(p, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "help!\x00\x00x", string(p[:n]))
+	fi, err := i.Stat()
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, fi.Size())
+	usage, err := prov.Usage()
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, usage)
+	dir, err := prov.NewInstance("a")
+	require.NoError(t, err)
+	names, err := dir.Readdirnames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names)
+	require.NoError(t, i.Delete())
+	_, err = i.Stat()
+	assert.Error(t, err)
+}
+
+func TestCapacityEviction(t *testing.T) {
+	prov := newTestProvider(t, NewProviderOpts{Capacity: 6})
+	for _, name := range []string{"a", "b", "c"} {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(bytes.NewReader([]byte("abc"))))
+	}
+	usage, err := prov.Usage()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, usage, int64(6))
+	i, err := prov.NewInstance("big")
+	require.NoError(t, err)
+	assert.Error(t, i.Put(bytes.NewReader(make([]byte, 7))))
+}
@@ -0,0 +1,20 @@
+package sqliteStorage
+
+import (
+	"crawshaw.io/sqlite"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Limits the total size of a torrent's pieces, including the chunks of incomplete ones, with a
+// prefix quota (see sqliteProvider.SetPrefixQuota). The pieces must be stored with
+// NewPoolOpts.PerTorrentPrefix, and without a namespace.
+func SetTorrentQuota(conn *sqlite.Conn, infoHash metainfo.Hash, quota int64) error {
+	return SetPrefixQuota(conn, storage.TorrentPiecesPrefix(infoHash), quota)
+}
+
+// Removes the quota set by SetTorrentQuota.
+func UnsetTorrentQuota(conn *sqlite.Conn, infoHash metainfo.Hash) error {
+	return UnsetPrefixQuota(conn, storage.TorrentPiecesPrefix(infoHash))
+}
@@ -0,0 +1,55 @@
+package sqliteStorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestTorrentQuota(t *testing.T) {
+	opts := NewPoolOpts{
+		Path:             filepath.Join(t.TempDir(), "sqlite3.db"),
+		PerTorrentPrefix: true,
+	}
+	ci, err := NewPiecesStorage(opts)
+	require.NoError(t, err)
+	defer ci.Close()
+	// The torrents have the same pieces, which would be shared without PerTorrentPrefix.
+	info := testutil.Greeting.Info(5)
+	limited, other := metainfo.Hash{1}, metainfo.Hash{2}
+	conns, _, err := NewPool(NewPoolOpts{Path: opts.Path})
+	require.NoError(t, err)
+	conn := conns.Get(context.Background())
+	require.NoError(t, SetTorrentQuota(conn, limited, 10))
+	conns.Put(conn)
+	require.NoError(t, conns.Close())
+
+	complete := func(ih metainfo.Hash) (n int) {
+		ti, err := ci.OpenTorrent(&info, ih)
+		require.NoError(t, err)
+		defer ti.Close()
+		for i := 0; i < info.NumPieces(); i++ {
+			p := info.Piece(i)
+			pi := ti.Piece(p)
+			data := testutil.GreetingFileContents[p.Offset() : p.Offset()+p.Length()]
+			_, err := pi.WriteAt([]byte(data), 0)
+			require.NoError(t, err)
+			require.NoError(t, pi.MarkComplete())
+		}
+		for i := 0; i < info.NumPieces(); i++ {
+			if ti.Piece(info.Piece(i)).Completion().Complete {
+				n++
+			}
+		}
+		return
+	}
+	// The limited torrent's 14 bytes of pieces don't fit in its quota.
+	assert.Less(t, complete(limited), info.NumPieces())
+	assert.Equal(t, info.NumPieces(), complete(other))
+}
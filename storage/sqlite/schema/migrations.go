@@ -0,0 +1,409 @@
+package schema
+
+import "fmt"
+
+// A change to the schema after Base. The version of a database, stored in its user_version, is the
+// number of migrations that have been applied to it.
+type Migration struct {
+	Name   string
+	Script string
+}
+
+// The size of a blob row, including any chunks.
+const BlobSizeExpr = "(length(cast(data as blob))+" +
+	"(select coalesce(sum(length(data)), 0) from blob_chunk where blob_chunk.name=blob.name))"
+
+// Selects the blobs to evict to bring each prefix with a quota within it. Blobs with the highest
+// eviction keys that fit in the quota are kept. The arguments are the eviction key and blob size
+// expressions, as in deletable_blob.
+const OverQuotaViewFormat = `
+create view over_quota_blob as
+select blob_rowid from (
+	select
+		b.blob_rowid,
+		quota.value as quota,
+		sum(b.size) over (
+			partition by quota.name
+			order by b.eviction_key desc, b.blob_rowid desc
+		) as kept
+	from setting as quota
+	join (
+		select rowid as blob_rowid, name, %[1]s as eviction_key, %[2]s as size
+		from blob
+	) as b
+	on substr(b.name, 1, length(quota.name)-length('quota:'))=substr(quota.name, length('quota:')+1)
+	where quota.name glob 'quota:*'
+)
+where kept > quota;
+`
+
+// Applied in order to existing databases. Only append to this, as the position of a migration is
+// its version.
+var Migrations = []Migration{
+	{
+		// Orders blobs for eviction by deletable_blob, which otherwise sorts the entire table each
+		// time a blob is written once the capacity is reached, and scans it for each further blob
+		// evicted. The rowid is implicitly part of the index, so it covers the (last_used, rowid)
+		// step. The query plans are checked by TestSchemaMigrations.
+		Name:   "index blob last_used",
+		Script: `create index if not exists blob_last_used on blob(last_used)`,
+	},
+	{
+		// See sqliteProvider.ProviderOpts.ChunkSize. Chunks count toward the size of their blob for eviction, and
+		// go with it.
+		Name: "blob chunks",
+		Script: `
+create table blob_chunk (
+	name text,
+	seq integer,
+	data blob,
+	primary key (name, seq)
+);
+
+create trigger after_insert_blob_chunk
+after insert on blob_chunk
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+end;
+
+create trigger after_delete_blob_chunk
+after delete on blob_chunk
+begin
+	update blob_meta set value=value-length(cast(old.data as blob)) where key='size';
+end;
+
+create trigger after_delete_blob_delete_chunks
+after delete on blob
+begin
+	delete from blob_chunk where name=old.name;
+end;
+
+drop view deletable_blob;
+
+create view deletable_blob as
+with recursive excess (
+	usage_with,
+	last_used,
+	blob_rowid,
+	data_length
+) as (
+	select * 
+	from (
+		select 
+			(select value from blob_meta where key='size') as usage_with,
+			last_used,
+			rowid,
+			length(cast(data as blob))+
+				(select coalesce(sum(length(data)), 0) from blob_chunk where name=blob.name)
+		from blob order by last_used, rowid limit 1
+	)
+	where usage_with >= (select value from setting where name='capacity')
+	union all
+	select 
+		usage_with-data_length,
+		blob.last_used,
+		blob.rowid,
+		length(cast(data as blob))+
+			(select coalesce(sum(length(data)), 0) from blob_chunk where name=blob.name)
+	from excess join blob
+	on blob.rowid=(select rowid from blob where (last_used, rowid) > (excess.last_used, blob_rowid))
+	where usage_with >= (select value from setting where name='capacity')
+)
+select * from excess;
+`,
+	},
+	{
+		// Counts accesses since a blob was last written, for sqliteProvider.EvictLFU.
+		Name:   "blob access count",
+		Script: `alter table blob add column access_count integer not null default 0`,
+	},
+	{
+		// See sqliteProvider.SetPrefixQuota. Blobs over their prefix quotas are evicted before those over the
+		// capacity.
+		Name: "prefix quotas",
+		Script: "drop view if exists over_quota_blob;\n" +
+			fmt.Sprintf(OverQuotaViewFormat, "last_used", BlobSizeExpr) + `
+drop trigger after_insert_blob;
+create trigger after_insert_blob
+after insert on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+end;
+
+drop trigger after_update_blob;
+create trigger after_update_blob
+after update of data on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob))-length(cast(old.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+end;
+
+drop trigger after_insert_blob_chunk;
+create trigger after_insert_blob_chunk
+after insert on blob_chunk
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+end;
+`,
+	},
+	{
+		// See sqliteProvider.ProviderOpts.Compression. Null for chunks stored as is.
+		Name:   "compressed chunks",
+		Script: `alter table blob_chunk add column uncompressed_size integer`,
+	},
+	{
+		// Counts blobs evicted by the triggers, for sqliteProvider.Provider.Stats. Within a trigger, changes() is
+		// the number of rows deleted by the preceding statement.
+		Name: "eviction counts",
+		Script: `
+insert or ignore into blob_meta values ('evictions', 0);
+
+drop trigger after_insert_blob;
+create trigger after_insert_blob
+after insert on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+end;
+
+drop trigger after_update_blob;
+create trigger after_update_blob
+after update of data on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob))-length(cast(old.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+end;
+
+drop trigger after_insert_blob_chunk;
+create trigger after_insert_blob_chunk
+after insert on blob_chunk
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+end;
+`,
+	},
+	{
+		// See sqliteProvider.Provider.SetPieceCompletion.
+		Name: "piece completion",
+		Script: `
+create table piece_completion(
+	infohash text,
+	"index" integer,
+	complete integer,
+	primary key (infohash, "index")
+) without rowid`,
+	},
+	{
+		// Counts the bytes of blobs evicted by the triggers, as the drop in size across the
+		// evictions.
+		Name: "evicted bytes",
+		Script: `
+insert or ignore into blob_meta values ('evicted_bytes', 0);
+
+drop trigger after_insert_blob;
+create trigger after_insert_blob
+after insert on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_update_blob;
+create trigger after_update_blob
+after update of data on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob))-length(cast(old.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_insert_blob_chunk;
+create trigger after_insert_blob_chunk
+after insert on blob_chunk
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+`,
+	},
+	{
+		// Skips over_quota_blob in the triggers unless a quota is set, as it selects from every
+		// blob. The condition doesn't depend on the blob table, so sqlite checks it once before
+		// looking at any rows.
+		Name: "guard prefix quotas",
+		Script: `
+drop trigger after_insert_blob;
+create trigger after_insert_blob
+after insert on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where exists (select 1 from setting where name glob 'quota:*')
+		and rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_update_blob;
+create trigger after_update_blob
+after update of data on blob
+begin
+	update blob_meta set value=value+length(cast(new.data as blob))-length(cast(old.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where exists (select 1 from setting where name glob 'quota:*')
+		and rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_insert_blob_chunk;
+create trigger after_insert_blob_chunk
+after insert on blob_chunk
+begin
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where exists (select 1 from setting where name glob 'quota:*')
+		and rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+`,
+	},
+	{
+		// Keeps the size of each blob, including its chunks, on its row, so it can be indexed for
+		// sqliteProvider.EvictLargest. The index is on the eviction key, which is the negated size.
+		Name: "blob size",
+		Script: `
+alter table blob add column size integer not null default 0;
+update blob set size=` + BlobSizeExpr + `;
+create index blob_neg_size on blob(-size);
+
+drop trigger after_insert_blob;
+create trigger after_insert_blob
+after insert on blob
+begin
+	update blob set size=` + BlobSizeExpr + ` where rowid=new.rowid;
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where exists (select 1 from setting where name glob 'quota:*')
+		and rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_update_blob;
+create trigger after_update_blob
+after update of data on blob
+begin
+	update blob set size=size+length(cast(new.data as blob))-length(cast(old.data as blob)) where rowid=new.rowid;
+	update blob_meta set value=value+length(cast(new.data as blob))-length(cast(old.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where exists (select 1 from setting where name glob 'quota:*')
+		and rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_insert_blob_chunk;
+create trigger after_insert_blob_chunk
+after insert on blob_chunk
+begin
+	update blob set size=size+length(cast(new.data as blob)) where name=new.name;
+	update blob_meta set value=value+length(cast(new.data as blob)) where key='size';
+	update blob_meta set value=value+(select value from blob_meta where key='size') where key='evicted_bytes';
+	delete from blob where exists (select 1 from setting where name glob 'quota:*')
+		and rowid in (select blob_rowid from over_quota_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	delete from blob where rowid in (select blob_rowid from deletable_blob);
+	update blob_meta set value=value+changes() where key='evictions';
+	update blob_meta set value=value-(select value from blob_meta where key='size') where key='evicted_bytes';
+end;
+
+drop trigger after_delete_blob_chunk;
+create trigger after_delete_blob_chunk
+after delete on blob_chunk
+begin
+	update blob set size=size-length(cast(old.data as blob)) where name=old.name;
+	update blob_meta set value=value-length(cast(old.data as blob)) where key='size';
+end;
+`,
+	},
+	{
+		// Evicts blobs only until the usage is under the capacity. The recursive step checked the
+		// usage before the previous blob was evicted, so one more blob than needed was evicted.
+		// The blob sizes now come from the size column. sqliteProvider recreates the views for
+		// any other eviction policy after migrating.
+		Name: "evict down to capacity",
+		Script: `
+drop view deletable_blob;
+create view deletable_blob as
+with recursive excess (
+	usage_with,
+	last_used,
+	blob_rowid,
+	data_length
+) as (
+	select * 
+	from (
+		select 
+			(select value from blob_meta where key='size') as usage_with,
+			last_used,
+			rowid,
+			size
+		from blob order by last_used, rowid limit 1
+	)
+	where usage_with >= (select value from setting where name='capacity')
+	union all
+	select 
+		excess.usage_with-excess.data_length,
+		blob.last_used,
+		blob.rowid,
+		blob.size
+	from excess join blob
+	on blob.rowid=(select rowid from blob where (last_used, rowid) > (excess.last_used, blob_rowid))
+	where excess.usage_with-excess.data_length >= (select value from setting where name='capacity')
+)
+select * from excess;
+`,
+	},
+}
@@ -0,0 +1,51 @@
+package sqliteStorage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	sqliteProvider "github.com/anacrolix/torrent/storage/sqlite/provider"
+)
+
+type (
+	BlobVerifier = sqliteProvider.BlobVerifier
+	CorruptBlob  = sqliteProvider.CorruptBlob
+	ScrubResult  = sqliteProvider.ScrubResult
+)
+
+// Completed pieces are stored by storage.NewResourcePieces under this, named by their hash. There
+// may be a namespace or torrent before it (see storage.ResourcePiecesOpts.PerTorrentPrefix).
+const completedPieceDir = "completed"
+
+// Returns the hex hash a completed piece's blob is named by.
+func completedPieceHash(name string) (hash string, ok bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) < 2 || parts[len(parts)-2] != completedPieceDir {
+		return
+	}
+	hash = parts[len(parts)-1]
+	_, err := hex.DecodeString(hash)
+	return hash, err == nil && len(hash) == 2*sha1.Size
+}
+
+// A BlobVerifier that checks completed pieces against the hash they're named by. The chunks of
+// incomplete pieces can't be checked on their own, and are skipped.
+type PieceVerifier struct{}
+
+var _ BlobVerifier = PieceVerifier{}
+
+func (PieceVerifier) Verifies(name string) bool {
+	_, ok := completedPieceHash(name)
+	return ok
+}
+
+func (PieceVerifier) Verify(name string, data []byte) error {
+	sum := sha1.Sum(data)
+	want, _ := completedPieceHash(name)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("piece data has hash %v", got)
+	}
+	return nil
+}
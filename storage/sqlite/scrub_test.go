@@ -0,0 +1,52 @@
+package sqliteStorage
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubPieces(t *testing.T) {
+	conns, provOpts, err := NewPool(NewPoolOpts{Path: filepath.Join(t.TempDir(), "sqlite3.db")})
+	require.NoError(t, err)
+	prov, err := NewProvider(conns, provOpts)
+	require.NoError(t, err)
+	defer prov.Close()
+	put := func(name, data string) {
+		i, err := prov.NewInstance(name)
+		require.NoError(t, err)
+		require.NoError(t, i.Put(strings.NewReader(data)))
+	}
+	hash := func(s string) string {
+		sum := sha1.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	good := "completed/" + hash("hello")
+	bad := "completed/" + hash("world")
+	put(good, "hello")
+	put(bad, "wurld")
+	// Chunks of incomplete pieces aren't checked.
+	put("incompleted/"+hash("x")+"/0", "y")
+	res, err := prov.Scrub(context.Background(), PieceVerifier{}, true)
+	require.NoError(t, err)
+	assert.Empty(t, res.Integrity)
+	assert.EqualValues(t, 2, res.Checked)
+	assert.EqualValues(t, 10, res.CheckedBytes)
+	require.Len(t, res.Corrupt, 1)
+	assert.Equal(t, bad, res.Corrupt[0].Name)
+	assert.True(t, res.Corrupt[0].Deleted)
+	i, err := prov.NewInstance(bad)
+	require.NoError(t, err)
+	_, err = i.Stat()
+	assert.Error(t, err)
+	i, err = prov.NewInstance(good)
+	require.NoError(t, err)
+	_, err = i.Stat()
+	assert.NoError(t, err)
+}
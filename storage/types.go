@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"github.com/anacrolix/torrent/storage/types"
+)
+
+// Kinds of storage failure, for use with errors.Is on errors from storage, such as those passed to
+// Torrent.SetOnWriteChunkError. See package types.
+var (
+	ErrDiskFull         = types.ErrDiskFull
+	ErrReadOnly         = types.ErrReadOnly
+	ErrCapacityExceeded = types.ErrCapacityExceeded
+	ErrClosed           = types.ErrClosed
+	ErrBusy             = types.ErrBusy
+)
+
+type (
+	Error     = types.Error
+	ReadRange = types.ReadRange
+	ReadStats = types.ReadStats
+)
+
+// Returns err wrapped with the kind of storage failure it represents, if it's recognized and not
+// already of a kind.
+func ClassifyError(err error) error {
+	return types.ClassifyError(err)
+}
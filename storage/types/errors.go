@@ -0,0 +1,62 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Kinds of storage failure, for use with errors.Is on errors from storage, such as those passed to
+// Torrent.SetOnWriteChunkError.
+var (
+	ErrDiskFull         = errors.New("storage full")
+	ErrReadOnly         = errors.New("storage read-only")
+	ErrCapacityExceeded = errors.New("storage capacity exceeded")
+	ErrClosed           = errors.New("storage closed")
+	// The storage stayed busy, such as a sqlite database locked by other writers.
+	ErrBusy = errors.New("storage busy")
+)
+
+// An error from a storage implementation, with one of the kinds above.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+func (e Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Returns err wrapped with the kind of storage failure it represents, if it's recognized and not
+// already of a kind. Implementations can return the kinds themselves where they know better.
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range []error{ErrDiskFull, ErrReadOnly, ErrCapacityExceeded, ErrClosed, ErrBusy} {
+		if errors.Is(err, kind) {
+			return err
+		}
+	}
+	var kind error
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		kind = ErrDiskFull
+	case errors.Is(err, syscall.EROFS):
+		kind = ErrReadOnly
+	case errors.Is(err, os.ErrClosed):
+		kind = ErrClosed
+	default:
+		return err
+	}
+	return Error{kind, err}
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	err := ClassifyError(&os.PathError{Op: "write", Path: "a", Err: syscall.ENOSPC})
+	assert.True(t, errors.Is(err, ErrDiskFull))
+	assert.True(t, errors.Is(err, syscall.ENOSPC))
+	assert.False(t, errors.Is(err, ErrReadOnly))
+	assert.True(t, errors.Is(ClassifyError(os.ErrClosed), ErrClosed))
+	// Already classified errors are left alone.
+	busy := Error{ErrBusy, errors.New("locked")}
+	assert.Equal(t, busy, ClassifyError(busy))
+	other := errors.New("other")
+	assert.Equal(t, other, ClassifyError(other))
+	assert.Nil(t, ClassifyError(nil))
+}
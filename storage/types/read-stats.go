@@ -0,0 +1,45 @@
+package types
+
+import (
+	"time"
+)
+
+// Counts of piece data reads for the lifetime of a storage.ClientImpl. See
+// storage.ReadStatsReporter.
+type ReadStats struct {
+	Reads int64
+	// Reads served by a cache, and reads that had to go to the backing store. Both are zero if the
+	// storage has no cache.
+	CacheHits   int64
+	CacheMisses int64
+	// Bytes served by a cache, and bytes read from the backing store.
+	CacheBytes   int64
+	BackingBytes int64
+	// The sum of the durations of all the reads, whether or not they were cached.
+	ReadDuration time.Duration
+}
+
+func (me ReadStats) MeanReadDuration() time.Duration {
+	if me.Reads == 0 {
+		return 0
+	}
+	return me.ReadDuration / time.Duration(me.Reads)
+}
+
+// The fraction of cached reads, or zero if nothing was looked up in a cache.
+func (me ReadStats) CacheHitRatio() float64 {
+	lookups := me.CacheHits + me.CacheMisses
+	if lookups == 0 {
+		return 0
+	}
+	return float64(me.CacheHits) / float64(lookups)
+}
+
+func (me *ReadStats) Add(other ReadStats) {
+	me.Reads += other.Reads
+	me.CacheHits += other.CacheHits
+	me.CacheMisses += other.CacheMisses
+	me.CacheBytes += other.CacheBytes
+	me.BackingBytes += other.BackingBytes
+	me.ReadDuration += other.ReadDuration
+}
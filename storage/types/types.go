@@ -0,0 +1,14 @@
+// Package types holds the types shared by package storage and the backends it wraps, such as
+// sqliteProvider, so the backends don't depend on storage. Package storage re-exports them.
+package types
+
+// A range of an instance's data, for storage.RangeReader.
+type ReadRange struct {
+	Name string
+	Off  int64
+	// Filled with the data from Off.
+	Buf []byte
+	// Set to the number of bytes read into Buf, which is less than its length if the instance ends
+	// first, or doesn't exist.
+	N int
+}
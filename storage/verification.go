@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Records which pieces of a torrent are queued to be verified, so that verification interrupted by
+// a restart can resume. PieceCompletion implementations may implement this to persist the queue
+// with completion state.
+type PendingVerifications interface {
+	// Records the pieces of the torrent as pending or not, together, such as in one transaction.
+	SetVerificationPending(infoHash metainfo.Hash, pieces []int, pending bool) error
+	// Returns the indexes of the torrent's pending pieces, in order.
+	GetVerificationPending(infoHash metainfo.Hash) ([]int, error)
+}
+
+// Implemented by TorrentImpls that keep piece completion in a PieceCompletionGetSetter.
+type completionHolder interface {
+	pieceCompletion() (PieceCompletionGetSetter, metainfo.Hash)
+}
+
+func (fts *fileTorrentImpl) pieceCompletion() (PieceCompletionGetSetter, metainfo.Hash) {
+	return fts.completion, fts.infoHash
+}
+
+func (ts *mmapTorrentStorage) pieceCompletion() (PieceCompletionGetSetter, metainfo.Hash) {
+	return ts.pc, ts.infoHash
+}
+
+func (t Torrent) pendingVerifications() (pv PendingVerifications, infoHash metainfo.Hash, ok bool) {
+	ch, ok := t.TorrentImpl.(completionHolder)
+	if !ok {
+		return
+	}
+	pc, infoHash := ch.pieceCompletion()
+	pv, ok = pc.(PendingVerifications)
+	return
+}
+
+// Records whether the pieces are queued to be verified, if the storage's completion persists it
+// (see PendingVerifications).
+func (t Torrent) SetVerificationPending(pieces []int, pending bool) error {
+	pv, ih, ok := t.pendingVerifications()
+	if !ok || len(pieces) == 0 {
+		return nil
+	}
+	return pv.SetVerificationPending(ih, pieces, pending)
+}
+
+// Returns the pieces that were queued to be verified, with ok false if the storage doesn't persist
+// them.
+func (t Torrent) GetVerificationPending() (pieces []int, ok bool, err error) {
+	pv, ih, ok := t.pendingVerifications()
+	if !ok {
+		return
+	}
+	pieces, err = pv.GetVerificationPending(ih)
+	return
+}
+
+func (me *mapPieceCompletion) SetVerificationPending(infoHash metainfo.Hash, pieces []int, pending bool) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.pending == nil {
+		me.pending = make(map[metainfo.PieceKey]struct{})
+	}
+	for _, i := range pieces {
+		pk := metainfo.PieceKey{InfoHash: infoHash, Index: i}
+		if pending {
+			me.pending[pk] = struct{}{}
+		} else {
+			delete(me.pending, pk)
+		}
+	}
+	return nil
+}
+
+func (me *mapPieceCompletion) GetVerificationPending(infoHash metainfo.Hash) (pieces []int, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for pk := range me.pending {
+		if pk.InfoHash == infoHash {
+			pieces = append(pieces, pk.Index)
+		}
+	}
+	sort.Ints(pieces)
+	return
+}
@@ -25,6 +25,35 @@ func (cl Client) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (*Torr
 	return &Torrent{t}, nil
 }
 
+// Checks the storage is reachable, if the ClientImpl supports it (see Pinger).
+func (cl Client) Ping() error {
+	if p, ok := cl.ci.(Pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// Returns the space available to the storage, with ok false if the ClientImpl doesn't report it (see
+// SpaceReporter).
+func (cl Client) AvailableSpace() (avail int64, ok bool, err error) {
+	sr, ok := cl.ci.(SpaceReporter)
+	if !ok {
+		return
+	}
+	avail, err = sr.AvailableSpace()
+	return
+}
+
+// Returns the ClientImpl's read stats, with ok false if it doesn't report them (see
+// ReadStatsReporter).
+func (cl Client) ReadStats() (stats ReadStats, ok bool) {
+	rsr, ok := cl.ci.(ReadStatsReporter)
+	if !ok {
+		return
+	}
+	return rsr.ReadStats(), true
+}
+
 type Torrent struct {
 	TorrentImpl
 }
@@ -65,7 +94,9 @@ func (p Piece) WriteAt(b []byte, off int64) (n int, err error) {
 		panic("write overflows piece")
 	}
 	b = missinggo.LimitLen(b, p.mip.Length()-off)
-	return p.PieceImpl.WriteAt(b, off)
+	n, err = p.PieceImpl.WriteAt(b, off)
+	err = ClassifyError(err)
+	return
 }
 
 func (p Piece) ReadAt(b []byte, off int64) (n int, err error) {
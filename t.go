@@ -1,12 +1,15 @@
 package torrent
 
 import (
+	"errors"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anacrolix/missinggo/pubsub"
 
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
 )
 
 // The Torrent's infohash. This is fixed and cannot change. It uniquely identifies a torrent.
@@ -79,15 +82,78 @@ func (t *Torrent) PieceBytesMissing(piece int) int64 {
 	return int64(t.pieces[piece].bytesLeft())
 }
 
+// Returns the torrent's labels. See TorrentSpec.Labels.
+func (t *Torrent) Labels() []string {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return append([]string(nil), t.labels...)
+}
+
+// Adds labels to the torrent. See TorrentSpec.Labels.
+func (t *Torrent) AddLabels(labels ...string) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.addLabels(labels)
+}
+
+func (t *Torrent) addLabels(labels []string) {
+	for _, l := range labels {
+		if !t.hasLabel(l) {
+			t.labels = append(t.labels, l)
+		}
+	}
+}
+
+func (t *Torrent) hasLabel(label string) bool {
+	for _, l := range t.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
 // Drop the torrent from the client, and close it. It's always safe to do
 // this. No data corruption can, or should occur to either the torrent's data,
 // or connected peers.
 func (t *Torrent) Drop() {
 	t.cl.lock()
-	t.cl.dropTorrent(t.infoHash)
+	if t.cl.dropTorrent(t.infoHash) == nil {
+		t.runEventCommands(TorrentEventRemoved, nil)
+	}
 	t.cl.unlock()
 }
 
+// Drops the torrent, and then deletes its data. If the storage doesn't support that (see
+// storage.DataDeleter), an error is returned and the torrent isn't dropped.
+func (t *Torrent) DropAndDeleteData() error {
+	t.cl.lock()
+	var dd storage.DataDeleter
+	if t.storage != nil {
+		var ok bool
+		dd, ok = t.storage.TorrentImpl.(storage.DataDeleter)
+		if !ok {
+			t.cl.unlock()
+			return errors.New("storage doesn't support deleting data")
+		}
+	}
+	err := t.cl.dropTorrent(t.infoHash)
+	if err != nil {
+		t.cl.unlock()
+		return err
+	}
+	t.runEventCommands(TorrentEventRemoved, nil)
+	t.cl.unlock()
+	// The Torrent is closed, so only this touches the storage now, and it can take its time without
+	// holding up the Client.
+	if dd == nil {
+		return nil
+	}
+	t.storageLock.Lock()
+	defer t.storageLock.Unlock()
+	return dd.DeleteData()
+}
+
 // Number of bytes of the entire torrent we have completed. This is the sum of
 // completed pieces, and dirtied chunks of incomplete pieces. Do not use this
 // for download rate, as it can go down when pieces are lost or fail checks.
@@ -150,6 +216,7 @@ func (t *Torrent) addReader(r *reader) {
 		t.readers = make(map[*reader]struct{})
 	}
 	t.readers[r] = struct{}{}
+	r.lastUsed = time.Now()
 	r.posChanged()
 }
 
@@ -175,6 +242,16 @@ func (t *Torrent) downloadPiecesLocked(begin, end pieceIndex) {
 	}
 }
 
+// Sets an exact order in which to request pieces, overriding rarity, reader positions and
+// per-connection inclinations. Only wanted pieces are requested (see DownloadPieces and
+// File.SetPriority), and wanted pieces missing from order follow in the usual manner. Passing nil
+// restores the default ordering.
+func (t *Torrent) SetPieceOrder(order []pieceIndex) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.setPieceOrder(order)
+}
+
 func (t *Torrent) CancelPieces(begin, end pieceIndex) {
 	t.cl.lock()
 	defer t.cl.unlock()
@@ -195,7 +272,7 @@ func (t *Torrent) cancelPiecesLocked(begin, end pieceIndex) {
 func (t *Torrent) initFiles() {
 	var offset int64
 	t.files = new([]*File)
-	for _, fi := range t.info.UpvertedFiles() {
+	for i, fi := range t.info.UpvertedFiles() {
 		var path []string
 		if len(fi.PathUTF8) != 0 {
 			path = fi.PathUTF8
@@ -203,12 +280,13 @@ func (t *Torrent) initFiles() {
 			path = fi.Path
 		}
 		*t.files = append(*t.files, &File{
-			t,
-			strings.Join(append([]string{t.info.Name}, path...), "/"),
-			offset,
-			fi.Length,
-			fi,
-			PiecePriorityNone,
+			t:      t,
+			index:  i,
+			path:   strings.Join(append([]string{t.info.Name}, path...), "/"),
+			offset: offset,
+			length: fi.Length,
+			fi:     fi,
+			prio:   PiecePriorityNone,
 		})
 		offset += fi.Length
 	}
@@ -243,12 +321,30 @@ func (t *Torrent) String() string {
 	}
 }
 
+// Adds trackers to the given tiers and starts announcing to them. Changes to trackers are
+// reflected in Torrent.Metainfo, which can be saved to restore them later.
 func (t *Torrent) AddTrackers(announceList [][]string) {
 	t.cl.lock()
 	defer t.cl.unlock()
 	t.addTrackers(announceList)
 }
 
+// Removes a tracker from all tiers and stops announcing to it. It reports whether the tracker was
+// present.
+func (t *Torrent) RemoveTracker(url string) bool {
+	t.cl.lock()
+	defer t.cl.unlock()
+	return t.removeTracker(url)
+}
+
+// Replaces all trackers with the given tiers. Announcing stops for trackers that aren't in the new
+// list, and continues uninterrupted for those that are.
+func (t *Torrent) ReplaceTrackers(announceList [][]string) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.replaceTrackers(announceList)
+}
+
 func (t *Torrent) Piece(i pieceIndex) *Piece {
 	return t.piece(i)
 }
@@ -22,6 +22,7 @@ import (
 	"github.com/anacrolix/torrent/webseed"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/pion/datachannel"
+	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/dht/v2"
 	"github.com/anacrolix/log"
@@ -33,6 +34,7 @@ import (
 	"github.com/anacrolix/missinggo/v2/prioritybitmap"
 
 	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/merkle"
 	"github.com/anacrolix/torrent/metainfo"
 	pp "github.com/anacrolix/torrent/peer_protocol"
 	"github.com/anacrolix/torrent/storage"
@@ -53,6 +55,47 @@ type Torrent struct {
 	dataDownloadDisallowed bool
 	dataUploadDisallowed   bool
 	userOnWriteChunkErr    func(error)
+	// Set after the error event for a failed chunk write, until data download is next allowed.
+	writeChunkErrReported bool
+
+	hashFailureQuarantine HashFailureQuarantine
+	// Hash failures for data from peers within the quarantine window.
+	recentHashFailures []HashFailure
+	// Set while the torrent is quarantined.
+	quarantine *TorrentQuarantine
+
+	// See allPiecesBitfield.
+	allPiecesBitfieldCache []bool
+
+	// Set for BEP 30 merkle torrents, which have no piece hashes in the info.
+	merkleTree *merkle.Tree
+	// Tree hashes received from peers with the first chunk of pieces, used when the piece is next
+	// hashed.
+	merkleProposedHashes map[pieceIndex]map[int]metainfo.Hash
+	// Set while the torrent shouldn't be announced to the DHT.
+	dhtAnnouncesDisallowed missinggo.Event
+	// Pieces are assumed complete when the info is obtained. See TorrentSpec.SeedMode.
+	seedMode bool
+	// Pieces complete in a TorrentSnapshot being restored, applied when the info is set.
+	restoredCompletion *bitmap.Bitmap
+	// Applied to the storage when it's opened. See TorrentSpec.FilePaths.
+	filePaths map[int]string
+	// Set once the completion hooks have started for the current completion, and cleared when a
+	// piece is found incomplete.
+	completionHooksRan bool
+	// Held while completion hooks run.
+	completionHooksMu sync.Mutex
+
+	// See TorrentSpec.Labels.
+	labels []string
+	// When the torrent was added to the Client, and when piece data was last seen transferred by
+	// lifecycle rule evaluation.
+	addedAt    time.Time
+	lastActive time.Time
+	// Piece data transferred as of the last lifecycle rule evaluation.
+	lifecycleBytesTransferred int64
+	// Indexes of the ClientConfig.LifecycleRules that have been applied.
+	lifecycleRulesApplied map[int]struct{}
 
 	// Determines what chunks to request from peers.
 	requestStrategy requestStrategy
@@ -86,11 +129,21 @@ type Torrent struct {
 	files     *[]*File
 
 	webSeeds map[string]*peer
+	// Webseeds that have been added but aren't used for requests. See Torrent.SetWebSeedEnabled.
+	disabledWebSeeds map[string]*peer
+	// Useful piece data received from webseeds. See Torrent.DownloadSourceStats.
+	webSeedBytesUseful Count
 
 	// Active peer connections, running message stream loops. TODO: Make this
 	// open (not-closed) connections only.
 	conns               map[*PeerConn]struct{}
 	maxEstablishedConns int
+	// Active peers that sent have-all, and webseeds. See pieceAvailability.
+	peersWithAllPieces int
+	// A TorrentPriority, accessed atomically so rate limiting can read it without the Client lock.
+	priority int32
+	// When useful piece data was last received. See Client.updateTopPriority.
+	lastUsefulData time.Time
 	// Set of addrs to which we're attempting to connect. Connections are
 	// half-open until all handshakes are completed.
 	halfOpen    map[string]PeerInfo
@@ -128,6 +181,11 @@ type Torrent struct {
 	readers                map[*reader]struct{}
 	_readerNowPieces       bitmap.Bitmap
 	_readerReadaheadPieces bitmap.Bitmap
+	// Ranges readers have recently moved away from, and the pieces in them. See
+	// ClientConfig.ReaderPriorityLinger.
+	lingeringReaderPieces []lingeringPieceRange
+	_readerLingerPieces   bitmap.Bitmap
+	readerDecayTimer      *time.Timer
 
 	// A cache of pieces we need to get. Calculated from various piece and
 	// file priorities and completion states elsewhere.
@@ -137,6 +195,19 @@ type Torrent struct {
 	// Pieces that need to be hashed.
 	piecesQueuedForHash bitmap.Bitmap
 	activePieceHashes   int
+	// Pieces recorded in storage as queued for verification. See QueueDataVerification.
+	piecesPendingVerification bitmap.Bitmap
+	// Changes to piecesPendingVerification not yet written to storage, by piece. They're written in
+	// batches, outside the client lock, by writeVerificationPending.
+	verificationPendingChanges     map[pieceIndex]bool
+	verificationPendingWriteQueued bool
+	// Held while writing the changes, so they reach storage in order.
+	verificationPendingWriteMu sync.Mutex
+
+	// An exact order to request pieces in ahead of all others, set by SetPieceOrder.
+	// pieceOrderRanks maps a piece index to its first position in pieceOrder.
+	pieceOrder      []pieceIndex
+	pieceOrderRanks map[pieceIndex]int
 
 	// A pool of piece priorities []int for assignment to new connections.
 	// These "inclinations" are used to give connections preference for
@@ -165,6 +236,10 @@ func (t *Torrent) readerReadaheadPieces() bitmap.Bitmap {
 	return t._readerReadaheadPieces
 }
 
+func (t *Torrent) readerLingerPieces() bitmap.Bitmap {
+	return t._readerLingerPieces
+}
+
 func (t *Torrent) ignorePieces() bitmap.Bitmap {
 	ret := t._completedPieces.Copy()
 	ret.Union(t.piecesQueuedForHash)
@@ -270,6 +345,12 @@ func (t *Torrent) addPeer(p PeerInfo) (added bool) {
 	if t.closed.IsSet() {
 		return false
 	}
+	var ok bool
+	p.Addr, ok = cl.resolveOverlayAddr(p.Addr)
+	if !ok {
+		torrent.Add("peers not added because no overlay claims their host", 1)
+		return false
+	}
 	if ipAddr, ok := tryIpPortFromNetAddr(p.Addr); ok {
 		if cl.badPeerIPPort(ipAddr.IP, ipAddr.Port) {
 			torrent.Add("peers not added because of bad addr", 1)
@@ -342,13 +423,20 @@ func infoPieceHashes(info *metainfo.Info) (ret [][]byte) {
 
 func (t *Torrent) makePieces() {
 	hashes := infoPieceHashes(t.info)
-	t.pieces = make([]Piece, len(hashes))
-	for i, hash := range hashes {
+	if t.info.IsMerkle() {
+		var root metainfo.Hash
+		missinggo.CopyExact(&root, t.info.RootHash)
+		t.merkleTree = merkle.NewTree(root, t.info.NumPieces())
+	}
+	t.pieces = make([]Piece, t.info.NumPieces())
+	for i := range t.pieces {
 		piece := &t.pieces[i]
 		piece.t = t
 		piece.index = pieceIndex(i)
 		piece.noPendingWrites.L = &piece.pendingWritesMutex
-		piece.hash = (*metainfo.Hash)(unsafe.Pointer(&hash[0]))
+		if t.merkleTree == nil {
+			piece.hash = (*metainfo.Hash)(unsafe.Pointer(&hashes[i][0]))
+		}
 		files := *t.files
 		beginFile := pieceFirstFileIndex(piece.torrentBeginOffset(), files)
 		endFile := pieceEndFileIndex(piece.torrentEndOffset(), files)
@@ -390,12 +478,23 @@ func (t *Torrent) setInfo(info *metainfo.Info) error {
 	if err := validateInfo(info); err != nil {
 		return fmt.Errorf("bad info: %s", err)
 	}
+	if info.IsMerkle() && !t.cl.config.EnableMerkleTorrents {
+		return errors.New("merkle torrents are disabled")
+	}
 	if t.storageOpener != nil {
 		var err error
 		t.storage, err = t.storageOpener.OpenTorrent(info, t.infoHash)
 		if err != nil {
 			return fmt.Errorf("error opening torrent storage: %s", err)
 		}
+		for i, path := range t.filePaths {
+			err = t.storageFileRelocator().RelocateFile(i, path, false)
+			if err != nil {
+				t.storage.Close()
+				t.storage = nil
+				return fmt.Errorf("setting path of file %d: %w", i, err)
+			}
+		}
 	}
 	t.nameMu.Lock()
 	t.info = info
@@ -408,11 +507,109 @@ func (t *Torrent) setInfo(info *metainfo.Info) error {
 	return nil
 }
 
+// Returns the storage's FileRelocator, or one that always fails if it doesn't support relocation.
+func (t *Torrent) storageFileRelocator() storage.FileRelocator {
+	if fr, ok := t.storage.TorrentImpl.(storage.FileRelocator); ok {
+		return fr
+	}
+	return unsupportedFileRelocator{}
+}
+
+type unsupportedFileRelocator struct{}
+
+func (unsupportedFileRelocator) RelocateFile(int, string, bool) error {
+	return errors.New("storage doesn't support file paths")
+}
+
+// Sets the path for a file without moving data. If the storage isn't open yet, it's applied when it
+// is.
+func (t *Torrent) setFilePath(index int, path string) error {
+	t.cl.lock()
+	haveStorage := t.storage != nil
+	if !haveStorage {
+		if t.filePaths == nil {
+			t.filePaths = make(map[int]string)
+		}
+		t.filePaths[index] = path
+	}
+	t.cl.unlock()
+	if haveStorage {
+		return t.relocateFile(index, path, false)
+	}
+	return nil
+}
+
+func (t *Torrent) relocateFile(index int, path string, move bool) error {
+	t.cl.lock()
+	if t.storage == nil {
+		t.cl.unlock()
+		return errors.New("storage closed")
+	}
+	fr := t.storageFileRelocator()
+	t.cl.unlock()
+	// Moving can take a while, and the storage handles concurrent access to the file itself.
+	err := fr.RelocateFile(index, path, move)
+	if err != nil {
+		return err
+	}
+	t.cl.lock()
+	defer t.cl.unlock()
+	if t.filePaths == nil {
+		t.filePaths = make(map[int]string)
+	}
+	t.filePaths[index] = path
+	// The data might not have made it, or we may have moved onto data that was already there.
+	for i := (*t.files)[index].firstPieceIndex(); i < (*t.files)[index].endPieceIndex(); i++ {
+		t.updatePieceCompletion(i)
+	}
+	return nil
+}
+
+func (t *Torrent) enterSeedMode() {
+	if t.seedMode {
+		return
+	}
+	t.seedMode = true
+	if t.haveInfo() {
+		t.assumePiecesComplete()
+		for i := range t.pieces {
+			t.updatePieceCompletion(i)
+		}
+	}
+}
+
+// Marks pieces complete in storage without hashing them. They're verified if reading them for a
+// peer fails.
+func (t *Torrent) assumePiecesComplete() {
+	for i := range t.pieces {
+		p := &t.pieces[i]
+		if p.hashing || p.queuedForHash() || t.pieceCompleteUncached(i).Complete {
+			continue
+		}
+		if err := p.Storage().MarkComplete(); err != nil {
+			t.logger.Printf("error marking piece %d complete for seed mode: %v", i, err)
+			continue
+		}
+		p.assumedComplete = true
+	}
+	torrent.Add("seed mode torrents", 1)
+}
+
 // This seems to be all the follow-up tasks after info is set, that can't fail.
 func (t *Torrent) onSetInfo() {
 	t.iterPeers(func(p *peer) {
 		p.onGotInfo(t.info)
 	})
+	for _, ws := range t.disabledWebSeeds {
+		ws.onGotInfo(t.info)
+	}
+	t.initPieceAvailability()
+	if t.seedMode {
+		t.assumePiecesComplete()
+	}
+	if t.restoredCompletion != nil {
+		t.verifyRestoredCompletion()
+	}
 	for i := range t.pieces {
 		t.updatePieceCompletion(pieceIndex(i))
 		p := &t.pieces[i]
@@ -421,7 +618,12 @@ func (t *Torrent) onSetInfo() {
 			t.queuePieceCheck(pieceIndex(i))
 		}
 	}
+	t.resumeVerification()
 	t.cl.event.Broadcast()
+	// Data that's complete when loaded has already been handled.
+	t.completionHooksRan = t.haveAllPieces()
+	t.startLoadedFileScans()
+	t.maybeBuildMerkleTree()
 	t.gotMetainfo.Set()
 	t.updateWantPeersEvent()
 	t.pendingRequests = make(map[request]int)
@@ -721,12 +923,19 @@ func (t *Torrent) numPiecesCompleted() (num int) {
 
 func (t *Torrent) close() (err error) {
 	t.closed.Set()
+	t.cancelFileScans()
 	t.tickleReaders()
+	if t.readerDecayTimer != nil {
+		t.readerDecayTimer.Stop()
+	}
 	if t.storage != nil {
 		t.storageLock.Lock()
 		t.storage.Close()
 		t.storageLock.Unlock()
 	}
+	if uc := t.cl.uploadCache; uc != nil {
+		uc.invalidateTorrent(t.infoHash)
+	}
 	for conn := range t.conns {
 		conn.close()
 	}
@@ -756,9 +965,21 @@ func (t *Torrent) writeChunk(piece int, begin int64, data []byte) (err error) {
 	return err
 }
 
+// Returns a bitfield with every piece set. It's shared between connections to avoid building one for
+// each, and must not be modified.
+func (t *Torrent) allPiecesBitfield() []bool {
+	if len(t.allPiecesBitfieldCache) != t.numPieces() {
+		t.allPiecesBitfieldCache = make([]bool, t.numPieces())
+		for i := range t.allPiecesBitfieldCache {
+			t.allPiecesBitfieldCache[i] = true
+		}
+	}
+	return t.allPiecesBitfieldCache
+}
+
 func (t *Torrent) bitfield() (bf []bool) {
 	bf = make([]bool, t.numPieces())
-	t._completedPieces.IterTyped(func(piece int) (again bool) {
+	t.advertisedPieces().IterTyped(func(piece int) (again bool) {
 		bf[piece] = true
 		return true
 	})
@@ -788,7 +1009,7 @@ func (t *Torrent) pieceLength(piece pieceIndex) pp.Integer {
 }
 
 func (t *Torrent) hashPiece(piece pieceIndex) (ret metainfo.Hash, err error) {
-	hash := pieceHash.New()
+	hash := t.cl.newPieceHash()
 	p := t.piece(piece)
 	p.waitNoPendingWrites()
 	storagePiece := t.pieces[piece].Storage()
@@ -865,7 +1086,7 @@ func (t *Torrent) wantPieceIndex(index pieceIndex) bool {
 
 // The worst connection is one that hasn't been sent, or sent anything useful for the longest. A bad
 // connection is one that usually sends us unwanted pieces, or has been in worser half of the
-// established connections for more than a minute.
+// established connections for longer than the probation period.
 func (t *Torrent) worstBadConn() *PeerConn {
 	wcs := worseConnSlice{t.unclosedConnsAsSlice()}
 	heap.Init(&wcs)
@@ -877,8 +1098,8 @@ func (t *Torrent) worstBadConn() *PeerConn {
 		// If the connection is in the worst half of the established
 		// connection quota and is older than a minute.
 		if wcs.Len() >= (t.maxEstablishedConns+1)/2 {
-			// Give connections 1 minute to prove themselves.
-			if time.Since(c.completedHandshake) > time.Minute {
+			// Give connections some time to prove themselves.
+			if time.Since(c.completedHandshake) > t.cl.peerProbationPeriod() {
 				return c
 			}
 		}
@@ -923,12 +1144,15 @@ func (t *Torrent) readersChanged() {
 
 func (t *Torrent) updateReaderPieces() {
 	t._readerNowPieces, t._readerReadaheadPieces = t.readerPiecePriorities()
+	t._readerLingerPieces = t.readerLingerPiecePriorities()
+	t.scheduleReaderPriorityDecay()
 }
 
 func (t *Torrent) readerPosChanged(from, to pieceRange) {
 	if from == to {
 		return
 	}
+	t.addLingeringReaderPieces(from)
 	t.updateReaderPieces()
 	// Order the ranges, high and low.
 	l, h := from, to
@@ -983,6 +1207,44 @@ func (t *Torrent) updatePiecePriority(piece pieceIndex) {
 	t.piecePriorityChanged(piece)
 }
 
+func (t *Torrent) setPieceOrder(order []pieceIndex) {
+	t.pieceOrder = nil
+	t.pieceOrderRanks = nil
+	if len(order) != 0 {
+		t.pieceOrder = append(t.pieceOrder, order...)
+		t.pieceOrderRanks = make(map[pieceIndex]int, len(order))
+		for i, piece := range order {
+			if _, ok := t.pieceOrderRanks[piece]; !ok {
+				t.pieceOrderRanks[piece] = i
+			}
+		}
+	}
+	if !t.haveInfo() {
+		return
+	}
+	t.iterPeers(func(c *peer) {
+		changed := false
+		for i := pieceIndex(0); i < t.numPieces(); i++ {
+			if c.updatePiecePriority(i) {
+				changed = true
+			}
+		}
+		if changed {
+			c.updateRequests()
+		}
+	})
+}
+
+func (t *Torrent) explicitPieceOrder() []pieceIndex {
+	return t.pieceOrder
+}
+
+// Returns the position of the piece in the order given to SetPieceOrder.
+func (t *Torrent) pieceOrderRank(piece pieceIndex) (rank int, ok bool) {
+	rank, ok = t.pieceOrderRanks[piece]
+	return
+}
+
 func (t *Torrent) updateAllPiecePriorities() {
 	t.updatePiecePriorities(0, t.numPieces())
 }
@@ -1021,7 +1283,7 @@ func (t *Torrent) byteRegionPieces(off, size int64) (begin, end pieceIndex) {
 func (t *Torrent) forReaderOffsetPieces(f func(begin, end pieceIndex) (more bool)) (all bool) {
 	for r := range t.readers {
 		p := r.pieces
-		if p.begin >= p.end {
+		if p.begin >= p.end || t.readerIdle(r) {
 			continue
 		}
 		if !f(p.begin, p.end) {
@@ -1056,7 +1318,15 @@ func (t *Torrent) pieceCompletionChanged(piece pieceIndex) {
 	t.cl.event.Broadcast()
 	if t.pieceComplete(piece) {
 		t.onPieceCompleted(piece)
+		t.maybeRunCompletionHooks()
+		if t.haveAllPieces() {
+			t.cl.updateTopPriority()
+		}
 	} else {
+		t.completionHooksRan = false
+		if uc := t.cl.uploadCache; uc != nil {
+			uc.invalidatePiece(t.infoHash, piece, int64(t.pieceLength(piece)))
+		}
 		t.onIncompletePiece(piece)
 	}
 	t.updatePiecePriority(piece)
@@ -1140,9 +1410,10 @@ func (t *Torrent) updatePieceCompletion(piece pieceIndex) bool {
 func (t *Torrent) readAt(b []byte, off int64) (n int, err error) {
 	for len(b) != 0 {
 		p := &t.pieces[off/t.info.PieceLength]
-		p.waitNoPendingWrites()
+		pieceOff := off - p.Info().Offset()
+		p.waitNoPendingWritesIn(pieceOff, min(int64(len(b)), p.Info().Length()-pieceOff))
 		var n1 int
-		n1, err = p.Storage().ReadAt(b, off-p.Info().Offset())
+		n1, err = p.Storage().ReadAt(b, pieceOff)
 		if n1 == 0 {
 			break
 		}
@@ -1172,6 +1443,7 @@ func (t *Torrent) maybeCompleteMetadata() error {
 	if t.cl.config.Debug {
 		t.logger.Printf("%s: got metadata from peers", t)
 	}
+	t.cacheMetadata()
 	return nil
 }
 
@@ -1228,6 +1500,72 @@ func (t *Torrent) addTrackers(announceList [][]string) {
 	t.updateWantPeersEvent()
 }
 
+func (t *Torrent) removeTracker(_url string) (removed bool) {
+	if t.metainfo.Announce == _url {
+		t.metainfo.Announce = ""
+		removed = true
+	}
+	var announceList [][]string
+	for _, tier := range t.metainfo.AnnounceList {
+		var newTier []string
+		for _, u := range tier {
+			if u == _url {
+				removed = true
+			} else {
+				newTier = append(newTier, u)
+			}
+		}
+		if len(newTier) != 0 {
+			announceList = append(announceList, newTier)
+		}
+	}
+	t.metainfo.AnnounceList = announceList
+	for _, key := range trackerAnnouncerKeys(_url) {
+		t.stopTrackerAnnouncer(key)
+	}
+	return
+}
+
+func (t *Torrent) replaceTrackers(announceList [][]string) {
+	t.metainfo.Announce = ""
+	t.metainfo.AnnounceList = nil
+	wanted := make(map[string]struct{})
+	for _, tier := range announceList {
+		for _, u := range tier {
+			for _, key := range trackerAnnouncerKeys(u) {
+				wanted[key] = struct{}{}
+			}
+		}
+	}
+	for key := range t.trackerAnnouncers {
+		if _, ok := wanted[key]; !ok {
+			t.stopTrackerAnnouncer(key)
+		}
+	}
+	t.addTrackers(announceList)
+}
+
+// Returns the keys in Torrent.trackerAnnouncers that a tracker URL is announced under.
+func trackerAnnouncerKeys(_url string) []string {
+	u, err := url.Parse(_url)
+	if err != nil || u.Scheme != "udp" {
+		return []string{_url}
+	}
+	u.Scheme = "udp4"
+	udp4 := u.String()
+	u.Scheme = "udp6"
+	return []string{udp4, u.String()}
+}
+
+func (t *Torrent) stopTrackerAnnouncer(key string) {
+	ta, ok := t.trackerAnnouncers[key]
+	if !ok {
+		return
+	}
+	delete(t.trackerAnnouncers, key)
+	ta.stop()
+}
+
 // Don't call this before the info is available.
 func (t *Torrent) bytesCompleted() int64 {
 	if !t.haveInfo() {
@@ -1257,6 +1595,8 @@ func (t *Torrent) deleteConnection(c *PeerConn) (ret bool) {
 		if !t.cl.config.DisablePEX {
 			t.pex.Drop(c)
 		}
+		t.cl.deletePeerIDConn(c)
+		t.decPeerPieceAvailability(&c.peer)
 	}
 	torrent.Add("deleted connections", 1)
 	c.deleteAllRequests()
@@ -1314,13 +1654,13 @@ func (t *Torrent) seeding() bool {
 	if t.closed.IsSet() {
 		return false
 	}
-	if t.dataUploadDisallowed {
+	if t.dataUploadDisallowed || t.quarantined() {
 		return false
 	}
 	if cl.config.NoUpload {
 		return false
 	}
-	if !cl.config.Seed {
+	if !cl.config.Seed && !cl.config.SeedOnly {
 		return false
 	}
 	if cl.config.DisableAggressiveUpload && t.needData() {
@@ -1374,11 +1714,13 @@ func (t *Torrent) runHandshookConnLoggingErr(pc *PeerConn) {
 
 func (t *Torrent) startWebsocketAnnouncer(u url.URL) torrentTrackerAnnouncer {
 	wtc, release := t.cl.websocketTrackers.Get(u.String())
+	var releaseOnce sync.Once
+	stop := func() { releaseOnce.Do(release) }
 	go func() {
 		<-t.closed.LockedChan(t.cl.locker())
-		release()
+		stop()
 	}()
-	wst := websocketTrackerStatus{u, wtc}
+	wst := websocketTrackerStatus{u, wtc, stop}
 	go func() {
 		err := wtc.Announce(tracker.Started, t.infoHash)
 		if err != nil {
@@ -1431,7 +1773,7 @@ func (t *Torrent) startScrapingTracker(_url string) {
 				return nil
 			}
 		}
-		urlString := (*u).String()
+		host := u.Host
 		cl := t.cl
 		newAnnouncer := &trackerScraper{
 			u: *u,
@@ -1440,21 +1782,20 @@ func (t *Torrent) startScrapingTracker(_url string) {
 				cl.lock()
 				defer cl.unlock()
 				if cl.activeAnnounces == nil {
-					cl.activeAnnounces = make(map[string]struct{})
+					cl.activeAnnounces = make(map[string]int)
 				}
-				for {
-					if _, ok := cl.activeAnnounces[urlString]; ok {
-						cl.event.Wait()
-					} else {
-						break
-					}
+				for cl.activeAnnounces[host] >= cl.trackerHostAnnounceConcurrency() {
+					cl.event.Wait()
 				}
-				cl.activeAnnounces[urlString] = struct{}{}
+				cl.activeAnnounces[host]++
 			},
 			done: func(slowdown bool) {
 				cl.lock()
 				defer cl.unlock()
-				delete(cl.activeAnnounces, urlString)
+				cl.activeAnnounces[host]--
+				if cl.activeAnnounces[host] == 0 {
+					delete(cl.activeAnnounces, host)
+				}
 				cl.event.Broadcast()
 			},
 		}
@@ -1514,7 +1855,7 @@ func (t *Torrent) announceRequest(event tracker.AnnounceEvent) tracker.AnnounceR
 
 // Adds peers revealed in an announce until the announce ends, or we have
 // enough peers.
-func (t *Torrent) consumeDhtAnnouncePeers(pvs <-chan dht.PeersValues) {
+func (t *Torrent) consumeDhtAnnouncePeers(pvs <-chan dht.PeersValues, counts *dhtFamilyCounts) {
 	cl := t.cl
 	for v := range pvs {
 		cl.lock()
@@ -1523,6 +1864,7 @@ func (t *Torrent) consumeDhtAnnouncePeers(pvs <-chan dht.PeersValues) {
 				// Can't do anything with this.
 				continue
 			}
+			counts.PeersFound.Add(1)
 			t.addPeer(PeerInfo{
 				Addr:   ipPortAddr{cp.IP, cp.Port},
 				Source: PeerSourceDhtGetPeers,
@@ -1533,14 +1875,17 @@ func (t *Torrent) consumeDhtAnnouncePeers(pvs <-chan dht.PeersValues) {
 }
 
 func (t *Torrent) announceToDht(impliedPort bool, s DhtServer) error {
-	ps, err := s.Announce(t.infoHash, t.cl.incomingPeerPort(), impliedPort)
+	counts := &t.cl.dhtFamilyCounts[dhtServerFamily(s)]
+	counts.Announces.Add(1)
+	ps, err := s.Announce(t.infoHash, t.cl.dhtAnnouncePort(), impliedPort)
 	if err != nil {
 		return err
 	}
-	go t.consumeDhtAnnouncePeers(ps.Peers())
+	go t.consumeDhtAnnouncePeers(ps.Peers(), counts)
 	select {
 	case <-t.closed.LockedChan(t.cl.locker()):
-	case <-time.After(5 * time.Minute):
+	case <-t.dhtAnnouncesDisallowed.LockedChan(t.cl.locker()):
+	case <-time.After(t.cl.dhtAnnounceInterval()):
 	}
 	ps.Close()
 	return nil
@@ -1555,7 +1900,7 @@ func (t *Torrent) dhtAnnouncer(s DhtServer) {
 			if t.closed.IsSet() {
 				return
 			}
-			if !t.wantPeers() {
+			if !t.wantPeers() || t.dhtAnnouncesDisallowed.IsSet() {
 				goto wait
 			}
 			// TODO: Determine if there's a listener on the port we're announcing.
@@ -1570,9 +1915,11 @@ func (t *Torrent) dhtAnnouncer(s DhtServer) {
 			t.numDHTAnnounces++
 			cl.unlock()
 			defer cl.lock()
-			err := t.announceToDht(true, s)
+			// Without a port or an implied port, the DHT only gets peers.
+			err := t.announceToDht(!cl.config.DisableDhtImpliedPort && !cl.config.OutgoingOnly, s)
 			if err != nil {
 				t.logger.WithDefaultLevel(log.Warning).Printf("error announcing %q to DHT: %s", t, err)
+				cl.countError(ErrorCategoryDht, err)
 			}
 		}()
 	}
@@ -1683,6 +2030,8 @@ func (t *Torrent) addConnection(c *PeerConn) (err error) {
 		panic(len(t.conns))
 	}
 	t.conns[c] = struct{}{}
+	t.cl.addPeerIDConn(c)
+	t.incPeerPieceAvailability(&c.peer)
 	if !t.cl.config.DisablePEX && !c.PeerExtensionBytes.SupportsExtended() {
 		t.pex.Add(c) // as no further extended handshake expected
 	}
@@ -1724,6 +2073,7 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 	t.logger.Log(log.Fstr("hashed piece %d (passed=%t)", piece, passed).SetLevel(log.Debug))
 	p := t.piece(piece)
 	p.numVerifies++
+	p.assumedComplete = false
 	t.cl.event.Broadcast()
 	if t.closed.IsSet() {
 		return
@@ -1752,6 +2102,7 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 		err := p.Storage().MarkComplete()
 		if err != nil {
 			t.logger.Printf("%T: error marking piece complete %d: %s", t.storage, piece, err)
+			t.cl.countError(ErrorCategoryStorage, err)
 		}
 		t.cl.lock()
 
@@ -1772,12 +2123,15 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 			}
 
 			bannableTouchers := make([]*peer, 0, len(p.dirtiers))
+			touchers := make([]*peer, 0, len(p.dirtiers))
 			for c := range p.dirtiers {
+				touchers = append(touchers, c)
 				if !c.trusted {
 					bannableTouchers = append(bannableTouchers, c)
 				}
 			}
 			t.clearPieceTouchers(piece)
+			t.onPeerHashFailure(piece, touchers)
 			slices.Sort(bannableTouchers, connLessTrusted)
 
 			if t.cl.config.Debug {
@@ -1815,6 +2169,9 @@ func (t *Torrent) cancelRequestsForPiece(piece pieceIndex) {
 func (t *Torrent) onPieceCompleted(piece pieceIndex) {
 	t.pendAllChunkSpecs(piece)
 	t.cancelRequestsForPiece(piece)
+	if !t.pieceScanned(piece) {
+		return
+	}
 	for conn := range t.conns {
 		conn.have(piece)
 	}
@@ -1848,7 +2205,16 @@ func (t *Torrent) onIncompletePiece(piece pieceIndex) {
 }
 
 func (t *Torrent) tryCreateMorePieceHashers() {
-	for !t.closed.IsSet() && t.activePieceHashes < 2 && t.tryCreatePieceHasher() {
+	if t.cl.config.PieceHashers > 0 {
+		// The Client shares the hashers out among its torrents.
+		t.cl.tryCreateMorePieceHashers()
+		return
+	}
+	t.startPieceHashers()
+}
+
+func (t *Torrent) startPieceHashers() {
+	for !t.closed.IsSet() && t.activePieceHashes < 2 && t.cl.pieceHashAllowed() && t.tryCreatePieceHasher() {
 	}
 }
 
@@ -1867,11 +2233,26 @@ func (t *Torrent) tryCreatePieceHasher() bool {
 	t.updatePiecePriority(pi)
 	t.storageLock.RLock()
 	t.activePieceHashes++
+	t.cl.activePieceHashes++
 	go t.pieceHasher(pi)
 	return true
 }
 
+// Pieces readers are waiting on are hashed first.
 func (t *Torrent) getPieceToHash() (ret pieceIndex, ok bool) {
+	for _, wanted := range []bitmap.Bitmap{t.readerNowPieces(), t.readerReadaheadPieces()} {
+		wanted.IterTyped(func(i pieceIndex) bool {
+			if !t.piecesQueuedForHash.Get(bitmap.BitIndex(i)) || t.piece(i).hashing {
+				return true
+			}
+			ret = i
+			ok = true
+			return false
+		})
+		if ok {
+			return
+		}
+	}
 	t.piecesQueuedForHash.IterTyped(func(i pieceIndex) bool {
 		if t.piece(i).hashing {
 			return true
@@ -1886,21 +2267,134 @@ func (t *Torrent) getPieceToHash() (ret pieceIndex, ok bool) {
 func (t *Torrent) pieceHasher(index pieceIndex) {
 	p := t.piece(index)
 	sum, copyErr := t.hashPiece(index)
-	correct := sum == *p.hash
 	switch copyErr {
 	case nil, io.EOF:
 	default:
-		log.Fmsg("piece %v (%s) hash failure copy error: %v", p, p.hash.HexString(), copyErr).Log(t.logger)
+		log.Fmsg("piece %v hash failure copy error: %v", p, copyErr).Log(t.logger)
+		t.cl.countError(ErrorCategoryHashing, copyErr)
 	}
 	t.storageLock.RUnlock()
 	t.cl.lock()
-	defer t.cl.unlock()
+	correct := t.pieceHashCorrect(index, sum)
 	p.hashing = false
 	t.updatePiecePriority(index)
 	t.pieceHashed(index, correct, copyErr)
+	t.verificationDone(index)
 	t.publishPieceChange(index)
 	t.activePieceHashes--
+	t.cl.activePieceHashes--
 	t.tryCreateMorePieceHashers()
+	res := PieceHashResult{
+		Index:  index,
+		Hash:   sum,
+		Passed: correct,
+	}
+	if copyErr != io.EOF {
+		res.Err = copyErr
+	}
+	runHandlers := t.pieceHashedHandlers(res)
+	t.cl.unlock()
+	runHandlers()
+}
+
+// Verifies and stores a piece obtained outside the swarm, such as from an HTTP mirror or another
+// node in a cluster. On success the piece is marked complete and peers are told we have it. Data
+// beyond the piece length is not read.
+func (t *Torrent) ImportPiece(index pieceIndex, r io.Reader) error {
+	t.cl.lock()
+	if !t.haveInfo() {
+		t.cl.unlock()
+		return errors.New("torrent info not available")
+	}
+	if index < 0 || index >= t.numPieces() {
+		t.cl.unlock()
+		return fmt.Errorf("piece index %d out of range", index)
+	}
+	if t.pieceComplete(index) {
+		t.cl.unlock()
+		return nil
+	}
+	p := t.piece(index)
+	data := make([]byte, p.length())
+	t.cl.unlock()
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("reading piece data: %w", err)
+	}
+	hash := t.cl.newPieceHash()
+	hash.Write(data)
+	var sum metainfo.Hash
+	missinggo.CopyExact(&sum, hash.Sum(nil))
+	t.cl.lock()
+	correct := t.pieceHashCorrect(index, sum)
+	t.cl.unlock()
+	if !correct {
+		return fmt.Errorf("piece %d hash mismatch", index)
+	}
+	p.waitNoPendingWrites()
+	t.storageLock.RLock()
+	err := t.writeChunk(index, 0, data)
+	t.storageLock.RUnlock()
+	if err != nil {
+		return fmt.Errorf("writing piece: %w", err)
+	}
+	t.cl.lock()
+	if t.closed.IsSet() {
+		t.cl.unlock()
+		return errors.New("torrent closed")
+	}
+	torrent.Add("pieces imported", 1)
+	t.pieceHashed(index, true, nil)
+	t.publishPieceChange(index)
+	runHandlers := t.pieceHashedHandlers(PieceHashResult{
+		Index:  index,
+		Hash:   sum,
+		Passed: true,
+	})
+	t.cl.unlock()
+	runHandlers()
+	return nil
+}
+
+// Returns a func that runs the handlers registered for a hashed piece and its files. It should be
+// called without the Client lock. Handlers for completed files wait for any content scans.
+func (t *Torrent) pieceHashedHandlers(res PieceHashResult) func() {
+	if t.closed.IsSet() {
+		return func() {}
+	}
+	t.updateFileScans(res)
+	p := t.piece(res.Index)
+	var pieceHandlers []*func(PieceHashResult)
+	pieceHandlers = append(pieceHandlers, p.hashedHandlers...)
+	type fileHandlers struct {
+		handlers []*func(FileHashResult)
+		res      FileHashResult
+	}
+	var files []fileHandlers
+	for _, f := range p.files {
+		if len(f.hashedHandlers) == 0 {
+			continue
+		}
+		complete := res.Passed && f.piecesComplete()
+		if res.Passed && !complete {
+			continue
+		}
+		if complete && t.scanningContent() && f.scan != fileScanPassed {
+			continue
+		}
+		fh := fileHandlers{res: FileHashResult{File: f, Complete: complete, Piece: res}}
+		fh.handlers = append(fh.handlers, f.hashedHandlers...)
+		files = append(files, fh)
+	}
+	return func() {
+		for _, h := range pieceHandlers {
+			(*h)(res)
+		}
+		for _, f := range files {
+			for _, h := range f.handlers {
+				(*h)(f.res)
+			}
+		}
+	}
 }
 
 // Return the connections that touched a piece, and clear the entries while doing it.
@@ -2017,6 +2511,12 @@ func (t *Torrent) requestStrategyCallbacks() requestStrategyCallbacks {
 }
 
 func (t *Torrent) onWriteChunkErr(err error) {
+	t.cl.countError(ErrorCategoryStorage, err)
+	if !t.writeChunkErrReported {
+		// Chunk writes tend to fail together, so report only the first.
+		t.writeChunkErrReported = true
+		t.runEventCommands(TorrentEventError, err)
+	}
 	if t.userOnWriteChunkErr != nil {
 		go t.userOnWriteChunkErr(err)
 		return
@@ -2025,6 +2525,21 @@ func (t *Torrent) onWriteChunkErr(err error) {
 	t.disallowDataDownloadLocked()
 }
 
+// Stops announcing the torrent to the DHT. An announce in progress is abandoned.
+func (t *Torrent) DisallowDhtAnnounces() {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.dhtAnnouncesDisallowed.Set()
+}
+
+// Resumes announcing the torrent to the DHT, if it was disallowed.
+func (t *Torrent) AllowDhtAnnounces() {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.dhtAnnouncesDisallowed.Clear()
+	t.cl.event.Broadcast()
+}
+
 func (t *Torrent) DisallowDataDownload() {
 	t.cl.lock()
 	defer t.cl.unlock()
@@ -2043,6 +2558,7 @@ func (t *Torrent) AllowDataDownload() {
 	t.cl.lock()
 	defer t.cl.unlock()
 	t.dataDownloadDisallowed = false
+	t.writeChunkErrReported = false
 	t.tickleReaders()
 	t.iterPeers(func(c *peer) {
 		c.updateRequests()
@@ -2063,6 +2579,10 @@ func (t *Torrent) AllowDataUpload() {
 func (t *Torrent) DisallowDataUpload() {
 	t.cl.lock()
 	defer t.cl.unlock()
+	t.disallowDataUploadLocked()
+}
+
+func (t *Torrent) disallowDataUploadLocked() {
 	t.dataUploadDisallowed = true
 	for c := range t.conns {
 		c.updateRequests()
@@ -2070,7 +2590,8 @@ func (t *Torrent) DisallowDataUpload() {
 }
 
 // Sets a handler that is called if there's an error writing a chunk to local storage. By default,
-// or if nil, a critical message is logged, and data download is disabled.
+// or if nil, a critical message is logged, and data download is disabled. Use errors.Is with the
+// storage error kinds, such as storage.ErrDiskFull, to tell failures apart.
 func (t *Torrent) SetOnWriteChunkError(f func(error)) {
 	t.cl.lock()
 	defer t.cl.unlock()
@@ -2086,13 +2607,93 @@ func (t *Torrent) iterPeers(f func(*peer)) {
 	}
 }
 
-func (t *Torrent) addWebSeed(url string) {
+// Options for webseeds added with Torrent.AddWebSeed.
+type WebSeedOpts struct {
+	// Limits the rate piece data is requested from the webseed. Requests wait for the limiter before
+	// they're sent, in parts of at most the burst. Nil means unlimited.
+	RateLimiter *rate.Limiter
+	// The cost of egress from the webseed, in any unit, for ClientConfig.WebSeedArbitration.
+	CostPerGB float64
+	// Add the webseed without using it until it's enabled with Torrent.SetWebSeedEnabled.
+	Disabled bool
+}
+
+// Adds a webseed (BEP 19) URL. Nothing changes if the URL is already present, enabled or not.
+func (t *Torrent) AddWebSeed(url string, opts WebSeedOpts) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.addWebSeed(url, opts)
+}
+
+// Removes a webseed, cancelling its outstanding requests. It reports whether the URL was present.
+func (t *Torrent) RemoveWebSeed(url string) bool {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if ws, ok := t.disabledWebSeeds[url]; ok {
+		delete(t.disabledWebSeeds, url)
+		ws.close()
+		return true
+	}
+	ws, ok := t.webSeeds[url]
+	if !ok {
+		return false
+	}
+	delete(t.webSeeds, url)
+	t.decPeerPieceAvailability(ws)
+	t.stopWebSeed(ws)
+	ws.close()
+	return true
+}
+
+// Sets whether a webseed is used for requests. Disabling a webseed cancels its outstanding
+// requests. It reports whether the URL was present.
+func (t *Torrent) SetWebSeedEnabled(url string, enabled bool) bool {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if enabled {
+		ws, ok := t.disabledWebSeeds[url]
+		if ok {
+			delete(t.disabledWebSeeds, url)
+			t.webSeeds[url] = ws
+			t.incPeerPieceAvailability(ws)
+			if t.haveInfo() {
+				ws.updateRequests()
+			}
+		}
+		return ok || t.webSeeds[url] != nil
+	}
+	ws, ok := t.webSeeds[url]
+	if ok {
+		delete(t.webSeeds, url)
+		t.decPeerPieceAvailability(ws)
+		if t.disabledWebSeeds == nil {
+			t.disabledWebSeeds = make(map[string]*peer)
+		}
+		t.disabledWebSeeds[url] = ws
+		t.stopWebSeed(ws)
+	}
+	return ok || t.disabledWebSeeds[url] != nil
+}
+
+// Cancels a webseed's requests. It must already be removed from Torrent.webSeeds, so that they're
+// given to other peers.
+func (t *Torrent) stopWebSeed(ws *peer) {
+	for r := range ws.requests {
+		ws.remoteRejectedRequest(r)
+		ws.peerImpl.cancel(r)
+	}
+}
+
+func (t *Torrent) addWebSeed(url string, opts WebSeedOpts) {
 	if t.cl.config.DisableWebseeds {
 		return
 	}
 	if _, ok := t.webSeeds[url]; ok {
 		return
 	}
+	if _, ok := t.disabledWebSeeds[url]; ok {
+		return
+	}
 	const maxRequests = 10
 	ws := webseedPeer{
 		peer: peer{
@@ -2107,14 +2708,27 @@ func (t *Torrent) addWebSeed(url string) {
 			HttpClient: http.DefaultClient,
 			Url:        url,
 		},
-		requests: make(map[request]webseed.Request, maxRequests),
+		requests:    make(map[request]webseed.Request, maxRequests),
+		rateLimiter: opts.RateLimiter,
+		costPerGB:   opts.CostPerGB,
 	}
 	ws.peer.logger = t.logger.WithContextValue(&ws)
 	ws.peer.peerImpl = &ws
 	if t.haveInfo() {
 		ws.onGotInfo(t.info)
 	}
+	if opts.Disabled {
+		if t.disabledWebSeeds == nil {
+			t.disabledWebSeeds = make(map[string]*peer)
+		}
+		t.disabledWebSeeds[url] = &ws.peer
+		return
+	}
 	t.webSeeds[url] = &ws.peer
+	t.incPeerPieceAvailability(&ws.peer)
+	if t.haveInfo() {
+		ws.peer.updateRequests()
+	}
 }
 
 func (t *Torrent) peerIsActive(p *peer) (active bool) {
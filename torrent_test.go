@@ -1,11 +1,17 @@
 package torrent
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/anacrolix/missinggo"
 	"github.com/bradfitz/iter"
@@ -163,6 +169,77 @@ func TestPieceHashFailed(t *testing.T) {
 	tt.cl.unlock()
 }
 
+func TestSetPieceOrder(t *testing.T) {
+	cl := Client{config: TestingConfig()}
+	cl.initLogger()
+	c := cl.newConnection(nil, false, nil, "", "")
+	tt := cl.newTorrent(metainfo.Hash{}, nil)
+	c.setTorrent(tt)
+	require.NoError(t, tt.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, metainfo.HashSize*5),
+		PieceLength: 1,
+		Length:      5,
+	}))
+	c.peerSentHaveAll = true
+	tt.DownloadAll()
+	tt.SetPieceOrder([]pieceIndex{3, 1})
+	for i := pieceIndex(0); i < tt.numPieces(); i++ {
+		c.updatePiecePriority(i)
+	}
+	var biased, unbiased []pieceIndex
+	c.pieceRequestOrder().IterTyped(func(i int) bool {
+		biased = append(biased, i)
+		return true
+	})
+	iterUnbiasedPieceRequestOrder(c, func(i pieceIndex) bool {
+		unbiased = append(unbiased, i)
+		return true
+	})
+	assert.EqualValues(t, []pieceIndex{3, 1}, biased[:2])
+	assert.EqualValues(t, []pieceIndex{3, 1}, unbiased[:2])
+	assert.Len(t, unbiased, 5)
+}
+
+func TestImportPiece(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	assert.Error(t, tt.ImportPiece(1, strings.NewReader("xxxxx")))
+	assert.False(t, tt.Piece(1).State().Complete)
+	require.NoError(t, tt.ImportPiece(1, strings.NewReader(testutil.GreetingFileContents[5:10])))
+	assert.True(t, tt.Piece(1).State().Complete)
+	assert.False(t, tt.Piece(0).State().Complete)
+}
+
+func TestReadAroundPendingWrites(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	p := tt.piece(0)
+	cs := chunkSpec{Begin: 2, Length: 2}
+	p.incrementPendingWrites(cs)
+	// Reads of other ranges of the piece don't wait for the write.
+	p.waitNoPendingWritesIn(0, 2)
+	p.waitNoPendingWritesIn(4, 1)
+	waited := make(chan struct{})
+	go func() {
+		p.waitNoPendingWritesIn(1, 2)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("read overlapping a pending write didn't wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+	p.decrementPendingWrites(cs)
+	<-waited
+	p.waitNoPendingWrites()
+}
+
 // Check the behaviour of Torrent.Metainfo when metadata is not completed.
 func TestTorrentMetainfoIncompleteMetadata(t *testing.T) {
 	cfg := TestingConfig()
@@ -218,3 +295,295 @@ func TestTorrentMetainfoIncompleteMetadata(t *testing.T) {
 	assert.False(t, tt.haveAllMetadataPieces())
 	assert.Nil(t, tt.Metainfo().InfoBytes)
 }
+
+func TestBitfieldRoundTrip(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	require.NoError(t, tt.ImportPiece(1, strings.NewReader(testutil.GreetingFileContents[5:10])))
+	bf := tt.Bitfield()
+	assert.EqualValues(t, []byte{bitfieldVersion, 3, 0x40}, bf)
+	require.NoError(t, tt.SetBitfieldUnchecked([]byte{bitfieldVersion, 3, 0xa0}))
+	assert.True(t, tt.Piece(0).State().Complete)
+	assert.False(t, tt.Piece(1).State().Complete)
+	assert.True(t, tt.Piece(2).State().Complete)
+	assert.Error(t, tt.SetBitfieldUnchecked([]byte{bitfieldVersion, 4, 0xf0}))
+	assert.Error(t, tt.SetBitfieldUnchecked(bf[:2]))
+}
+
+func TestExportImportPieces(t *testing.T) {
+	newTorrent := func() *Torrent {
+		cl, err := NewClient(TestingConfig())
+		require.NoError(t, err)
+		t.Cleanup(func() { cl.Close() })
+		tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+		require.NoError(t, err)
+		return tt
+	}
+	src := newTorrent()
+	for i, off := range []int{0, 5, 10} {
+		end := off + 5
+		if end > len(testutil.GreetingFileContents) {
+			end = len(testutil.GreetingFileContents)
+		}
+		require.NoError(t, src.ImportPiece(i, strings.NewReader(testutil.GreetingFileContents[off:end])))
+	}
+	dst := newTorrent()
+	require.NoError(t, dst.ImportPiece(1, strings.NewReader(testutil.GreetingFileContents[5:10])))
+	var buf bytes.Buffer
+	exported, err := src.ExportPieces(&buf, dst.Bitfield())
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, exported)
+	// The version, and the two pieces and their indexes, and the terminator.
+	assert.EqualValues(t, 1+1+5+1+4+1, buf.Len())
+	imported, err := dst.ImportPieces(&buf)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, imported)
+	assert.EqualValues(t, src.Bitfield(), dst.Bitfield())
+	_, err = dst.ImportPieces(bytes.NewReader([]byte{pieceExportVersion, 4}))
+	assert.Error(t, err)
+}
+
+func TestDebugSnapshot(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	require.NoError(t, tt.ImportPiece(1, strings.NewReader(testutil.GreetingFileContents[5:10])))
+	s := tt.DebugSnapshot()
+	assert.True(t, s.HaveInfo)
+	require.Len(t, s.Pieces, 3)
+	assert.False(t, s.Pieces[0].Complete)
+	assert.True(t, s.Pieces[1].Complete)
+	assert.EqualValues(t, 1, s.Pieces[1].NumChunks)
+	assert.Empty(t, s.Peers)
+}
+
+func TestAddRemoveWebSeed(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	const url = "http://localhost:1/greeting"
+	tt.AddWebSeed(url, WebSeedOpts{Disabled: true})
+	assert.Empty(t, tt.DebugSnapshot().Peers)
+	assert.True(t, tt.SetWebSeedEnabled(url, true))
+	peers := tt.DebugSnapshot().Peers
+	require.Len(t, peers, 1)
+	assert.True(t, peers[0].WebSeed)
+	assert.True(t, tt.RemoveWebSeed(url))
+	assert.False(t, tt.RemoveWebSeed(url))
+	assert.False(t, tt.SetWebSeedEnabled(url, true))
+	assert.Empty(t, tt.DebugSnapshot().Peers)
+}
+
+func TestRemoveAndReplaceTrackers(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	tt.AddTrackers([][]string{{"http://a/announce", "udp://b:1"}, {"http://c/announce"}})
+	assert.True(t, tt.RemoveTracker("http://c/announce"))
+	assert.False(t, tt.RemoveTracker("http://c/announce"))
+	assert.EqualValues(t, [][]string{{"http://a/announce", "udp://b:1"}}, tt.Metainfo().AnnounceList)
+	tt.ReplaceTrackers([][]string{{"udp://b:1"}})
+	assert.EqualValues(t, [][]string{{"udp://b:1"}}, tt.Metainfo().AnnounceList)
+	assert.EqualValues(t, []string{"udp4://b:1", "udp6://b:1"}, trackerAnnouncerKeys("udp://b:1"))
+}
+
+func TestSeedModeAssumesComplete(t *testing.T) {
+	cl, err := NewClient(TestingConfig())
+	require.NoError(t, err)
+	defer cl.Close()
+	spec := TorrentSpecFromMetaInfo(testutil.GreetingMetaInfo())
+	spec.SeedMode = true
+	tt, _, err := cl.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, tt.BytesMissing())
+	for i := 0; i < tt.NumPieces(); i++ {
+		assert.True(t, tt.PieceState(i).Complete)
+		assert.False(t, tt.PieceState(i).Checking)
+	}
+}
+
+func TestFileStoragePaths(t *testing.T) {
+	cfg := TestingConfig()
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	first := filepath.Join(cfg.DataDir, "elsewhere", "greeting")
+	spec := TorrentSpecFromMetaInfo(testutil.GreetingMetaInfo())
+	spec.FilePaths = map[int]string{0: first}
+	tt, _, err := cl.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	require.NoError(t, tt.ImportPiece(0, strings.NewReader(testutil.GreetingFileContents[:5])))
+	_, err = os.Stat(first)
+	require.NoError(t, err)
+	second := filepath.Join(cfg.DataDir, "moved")
+	require.NoError(t, tt.Files()[0].SetStoragePath(second))
+	_, err = os.Stat(first)
+	assert.True(t, os.IsNotExist(err))
+	b, err := ioutil.ReadFile(second)
+	require.NoError(t, err)
+	assert.Equal(t, testutil.GreetingFileContents[:5], string(b))
+	assert.True(t, tt.Piece(0).State().Complete)
+}
+
+func TestHashFailureQuarantine(t *testing.T) {
+	cfg := TestingConfig()
+	// No window, so the failures aren't forgotten however far apart they are.
+	cfg.HashFailureQuarantine = HashFailureQuarantine{Failures: 2}
+	var mu sync.Mutex
+	var got []TorrentQuarantine
+	cfg.Callbacks.TorrentQuarantined = func(_ *Torrent, q TorrentQuarantine) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, q)
+	}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tor, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	<-tor.GotInfo()
+	// Fails the piece's hash with all its data from a new connection from ip.
+	fail := func(piece pieceIndex, ip net.IP) *PeerConn {
+		nc, _ := net.Pipe()
+		c := cl.newConnection(nc, false, &net.TCPAddr{IP: ip, Port: 1234}, "tcp", "")
+		c.setTorrent(tor)
+		require.NoError(t, tor.addConnection(c))
+		p := tor.piece(piece)
+		for i := 0; i < int(p.numChunks()); i++ {
+			p._dirtyChunks.Add(i)
+		}
+		c.onDirtiedPiece(piece)
+		tor.pieceHashed(piece, false, nil)
+		return c
+	}
+	cl.lock()
+	first := fail(0, net.IPv4(192, 0, 2, 1))
+	assert.True(t, first.closed.IsSet())
+	assert.Contains(t, cl.badPeerIPs, "192.0.2.1")
+	assert.False(t, tor.quarantined())
+	fail(1, net.IPv4(192, 0, 2, 2))
+	assert.True(t, tor.quarantined())
+	assert.False(t, tor.seeding())
+	cl.unlock()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 1
+	}, 10*time.Second, time.Millisecond)
+	require.Len(t, got[0].Failures, 2)
+	assert.Equal(t, []string{"192.0.2.1:1234"}, got[0].Failures[0].Peers)
+	assert.Equal(t, []string{"192.0.2.2:1234"}, got[0].Failures[1].Peers)
+	_, quarantined := tor.Quarantine()
+	assert.True(t, quarantined)
+	tor.ResumeFromQuarantine()
+	_, quarantined = tor.Quarantine()
+	assert.False(t, quarantined)
+}
+
+func TestMerkleTorrentRequiresConfig(t *testing.T) {
+	cfg := TestingConfig()
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	info := metainfo.Info{
+		Name:        "a",
+		Length:      3,
+		PieceLength: 2,
+		RootHash:    make([]byte, 20),
+	}
+	assert.True(t, info.IsMerkle())
+	assert.Equal(t, 2, info.NumPieces())
+	tor, _ := cl.AddTorrentInfoHash(metainfo.Hash{})
+	cl.lock()
+	defer cl.unlock()
+	assert.Error(t, tor.setInfo(&info))
+	cl.config.EnableMerkleTorrents = true
+	require.NoError(t, tor.setInfo(&info))
+	assert.Len(t, tor.pieces, 2)
+	assert.NotNil(t, tor.merkleTree)
+}
+
+func TestReaderPriorityDecay(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.ReaderIdleTimeout = time.Minute
+	cfg.ReaderPriorityLinger = time.Minute
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tor, _ := cl.AddTorrentInfoHash(metainfo.Hash{})
+	cl.lock()
+	require.NoError(t, tor.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, metainfo.HashSize*10),
+		PieceLength: 1,
+		Length:      10,
+	}))
+	cl.unlock()
+	r := tor.NewReader()
+	defer r.Close()
+	r.SetReadahead(2)
+	_, err = r.Seek(5, io.SeekStart)
+	require.NoError(t, err)
+	cl.lock()
+	defer cl.unlock()
+	// The window the reader left lingers below the new one.
+	assert.Equal(t, PiecePriorityNormal, tor.piecePriority(0))
+	assert.Equal(t, PiecePriorityNow, tor.piecePriority(5))
+	assert.Equal(t, PiecePriorityReadahead, tor.piecePriority(6))
+	// Expire the lingering range, and let the reader go idle.
+	for i := range tor.lingeringReaderPieces {
+		tor.lingeringReaderPieces[i].until = time.Now()
+	}
+	r.(*reader).lastUsed = time.Now().Add(-time.Hour)
+	tor.readersChanged()
+	for i := pieceIndex(0); i < tor.numPieces(); i++ {
+		assert.Equal(t, PiecePriorityNone, tor.piecePriority(i), i)
+	}
+	// Using the reader again restores its window.
+	r.(*reader).markUsed()
+	assert.Equal(t, PiecePriorityNow, tor.piecePriority(5))
+}
+
+func TestReaderPriorityLingerMergesRanges(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.ReaderPriorityLinger = time.Minute
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tor, _ := cl.AddTorrentInfoHash(metainfo.Hash{})
+	cl.lock()
+	require.NoError(t, tor.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, metainfo.HashSize*100),
+		PieceLength: 1,
+		Length:      100,
+	}))
+	cl.unlock()
+	r := tor.NewReader()
+	defer r.Close()
+	r.SetReadahead(2)
+	// Moving forward a piece at a time leaves overlapping ranges behind, which are merged.
+	for off := int64(1); off < 50; off++ {
+		_, err = r.Seek(off, io.SeekStart)
+		require.NoError(t, err)
+	}
+	cl.lock()
+	assert.Len(t, tor.lingeringReaderPieces, 1)
+	assert.EqualValues(t, 0, tor.lingeringReaderPieces[0].begin)
+	cl.unlock()
+	// A range apart from the others is kept separately.
+	_, err = r.Seek(90, io.SeekStart)
+	require.NoError(t, err)
+	_, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	cl.lock()
+	defer cl.unlock()
+	assert.Len(t, tor.lingeringReaderPieces, 2)
+}
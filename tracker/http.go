@@ -104,8 +104,12 @@ func setAnnounceParams(_url *url.URL, ar *AnnounceRequest, opts Announce) {
 		// addresses for other address-families, although it's not encouraged.
 		q.Add("ip", ipString)
 	}
-	doIp("ipv4", opts.ClientIp4.IP)
-	doIp("ipv6", opts.ClientIp6.IP)
+	if opts.ClientHost != "" {
+		q.Set("ip", opts.ClientHost)
+	} else {
+		doIp("ipv4", opts.ClientIp4.IP)
+		doIp("ipv6", opts.ClientIp6.IP)
+	}
 	_url.RawQuery = q.Encode()
 }
 
@@ -118,22 +122,7 @@ func announceHTTP(opt Announce, _url *url.URL) (ret AnnounceResponse, err error)
 	if opt.Context != nil {
 		req = req.WithContext(opt.Context)
 	}
-	resp, err := (&http.Client{
-		//Timeout: time.Second * 15,
-		Transport: &http.Transport{
-			//Dial: (&net.Dialer{
-			//	Timeout: 15 * time.Second,
-			//}).Dial,
-			Proxy: opt.HTTPProxy,
-			//TLSHandshakeTimeout: 15 * time.Second,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-				ServerName:         opt.ServerName,
-			},
-			// This is for S3 trackers that hold connections open.
-			DisableKeepAlives: true,
-		},
-	}).Do(req)
+	resp, err := opt.httpClient().Do(req)
 	if err != nil {
 		return
 	}
@@ -145,7 +134,11 @@ func announceHTTP(opt Announce, _url *url.URL) (ret AnnounceResponse, err error)
 		return
 	}
 	var trackerResponse HttpResponse
-	err = bencode.Unmarshal(buf.Bytes(), &trackerResponse)
+	if isI2pHost(_url.Hostname()) {
+		err = unmarshalI2pHttpResponse(buf.Bytes(), &trackerResponse)
+	} else {
+		err = bencode.Unmarshal(buf.Bytes(), &trackerResponse)
+	}
 	if _, ok := err.(bencode.ErrUnusedTrailingBytes); ok {
 		err = nil
 	} else if err != nil {
@@ -175,3 +168,37 @@ func announceHTTP(opt Announce, _url *url.URL) (ret AnnounceResponse, err error)
 	}
 	return
 }
+
+func (opt Announce) httpClient() *http.Client {
+	if opt.DialContext != nil {
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: opt.DialContext,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					ServerName:         opt.ServerName,
+				},
+				DisableKeepAlives: true,
+			},
+		}
+	}
+	if opt.Pool != nil {
+		return opt.Pool.httpClient(opt.ServerName)
+	}
+	return &http.Client{
+		//Timeout: time.Second * 15,
+		Transport: &http.Transport{
+			//Dial: (&net.Dialer{
+			//	Timeout: 15 * time.Second,
+			//}).Dial,
+			Proxy: opt.HTTPProxy,
+			//TLSHandshakeTimeout: 15 * time.Second,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				ServerName:         opt.ServerName,
+			},
+			// This is for S3 trackers that hold connections open.
+			DisableKeepAlives: true,
+		},
+	}
+}
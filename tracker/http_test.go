@@ -1,12 +1,14 @@
 package tracker
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/i2p"
 )
 
 func TestUnmarshalHTTPResponsePeerDicts(t *testing.T) {
@@ -32,6 +34,17 @@ func TestUnmarshalHTTPResponsePeerDicts(t *testing.T) {
 	assert.EqualValues(t, 0x3536, hr.Peers6[0].Port)
 }
 
+func TestUnmarshalHTTPResponsePeerDictHost(t *testing.T) {
+	var hr HttpResponse
+	require.NoError(t, bencode.Unmarshal(
+		[]byte("d5:peersld2:ip16:abcdefgh.b32.i2p4:porti6881eeee"),
+		&hr))
+	require.Len(t, hr.Peers, 1)
+	assert.Nil(t, hr.Peers[0].IP)
+	assert.Equal(t, "abcdefgh.b32.i2p", hr.Peers[0].Host)
+	assert.Equal(t, "abcdefgh.b32.i2p:6881", hr.Peers[0].String())
+}
+
 func TestUnmarshalHttpResponseNoPeers(t *testing.T) {
 	var hr HttpResponse
 	require.NoError(t, bencode.Unmarshal(
@@ -49,3 +62,24 @@ func TestUnmarshalHttpResponsePeers6NotCompact(t *testing.T) {
 		&hr,
 	))
 }
+
+func TestUnmarshalI2pHttpResponseCompactPeers(t *testing.T) {
+	var hr HttpResponse
+	peers := strings.Repeat("\x01", 32) + strings.Repeat("\x02", 32)
+	require.NoError(t, unmarshalI2pHttpResponse(
+		[]byte("d8:intervali600e5:peers64:"+peers+"e"),
+		&hr))
+	assert.EqualValues(t, 600, hr.Interval)
+	require.Len(t, hr.Peers, 2)
+	var h [32]byte
+	copy(h[:], peers[32:])
+	assert.Equal(t, i2p.HashAddr(h), hr.Peers[1].Host)
+	assert.True(t, strings.HasSuffix(hr.Peers[0].Host, ".b32.i2p"))
+	assert.Error(t, unmarshalI2pHttpResponse([]byte("d5:peers6:abcdefe"), &hr))
+	// Non-compact peers are as for other trackers.
+	require.NoError(t, unmarshalI2pHttpResponse(
+		[]byte("d5:peersld2:ip16:abcdefgh.b32.i2p4:porti6881eeee"),
+		&hr))
+	require.Len(t, hr.Peers, 1)
+	assert.Equal(t, "abcdefgh.b32.i2p", hr.Peers[0].Host)
+}
@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/i2p"
+)
+
+// Trackers inside I2P give compact peers as the 32-byte SHA-256 hashes of their destinations, rather
+// than IPs and ports. Non-compact peers have base64 destinations with a .i2p suffix as their ip.
+
+func isI2pHost(host string) bool {
+	return strings.HasSuffix(host, ".i2p")
+}
+
+// Peers from an I2P tracker. Compact peers have the .b32.i2p address of their destination as the
+// Host, and no port.
+type I2pPeers []Peer
+
+func (me *I2pPeers) UnmarshalBencode(b []byte) (err error) {
+	var s string
+	if bencode.Unmarshal(b, &s) != nil {
+		return (*Peers)(me).UnmarshalBencode(b)
+	}
+	vars.Add("http responses with i2p compact peers", 1)
+	if len(s)%32 != 0 {
+		return fmt.Errorf("i2p compact peers length %v isn't a multiple of 32", len(s))
+	}
+	for i := 0; i < len(s); i += 32 {
+		var h [32]byte
+		copy(h[:], s[i:])
+		*me = append(*me, Peer{Host: i2p.HashAddr(h)})
+	}
+	return
+}
+
+type i2pHttpResponse struct {
+	FailureReason string   `bencode:"failure reason"`
+	Interval      int32    `bencode:"interval"`
+	TrackerId     string   `bencode:"tracker id"`
+	Complete      int32    `bencode:"complete"`
+	Incomplete    int32    `bencode:"incomplete"`
+	Peers         I2pPeers `bencode:"peers"`
+}
+
+func unmarshalI2pHttpResponse(b []byte, ret *HttpResponse) error {
+	var r i2pHttpResponse
+	err := bencode.Unmarshal(b, &r)
+	*ret = HttpResponse{
+		FailureReason: r.FailureReason,
+		Interval:      r.Interval,
+		TrackerId:     r.TrackerId,
+		Complete:      r.Complete,
+		Incomplete:    r.Incomplete,
+		Peers:         Peers(r.Peers),
+	}
+	return err
+}
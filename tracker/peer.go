@@ -8,13 +8,20 @@ import (
 )
 
 type Peer struct {
-	IP   net.IP
+	IP net.IP
+	// Set instead of IP if the non-compact form gave a host that isn't an IP address, such as a
+	// DNS name or an overlay network address.
+	Host string
 	Port int
 	ID   []byte
 }
 
 func (p Peer) String() string {
-	loc := net.JoinHostPort(p.IP.String(), fmt.Sprintf("%d", p.Port))
+	host := p.IP.String()
+	if p.IP == nil && p.Host != "" {
+		host = p.Host
+	}
+	loc := net.JoinHostPort(host, fmt.Sprintf("%d", p.Port))
 	if len(p.ID) != 0 {
 		return fmt.Sprintf("%x at %s", p.ID, loc)
 	} else {
@@ -24,7 +31,11 @@ func (p Peer) String() string {
 
 // Set from the non-compact form in BEP 3.
 func (p *Peer) FromDictInterface(d map[string]interface{}) {
-	p.IP = net.ParseIP(d["ip"].(string))
+	ip := d["ip"].(string)
+	p.IP = net.ParseIP(ip)
+	if p.IP == nil {
+		p.Host = ip
+	}
 	if _, ok := d["peer id"]; ok {
 		p.ID = []byte(d["peer id"].(string))
 	}
@@ -0,0 +1,238 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/missinggo/pproffd"
+	"github.com/pkg/errors"
+)
+
+// Shares connections between announces to the same trackers. HTTP announces reuse kept-alive
+// connections, and UDP announces to the same tracker address are multiplexed over a single socket
+// and share its connection ID. The zero value is ready for use. Close it when no longer needed.
+type Pool struct {
+	// Used for HTTP announces made through the Pool, in place of Announce.HTTPProxy.
+	HTTPProxy func(*http.Request) (*url.URL, error)
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+	udpConns   map[udpConnKey]*udpConn
+}
+
+type udpConnKey struct {
+	network string
+	addr    string
+}
+
+// Returns a client sharing a Transport with other announces to trackers with the same TLS server
+// name.
+func (p *Pool) httpClient(serverName string) *http.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.transports[serverName]
+	if !ok {
+		t = &http.Transport{
+			Proxy: p.HTTPProxy,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				ServerName:         serverName,
+			},
+			// Some trackers (like S3) hold connections open, so don't keep idle ones around for
+			// longer than it takes for a burst of announces to complete.
+			IdleConnTimeout: 30 * time.Second,
+		}
+		if p.transports == nil {
+			p.transports = make(map[string]*http.Transport)
+		}
+		p.transports[serverName] = t
+	}
+	return &http.Client{Transport: t}
+}
+
+func (p *Pool) udpConn(network, addr string) (*udpConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := udpConnKey{network, addr}
+	if c, ok := p.udpConns[key]; ok {
+		return c, nil
+	}
+	s, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &udpConn{
+		socket:  pproffd.WrapNetConn(s),
+		pending: make(map[int32]chan []byte),
+	}
+	if p.udpConns == nil {
+		p.udpConns = make(map[udpConnKey]*udpConn)
+	}
+	p.udpConns[key] = c
+	vars.Add("udp tracker sockets opened", 1)
+	go func() {
+		c.readLoop()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.udpConns[key] == c {
+			delete(p.udpConns, key)
+		}
+	}()
+	return c, nil
+}
+
+// Closes idle HTTP connections and all UDP sockets. Announces in progress through the Pool will
+// fail.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+	for key, c := range p.udpConns {
+		c.socket.Close()
+		delete(p.udpConns, key)
+	}
+	return nil
+}
+
+// A UDP tracker socket shared by concurrent announces. Responses are routed to requests by
+// transaction ID.
+type udpConn struct {
+	socket net.Conn
+	// Serializes obtaining connection IDs, so that concurrent announces don't all connect.
+	connectMu sync.Mutex
+
+	mu                   sync.Mutex
+	connectionId         int64
+	connectionIdReceived time.Time
+	contiguousTimeouts   int
+	pending              map[int32]chan []byte
+	readErr              error
+}
+
+func (c *udpConn) readLoop() {
+	defer c.socket.Close()
+	b := make([]byte, 0x800) // 2KiB
+	for {
+		n, err := c.socket.Read(b)
+		if err != nil {
+			c.mu.Lock()
+			c.readErr = err
+			for tid, ch := range c.pending {
+				close(ch)
+				delete(c.pending, tid)
+			}
+			c.mu.Unlock()
+			return
+		}
+		var h ResponseHeader
+		if read(bytes.NewReader(b[:n]), &h) != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[h.TransactionId]
+		if ok {
+			delete(c.pending, h.TransactionId)
+		}
+		c.mu.Unlock()
+		if ok {
+			ch <- append([]byte(nil), b[:n]...)
+		}
+	}
+}
+
+func (c *udpConn) connectionID(ctx context.Context) (int64, error) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.mu.Lock()
+	if connectionIdFresh(c.connectionIdReceived) {
+		defer c.mu.Unlock()
+		vars.Add("udp tracker connection ids reused", 1)
+		return c.connectionId, nil
+	}
+	c.mu.Unlock()
+	b, err := c.request(ctx, connectRequestConnectionId, ActionConnect, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	var res ConnectionResponse
+	err = readBody(b, &res)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.connectionId = res.ConnectionId
+	c.connectionIdReceived = time.Now()
+	c.mu.Unlock()
+	return res.ConnectionId, nil
+}
+
+func (c *udpConn) request(ctx context.Context, connectionId int64, action Action, args interface{}, options []byte) (*bytes.Buffer, error) {
+	ch := make(chan []byte, 1)
+	c.mu.Lock()
+	if c.readErr != nil {
+		err := c.readErr
+		c.mu.Unlock()
+		return nil, errors.Wrap(err, "reading from socket")
+	}
+	tid := newTransactionId()
+	for {
+		if _, ok := c.pending[tid]; !ok {
+			break
+		}
+		tid = newTransactionId()
+	}
+	c.pending[tid] = ch
+	timer := time.NewTimer(timeout(c.contiguousTimeouts))
+	c.mu.Unlock()
+	defer timer.Stop()
+	defer func() {
+		c.mu.Lock()
+		if c.pending[tid] == ch {
+			delete(c.pending, tid)
+		}
+		c.mu.Unlock()
+	}()
+	_, err := c.socket.Write(encodeRequest(
+		&RequestHeader{
+			ConnectionId:  connectionId,
+			Action:        action,
+			TransactionId: tid,
+		}, args, options))
+	if err != nil {
+		return nil, errors.Wrap(err, "writing request")
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		c.mu.Lock()
+		c.contiguousTimeouts++
+		c.mu.Unlock()
+		return nil, errors.New("timed out waiting for response")
+	case b, ok := <-ch:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !ok {
+			return nil, errors.Wrap(c.readErr, "reading from socket")
+		}
+		c.contiguousTimeouts = 0
+		buf := bytes.NewBuffer(b)
+		var h ResponseHeader
+		err = read(buf, &h)
+		if err != nil {
+			panic(err)
+		}
+		if h.Action == ActionError {
+			err = errors.New(buf.String())
+		}
+		return buf, err
+	}
+}
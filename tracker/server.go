@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"net/url"
+	"strings"
 
 	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/missinggo"
@@ -22,6 +26,68 @@ type server struct {
 	pc    net.PacketConn
 	conns map[int64]struct{}
 	t     map[[20]byte]torrent
+	// If set, called with the URL data (BEP 41) of each announce, such as "/announce/passkey?a=b".
+	// Announces it returns an error for are refused with the error's message.
+	checkURLData func(urlData string, ar AnnounceRequest) error
+}
+
+// Concatenates the URL data of the BEP 41 options following a request. Options cut short by the
+// end of the packet are ignored.
+func readURLData(r *bytes.Reader) string {
+	var urlData []byte
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		switch typ {
+		case optionTypeEndOfOptions:
+			return string(urlData)
+		case optionTypeNOP:
+			continue
+		}
+		n, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			break
+		}
+		if typ == optionTypeURLData {
+			urlData = append(urlData, b...)
+		}
+	}
+	return string(urlData)
+}
+
+// Returns a checkURLData for private trackers that take a passkey as the path segment after
+// prefix, as in "/announce/<passkey>". Announces without a passkey, or with one valid returns false
+// for, are refused.
+func passkeyChecker(prefix string, valid func(passkey string, ar AnnounceRequest) bool) func(string, AnnounceRequest) error {
+	return func(urlData string, ar AnnounceRequest) error {
+		passkey, ok := urlDataPasskey(urlData, prefix)
+		if !ok {
+			return errors.New("missing passkey")
+		}
+		if !valid(passkey, ar) {
+			return errors.New("invalid passkey")
+		}
+		return nil
+	}
+}
+
+// Extracts the path segment following prefix in the URL data.
+func urlDataPasskey(urlData, prefix string) (passkey string, ok bool) {
+	u, err := url.Parse(urlData)
+	if err != nil {
+		return
+	}
+	passkey = strings.TrimPrefix(u.Path, strings.TrimSuffix(prefix, "/")+"/")
+	if passkey == u.Path || passkey == "" || strings.Contains(passkey, "/") {
+		return "", false
+	}
+	return passkey, true
 }
 
 func marshal(parts ...interface{}) (ret []byte, err error) {
@@ -92,6 +158,15 @@ func (s *server) serveOne() (err error) {
 		if err != nil {
 			return
 		}
+		if s.checkURLData != nil {
+			if checkErr := s.checkURLData(readURLData(r), ar); checkErr != nil {
+				err = s.respond(addr, ResponseHeader{
+					TransactionId: h.TransactionId,
+					Action:        ActionError,
+				}, []byte(checkErr.Error()))
+				return
+			}
+		}
 		t := s.t[ar.InfoHash]
 		bm := func() encoding.BinaryMarshaler {
 			ip := missinggo.AddrIP(addr)
@@ -3,6 +3,7 @@ package tracker
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -63,7 +64,15 @@ type Announce struct {
 	ClientIp4 krpc.NodeAddr
 	// If the port is zero, it's assumed to be the same as the Request.Port.
 	ClientIp6 krpc.NodeAddr
-	Context   context.Context
+	// If set, given as the ip parameter in place of ClientIp4 and ClientIp6, such as the client's
+	// address on an overlay network.
+	ClientHost string
+	Context    context.Context
+	// If set, connections to the tracker are shared with other announces through the same Pool.
+	Pool *Pool
+	// If set, HTTP announces connect to the tracker with this, bypassing the Pool and HTTPProxy,
+	// such as for trackers on an overlay network.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
 func (me Announce) Do() (res AnnounceResponse, err error) {
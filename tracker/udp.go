@@ -81,8 +81,10 @@ type udpAnnounce struct {
 	connectionIdReceived time.Time
 	connectionId         int64
 	socket               net.Conn
-	url                  url.URL
-	a                    *Announce
+	// Set if the announce is made over a socket shared through a Pool.
+	shared *udpConn
+	url    url.URL
+	a      *Announce
 }
 
 func (c *udpAnnounce) Close() error {
@@ -96,7 +98,11 @@ func (c *udpAnnounce) ipv6() bool {
 	if c.a.UdpNetwork == "udp6" {
 		return true
 	}
-	rip := missinggo.AddrIP(c.socket.RemoteAddr())
+	socket := c.socket
+	if c.shared != nil {
+		socket = c.shared.socket
+	}
+	rip := missinggo.AddrIP(socket.RemoteAddr())
 	return rip.To16() != nil && rip.To4() == nil
 }
 
@@ -112,10 +118,7 @@ func (c *udpAnnounce) Do(req AnnounceRequest) (res AnnounceResponse, err error)
 	} else if req.IPAddress == 0 && c.a.ClientIp4.IP != nil {
 		req.IPAddress = binary.BigEndian.Uint32(c.a.ClientIp4.IP.To4())
 	}
-	// Clearly this limits the request URI to 255 bytes. BEP 41 supports
-	// longer but I'm not fussed.
-	options := append([]byte{optionTypeURLData, byte(len(reqURI))}, []byte(reqURI)...)
-	b, err := c.request(ActionAnnounce, req, options)
+	b, err := c.request(ActionAnnounce, req, urlDataOptions(reqURI))
 	if err != nil {
 		return
 	}
@@ -151,11 +154,40 @@ func (c *udpAnnounce) Do(req AnnounceRequest) (res AnnounceResponse, err error)
 	return
 }
 
+// Encodes the URL data as BEP 41 options, split across as many as needed, as each holds at most
+// 255 bytes.
+func urlDataOptions(urlData string) (options []byte) {
+	for {
+		n := len(urlData)
+		if n > 0xff {
+			n = 0xff
+		}
+		options = append(options, optionTypeURLData, byte(n))
+		options = append(options, urlData[:n]...)
+		urlData = urlData[n:]
+		if urlData == "" {
+			return
+		}
+	}
+}
+
 // body is the binary serializable request body. trailer is optional data
 // following it, such as for BEP 41.
 func (c *udpAnnounce) write(h *RequestHeader, body interface{}, trailer []byte) (err error) {
+	b := encodeRequest(h, body, trailer)
+	n, err := c.socket.Write(b)
+	if err != nil {
+		return
+	}
+	if n != len(b) {
+		panic("write should send all or error")
+	}
+	return
+}
+
+func encodeRequest(h *RequestHeader, body interface{}, trailer []byte) []byte {
 	var buf bytes.Buffer
-	err = binary.Write(&buf, binary.BigEndian, h)
+	err := binary.Write(&buf, binary.BigEndian, h)
 	if err != nil {
 		panic(err)
 	}
@@ -165,18 +197,8 @@ func (c *udpAnnounce) write(h *RequestHeader, body interface{}, trailer []byte)
 			panic(err)
 		}
 	}
-	_, err = buf.Write(trailer)
-	if err != nil {
-		return
-	}
-	n, err := c.socket.Write(buf.Bytes())
-	if err != nil {
-		return
-	}
-	if n != buf.Len() {
-		panic("write should send all or error")
-	}
-	return
+	buf.Write(trailer)
+	return buf.Bytes()
 }
 
 func read(r io.Reader, data interface{}) error {
@@ -190,6 +212,9 @@ func write(w io.Writer, data interface{}) error {
 // args is the binary serializable request body. trailer is optional data
 // following it, such as for BEP 41.
 func (c *udpAnnounce) request(action Action, args interface{}, options []byte) (*bytes.Buffer, error) {
+	if c.shared != nil {
+		return c.shared.request(c.a.Context, c.connectionId, action, args, options)
+	}
 	tid := newTransactionId()
 	if err := errors.Wrap(
 		c.write(
@@ -261,7 +286,12 @@ func readBody(r io.Reader, data ...interface{}) (err error) {
 }
 
 func (c *udpAnnounce) connected() bool {
-	return !c.connectionIdReceived.IsZero() && time.Now().Before(c.connectionIdReceived.Add(time.Minute))
+	return connectionIdFresh(c.connectionIdReceived)
+}
+
+// Connection IDs are valid for a minute after they're received (BEP 15).
+func connectionIdFresh(received time.Time) bool {
+	return !received.IsZero() && time.Now().Before(received.Add(time.Minute))
 }
 
 func (c *udpAnnounce) dialNetwork() string {
@@ -271,18 +301,26 @@ func (c *udpAnnounce) dialNetwork() string {
 	return "udp"
 }
 
+func (c *udpAnnounce) dialAddr() string {
+	hmp := missinggo.SplitHostMaybePort(c.url.Host)
+	if hmp.NoPort {
+		hmp.NoPort = false
+		hmp.Port = 80
+	}
+	return hmp.String()
+}
+
 func (c *udpAnnounce) connect() (err error) {
+	if c.shared != nil {
+		c.connectionId, err = c.shared.connectionID(c.a.Context)
+		return
+	}
 	if c.connected() {
 		return nil
 	}
 	c.connectionId = connectRequestConnectionId
 	if c.socket == nil {
-		hmp := missinggo.SplitHostMaybePort(c.url.Host)
-		if hmp.NoPort {
-			hmp.NoPort = false
-			hmp.Port = 80
-		}
-		c.socket, err = net.Dial(c.dialNetwork(), hmp.String())
+		c.socket, err = net.Dial(c.dialNetwork(), c.dialAddr())
 		if err != nil {
 			return
 		}
@@ -309,6 +347,13 @@ func announceUDP(opt Announce, _url *url.URL) (AnnounceResponse, error) {
 		url: *_url,
 		a:   &opt,
 	}
+	if opt.Pool != nil {
+		var err error
+		ua.shared, err = opt.Pool.udpConn(ua.dialNetwork(), ua.dialAddr())
+		if err != nil {
+			return AnnounceResponse{}, err
+		}
+	}
 	defer ua.Close()
 	return ua.Do(opt.Request)
 }
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 
@@ -127,6 +128,56 @@ func TestAnnounceLocalhost(t *testing.T) {
 	assert.EqualValues(t, 2, len(ar.Peers))
 }
 
+func TestAnnounceLocalhostPool(t *testing.T) {
+	t.Parallel()
+	ih := [20]byte{0xa3, 0x56, 0x41, 0x43, 0x74, 0x23, 0xe6, 0x26, 0xd9, 0x38, 0x25, 0x4a, 0x6b, 0x80, 0x49, 0x10, 0xa6, 0x67, 0xa, 0xc1}
+	srv := server{
+		t: map[[20]byte]torrent{
+			ih: {
+				Seeders:  1,
+				Leechers: 2,
+				Peers: krpc.CompactIPv4NodeAddrs{
+					{[]byte{1, 2, 3, 4}, 5},
+				},
+			},
+		},
+	}
+	var err error
+	srv.pc, err = net.ListenPacket("udp", ":0")
+	require.NoError(t, err)
+	defer srv.pc.Close()
+	served := make(chan error, 1)
+	go func() {
+		// One connect, shared by both announces.
+		for i := 0; i < 3; i++ {
+			if err := srv.serveOne(); err != nil {
+				served <- err
+				return
+			}
+		}
+		served <- nil
+	}()
+	var pool Pool
+	defer pool.Close()
+	for i := 0; i < 2; i++ {
+		req := AnnounceRequest{
+			NumWant:  -1,
+			InfoHash: ih,
+		}
+		rand.Read(req.PeerId[:])
+		ar, err := Announce{
+			TrackerUrl: fmt.Sprintf("udp://%s/announce", srv.pc.LocalAddr().String()),
+			Request:    req,
+			Pool:       &pool,
+		}.Do()
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, ar.Seeders)
+	}
+	require.NoError(t, <-served)
+	assert.Len(t, srv.conns, 1)
+	assert.Len(t, pool.udpConns, 1)
+}
+
 func TestUDPTracker(t *testing.T) {
 	t.Parallel()
 	if testing.Short() {
@@ -234,3 +285,67 @@ func TestURLPathOption(t *testing.T) {
 	write(w, AnnounceResponseHeader{})
 	conn.WriteTo(w.Bytes(), addr)
 }
+
+func TestURLDataOptions(t *testing.T) {
+	long := "/announce/" + strings.Repeat("a", 300)
+	options := urlDataOptions(long)
+	// Two URL data options, with a NOP and the end of options after.
+	options = append(options, optionTypeNOP, optionTypeEndOfOptions, optionTypeURLData, 1, 'x')
+	assert.Equal(t, long, readURLData(bytes.NewReader(options)))
+	// Truncated options are ignored.
+	assert.Equal(t, "/a", readURLData(bytes.NewReader([]byte{optionTypeURLData, 2, '/', 'a', optionTypeURLData, 5, 'b'})))
+}
+
+func TestURLDataPasskey(t *testing.T) {
+	for _, c := range []struct {
+		urlData string
+		passkey string
+		ok      bool
+	}{
+		{"/announce/abc", "abc", true},
+		{"/announce/abc?info_hash=x", "abc", true},
+		{"/announce", "", false},
+		{"/announce/", "", false},
+		{"/announce/abc/def", "", false},
+		{"/scrape/abc", "", false},
+	} {
+		passkey, ok := urlDataPasskey(c.urlData, "/announce")
+		assert.Equal(t, c.passkey, passkey, c.urlData)
+		assert.Equal(t, c.ok, ok, c.urlData)
+	}
+}
+
+func TestAnnouncePasskey(t *testing.T) {
+	t.Parallel()
+	var ih [20]byte
+	srv := server{
+		t: map[[20]byte]torrent{ih: {Seeders: 1}},
+		checkURLData: passkeyChecker("/announce", func(passkey string, ar AnnounceRequest) bool {
+			return passkey == "secret"
+		}),
+	}
+	var err error
+	srv.pc, err = net.ListenPacket("udp", ":0")
+	require.NoError(t, err)
+	defer srv.pc.Close()
+	go func() {
+		for {
+			if srv.serveOne() != nil {
+				return
+			}
+		}
+	}()
+	announce := func(path string) (AnnounceResponse, error) {
+		req := AnnounceRequest{InfoHash: ih, NumWant: -1}
+		return Announce{
+			TrackerUrl: fmt.Sprintf("udp://%s%s", srv.pc.LocalAddr().String(), path),
+			Request:    req,
+		}.Do()
+	}
+	ar, err := announce("/announce/secret")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, ar.Seeders)
+	_, err = announce("/announce/wrong")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid passkey")
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/log"
+	"github.com/anacrolix/missinggo"
 
 	"github.com/anacrolix/torrent/tracker"
 )
@@ -24,17 +25,25 @@ type trackerScraper struct {
 	// The slowdown argument lets us indicate if we think there should be some backpressure on
 	// access to the tracker. It doesn't necessarily have to be used.
 	done func(slowdown bool)
+	// Set when the tracker is removed from the Torrent.
+	stopped missinggo.Event
 }
 
 type torrentTrackerAnnouncer interface {
 	statusLine() string
 	URL() *url.URL
+	// Stops announcing. Called with the Client lock held.
+	stop()
 }
 
 func (me trackerScraper) URL() *url.URL {
 	return &me.u
 }
 
+func (me *trackerScraper) stop() {
+	me.stopped.Set()
+}
+
 func (ts *trackerScraper) statusLine() string {
 	var w bytes.Buffer
 	fmt.Fprintf(&w, "next ann: %v, last ann: %v",
@@ -117,10 +126,34 @@ func (me *trackerScraper) announce(event tracker.AnnounceEvent) (ret trackerAnno
 	// reasonably long timeout is its own form of backpressure (it remains to be seen if it's
 	// enough).
 	defer me.done(false)
-	ip, err := me.getIp()
-	if err != nil {
-		ret.Err = fmt.Errorf("error getting ip: %s", err)
-		return
+	ann := tracker.Announce{
+		HTTPProxy:  me.t.cl.config.HTTPProxy,
+		UserAgent:  me.t.cl.config.HTTPUserAgent,
+		HostHeader: me.u.Host,
+		ServerName: me.u.Hostname(),
+		UdpNetwork: me.u.Scheme,
+		Pool:       me.t.cl.trackerPool,
+	}
+	if on := me.t.cl.trackerOverlay(me.u.Hostname()); on != nil {
+		// The tracker isn't resolved, and is only told the client's address on the network.
+		if me.u.Scheme != "http" && me.u.Scheme != "https" {
+			ret.Err = fmt.Errorf("%v trackers on overlay networks aren't supported", me.u.Scheme)
+			return
+		}
+		ann.TrackerUrl = me.u.String()
+		ann.DialContext = overlayDialContext(on)
+		if l, ok := on.(Listener); ok {
+			ann.ClientHost = l.Addr().String()
+		}
+	} else {
+		ip, err := me.getIp()
+		if err != nil {
+			ret.Err = fmt.Errorf("error getting ip: %s", err)
+			return
+		}
+		ann.TrackerUrl = me.trackerUrl(ip)
+		ann.ClientIp4 = krpc.NodeAddr{IP: me.t.cl.config.PublicIp4}
+		ann.ClientIp6 = krpc.NodeAddr{IP: me.t.cl.config.PublicIp6}
 	}
 	me.t.cl.rLock()
 	req := me.t.announceRequest(event)
@@ -130,18 +163,8 @@ func (me *trackerScraper) announce(event tracker.AnnounceEvent) (ret trackerAnno
 	//ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	//defer cancel()
 	me.t.logger.WithDefaultLevel(log.Debug).Printf("announcing to %q: %#v", me.u.String(), req)
-	res, err := tracker.Announce{
-		//Context:    ctx,
-		HTTPProxy:  me.t.cl.config.HTTPProxy,
-		UserAgent:  me.t.cl.config.HTTPUserAgent,
-		TrackerUrl: me.trackerUrl(ip),
-		Request:    req,
-		HostHeader: me.u.Host,
-		ServerName: me.u.Hostname(),
-		UdpNetwork: me.u.Scheme,
-		ClientIp4:  krpc.NodeAddr{IP: me.t.cl.config.PublicIp4},
-		ClientIp6:  krpc.NodeAddr{IP: me.t.cl.config.PublicIp6},
-	}.Do()
+	ann.Request = req
+	res, err := ann.Do()
 	if err != nil {
 		ret.Err = fmt.Errorf("announcing: %w", err)
 		return
@@ -158,6 +181,9 @@ func (me *trackerScraper) Run() {
 	e := tracker.Started
 	for {
 		ar := me.announce(e)
+		if ar.Err != nil {
+			me.t.cl.countError(ErrorCategoryTracker, ar.Err)
+		}
 		// after first announce, get back to regular "none"
 		e = tracker.None
 		me.t.cl.lock()
@@ -174,6 +200,7 @@ func (me *trackerScraper) Run() {
 		me.t.cl.lock()
 		wantPeers := me.t.wantPeersEvent.C()
 		closed := me.t.closed.C()
+		stopped := me.stopped.C()
 		me.t.cl.unlock()
 
 		// If we want peers, reduce the interval to the minimum.
@@ -190,6 +217,8 @@ func (me *trackerScraper) Run() {
 		select {
 		case <-closed:
 			return
+		case <-stopped:
+			return
 		case <-wantPeers:
 			// Recalculate the interval.
 			goto wait
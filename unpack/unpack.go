@@ -0,0 +1,229 @@
+// Package unpack provides a torrent.CompletionHook that extracts zip and rar archives from completed
+// torrents.
+package unpack
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/storage"
+)
+
+type Opts struct {
+	// Archives are extracted under a directory named for the torrent in Dir.
+	Dir string
+	// The command used to extract rar archives, which is given the same arguments as unrar. Defaults
+	// to "unrar". Rar archives fail to extract if it's not available.
+	Unrar string
+	// Directory for copies of rar volumes while they're extracted. Defaults to the system temporary
+	// directory.
+	TempDir string
+}
+
+// Returns a hook that extracts the zip and rar archives in a torrent. Multi-volume rar archives
+// (.partN.rar and .rNN) are extracted once, from the first volume. Torrent data is read through the
+// Client, so any storage works.
+func Hook(opts Opts) torrent.CompletionHook {
+	if opts.Unrar == "" {
+		opts.Unrar = "unrar"
+	}
+	return func(t *torrent.Torrent, files []*torrent.File) error {
+		dir, err := storage.ToSafeFilePath(t.Name())
+		if err != nil {
+			return fmt.Errorf("torrent name %q: %w", t.Name(), err)
+		}
+		dir = filepath.Join(opts.Dir, dir)
+		for _, f := range files {
+			switch archiveType(f.DisplayPath()) {
+			case zipArchive:
+				err = unpackZip(f, dir)
+			case rarArchive:
+				err = unpackRar(f, files, dir, opts)
+			default:
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("extracting %q: %w", f.DisplayPath(), err)
+			}
+		}
+		return nil
+	}
+}
+
+type archive int
+
+const (
+	notArchive archive = iota
+	zipArchive
+	// The first volume of a rar archive.
+	rarArchive
+)
+
+var (
+	rarPartRegexp = regexp.MustCompile(`(?i)\.part0*(\d+)\.rar$`)
+	rarExtRegexp  = regexp.MustCompile(`(?i)\.rar$`)
+)
+
+func archiveType(name string) archive {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return zipArchive
+	case strings.HasSuffix(lower, ".rar"):
+		if m := rarPartRegexp.FindStringSubmatch(lower); m != nil && m[1] != "1" {
+			return notArchive
+		}
+		return rarArchive
+	default:
+		return notArchive
+	}
+}
+
+// Returns the files that are volumes of the rar archive with first volume first, including first.
+func rarVolumes(first *torrent.File, files []*torrent.File) (ret []*torrent.File) {
+	dir, name := path.Split(first.DisplayPath())
+	stem := rarExtRegexp.ReplaceAllString(rarPartRegexp.ReplaceAllString(name, ""), "")
+	volume := regexp.MustCompile(`(?i)^` + regexp.QuoteMeta(stem) + `(\.part\d+\.rar|\.rar|\.[rs]\d\d)$`)
+	for _, f := range files {
+		d, n := path.Split(f.DisplayPath())
+		if d == dir && volume.MatchString(n) {
+			ret = append(ret, f)
+		}
+	}
+	return
+}
+
+func unpackZip(f *torrent.File, dir string) error {
+	r := f.NewReader()
+	defer r.Close()
+	return extractZip(&readerAt{r: r}, f.Length(), dir)
+}
+
+func extractZip(ra io.ReaderAt, size int64, dir string) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		err = extractZipFile(zf, dir)
+		if err != nil {
+			return fmt.Errorf("%q: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipFile(zf *zip.File, dir string) error {
+	name, err := storage.ToSafeFilePath(filepath.FromSlash(strings.TrimLeft(zf.Name, `/\`)))
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(dir, name)
+	if zf.FileInfo().IsDir() {
+		return os.MkdirAll(target, 0777)
+	}
+	if !zf.Mode().IsRegular() {
+		// Links and such could point outside dir.
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0777)
+	if err != nil {
+		return err
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rc)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Volumes are copied out of the torrent so that unrar can find them by name, regardless of the
+// storage used.
+func unpackRar(first *torrent.File, files []*torrent.File, dir string, opts Opts) error {
+	unrar, err := exec.LookPath(opts.Unrar)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(opts.TempDir, "unpack")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var firstPath string
+	for _, f := range rarVolumes(first, files) {
+		p := filepath.Join(tmp, path.Base(f.DisplayPath()))
+		err = copyFile(f, p)
+		if err != nil {
+			return err
+		}
+		if f == first {
+			firstPath = p
+		}
+	}
+	if firstPath == "" {
+		return errors.New("first volume not found")
+	}
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command(unrar, "x", "-o+", "-y", "-idq", firstPath, dir+string(filepath.Separator)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", opts.Unrar, err, out)
+	}
+	return nil
+}
+
+func copyFile(f *torrent.File, name string) error {
+	r := f.NewReader()
+	defer r.Close()
+	w, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Adapts a Reader for the random access archive/zip requires.
+type readerAt struct {
+	mu sync.Mutex
+	r  torrent.Reader
+}
+
+func (me *readerAt) ReadAt(b []byte, off int64) (int, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	_, err := me.r.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(me.r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
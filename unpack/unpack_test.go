@@ -0,0 +1,52 @@
+package unpack
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveType(t *testing.T) {
+	assert.Equal(t, zipArchive, archiveType("a/b.ZIP"))
+	assert.Equal(t, rarArchive, archiveType("a/b.rar"))
+	assert.Equal(t, rarArchive, archiveType("a/b.part01.rar"))
+	assert.Equal(t, rarArchive, archiveType("a/b.part1.rar"))
+	assert.Equal(t, notArchive, archiveType("a/b.part02.rar"))
+	assert.Equal(t, notArchive, archiveType("a/b.r00"))
+	assert.Equal(t, notArchive, archiveType("a/b.mkv"))
+}
+
+func TestExtractZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct{ name, data string }{
+		{"dir/hello.txt", "hello"},
+		{"/rooted.txt", "rooted"},
+		{"../escape.txt", "nope"},
+	} {
+		w, err := zw.Create(e.name)
+		require.NoError(t, err)
+		w.Write([]byte(e.data))
+	}
+	require.NoError(t, zw.Close())
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	err = extractZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), out)
+	assert.Error(t, err)
+	b, err := ioutil.ReadFile(filepath.Join(out, "dir", "hello.txt"))
+	require.NoError(t, err)
+	assert.EqualValues(t, "hello", b)
+	b, err = ioutil.ReadFile(filepath.Join(out, "rooted.txt"))
+	require.NoError(t, err)
+	assert.EqualValues(t, "rooted", b)
+	_, err = os.Stat(filepath.Join(dir, "escape.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"container/list"
+	"io"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// When seeding to a crowd, many peers request the same pieces within seconds of each other. The
+// upload cache keeps recently read regions of pieces in memory, shared across the Client's
+// torrents, so each region is read from storage once. Peers request a piece's chunks in order, so
+// requests are served from the aligned region containing them, and the chunks that follow are
+// cached with the first. Concurrent misses for a region wait on a single read. See
+// ClientConfig.UploadCacheSize.
+
+// The size of the piece regions cached, unless the piece is shorter.
+const uploadCacheRegionSize = 1 << 18
+
+type uploadCacheKey struct {
+	infoHash metainfo.Hash
+	piece    pieceIndex
+	// The region's offset in the piece.
+	off int64
+}
+
+type uploadCacheEntry struct {
+	key uploadCacheKey
+	// Closed once data and err are set.
+	ready chan struct{}
+	data  []byte
+	err   error
+	// Nil until the region is read.
+	elem *list.Element
+}
+
+type uploadCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      list.List
+	entries  map[uploadCacheKey]*uploadCacheEntry
+}
+
+func newUploadCache(capacity int64) *uploadCache {
+	return &uploadCache{
+		capacity: capacity,
+		entries:  make(map[uploadCacheKey]*uploadCacheEntry),
+	}
+}
+
+// Returns the region's data, calling read for it if it isn't cached, or being read already. The
+// returned data is shared, and mustn't be modified.
+func (me *uploadCache) get(key uploadCacheKey, read func() ([]byte, error)) ([]byte, error) {
+	me.mu.Lock()
+	e, ok := me.entries[key]
+	if ok {
+		if e.elem != nil {
+			me.lru.MoveToFront(e.elem)
+		}
+		me.mu.Unlock()
+		torrent.Add("upload cache hits", 1)
+		<-e.ready
+		return e.data, e.err
+	}
+	e = &uploadCacheEntry{key: key, ready: make(chan struct{})}
+	me.entries[key] = e
+	me.mu.Unlock()
+	torrent.Add("upload cache misses", 1)
+	e.data, e.err = read()
+	close(e.ready)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.entries[key] != e {
+		// Invalidated while it was read.
+		return e.data, e.err
+	}
+	if e.err != nil || int64(len(e.data)) > me.capacity {
+		delete(me.entries, key)
+		return e.data, e.err
+	}
+	e.elem = me.lru.PushFront(e)
+	me.size += int64(len(e.data))
+	for me.size > me.capacity {
+		me.remove(me.lru.Back().Value.(*uploadCacheEntry))
+	}
+	return e.data, e.err
+}
+
+func (me *uploadCache) remove(e *uploadCacheEntry) {
+	delete(me.entries, e.key)
+	if e.elem != nil {
+		me.lru.Remove(e.elem)
+		me.size -= int64(len(e.data))
+	}
+}
+
+// Drops the cached regions of the piece, such as when its data is no longer complete.
+func (me *uploadCache) invalidatePiece(infoHash metainfo.Hash, piece pieceIndex, length int64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for off := int64(0); off < length; off += uploadCacheRegionSize {
+		if e, ok := me.entries[uploadCacheKey{infoHash, piece, off}]; ok {
+			me.remove(e)
+		}
+	}
+}
+
+// Drops the torrent's cached regions.
+func (me *uploadCache) invalidateTorrent(infoHash metainfo.Hash) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for key, e := range me.entries {
+		if key.infoHash == infoHash {
+			me.remove(e)
+		}
+	}
+}
+
+// Reads the request's data through the upload cache. ok is false if the request crosses a region
+// boundary, and should be read directly.
+func (t *Torrent) readCachedRequestData(uc *uploadCache, r request) (b []byte, ok bool, err error) {
+	p := t.info.Piece(int(r.Index))
+	begin := int64(r.Begin)
+	regionOff := begin / uploadCacheRegionSize * uploadCacheRegionSize
+	regionLen := p.Length() - regionOff
+	if regionLen > uploadCacheRegionSize {
+		regionLen = uploadCacheRegionSize
+	}
+	end := begin + int64(r.Length)
+	if end > regionOff+regionLen {
+		return
+	}
+	ok = true
+	region, err := uc.get(uploadCacheKey{t.infoHash, pieceIndex(r.Index), regionOff}, func() ([]byte, error) {
+		b := make([]byte, regionLen)
+		n, err := t.readAt(b, p.Offset()+regionOff)
+		if n == len(b) && err == io.EOF {
+			err = nil
+		}
+		return b[:n], err
+	})
+	if err != nil {
+		return
+	}
+	// The cached data is shared.
+	b = append([]byte(nil), region[begin-regionOff:end-regionOff]...)
+	return
+}
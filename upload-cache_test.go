@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadCacheSingleRead(t *testing.T) {
+	uc := newUploadCache(10)
+	key := uploadCacheKey{piece: 1}
+	release := make(chan struct{})
+	reads := 0
+	read := func() ([]byte, error) {
+		reads++
+		<-release
+		return []byte("hello"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := uc.get(key, read)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+		}()
+	}
+	// Let the readers find the entry before the read finishes.
+	for {
+		uc.mu.Lock()
+		_, ok := uc.entries[key]
+		uc.mu.Unlock()
+		if ok {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, reads)
+	assert.EqualValues(t, 5, uc.size)
+}
+
+func TestUploadCacheEvictAndInvalidate(t *testing.T) {
+	uc := newUploadCache(10)
+	reads := 0
+	get := func(key uploadCacheKey, data string) {
+		b, err := uc.get(key, func() ([]byte, error) {
+			reads++
+			return []byte(data), nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, data, string(b))
+	}
+	a := uploadCacheKey{piece: 0}
+	b := uploadCacheKey{piece: 1}
+	c := uploadCacheKey{piece: 2}
+	get(a, "aaaa")
+	get(b, "bbbb")
+	get(a, "aaaa")
+	assert.Equal(t, 2, reads)
+	// b is the least recently used.
+	get(c, "cccc")
+	assert.EqualValues(t, 8, uc.size)
+	assert.NotContains(t, uc.entries, b)
+	uc.invalidatePiece(a.infoHash, a.piece, 1)
+	assert.NotContains(t, uc.entries, a)
+	assert.EqualValues(t, 4, uc.size)
+	// Errors aren't cached.
+	_, err := uc.get(b, func() ([]byte, error) { return nil, errors.New("boom") })
+	assert.Error(t, err)
+	assert.NotContains(t, uc.entries, b)
+	uc.invalidateTorrent(c.infoHash)
+	assert.Empty(t, uc.entries)
+	assert.Zero(t, uc.size)
+}
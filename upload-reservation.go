@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Sets up the limiter that holds uploads to established peers below the UploadRateLimiter's rate,
+// leaving ClientConfig.NewPeerUploadReservation of it for peers that have no pieces yet.
+func (cl *Client) initUploadReservation() {
+	f := cl.config.NewPeerUploadReservation
+	l := cl.config.UploadRateLimiter
+	if f <= 0 || f >= 1 || l == nil || l.Limit() == rate.Inf {
+		return
+	}
+	cl.establishedUploadLimiter = rate.NewLimiter(l.Limit()*rate.Limit(1-f), l.Burst())
+}
+
+// The peer has nothing yet, so it can only be bootstrapped into the swarm by uploading to it.
+func (c *PeerConn) bootstrapping() bool {
+	return !c.peerSentHaveAll && c._peerPieces.IsEmpty()
+}
+
+// Whether uploading to a bootstrapping peer is allowed even though it has nothing we want.
+func (c *PeerConn) bootstrapUploadAllowed() bool {
+	return c.t.cl.establishedUploadLimiter != nil && c.bootstrapping()
+}
+
+// The limiters an upload to the peer must reserve from.
+func (c *PeerConn) uploadLimiters() []*rate.Limiter {
+	cl := c.t.cl
+	if cl.rateLimitExempt(c.networkClass) {
+		return []*rate.Limiter{unlimited}
+	}
+	if cl.establishedUploadLimiter == nil || c.bootstrapping() {
+		return []*rate.Limiter{cl.config.UploadRateLimiter}
+	}
+	return []*rate.Limiter{cl.config.UploadRateLimiter, cl.establishedUploadLimiter}
+}
+
+// Reserves n tokens from each of the limiters. If any of them would delay, nothing is reserved and
+// the longest delay is returned. ok is false if n exceeds a limiter's burst.
+func reserveUpload(limiters []*rate.Limiter, n int) (delay time.Duration, ok bool) {
+	now := time.Now()
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	cancel := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	for _, l := range limiters {
+		r := l.ReserveN(now, n)
+		if !r.OK() {
+			cancel()
+			return 0, false
+		}
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		cancel()
+	}
+	return delay, true
+}
@@ -0,0 +1,44 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestUploadReservation(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.UploadRateLimiter = rate.NewLimiter(1000, 100)
+	cfg.NewPeerUploadReservation = 0.25
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	require.NotNil(t, cl.establishedUploadLimiter)
+	assert.EqualValues(t, 750, cl.establishedUploadLimiter.Limit())
+	assert.Equal(t, 100, cl.establishedUploadLimiter.Burst())
+}
+
+func TestPeerConnBootstrapping(t *testing.T) {
+	var c PeerConn
+	assert.True(t, c.bootstrapping())
+	c._peerPieces.Set(0, true)
+	assert.False(t, c.bootstrapping())
+}
+
+func TestReserveUpload(t *testing.T) {
+	shared := rate.NewLimiter(1, 10)
+	established := rate.NewLimiter(1, 10)
+	require.True(t, established.AllowN(time.Now(), 10))
+	// The established limiter is exhausted, so nothing is taken from the shared one.
+	delay, ok := reserveUpload([]*rate.Limiter{shared, established}, 5)
+	assert.True(t, ok)
+	assert.True(t, delay > 0)
+	delay, ok = reserveUpload([]*rate.Limiter{shared}, 10)
+	assert.True(t, ok)
+	assert.EqualValues(t, 0, delay)
+	_, ok = reserveUpload([]*rate.Limiter{shared}, 11)
+	assert.False(t, ok)
+}
@@ -0,0 +1,214 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"sort"
+
+	"github.com/anacrolix/missinggo/v2/bitmap"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Verifying whole torrents, such as after an unclean shutdown, takes a while, and hashing them all
+// at once makes every one of them slow to come back. With ClientConfig.PieceHashers, the Client
+// hashes at most that many pieces at a time, and gives them to torrents in verification order:
+// those being read first, then by TorrentPriority, then those with connections. Within a torrent,
+// pieces readers are waiting on are hashed first. Pieces queued by QueueDataVerification are
+// recorded where the storage supports it (see storage.PendingVerifications), and are queued again
+// when the torrent is next added, so verification interrupted by a restart resumes.
+
+// A torrent with pieces waiting to be hashed. See Client.VerificationQueue.
+type VerificationStatus struct {
+	InfoHash metainfo.Hash
+	// Pieces queued to be hashed, and being hashed.
+	Queued  int
+	Hashing int
+}
+
+// Queues every piece to be hashed again, and returns without waiting. Use Piece.VerifyData to wait
+// for a piece.
+func (t *Torrent) QueueDataVerification() error {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if !t.haveInfo() {
+		return errors.New("torrent has no info")
+	}
+	for i := range t.pieces {
+		t.queueVerification(i)
+	}
+	return nil
+}
+
+// Queues verification of every torrent that has its info, such as after an unclean shutdown.
+func (cl *Client) QueueDataVerification() {
+	for _, t := range cl.Torrents() {
+		t.QueueDataVerification()
+	}
+}
+
+// Returns the torrents with pieces waiting to be hashed, in verification order.
+func (cl *Client) VerificationQueue() (ret []VerificationStatus) {
+	cl.rLock()
+	defer cl.rUnlock()
+	for _, t := range cl.torrentsInVerificationOrder() {
+		queued := int(t.piecesQueuedForHash.Len())
+		if queued == 0 && t.activePieceHashes == 0 {
+			continue
+		}
+		ret = append(ret, VerificationStatus{
+			InfoHash: t.infoHash,
+			Queued:   queued,
+			Hashing:  t.activePieceHashes,
+		})
+	}
+	return
+}
+
+// Queues the piece to be hashed, recording it in storage until it is.
+func (t *Torrent) queueVerification(i pieceIndex) {
+	if !t.piecesPendingVerification.Get(bitmap.BitIndex(i)) && t.storage != nil {
+		t.piecesPendingVerification.Add(i)
+		t.changeVerificationPending(i, true)
+	}
+	t.queuePieceCheck(i)
+}
+
+// Queues the change to be written to storage. Changes made while a write is in progress are
+// written after it.
+func (t *Torrent) changeVerificationPending(i pieceIndex, pending bool) {
+	if t.verificationPendingChanges == nil {
+		t.verificationPendingChanges = make(map[pieceIndex]bool)
+	}
+	t.verificationPendingChanges[i] = pending
+	if !t.verificationPendingWriteQueued {
+		t.verificationPendingWriteQueued = true
+		go t.writeVerificationPending()
+	}
+}
+
+// Writes the queued changes to storage, with a call for each of pending and not, without the
+// client lock.
+func (t *Torrent) writeVerificationPending() {
+	t.verificationPendingWriteMu.Lock()
+	defer t.verificationPendingWriteMu.Unlock()
+	t.cl.lock()
+	changes := t.verificationPendingChanges
+	t.verificationPendingChanges = nil
+	t.verificationPendingWriteQueued = false
+	storage := t.storage
+	t.cl.unlock()
+	if storage == nil {
+		return
+	}
+	var set, cleared []int
+	for i, pending := range changes {
+		if pending {
+			set = append(set, i)
+		} else {
+			cleared = append(cleared, i)
+		}
+	}
+	sort.Ints(set)
+	sort.Ints(cleared)
+	if err := storage.SetVerificationPending(set, true); err != nil {
+		t.logger.Printf("error recording %d pieces pending verification: %v", len(set), err)
+	}
+	if err := storage.SetVerificationPending(cleared, false); err != nil {
+		t.logger.Printf("error clearing %d pieces pending verification: %v", len(cleared), err)
+	}
+}
+
+// Queues the pieces that storage recorded as pending verification. Called when the info is set.
+func (t *Torrent) resumeVerification() {
+	if t.storage == nil {
+		return
+	}
+	pieces, _, err := t.storage.GetVerificationPending()
+	if err != nil {
+		t.logger.Printf("error getting pieces pending verification: %v", err)
+		return
+	}
+	for _, i := range pieces {
+		if i < 0 || i >= t.numPieces() {
+			continue
+		}
+		t.piecesPendingVerification.Add(i)
+		t.queuePieceCheck(i)
+	}
+	if len(pieces) != 0 {
+		t.logger.Printf("resuming verification of %d pieces", len(pieces))
+	}
+}
+
+// Removes the piece from the recorded verification queue once it's been hashed, unless it's queued
+// again.
+func (t *Torrent) verificationDone(i pieceIndex) {
+	if !t.piecesPendingVerification.Get(bitmap.BitIndex(i)) || t.piece(i).queuedForHash() {
+		return
+	}
+	t.piecesPendingVerification.Remove(i)
+	if t.storage != nil {
+		t.changeVerificationPending(i, false)
+	}
+}
+
+// Whether the verification of l comes before r's.
+func (l *Torrent) verifiesBefore(r *Torrent) bool {
+	if lr, rr := len(l.readers) != 0, len(r.readers) != 0; lr != rr {
+		return lr
+	}
+	if lp, rp := l.Priority(), r.Priority(); lp != rp {
+		return lp > rp
+	}
+	if lc, rc := len(l.conns) != 0, len(r.conns) != 0; lc != rc {
+		return lc
+	}
+	return bytes.Compare(l.infoHash[:], r.infoHash[:]) < 0
+}
+
+func (cl *Client) torrentsInVerificationOrder() []*Torrent {
+	ts := cl.torrentsAsSlice()
+	sort.Slice(ts, func(i, j int) bool { return ts[i].verifiesBefore(ts[j]) })
+	return ts
+}
+
+// Whether ClientConfig.PieceHashers allows another piece to be hashed.
+func (cl *Client) pieceHashAllowed() bool {
+	return cl.config.PieceHashers <= 0 || cl.activePieceHashes < cl.config.PieceHashers
+}
+
+// Starts hashing pieces of torrents in verification order until ClientConfig.PieceHashers is
+// reached. It's called each time a piece is hashed, so rather than sorting all the torrents, it
+// picks the first of those with queued pieces for each hasher.
+func (cl *Client) tryCreateMorePieceHashers() {
+	if !cl.pieceHashAllowed() {
+		return
+	}
+	var waiting []*Torrent
+	for _, t := range cl.torrents {
+		if t.piecesQueuedForHash.Len() != 0 {
+			waiting = append(waiting, t)
+		}
+	}
+	for len(waiting) != 0 && cl.pieceHashAllowed() {
+		first := 0
+		for i, t := range waiting {
+			if t.verifiesBefore(waiting[first]) {
+				first = i
+			}
+		}
+		waiting[first].startPieceHashers()
+		waiting[first] = waiting[len(waiting)-1]
+		waiting = waiting[:len(waiting)-1]
+	}
+}
+
+// Returns a hash for verifying pieces. See ClientConfig.PieceHasher.
+func (cl *Client) newPieceHash() hash.Hash {
+	if f := cl.config.PieceHasher; f != nil {
+		return f()
+	}
+	return pieceHash.New()
+}
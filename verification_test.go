@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"hash"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+func waitVerificationQueue(t *testing.T, cl *Client) {
+	deadline := time.Now().Add(10 * time.Second)
+	for len(cl.VerificationQueue()) != 0 {
+		require.True(t, time.Now().Before(deadline), "verification didn't finish")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Pending pieces are cleared from storage in the background, after they're hashed.
+func waitNoVerificationPending(t *testing.T, pv storage.PendingVerifications, ih metainfo.Hash) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pending, err := pv.GetVerificationPending(ih)
+		require.NoError(t, err)
+		if len(pending) == 0 {
+			return
+		}
+		require.True(t, time.Now().Before(deadline), "pieces still pending: %v", pending)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueDataVerification(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	pc := storage.NewMapPieceCompletion()
+	pv := pc.(storage.PendingVerifications)
+	ih := mi.HashInfoBytes()
+	// Left by verification interrupted by a restart.
+	require.NoError(t, pv.SetVerificationPending(ih, []int{1}, true))
+	cfg := TestingConfig()
+	cfg.PieceHashers = 1
+	cfg.DefaultStorage = storage.NewFileWithCompletion(dir, pc)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	<-tt.GotInfo()
+	waitVerificationQueue(t, cl)
+	waitNoVerificationPending(t, pv, ih)
+	assert.EqualValues(t, tt.Length(), tt.BytesCompleted())
+
+	require.NoError(t, tt.QueueDataVerification())
+	cl.rLock()
+	assert.LessOrEqual(t, cl.activePieceHashes, 1)
+	cl.rUnlock()
+	waitVerificationQueue(t, cl)
+	waitNoVerificationPending(t, pv, ih)
+	cl.rLock()
+	for i := range tt.pieces {
+		assert.EqualValues(t, 2, tt.piece(i).numVerifies)
+	}
+	cl.rUnlock()
+}
+
+type countingPieceHash struct {
+	hash.Hash
+	written *int64
+}
+
+func (me countingPieceHash) Write(b []byte) (int, error) {
+	atomic.AddInt64(me.written, int64(len(b)))
+	return me.Hash.Write(b)
+}
+
+func TestPieceHasher(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	var written int64
+	cfg := TestingConfig()
+	cfg.DataDir = dir
+	cfg.PieceHasher = func() hash.Hash { return countingPieceHash{sha1.New(), &written} }
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	<-tt.GotInfo()
+	tt.VerifyData()
+	assert.EqualValues(t, tt.Length(), tt.BytesCompleted())
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&written), tt.Length())
+}
@@ -0,0 +1,114 @@
+package torrent
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Weights for deciding how much downloading is left to webseeds when peers can supply the same
+// pieces, so that origin egress is kept down once the swarm is fast enough. A webseed keeps all its
+// requests while the swarm is slow, and gives up more of them as the swarm's download rate nears
+// SwarmRate, and the more its latency and cost weigh. See ClientConfig.WebSeedArbitration.
+type WebSeedArbitration struct {
+	// The download rate from peers, in bytes per second, at which the swarm is considered to be
+	// keeping up. Zero disables arbitration.
+	SwarmRate float64
+	// Cost per second of a webseed's mean request latency.
+	LatencyWeight float64
+	// Cost per unit of WebSeedOpts.CostPerGB.
+	CostWeight float64
+}
+
+// The fraction of a webseed's requests it keeps.
+func (me WebSeedArbitration) fraction(swarmRate float64, latency time.Duration, costPerGB float64) float64 {
+	if me.SwarmRate <= 0 {
+		return 1
+	}
+	cost := me.LatencyWeight*latency.Seconds() + me.CostWeight*costPerGB
+	return 1 / (1 + cost*swarmRate/me.SwarmRate)
+}
+
+// The webseed's share of max requests under arbitration. It's also limited to what its
+// WebSeedOpts.RateLimiter can deliver over its latency, as further requests only wait. At least one
+// request is kept, so webseeds continue if the swarm stalls.
+func (ws *webseedPeer) arbitratedMaxRequests(max int) int {
+	t := ws.peer.t
+	f := t.cl.config.WebSeedArbitration.fraction(t.swarmDownloadRate(), ws.latency, ws.costPerGB)
+	ret := int(math.Ceil(float64(max) * f))
+	if l := ws.rateLimiter; l != nil && l.Limit() != rate.Inf && ws.latency > 0 && t.chunkSize > 0 {
+		capped := int(math.Ceil(float64(l.Limit())*ws.latency.Seconds()/float64(t.chunkSize))) + 1
+		if capped < ret {
+			ret = capped
+		}
+	}
+	if ret < 1 {
+		ret = 1
+	}
+	return ret
+}
+
+// Updates the webseed's mean latency with a completed request.
+func (ws *webseedPeer) recordLatency(d time.Duration) {
+	if ws.latency == 0 {
+		ws.latency = d
+		return
+	}
+	ws.latency += (d - ws.latency) / 8
+}
+
+// The sum of the download rates of the torrent's peer connections.
+func (t *Torrent) swarmDownloadRate() (ret float64) {
+	for pc := range t.conns {
+		r := pc.downloadRate()
+		if !math.IsNaN(r) && !math.IsInf(r, 0) {
+			ret += r
+		}
+	}
+	return
+}
+
+// Useful piece data received by source, for comparing webseed and swarm downloading.
+type DownloadSourceStats struct {
+	// From peer connections.
+	Swarm int64
+	// From all webseeds, including those since removed.
+	WebSeeds int64
+	// By URL, for the webseeds currently added.
+	ByWebSeed map[string]WebSeedStats
+}
+
+type WebSeedStats struct {
+	// Received while the webseed was added.
+	BytesReadUsefulData int64
+	// The mean request latency, weighted to recent requests.
+	Latency time.Duration
+	// The requests the webseed may have outstanding, after arbitration.
+	MaxRequests int
+	Enabled     bool
+}
+
+func (t *Torrent) DownloadSourceStats() (ret DownloadSourceStats) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	ret.WebSeeds = t.webSeedBytesUseful.Int64()
+	ret.Swarm = t.stats.BytesReadUsefulData.Int64() - ret.WebSeeds
+	ret.ByWebSeed = make(map[string]WebSeedStats, len(t.webSeeds)+len(t.disabledWebSeeds))
+	add := func(url string, p *peer, enabled bool) {
+		ws := p.peerImpl.(*webseedPeer)
+		ret.ByWebSeed[url] = WebSeedStats{
+			BytesReadUsefulData: p._stats.BytesReadUsefulData.Int64(),
+			Latency:             ws.latency,
+			MaxRequests:         p.nominalMaxRequests(),
+			Enabled:             enabled,
+		}
+	}
+	for url, p := range t.webSeeds {
+		add(url, p, true)
+	}
+	for url, p := range t.disabledWebSeeds {
+		add(url, p, false)
+	}
+	return
+}
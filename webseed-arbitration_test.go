@@ -0,0 +1,40 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestWebSeedArbitrationFraction(t *testing.T) {
+	var arb WebSeedArbitration
+	assert.EqualValues(t, 1, arb.fraction(1e6, time.Second, 10))
+	arb = WebSeedArbitration{SwarmRate: 1000, LatencyWeight: 1, CostWeight: 0.5}
+	// A slow swarm leaves the webseed alone.
+	assert.EqualValues(t, 1, arb.fraction(0, time.Second, 2))
+	// A swarm at the target rate, with a cost of 1+0.5*2.
+	assert.EqualValues(t, 1.0/3, arb.fraction(1000, time.Second, 2))
+	// Costlier webseeds give up more.
+	assert.True(t, arb.fraction(1000, time.Second, 4) < arb.fraction(1000, time.Second, 2))
+}
+
+func TestWebSeedArbitratedMaxRequests(t *testing.T) {
+	cfg := TestingConfig()
+	cfg.WebSeedArbitration = WebSeedArbitration{SwarmRate: 1, CostWeight: 1}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _ := cl.AddTorrentInfoHash([20]byte{1})
+	ws := webseedPeer{peer: peer{t: tt}, costPerGB: 1}
+	// No swarm, so nothing is given up.
+	assert.Equal(t, 10, ws.arbitratedMaxRequests(10))
+	// The rate limiter can only deliver one chunk over the latency.
+	ws.rateLimiter = rate.NewLimiter(rate.Limit(tt.chunkSize), int(tt.chunkSize))
+	ws.recordLatency(time.Second)
+	assert.Equal(t, 2, ws.arbitratedMaxRequests(10))
+	ws.recordLatency(3 * time.Second)
+	assert.Equal(t, time.Second+time.Second/4, ws.latency)
+}
@@ -1,8 +1,10 @@
 package torrent
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/anacrolix/torrent/common"
 	"github.com/anacrolix/torrent/metainfo"
@@ -10,12 +12,20 @@ import (
 	"github.com/anacrolix/torrent/segments"
 	"github.com/anacrolix/torrent/webseed"
 	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
 )
 
 type webseedPeer struct {
 	client   webseed.Client
 	requests map[request]webseed.Request
-	peer     peer
+	// Cancels requests that are waiting on the rate limiter before they're sent.
+	waitingRequests map[request]context.CancelFunc
+	peer            peer
+	rateLimiter     *rate.Limiter
+	// See WebSeedOpts.CostPerGB.
+	costPerGB float64
+	// The mean time for requests to complete, for arbitration. See recordLatency.
+	latency time.Duration
 }
 
 var _ peerImpl = (*webseedPeer)(nil)
@@ -42,6 +52,11 @@ func (ws *webseedPeer) writeInterested(interested bool) bool {
 }
 
 func (ws *webseedPeer) cancel(r request) bool {
+	if cancel, ok := ws.waitingRequests[r]; ok {
+		cancel()
+		delete(ws.waitingRequests, r)
+		return true
+	}
 	ws.requests[r].Cancel()
 	return true
 }
@@ -51,10 +66,67 @@ func (ws *webseedPeer) intoSpec(r request) webseed.RequestSpec {
 }
 
 func (ws *webseedPeer) request(r request) bool {
+	if ws.rateLimiter == nil || ws.rateLimiter.Limit() == rate.Inf {
+		ws.startRequest(r)
+		return true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if ws.waitingRequests == nil {
+		ws.waitingRequests = make(map[request]context.CancelFunc)
+	}
+	ws.waitingRequests[r] = cancel
+	go ws.requestAfterRateLimit(ctx, r)
+	return true
+}
+
+func (ws *webseedPeer) startRequest(r request) {
 	webseedRequest := ws.client.NewRequest(ws.intoSpec(r))
 	ws.requests[r] = webseedRequest
-	go ws.requestResultHandler(r, webseedRequest)
-	return true
+	go ws.requestResultHandler(r, webseedRequest, time.Now())
+}
+
+// Takes the request's length from the rate limiter before it's sent, so the limit applies to the
+// transfer rather than after it.
+func (ws *webseedPeer) requestAfterRateLimit(ctx context.Context, r request) {
+	err := waitRateLimit(ctx, ws.rateLimiter, int(r.Length))
+	ws.peer.t.cl.lock()
+	defer ws.peer.t.cl.unlock()
+	if ctx.Err() != nil {
+		// Cancelled, which removed it from waitingRequests.
+		return
+	}
+	delete(ws.waitingRequests, r)
+	if ws.peer.closed.IsSet() {
+		return
+	}
+	if err != nil {
+		ws.peer.logger.Printf("request %v not sent: %v", r, err)
+		ws.peer.remoteRejectedRequest(r)
+		return
+	}
+	ws.startRequest(r)
+}
+
+// Waits for n tokens from the limiter, in parts no larger than its burst, so that requests longer
+// than the burst are still limited.
+func waitRateLimit(ctx context.Context, l *rate.Limiter, n int) error {
+	if l.Limit() == rate.Inf {
+		return nil
+	}
+	if l.Burst() <= 0 {
+		return fmt.Errorf("rate limiter burst is %d", l.Burst())
+	}
+	for n > 0 {
+		m := n
+		if m > l.Burst() {
+			m = l.Burst()
+		}
+		if err := l.WaitN(ctx, m); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
 }
 
 func (ws *webseedPeer) connectionFlags() string {
@@ -68,12 +140,25 @@ func (ws *webseedPeer) updateRequests() {
 	ws.peer.doRequestState()
 }
 
-func (ws *webseedPeer) _close() {}
+func (ws *webseedPeer) _close() {
+	for r, cancel := range ws.waitingRequests {
+		cancel()
+		delete(ws.waitingRequests, r)
+	}
+}
 
-func (ws *webseedPeer) requestResultHandler(r request, webseedRequest webseed.Request) {
+func (ws *webseedPeer) requestResultHandler(r request, webseedRequest webseed.Request, started time.Time) {
 	result := <-webseedRequest.Result
+	latency := time.Since(started)
 	ws.peer.t.cl.lock()
 	defer ws.peer.t.cl.unlock()
+	if ws.peer.closed.IsSet() {
+		// The webseed was removed.
+		return
+	}
+	if result.Err == nil {
+		ws.recordLatency(latency)
+	}
 	if result.Err != nil {
 		ws.peer.logger.Printf("request %v rejected: %v", r, result.Err)
 		// Always close for now. We need to filter out temporary errors, but this is a nightmare in
@@ -86,6 +171,7 @@ func (ws *webseedPeer) requestResultHandler(r request, webseedRequest webseed.Re
 			ws.peer.remoteRejectedRequest(r)
 		}
 	} else {
+		useful := ws.peer._stats.BytesReadUsefulData.Int64()
 		err := ws.peer.receiveChunk(&pp.Message{
 			Type:  pp.Piece,
 			Index: r.Index,
@@ -95,5 +181,6 @@ func (ws *webseedPeer) requestResultHandler(r request, webseedRequest webseed.Re
 		if err != nil {
 			panic(err)
 		}
+		ws.peer.t.webSeedBytesUseful.Add(ws.peer._stats.BytesReadUsefulData.Int64() - useful)
 	}
 }
@@ -0,0 +1,22 @@
+package torrent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestWaitRateLimitOverBurst(t *testing.T) {
+	l := rate.NewLimiter(rate.Every(time.Millisecond), 10)
+	started := time.Now()
+	// Twice the burst, so the second half waits for the limiter to refill.
+	assert.NoError(t, waitRateLimit(context.Background(), l, 20))
+	assert.True(t, time.Since(started) >= 9*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, waitRateLimit(ctx, l, 1))
+	assert.Error(t, waitRateLimit(context.Background(), rate.NewLimiter(1, 0), 1))
+}
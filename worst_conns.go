@@ -10,7 +10,8 @@ import (
 
 func worseConn(l, r *peer) bool {
 	less, ok := multiless.New().Bool(
-		l.useful(), r.useful()).CmpInt64(
+		l.useful(), r.useful()).Bool(
+		l.affine, r.affine).CmpInt64(
 		l.lastHelpful().Sub(r.lastHelpful()).Nanoseconds()).CmpInt64(
 		l.completedHandshake.Sub(r.completedHandshake).Nanoseconds()).LazySameLess(
 		func() (same, less bool) {
@@ -13,8 +13,13 @@ import (
 )
 
 type websocketTrackerStatus struct {
-	url url.URL
-	tc  *webtorrent.TrackerClient
+	url     url.URL
+	tc      *webtorrent.TrackerClient
+	release func()
+}
+
+func (me websocketTrackerStatus) stop() {
+	me.release()
 }
 
 func (me websocketTrackerStatus) statusLine() string {